@@ -71,6 +71,12 @@ func runServe(cmd *cobra.Command, args []string) error {
 		log.Info("No config API key set, will use dynamic keys only")
 	}
 
+	if anon := cfg.Security.Anonymous; anon.Enabled {
+		log.Warn("Anonymous access enabled: requests without an API key are accepted",
+			zap.Int("requests_per_minute", anon.RequestsPerMinute),
+			zap.Int64("daily_tokens_per_ip", anon.DailyTokens))
+	}
+
 	// 创建服务器
 	srv, err := server.New(cfg, log)
 	if err != nil {
@@ -78,6 +84,19 @@ func runServe(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	// 输出生效的配置，确认加载的是哪个配置文件
+	srv.LogConfigSummary()
+
+	// 账号池为空时提示登录方式，避免首次部署后请求全部失败却不知原因
+	if srv.PoolStatus().Total == 0 {
+		log.Warn("No accounts configured; API requests will fail with no_accounts_configured until you log in",
+			zap.String("dashboard", fmt.Sprintf("http://localhost:%d/", cfg.Server.Port)),
+			zap.String("cli", "antigravity --login"))
+	}
+
+	// 新版本检查（updates.disabled 可关闭）
+	srv.StartUpdateCheck(Version)
+
 	// 启动HTTP服务器
 	httpServer := &http.Server{
 		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
@@ -85,14 +104,31 @@ func runServe(cmd *cobra.Command, args []string) error {
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 	}
+	tlsCfg := cfg.Server.TLS
+	if tlsCfg.CertFile != "" {
+		httpServer.TLSConfig, err = server.NewTLSConfig(tlsCfg)
+		if err != nil {
+			log.Error("Invalid TLS configuration", zap.Error(err))
+			return err
+		}
+	}
 
 	// 优雅关闭
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
-		log.Info("Server started", zap.String("addr", httpServer.Addr))
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Info("Server started",
+			zap.String("addr", httpServer.Addr),
+			zap.Bool("tls", tlsCfg.CertFile != ""),
+			zap.Bool("mtls", tlsCfg.ClientCAFile != ""))
+		var err error
+		if tlsCfg.CertFile != "" {
+			err = httpServer.ListenAndServeTLS(tlsCfg.CertFile, tlsCfg.KeyFile)
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal("Server failed", zap.Error(err))
 		}
 	}()
@@ -107,6 +143,7 @@ func runServe(cmd *cobra.Command, args []string) error {
 		log.Error("Server forced to shutdown", zap.Error(err))
 		return err
 	}
+	srv.Close()
 
 	log.Info("Server stopped gracefully")
 	return nil
@@ -119,7 +156,7 @@ func initDirectories(cfg *config.Config) error {
 		cfg.Storage.KeysDir,
 		cfg.Storage.UsageDir,
 		cfg.Storage.LogsDir,
-		"./uploads",
+		cfg.Storage.UploadsDir,
 	}
 
 	for _, dir := range dirs {
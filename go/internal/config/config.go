@@ -2,26 +2,44 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/antigravity/api-proxy/internal/i18n"
+	"github.com/antigravity/api-proxy/internal/upstream"
 	"github.com/spf13/viper"
 )
 
 // Config represents the application configuration
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	OAuth    OAuthConfig    `mapstructure:"oauth"`
-	Security SecurityConfig `mapstructure:"security"`
-	Logging  LoggingConfig  `mapstructure:"logging"`
-	Storage  StorageConfig  `mapstructure:"storage"`
+	Server    ServerConfig    `mapstructure:"server"`
+	OAuth     OAuthConfig     `mapstructure:"oauth"`
+	Security  SecurityConfig  `mapstructure:"security"`
+	Logging   LoggingConfig   `mapstructure:"logging"`
+	Storage   StorageConfig   `mapstructure:"storage"`
+	Proxy     ProxyConfig     `mapstructure:"proxy"`
+	Reports   ReportsConfig   `mapstructure:"reports"`
+	Updates   UpdatesConfig   `mapstructure:"updates"`
+	Resources ResourcesConfig `mapstructure:"resources"`
+	// Conversations 服务端会话状态
+	Conversations ConversationsConfig `mapstructure:"conversations"`
+	// Antigravity 上游接口地址、User-Agent 和响应等待时限，可用环境变量 ANTIGRAVITY_* 覆盖
+	Antigravity AntigravityConfig `mapstructure:"antigravity"`
 
 	// 以下配置内置在代码中，不暴露在配置文件
 	TokenRefresh TokenRefreshConfig // 始终启用，使用默认值
 	RateLimit    RateLimitConfig    // 内部使用
 	Monitoring   MonitoringConfig   // 内部使用
 	Defaults     DefaultsConfig     // 内部使用
-	Antigravity  AntigravityConfig  // 内置配置
+
+	// File 实际加载的配置文件（绝对路径），为空表示没有配置文件、只使用默认值和环境变量
+	File string `mapstructure:"-"`
+	// EnvOverrides 覆盖了配置文件的环境变量名
+	EnvOverrides []string `mapstructure:"-"`
 }
 
 type ServerConfig struct {
@@ -31,18 +49,96 @@ type ServerConfig struct {
 	ReadTimeout    time.Duration `mapstructure:"read_timeout"`
 	WriteTimeout   time.Duration `mapstructure:"write_timeout"`
 	MaxRequestSize string        `mapstructure:"max_request_size"`
+	// RequireAccount 开启后，至少有一个启用且未冷却的账号时 /ready 才返回200
+	RequireAccount bool `mapstructure:"require_account"`
+	// RejectUntilReady 配合 RequireAccount：未就绪时 /v1 与 /v1beta 请求直接返回503，避免逐个重试后失败
+	RejectUntilReady bool `mapstructure:"reject_until_ready"`
+	// Locale 服务端页面与错误信息的默认语言（en/zh），请求带 Accept-Language 时以其为准
+	Locale string    `mapstructure:"locale"`
+	TLS    TLSConfig `mapstructure:"tls"`
+	// TrustedProxies 信任其 X-Forwarded-For / X-Real-IP 的反向代理（IP或CIDR）；
+	// 默认为空，客户端IP取TCP连接的对端地址，避免伪造请求头绕过按IP的限制
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// TLSConfig serves HTTPS and optionally authenticates API clients by certificate
+type TLSConfig struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// ClientCAFile 非空时启用mTLS：校验客户端证书（未提供证书的连接仍可使用，例如浏览器访问管理面板）
+	ClientCAFile string `mapstructure:"client_ca_file"`
+	// RequireClientCert 开启后 /v1 与 /v1beta 只接受客户端证书认证，不再接受Bearer密钥
+	RequireClientCert bool `mapstructure:"require_client_cert"`
+	// ClientCerts 证书身份到API密钥的映射，身份为 Subject CN 或 "sha256:<证书指纹>"（不区分大小写）
+	ClientCerts map[string]string `mapstructure:"client_certs"`
 }
 
 type OAuthConfig struct {
 	// ClientID, ClientSecret, Scopes, RedirectURL 内置在代码中，不暴露在配置文件
 	// OAuth回调使用主服务器端口和 /oauth-callback 路由
+
+	// ModelsRefreshInterval 刷新令牌时重新获取账号模型列表的最短间隔（默认 24h）；
+	// 负数表示只在管理面板手动刷新，减少不必要的上游请求
+	ModelsRefreshInterval time.Duration `mapstructure:"models_refresh_interval"`
+	// HTTPTimeout OAuth侧请求（令牌交换与刷新、用户信息、模型列表）每次尝试的时限
+	HTTPTimeout time.Duration `mapstructure:"http_timeout"`
+	// HTTPMaxRetries 网络错误或 408/429/5xx 时的重试次数；负数表示不重试
+	HTTPMaxRetries int `mapstructure:"http_max_retries"`
+	// HTTPRetryBackoff 首次重试前的等待时间，之后每次翻倍（带随机抖动，最长 8s）
+	HTTPRetryBackoff time.Duration `mapstructure:"http_retry_backoff"`
 }
 
 type SecurityConfig struct {
-	AdminPassword  string   `mapstructure:"admin_password"`
-	APIKey         string   `mapstructure:"api_key"`
-	EnableCORS     bool     `mapstructure:"enable_cors"`
-	AllowedOrigins []string `mapstructure:"allowed_origins"`
+	AdminPassword  string     `mapstructure:"admin_password"`
+	APIKey         string     `mapstructure:"api_key"`
+	EnableCORS     bool       `mapstructure:"enable_cors"`
+	AllowedOrigins []string   `mapstructure:"allowed_origins"`
+	OIDC           OIDCConfig `mapstructure:"oidc"`
+	// APIKeys 在配置文件中声明的具名密钥，适合GitOps管理、不希望使用动态密钥文件的部署
+	APIKeys []StaticKeyConfig `mapstructure:"api_keys"`
+	// Anonymous 允许不带API Key的请求，按客户端IP严格限流；仅建议在本机单用户部署时开启
+	Anonymous AnonymousConfig `mapstructure:"anonymous"`
+	// MaskEmails 隐私模式：管理API、日志、通知和响应头中的账号邮箱显示为 f***@gmail.com，
+	// 适合管理面板开放给半信任用户的部署
+	MaskEmails bool `mapstructure:"mask_emails"`
+}
+
+// AnonymousConfig limits unauthenticated callers, per client IP
+type AnonymousConfig struct {
+	Enabled           bool  `mapstructure:"enabled"`
+	RequestsPerMinute int   `mapstructure:"requests_per_minute"`
+	DailyTokens       int64 `mapstructure:"daily_tokens"`
+}
+
+// StaticKeyConfig is an API key declared in config.yaml
+type StaticKeyConfig struct {
+	Name string `mapstructure:"name"`
+	Key  string `mapstructure:"key"`
+	// MaxRequests 每个 Window 内允许的请求数，0表示不限制；Window 默认1分钟
+	MaxRequests int           `mapstructure:"max_requests"`
+	Window      time.Duration `mapstructure:"window"`
+	// ContextTrimming 提示词超出模型上下文窗口时的处理："truncate" 丢弃最早的非系统消息，
+	// "summarize" 用 proxy.context_summary_model 把最早的消息压缩为摘要；为空时原样转发
+	ContextTrimming string `mapstructure:"context_trimming"`
+}
+
+// OIDCConfig 使用外部OIDC身份提供方（Google Workspace、Authentik、Keycloak等）登录管理面板
+type OIDCConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	Issuer       string `mapstructure:"issuer"` // 用于发现 /.well-known/openid-configuration
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	// RedirectURL 默认 http://localhost:<port>/admin/oidc/callback，反向代理后需改为外部地址
+	RedirectURL string   `mapstructure:"redirect_url"`
+	Scopes      []string `mapstructure:"scopes"`
+	// GroupsClaim 包含用户组的claim名称（ID Token或userinfo中）
+	GroupsClaim string `mapstructure:"groups_claim"`
+	// 组到角色的映射："admin" 拥有完整权限，"viewer" 只读；"*" 匹配任意已登录用户
+	AdminGroups  []string `mapstructure:"admin_groups"`
+	ViewerGroups []string `mapstructure:"viewer_groups"`
+	// DisablePassword 开启后不再接受共享管理员密码登录
+	DisablePassword bool          `mapstructure:"disable_password"`
+	SessionTTL      time.Duration `mapstructure:"session_ttl"`
 }
 
 type LoggingConfig struct {
@@ -54,6 +150,28 @@ type LoggingConfig struct {
 	MaxBackups    int    `mapstructure:"max_backups"`
 	MaxAge        int    `mapstructure:"max_age"`
 	Compress      bool   `mapstructure:"compress"`
+	// Sinks 额外的日志投递目标（syslog、Loki 或通用 HTTP），与文件和控制台输出并存
+	Sinks []LogSinkConfig `mapstructure:"sinks"`
+}
+
+// LogSinkConfig 描述一个集中式日志投递目标
+type LogSinkConfig struct {
+	// Type 为 syslog、loki 或 http
+	Type string `mapstructure:"type"`
+	// URL syslog 为 udp://host:514 或 tcp://host:514（为空时使用本机 syslog），
+	// loki 为服务地址（如 http://loki:3100），http 为接收 JSON 数组的地址
+	URL string `mapstructure:"url"`
+	// Level 该目标的最低日志级别，为空时使用 logging.level
+	Level string `mapstructure:"level"`
+	// Labels Loki 日志流标签，默认 {app: antigravity}；level 标签自动添加
+	Labels map[string]string `mapstructure:"labels"`
+	// Headers 附加的 HTTP 请求头，如认证信息或 X-Scope-OrgID
+	Headers map[string]string `mapstructure:"headers"`
+	// Tag syslog 标签，默认 antigravity
+	Tag string `mapstructure:"tag"`
+	// BatchSize 和 FlushInterval 控制 loki/http 的批量发送
+	BatchSize     int           `mapstructure:"batch_size"`
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
 }
 
 type StorageConfig struct {
@@ -62,6 +180,239 @@ type StorageConfig struct {
 	KeysDir     string `mapstructure:"keys_dir"`
 	UsageDir    string `mapstructure:"usage_dir"`
 	LogsDir     string `mapstructure:"logs_dir"`
+	// AccountFlushInterval 请求成功后账号状态和用量的更新先记在内存中，按该间隔合并写入账号文件（退出时也会写入）；
+	// 负数表示每次请求都立即写入
+	AccountFlushInterval time.Duration `mapstructure:"account_flush_interval"`
+	// UploadsDir 上传目录
+	UploadsDir string `mapstructure:"uploads_dir"`
+	// Retention 服务端写入目录的定期清理
+	Retention RetentionConfig `mapstructure:"retention"`
+}
+
+// RetentionConfig caps server-managed directories by age and total size
+type RetentionConfig struct {
+	// Interval 清理间隔，默认 1h；负数表示不清理
+	Interval time.Duration `mapstructure:"interval"`
+	// Files Files API 上传和批处理生成的文件（<data_dir>/batches/files），进行中批次引用的文件不会被删除
+	Files DirRetention `mapstructure:"files"`
+	// Uploads 上传目录（storage.uploads_dir）
+	Uploads DirRetention `mapstructure:"uploads"`
+	// ResponseCache 响应缓存的磁盘目录（proxy.response_cache.dir），未设置时跳过
+	ResponseCache DirRetention `mapstructure:"response_cache"`
+}
+
+// DirRetention limits one directory; older entries are removed first
+type DirRetention struct {
+	// MaxAge 超过该时间未修改的文件被删除；负数表示不按时间清理
+	MaxAge time.Duration `mapstructure:"max_age"`
+	// MaxSizeMB 目录总大小上限（MB），超出时从最旧的文件开始删除；负数表示不限制
+	MaxSizeMB int64 `mapstructure:"max_size_mb"`
+}
+
+// ProxyConfig controls how OpenAI requests are translated for upstream
+type ProxyConfig struct {
+	// DeveloperRole 决定 "developer" 角色消息的处理方式：
+	// "system" 合并到系统指令（默认），"user" 作为带前缀的用户消息
+	DeveloperRole string `mapstructure:"developer_role"`
+	// NonStreamUpstream 非流式请求调用的上游接口："generate" 使用 generateContent 一次返回完整结果（默认），
+	// "stream" 沿用 streamGenerateContent 并在本地聚合
+	NonStreamUpstream string `mapstructure:"non_stream_upstream"`
+	// AccountDailyTokens 每个账号每日可用token的估计值，用于选号前的额度预估；0表示不限制
+	AccountDailyTokens int64 `mapstructure:"account_daily_tokens"`
+	// MaxStreamsPerKey 每个API Key同时进行的流式请求上限，超出返回429；0表示不限制
+	MaxStreamsPerKey int `mapstructure:"max_streams_per_key"`
+	// BatchConcurrency 所有批处理任务合计同时执行的请求数
+	BatchConcurrency int `mapstructure:"batch_concurrency"`
+	// GoogleSearch 为所有请求附加 Google 搜索（grounding）工具；客户端也可通过 web_search 工具按请求开启
+	GoogleSearch bool `mapstructure:"google_search"`
+	// Images 请求中内联图片的校验与自动缩放
+	Images ImageConfig `mapstructure:"images"`
+	// StopSequences 默认附加的停止序列（聊天模板token，防止模型续写对话），为空时使用内置列表；
+	// 请求中的 stop 字段会替换该列表
+	StopSequences []string `mapstructure:"stop_sequences"`
+	// DisableStopSequences 不附加默认停止序列；请求可通过 extra_body.default_stop_sequences 覆盖
+	DisableStopSequences bool `mapstructure:"disable_stop_sequences"`
+	// StreamHeartbeat 流式响应静默超过该时长时发送 ": ping" 注释行，防止中间代理断开空闲连接；负数关闭
+	StreamHeartbeat time.Duration `mapstructure:"stream_heartbeat"`
+	// StreamFirstEventWindow 流式请求等待上游第一个数据事件的最长时间：在此之前上游出错时换账号重试，
+	// 客户端不会收到半截的流；超过该时间后不再等待，直接开始转发；负数关闭
+	StreamFirstEventWindow time.Duration `mapstructure:"stream_first_event_window"`
+	// StreamFlushInterval 流式响应合并输出的时间窗口：窗口内的多个小事件一次写出并flush，减少思考模型逐字输出时的系统调用；
+	// 负数表示每个事件立即flush（最低延迟）
+	StreamFlushInterval time.Duration `mapstructure:"stream_flush_interval"`
+	// StreamFlushBytes 缓冲的数据达到该字节数时不等窗口结束立即flush；0表示只按时间窗口
+	StreamFlushBytes int `mapstructure:"stream_flush_bytes"`
+	// MaxConcurrentPerAccount 每个账号同时进行的上游请求数上限（流式请求占用到流结束）；0表示不限制
+	MaxConcurrentPerAccount int `mapstructure:"max_concurrent_per_account"`
+	// RetrySameAccount 重试时允许再次选择本请求已尝试过的账号；默认先轮换到其他账号
+	RetrySameAccount bool `mapstructure:"retry_same_account"`
+	// HedgeDelay 所选账号在该时间内没有返回响应头时，用第二个空闲账号发出同样的请求，先成功响应的一方胜出；0表示关闭
+	HedgeDelay time.Duration `mapstructure:"hedge_delay"`
+	// RequestTimeout 单个请求在上游开始响应之前（含选号、重试和等待响应头）的默认时限；负数表示不限制。
+	// 客户端可通过 X-Request-Timeout 头或请求体 timeout 字段覆盖，客户端指定的时限覆盖整个请求（含流式输出）
+	RequestTimeout time.Duration `mapstructure:"request_timeout"`
+	// MaxRequestTimeout 客户端可申请的最长时限
+	MaxRequestTimeout time.Duration `mapstructure:"max_request_timeout"`
+	// StreamTimeout 上游开始响应后读取整个响应（流）的时限，替代 RequestTimeout；负数表示不限制。
+	// 客户端自行指定时限的请求不使用
+	StreamTimeout time.Duration `mapstructure:"stream_timeout"`
+	// MaxRetries 上游失败（限流、5xx、连接错误）后换账号重试的次数；负数表示不重试。
+	// 客户端可通过 X-Max-Retries 头按请求调整
+	MaxRetries int `mapstructure:"max_retries"`
+	// MaxRetriesLimit 客户端可申请的最多重试次数
+	MaxRetriesLimit int `mapstructure:"max_retries_limit"`
+	// VisionModel 请求含图片而所选模型不支持图片输入时，自动改用该模型；为空时返回 400
+	VisionModel string `mapstructure:"vision_model"`
+	// CountTokens 上游响应缺少 usageMetadata 时，在响应返回后调用上游 countTokens 获取准确的提示词token数
+	// 用于用量记录，代价是多一次上游请求；关闭时按文字类型本地估算
+	CountTokens bool `mapstructure:"count_tokens"`
+	// StructuredOutputRepairs strict json_schema 请求的输出不符合 schema 时，带上错误信息让模型重新生成的次数；
+	// 负数表示不重试（仍然校验，失败时返回错误）
+	StructuredOutputRepairs int `mapstructure:"structured_output_repairs"`
+	// ContextSummaryModel 密钥的上下文裁剪策略为 summarize 时，用于压缩早期消息的（低成本）模型
+	ContextSummaryModel string `mapstructure:"context_summary_model"`
+	// ModerationModel /v1/moderations 用于获取安全评分的（低成本）模型
+	ModerationModel string `mapstructure:"moderation_model"`
+	// ToolResults 超长工具结果（tool 角色消息、原生API的 functionResponse）的截断
+	ToolResults ToolResultConfig `mapstructure:"tool_results"`
+	// BestOf 扩展参数 extra_body.best_of 的多候选择优
+	BestOf BestOfConfig `mapstructure:"best_of"`
+	// Upstream 访问Google上游（聊天、OAuth、模型列表）共用的连接池
+	Upstream UpstreamConfig `mapstructure:"upstream"`
+	// CooldownQueue 所有账号都在冷却时让请求排队等待，而不是直接失败
+	CooldownQueue CooldownQueueConfig `mapstructure:"cooldown_queue"`
+	// ResponseCache 缓存相同的非流式请求的响应，重复请求不再消耗账号额度
+	ResponseCache ResponseCacheConfig `mapstructure:"response_cache"`
+}
+
+// ResponseCacheConfig caches responses of identical non-streaming requests
+type ResponseCacheConfig struct {
+	// Enabled 开启缓存；默认关闭
+	Enabled bool `mapstructure:"enabled"`
+	// TTL 缓存条目的有效期
+	TTL time.Duration `mapstructure:"ttl"`
+	// MaxEntries 内存中保留的条目数上限，超出时淘汰最久未使用的
+	MaxEntries int `mapstructure:"max_entries"`
+	// Dir 磁盘缓存目录，为空时只缓存在内存中；重启后磁盘上未过期的条目仍可命中
+	Dir string `mapstructure:"dir"`
+}
+
+// CooldownQueueConfig holds requests while every account is cooling down
+type CooldownQueueConfig struct {
+	// Enabled 开启排队；默认关闭，所有账号冷却时直接返回429
+	Enabled bool `mapstructure:"enabled"`
+	// MaxWait 单个请求最多排队的时长；最早的冷却结束时间更晚时直接失败
+	MaxWait time.Duration `mapstructure:"max_wait"`
+	// MaxQueued 同时排队的请求数上限，超出时直接失败
+	MaxQueued int `mapstructure:"max_queued"`
+}
+
+// UpstreamConfig tunes the shared connection pool used for all upstream calls
+type UpstreamConfig struct {
+	// MaxIdleConns 所有主机合计保留的空闲连接数
+	MaxIdleConns int `mapstructure:"max_idle_conns"`
+	// MaxIdleConnsPerHost 每个主机保留的空闲连接数
+	MaxIdleConnsPerHost int `mapstructure:"max_idle_conns_per_host"`
+	// MaxConnsPerHost 每个主机的连接总数上限（含使用中的），0表示不限制
+	MaxConnsPerHost int `mapstructure:"max_conns_per_host"`
+	// IdleConnTimeout 空闲连接保留的时长
+	IdleConnTimeout time.Duration `mapstructure:"idle_conn_timeout"`
+	// DialTimeout 建立TCP连接的时限
+	DialTimeout time.Duration `mapstructure:"dial_timeout"`
+	// TLSHandshakeTimeout TLS握手的时限
+	TLSHandshakeTimeout time.Duration `mapstructure:"tls_handshake_timeout"`
+	// DisableHTTP2 只使用 HTTP/1.1；默认与上游协商 HTTP/2
+	DisableHTTP2 bool `mapstructure:"disable_http2"`
+	// ProxyURL 出站代理（http://、https://、socks5://），为空时使用 HTTP(S)_PROXY 环境变量；
+	// 账号可用自己的 proxy 字段覆盖
+	ProxyURL string `mapstructure:"proxy_url"`
+	// Headers 附加到所有 Cloud Code 请求（聊天、countTokens、模型列表）的请求头，如客户端元数据；
+	// 账号的 headers 字段按名称覆盖。Authorization、Content-Type 等由代理管理的请求头不能设置
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+// ToolResultConfig bounds tool outputs (e.g. whole files) before they are sent
+// upstream, where they would otherwise exceed the request limits
+type ToolResultConfig struct {
+	// MaxChars 单个工具结果的最大字符数，超出部分被截断并附加说明；负数表示不截断
+	MaxChars int `mapstructure:"max_chars"`
+	// Strategy 截断方式："head_tail" 保留开头和结尾（默认，报错和总结通常在末尾），"head" 只保留开头
+	Strategy string `mapstructure:"strategy"`
+}
+
+// BestOfConfig controls best-of re-ranking: N candidates are generated in
+// parallel (spread over accounts) and only the highest scored one is returned
+type BestOfConfig struct {
+	// MaxCandidates 单个请求最多可申请的候选数；每个候选都是一次完整的上游调用，按实际消耗计费
+	MaxCandidates int `mapstructure:"max_candidates"`
+	// Scorer 默认评分方式："heuristic" 按结束原因、平均对数概率和长度打分（默认），"judge" 由 JudgeModel 评选
+	Scorer string `mapstructure:"scorer"`
+	// JudgeModel scorer 为 judge 时用于评选候选的模型
+	JudgeModel string `mapstructure:"judge_model"`
+}
+
+// maxStopSequences is the upstream limit on stopSequences
+const maxStopSequences = 5
+
+// DefaultStopSequences 是内置的默认停止序列
+var DefaultStopSequences = []string{"<|user|>", "<|bot|>", "<|context_request|>", "<|endoftext|>", "<|end_of_turn|>"}
+
+// ImageConfig bounds inline images before they are sent upstream;
+// zero limits disable the corresponding check
+type ImageConfig struct {
+	// MaxBytes 单张图片解码后的最大字节数
+	MaxBytes int `mapstructure:"max_bytes"`
+	// MaxDimension 图片宽、高的最大像素数
+	MaxDimension int `mapstructure:"max_dimension"`
+	// Resize 开启后超限的图片会被缩小并重新编码，否则直接返回400
+	Resize bool `mapstructure:"resize"`
+	// JPEGQuality 重新编码为JPEG时的质量（1-100）
+	JPEGQuality int `mapstructure:"jpeg_quality"`
+}
+
+// ReportsConfig controls the daily summary report job
+type ReportsConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Dir     string `mapstructure:"dir"`  // 报告输出目录
+	Hour    int    `mapstructure:"hour"` // 每天生成前一日报告的时间（本地时间，0-23）
+	// WebhookURL 非空时，生成的报告会以JSON POST到该地址
+	WebhookURL string `mapstructure:"webhook_url"`
+	// 费用估算单价（美元/百万token），为0时不计算费用
+	InputCostPerMillion  float64 `mapstructure:"input_cost_per_million"`
+	OutputCostPerMillion float64 `mapstructure:"output_cost_per_million"`
+}
+
+// UpdatesConfig 控制启动时（及之后定期）对 GitHub Releases 的新版本检查
+type UpdatesConfig struct {
+	// Disabled 关闭检查，离线或内网部署时使用
+	Disabled bool   `mapstructure:"disabled"`
+	Repo     string `mapstructure:"repo"` // owner/name
+	// Interval 两次检查之间的间隔
+	Interval time.Duration `mapstructure:"interval"`
+	// APIURL GitHub API 地址，可指向 GitHub Enterprise 或内部镜像
+	APIURL string `mapstructure:"api_url"`
+}
+
+// ResourcesConfig 控制数据目录磁盘空间、inode 和进程文件描述符的后台监控
+type ResourcesConfig struct {
+	// Disabled 关闭监控
+	Disabled bool `mapstructure:"disabled"`
+	// Interval 两次检查之间的间隔
+	Interval time.Duration `mapstructure:"interval"`
+	// DiskWarnPercent 数据目录所在磁盘的空间或 inode 使用率超过该百分比时告警
+	DiskWarnPercent float64 `mapstructure:"disk_warn_percent"`
+	// FDWarnPercent 打开的文件描述符数超过上限的该百分比时告警
+	FDWarnPercent float64 `mapstructure:"fd_warn_percent"`
+}
+
+// ConversationsConfig 控制服务端会话状态：客户端发送 conversation_id 和最新消息，
+// 代理保存之前的轮次并拼出完整上下文
+type ConversationsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// TTL 会话闲置超过该时长后删除；负数表示永不过期
+	TTL time.Duration `mapstructure:"ttl"`
+	// MaxMessages 每个会话保留的最大消息数（系统消息除外，超出时丢弃最早的轮次）；负数表示不限制
+	MaxMessages int `mapstructure:"max_messages"`
 }
 
 type TokenRefreshConfig struct {
@@ -91,10 +442,53 @@ type DefaultsConfig struct {
 	SystemInstruction string  `mapstructure:"system_instruction"`
 }
 
+// AntigravityConfig selects the upstream Cloud Code environment
 type AntigravityConfig struct {
-	BaseURL   string        `mapstructure:"base_url"`
-	UserAgent string        `mapstructure:"user_agent"`
-	Timeout   time.Duration `mapstructure:"timeout"`
+	// BaseURL 上游接口地址（不含 /v1internal 路径），默认为 daily sandbox 环境
+	BaseURL string `mapstructure:"base_url"`
+	// UserAgent 发往上游的 User-Agent
+	UserAgent string `mapstructure:"user_agent"`
+	// Timeout 每次上游尝试等待响应头的时限，超时后换账号重试；负数表示不限制。
+	// 流式输出本身不受此限制，整体时限见 proxy.request_timeout；
+	// generateContent 请求生成完才返回响应头，也不受此限制
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Environment variables that override the antigravity section
+const (
+	envBaseURL   = "ANTIGRAVITY_BASE_URL"
+	envUserAgent = "ANTIGRAVITY_USER_AGENT"
+	envTimeout   = "ANTIGRAVITY_TIMEOUT"
+)
+
+// applyEnvOverrides lets deployments target another upstream environment
+// without editing the config file
+func applyEnvOverrides(cfg *Config) error {
+	if v := os.Getenv(envBaseURL); v != "" {
+		cfg.Antigravity.BaseURL = v
+		cfg.EnvOverrides = append(cfg.EnvOverrides, envBaseURL)
+	}
+	if v := os.Getenv(envUserAgent); v != "" {
+		cfg.Antigravity.UserAgent = v
+		cfg.EnvOverrides = append(cfg.EnvOverrides, envUserAgent)
+	}
+	if v := os.Getenv(envTimeout); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", envTimeout, err)
+		}
+		cfg.Antigravity.Timeout = timeout
+		cfg.EnvOverrides = append(cfg.EnvOverrides, envTimeout)
+	}
+	return nil
+}
+
+// absPath resolves the config file path for display; it is kept as is when that fails
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
 }
 
 // Load loads the configuration from file and environment
@@ -104,6 +498,9 @@ func Load() (*Config, error) {
 	if err := viper.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
+	if err := applyEnvOverrides(&cfg); err != nil {
+		return nil, err
+	}
 
 	// 设置默认值
 	setDefaults(&cfg)
@@ -131,6 +528,7 @@ func LoadOrCreate() (*Config, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to load config from %s: %w", configFile, err)
 		}
+		cfg.File = absPath(configFile)
 		return cfg, nil
 	}
 
@@ -138,6 +536,9 @@ func LoadOrCreate() (*Config, error) {
 	fmt.Println("\n⚠️  Config file not found, creating default config...")
 
 	cfg := &Config{}
+	if err := applyEnvOverrides(cfg); err != nil {
+		return nil, err
+	}
 	setDefaults(cfg)
 
 	// 生成随机管理员密码
@@ -153,6 +554,7 @@ func LoadOrCreate() (*Config, error) {
 		fmt.Println("   Continuing with in-memory config...")
 	} else {
 		fmt.Println("\n✅ Config file created: config.yaml")
+		cfg.File = absPath(configFile)
 	}
 
 	return cfg, nil
@@ -166,6 +568,12 @@ func SaveConfig(cfg *Config) error {
 	viper.Set("security", cfg.Security)
 	viper.Set("logging", cfg.Logging)
 	viper.Set("storage", cfg.Storage)
+	viper.Set("proxy", cfg.Proxy)
+	viper.Set("reports", cfg.Reports)
+	viper.Set("updates", cfg.Updates)
+	viper.Set("resources", cfg.Resources)
+	viper.Set("conversations", cfg.Conversations)
+	viper.Set("antigravity", cfg.Antigravity)
 
 	// 确定配置文件路径
 	configPath := viper.ConfigFileUsed()
@@ -198,6 +606,32 @@ func setDefaults(cfg *Config) {
 	if cfg.Server.Mode == "" {
 		cfg.Server.Mode = "release"
 	}
+	for i := range cfg.Security.APIKeys {
+		if cfg.Security.APIKeys[i].Name == "" {
+			cfg.Security.APIKeys[i].Name = fmt.Sprintf("config-key-%d", i+1)
+		}
+	}
+	if cfg.Security.Anonymous.RequestsPerMinute == 0 {
+		cfg.Security.Anonymous.RequestsPerMinute = 10
+	}
+	if cfg.Security.Anonymous.DailyTokens == 0 {
+		cfg.Security.Anonymous.DailyTokens = 100000
+	}
+	if cfg.Security.OIDC.RedirectURL == "" {
+		cfg.Security.OIDC.RedirectURL = fmt.Sprintf("http://localhost:%d/admin/oidc/callback", cfg.Server.Port)
+	}
+	if len(cfg.Security.OIDC.Scopes) == 0 {
+		cfg.Security.OIDC.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.Security.OIDC.GroupsClaim == "" {
+		cfg.Security.OIDC.GroupsClaim = "groups"
+	}
+	if cfg.Security.OIDC.SessionTTL == 0 {
+		cfg.Security.OIDC.SessionTTL = 12 * time.Hour
+	}
+	if cfg.Server.Locale == "" {
+		cfg.Server.Locale = i18n.English
+	}
 	if cfg.Server.ReadTimeout == 0 {
 		cfg.Server.ReadTimeout = 30 * time.Second
 	}
@@ -243,8 +677,163 @@ func setDefaults(cfg *Config) {
 	if cfg.Storage.LogsDir == "" {
 		cfg.Storage.LogsDir = "./logs"
 	}
+	if cfg.Storage.AccountFlushInterval == 0 {
+		cfg.Storage.AccountFlushInterval = 5 * time.Second
+	}
+	if cfg.Storage.UploadsDir == "" {
+		cfg.Storage.UploadsDir = "./uploads"
+	}
+	if cfg.Storage.Retention.Interval == 0 {
+		cfg.Storage.Retention.Interval = time.Hour
+	}
+	setRetentionDefaults(&cfg.Storage.Retention.Files, 30*24*time.Hour, 2048)
+	setRetentionDefaults(&cfg.Storage.Retention.Uploads, 7*24*time.Hour, 1024)
+	setRetentionDefaults(&cfg.Storage.Retention.ResponseCache, 24*time.Hour, 512)
+
+	// 代理转换配置
+	if cfg.Proxy.DeveloperRole == "" {
+		cfg.Proxy.DeveloperRole = "system"
+	}
+	if cfg.Proxy.NonStreamUpstream == "" {
+		cfg.Proxy.NonStreamUpstream = "generate"
+	}
+	if cfg.Proxy.BatchConcurrency == 0 {
+		cfg.Proxy.BatchConcurrency = 4
+	}
+	if cfg.Proxy.Images.MaxBytes == 0 {
+		cfg.Proxy.Images.MaxBytes = 7 << 20
+	}
+	if cfg.Proxy.Images.MaxDimension == 0 {
+		cfg.Proxy.Images.MaxDimension = 3072
+	}
+	if cfg.Proxy.Images.JPEGQuality == 0 {
+		cfg.Proxy.Images.JPEGQuality = 85
+	}
+	if cfg.Proxy.StreamHeartbeat == 0 {
+		cfg.Proxy.StreamHeartbeat = 15 * time.Second
+	}
+	if cfg.Proxy.StreamFirstEventWindow == 0 {
+		cfg.Proxy.StreamFirstEventWindow = 10 * time.Second
+	}
+	if cfg.Proxy.StreamFlushInterval == 0 {
+		cfg.Proxy.StreamFlushInterval = 50 * time.Millisecond
+	}
+	if cfg.Proxy.CooldownQueue.MaxWait == 0 {
+		cfg.Proxy.CooldownQueue.MaxWait = 30 * time.Second
+	}
+	if cfg.Proxy.CooldownQueue.MaxQueued == 0 {
+		cfg.Proxy.CooldownQueue.MaxQueued = 100
+	}
+	if cfg.Proxy.ResponseCache.TTL == 0 {
+		cfg.Proxy.ResponseCache.TTL = 10 * time.Minute
+	}
+	if cfg.Proxy.ResponseCache.MaxEntries == 0 {
+		cfg.Proxy.ResponseCache.MaxEntries = 1000
+	}
+	if cfg.Proxy.RequestTimeout == 0 {
+		cfg.Proxy.RequestTimeout = 120 * time.Second
+	}
+	if cfg.Proxy.MaxRequestTimeout == 0 {
+		cfg.Proxy.MaxRequestTimeout = 30 * time.Minute
+	}
+	if cfg.Proxy.StreamTimeout == 0 {
+		cfg.Proxy.StreamTimeout = 30 * time.Minute
+	}
+	if cfg.Proxy.MaxRetries == 0 {
+		cfg.Proxy.MaxRetries = 4
+	}
+	if cfg.Proxy.MaxRetriesLimit == 0 {
+		cfg.Proxy.MaxRetriesLimit = 10
+	}
+	if len(cfg.Proxy.StopSequences) == 0 {
+		cfg.Proxy.StopSequences = DefaultStopSequences
+	}
+	if cfg.Proxy.ContextSummaryModel == "" {
+		cfg.Proxy.ContextSummaryModel = "gemini-2.5-flash"
+	}
+	if cfg.Proxy.ModerationModel == "" {
+		cfg.Proxy.ModerationModel = "gemini-2.5-flash"
+	}
+	if cfg.Proxy.StructuredOutputRepairs == 0 {
+		cfg.Proxy.StructuredOutputRepairs = 2
+	}
+	if cfg.Proxy.ToolResults.MaxChars == 0 {
+		cfg.Proxy.ToolResults.MaxChars = 100000
+	}
+	if cfg.Proxy.ToolResults.Strategy == "" {
+		cfg.Proxy.ToolResults.Strategy = "head_tail"
+	}
+	if cfg.Proxy.BestOf.MaxCandidates == 0 {
+		cfg.Proxy.BestOf.MaxCandidates = 4
+	}
+	if cfg.Proxy.BestOf.Scorer == "" {
+		cfg.Proxy.BestOf.Scorer = "heuristic"
+	}
+	if cfg.Proxy.BestOf.JudgeModel == "" {
+		cfg.Proxy.BestOf.JudgeModel = "gemini-2.5-flash"
+	}
+	if cfg.Proxy.Upstream.MaxIdleConns == 0 {
+		cfg.Proxy.Upstream.MaxIdleConns = 100
+	}
+	if cfg.Proxy.Upstream.MaxIdleConnsPerHost == 0 {
+		cfg.Proxy.Upstream.MaxIdleConnsPerHost = 20
+	}
+	if cfg.Proxy.Upstream.IdleConnTimeout == 0 {
+		cfg.Proxy.Upstream.IdleConnTimeout = 90 * time.Second
+	}
+	if cfg.Proxy.Upstream.DialTimeout == 0 {
+		cfg.Proxy.Upstream.DialTimeout = 10 * time.Second
+	}
+	if cfg.Proxy.Upstream.TLSHandshakeTimeout == 0 {
+		cfg.Proxy.Upstream.TLSHandshakeTimeout = 10 * time.Second
+	}
+
+	// 日报配置
+	if cfg.Reports.Dir == "" {
+		cfg.Reports.Dir = "./data/reports"
+	}
+
+	// 版本检查配置
+	if cfg.Updates.Repo == "" {
+		cfg.Updates.Repo = "XxxXTeam/Antigravity-"
+	}
+	if cfg.Updates.Interval == 0 {
+		cfg.Updates.Interval = 24 * time.Hour
+	}
+	if cfg.Updates.APIURL == "" {
+		cfg.Updates.APIURL = "https://api.github.com"
+	}
+
+	// 资源监控配置
+	if cfg.Conversations.TTL == 0 {
+		cfg.Conversations.TTL = 24 * time.Hour
+	}
+	if cfg.Conversations.MaxMessages == 0 {
+		cfg.Conversations.MaxMessages = 200
+	}
+	if cfg.Resources.Interval == 0 {
+		cfg.Resources.Interval = time.Minute
+	}
+	if cfg.Resources.DiskWarnPercent == 0 {
+		cfg.Resources.DiskWarnPercent = 90
+	}
+	if cfg.Resources.FDWarnPercent == 0 {
+		cfg.Resources.FDWarnPercent = 80
+	}
 
 	// Token刷新配置
+	if cfg.OAuth.ModelsRefreshInterval == 0 {
+		cfg.OAuth.ModelsRefreshInterval = 24 * time.Hour
+	}
+	if cfg.OAuth.HTTPTimeout == 0 {
+		cfg.OAuth.HTTPTimeout = 15 * time.Second
+	}
+	if cfg.OAuth.HTTPMaxRetries == 0 {
+		cfg.OAuth.HTTPMaxRetries = 2
+	}
+	if cfg.OAuth.HTTPRetryBackoff == 0 {
+		cfg.OAuth.HTTPRetryBackoff = 500 * time.Millisecond
+	}
 	if cfg.TokenRefresh.Interval == 0 {
 		cfg.TokenRefresh.Interval = 30 * time.Minute
 	}
@@ -279,16 +868,147 @@ func setDefaults(cfg *Config) {
 		cfg.Antigravity.BaseURL = "https://daily-cloudcode-pa.sandbox.googleapis.com"
 	}
 	if cfg.Antigravity.UserAgent == "" {
-		cfg.Antigravity.UserAgent = "antigravity/1.11.3 linux/amd64"
+		cfg.Antigravity.UserAgent = "antigravity/1.11.3 windows/amd64"
 	}
 	if cfg.Antigravity.Timeout == 0 {
 		cfg.Antigravity.Timeout = 60 * time.Second
 	}
 }
 
+// setRetentionDefaults fills the unset limits of one directory
+func setRetentionDefaults(r *DirRetention, maxAge time.Duration, maxSizeMB int64) {
+	if r.MaxAge == 0 {
+		r.MaxAge = maxAge
+	}
+	if r.MaxSizeMB == 0 {
+		r.MaxSizeMB = maxSizeMB
+	}
+}
+
 func validate(cfg *Config) error {
 	if cfg.Server.Port < 1 || cfg.Server.Port > 65535 {
 		return fmt.Errorf("invalid port: %d", cfg.Server.Port)
 	}
+	if i18n.Normalize(cfg.Server.Locale) == "" {
+		return fmt.Errorf("invalid server.locale: %q (expected en or zh)", cfg.Server.Locale)
+	}
+	if tls := cfg.Server.TLS; tls.CertFile != "" || tls.KeyFile != "" || tls.ClientCAFile != "" {
+		if tls.CertFile == "" || tls.KeyFile == "" {
+			return fmt.Errorf("server.tls requires both cert_file and key_file")
+		}
+	}
+	if cfg.Server.TLS.RequireClientCert && cfg.Server.TLS.ClientCAFile == "" {
+		return fmt.Errorf("server.tls.require_client_cert requires client_ca_file")
+	}
+	for _, proxy := range cfg.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("invalid server.trusted_proxies entry: %q (expected an IP or CIDR)", proxy)
+			}
+		}
+	}
+	seenKeys := make(map[string]bool)
+	for i, key := range cfg.Security.APIKeys {
+		if key.Key == "" {
+			return fmt.Errorf("security.api_keys[%d]: key is required", i)
+		}
+		if seenKeys[key.Key] || key.Key == cfg.Security.APIKey {
+			return fmt.Errorf("security.api_keys[%d]: duplicate key", i)
+		}
+		seenKeys[key.Key] = true
+		if key.MaxRequests < 0 || key.Window < 0 {
+			return fmt.Errorf("security.api_keys[%d]: invalid limits", i)
+		}
+		if key.ContextTrimming != "" && key.ContextTrimming != "truncate" && key.ContextTrimming != "summarize" {
+			return fmt.Errorf("security.api_keys[%d]: invalid context_trimming %q (expected truncate or summarize)", i, key.ContextTrimming)
+		}
+	}
+	if anon := cfg.Security.Anonymous; anon.RequestsPerMinute < 0 || anon.DailyTokens < 0 {
+		return fmt.Errorf("invalid security.anonymous limits")
+	}
+	if oidc := cfg.Security.OIDC; oidc.Enabled {
+		if oidc.Issuer == "" || oidc.ClientID == "" {
+			return fmt.Errorf("security.oidc requires issuer and client_id")
+		}
+		if len(oidc.AdminGroups) == 0 && len(oidc.ViewerGroups) == 0 {
+			return fmt.Errorf("security.oidc requires admin_groups or viewer_groups (use \"*\" to allow any user)")
+		}
+	}
+	if cfg.Proxy.DeveloperRole != "system" && cfg.Proxy.DeveloperRole != "user" {
+		return fmt.Errorf("invalid proxy.developer_role: %q (expected system or user)", cfg.Proxy.DeveloperRole)
+	}
+	if m := cfg.Proxy.NonStreamUpstream; m != "generate" && m != "stream" {
+		return fmt.Errorf("invalid proxy.non_stream_upstream: %q (expected generate or stream)", m)
+	}
+	if cfg.Proxy.AccountDailyTokens < 0 {
+		return fmt.Errorf("invalid proxy.account_daily_tokens: %d", cfg.Proxy.AccountDailyTokens)
+	}
+	if cfg.Proxy.MaxStreamsPerKey < 0 {
+		return fmt.Errorf("invalid proxy.max_streams_per_key: %d", cfg.Proxy.MaxStreamsPerKey)
+	}
+	if cfg.Proxy.MaxConcurrentPerAccount < 0 {
+		return fmt.Errorf("invalid proxy.max_concurrent_per_account: %d", cfg.Proxy.MaxConcurrentPerAccount)
+	}
+	if cfg.Proxy.StreamFlushBytes < 0 {
+		return fmt.Errorf("invalid proxy.stream_flush_bytes: %d", cfg.Proxy.StreamFlushBytes)
+	}
+	if cfg.Proxy.HedgeDelay < 0 {
+		return fmt.Errorf("invalid proxy.hedge_delay: %s", cfg.Proxy.HedgeDelay)
+	}
+	if cfg.Proxy.MaxRetriesLimit < 0 {
+		return fmt.Errorf("invalid proxy.max_retries_limit: %d", cfg.Proxy.MaxRetriesLimit)
+	}
+	if q := cfg.Proxy.CooldownQueue; q.MaxWait < 0 || q.MaxQueued < 0 {
+		return fmt.Errorf("invalid proxy.cooldown_queue: max_wait=%s max_queued=%d", q.MaxWait, q.MaxQueued)
+	}
+	if rc := cfg.Proxy.ResponseCache; rc.TTL < 0 || rc.MaxEntries < 0 {
+		return fmt.Errorf("invalid proxy.response_cache: ttl=%s max_entries=%d", rc.TTL, rc.MaxEntries)
+	}
+	if img := cfg.Proxy.Images; img.MaxBytes < 0 || img.MaxDimension < 0 {
+		return fmt.Errorf("invalid proxy.images limits: max_bytes=%d max_dimension=%d", img.MaxBytes, img.MaxDimension)
+	}
+	if q := cfg.Proxy.Images.JPEGQuality; q < 1 || q > 100 {
+		return fmt.Errorf("invalid proxy.images.jpeg_quality: %d (expected 1-100)", q)
+	}
+	if n := len(cfg.Proxy.StopSequences); n > maxStopSequences {
+		return fmt.Errorf("invalid proxy.stop_sequences: %d entries (Gemini accepts at most %d)", n, maxStopSequences)
+	}
+	if st := cfg.Proxy.ToolResults.Strategy; st != "head" && st != "head_tail" {
+		return fmt.Errorf("invalid proxy.tool_results.strategy: %q (expected head or head_tail)", st)
+	}
+	if u, err := url.Parse(cfg.Antigravity.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid antigravity.base_url: %q (expected an http or https URL)", cfg.Antigravity.BaseURL)
+	}
+	if sc := cfg.Proxy.BestOf.Scorer; sc != "heuristic" && sc != "judge" {
+		return fmt.Errorf("invalid proxy.best_of.scorer: %q (expected heuristic or judge)", sc)
+	}
+	if u := cfg.Proxy.Upstream; u.MaxIdleConns < 0 || u.MaxIdleConnsPerHost < 0 || u.MaxConnsPerHost < 0 {
+		return fmt.Errorf("proxy.upstream connection limits must not be negative")
+	}
+	if p := cfg.Proxy.Upstream.ProxyURL; p != "" {
+		if _, err := upstream.ParseProxyURL(p); err != nil {
+			return fmt.Errorf("invalid proxy.upstream.proxy_url: %w", err)
+		}
+	}
+	if err := upstream.ValidateHeaders(cfg.Proxy.Upstream.Headers); err != nil {
+		return fmt.Errorf("invalid proxy.upstream.headers: %w", err)
+	}
+	for i, sink := range cfg.Logging.Sinks {
+		switch sink.Type {
+		case "syslog":
+		case "loki", "http":
+			if sink.URL == "" {
+				return fmt.Errorf("logging.sinks[%d]: %s requires url", i, sink.Type)
+			}
+		default:
+			return fmt.Errorf("logging.sinks[%d]: invalid type %q (expected syslog, loki or http)", i, sink.Type)
+		}
+	}
+	if cfg.Reports.Hour < 0 || cfg.Reports.Hour > 23 {
+		return fmt.Errorf("invalid reports.hour: %d (expected 0-23)", cfg.Reports.Hour)
+	}
+	if !cfg.Updates.Disabled && strings.Count(cfg.Updates.Repo, "/") != 1 {
+		return fmt.Errorf("invalid updates.repo: %q (expected owner/name)", cfg.Updates.Repo)
+	}
 	return nil
 }
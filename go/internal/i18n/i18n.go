@@ -0,0 +1,82 @@
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 服务端生成的HTML页面和错误信息的多语言支持（en/zh）
+// 语言优先按请求的 Accept-Language 协商，其次使用配置的默认语言
+
+const (
+	English = "en"
+	Chinese = "zh"
+)
+
+// Supported lists the available bundles
+var Supported = []string{English, Chinese}
+
+// Normalize maps a language tag (e.g. "zh-CN", "en_US") to a supported
+// locale, or "" when it isn't supported
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	for _, lang := range Supported {
+		if tag == lang {
+			return lang
+		}
+	}
+	return ""
+}
+
+// Match picks the best supported locale from an Accept-Language header,
+// honouring q-values. fallback is used when nothing matches.
+func Match(acceptLanguage, fallback string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, entry := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(entry, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if lang := Normalize(tag); lang != "" && q > 0 {
+			candidates = append(candidates, candidate{lang, q})
+		}
+	}
+
+	if len(candidates) == 0 {
+		if lang := Normalize(fallback); lang != "" {
+			return lang
+		}
+		return English
+	}
+	// 稳定排序，q值相同时保持客户端给出的顺序
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].lang
+}
+
+// T returns the message for key in lang, formatted with args.
+// Missing translations fall back to English, then to the key itself.
+func T(lang, key string, args ...interface{}) string {
+	msg, ok := bundles[lang][key]
+	if !ok {
+		if msg, ok = bundles[English][key]; !ok {
+			msg = key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
@@ -0,0 +1,39 @@
+package i18n
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		header, fallback, want string
+	}{
+		{"", "", English},
+		{"", "zh", Chinese},
+		{"zh-CN,zh;q=0.9,en;q=0.8", "en", Chinese},
+		{"fr-FR,en;q=0.5,zh;q=0.7", "en", Chinese},
+		{"de", "zh", Chinese},
+		{"en-US", "zh", English},
+		{"zh;q=0", "en", English},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, Match(tc.header, tc.fallback), "header %q fallback %q", tc.header, tc.fallback)
+	}
+}
+
+func TestBundlesComplete(t *testing.T) {
+	for key := range bundles[English] {
+		assert.Contains(t, bundles[Chinese], key)
+	}
+	for key := range bundles[Chinese] {
+		assert.Contains(t, bundles[English], key)
+	}
+}
+
+func TestT(t *testing.T) {
+	assert.Equal(t, "无效的请求: bad", T(Chinese, "invalid_request_detail", "bad"))
+	assert.Equal(t, "Unauthorized", T("fr", "unauthorized"))
+	assert.Equal(t, "no_such_key", T(English, "no_such_key"))
+}
@@ -0,0 +1,175 @@
+package i18n
+
+// bundles holds the translations; every key must exist in the English bundle
+var bundles = map[string]map[string]string{
+	English: {
+		"invalid_request":               "Invalid request",
+		"invalid_request_detail":        "Invalid request: %s",
+		"invalid_password":              "Invalid password",
+		"unauthorized":                  "Unauthorized",
+		"missing_api_key":               "Missing Authorization header",
+		"invalid_api_key":               "Invalid API key",
+		"client_certificate_required":   "A client certificate mapped to an API key is required",
+		"invalid_simulation_input":      "rpm and tokens must be positive numbers",
+		"anonymous_quota_exceeded":      "Daily token limit for anonymous access reached; use an API key",
+		"invalid_image":                 "An input image could not be read",
+		"image_too_large":               "An input image exceeds the size limits",
+		"invalid_image_url":             "An image part is malformed",
+		"unsupported_image_url":         "Only base64 data URLs are supported for images",
+		"model_not_vision":              "The selected model does not accept image input",
+		"parameter_out_of_range":        "A sampling parameter is outside the range the model accepts",
+		"api_key_not_found":             "API key not found",
+		"key_not_found":                 "Key not found",
+		"key_generated":                 "Key generated successfully. Save it securely!",
+		"failed_list_keys":              "Failed to list keys",
+		"failed_generate_key":           "Failed to generate key",
+		"failed_delete_key":             "Failed to delete key",
+		"key_template_not_found":        "Key template not found",
+		"failed_save_key_template":      "Failed to save key template",
+		"api_key_expired":               "This API key has expired",
+		"model_not_allowed":             "This API key is not allowed to use the requested model",
+		"conversations_disabled":        "Server-side conversations are not enabled (conversations.enabled)",
+		"invalid_conversation_id":       "conversation_id must be 1-128 letters, digits, '-' or '_'",
+		"conversation_id_in_use":        "This conversation_id is used by another API key",
+		"conversation_not_found":        "Conversation not found",
+		"invalid_response_format":       "response_format must be text, json_object or json_schema with a schema object",
+		"structured_output_invalid":     "The model output did not match the requested JSON schema",
+		"json_output_invalid":           "The model output is not valid JSON",
+		"failed_get_stats":              "Failed to get stats",
+		"account_not_found":             "Account not found",
+		"invalid_account_id":            "Invalid account ID",
+		"failed_read_accounts":          "Failed to read accounts",
+		"failed_parse_account":          "Failed to parse account",
+		"failed_serialize_account":      "Failed to serialize account",
+		"failed_save_account":           "Failed to save account",
+		"failed_delete_account":         "Failed to delete account",
+		"opening_auth_window":           "Opening authorization window...",
+		"invalid_callback_url":          "Invalid callback URL",
+		"no_code_in_callback":           "No code found in callback URL",
+		"failed_exchange_code":          "Failed to exchange code for token",
+		"failed_get_user_info":          "Failed to get user info",
+		"failed_usage_history":          "Failed to get usage history",
+		"failed_refresh_history":        "Failed to get token refresh history",
+		"failed_refresh_models":         "Failed to refresh the account's models",
+		"notification_not_found":        "Notification not found",
+		"failed_update_notification":    "Failed to update notification",
+		"failed_update_notifications":   "Failed to update notifications",
+		"failed_clear_notifications":    "Failed to clear notifications",
+		"invalid_date":                  "Invalid date, expected YYYY-MM-DD",
+		"report_not_found":              "Report not found",
+		"failed_list_reports":           "Failed to list reports",
+		"failed_load_report":            "Failed to load report",
+		"failed_generate_report":        "Failed to generate report",
+		"failed_save_routing":           "Failed to save routing table",
+		"capture_not_found":             "Capture not found",
+		"sso_disabled":                  "SSO login is not enabled",
+		"password_login_disabled":       "Password login is disabled; sign in with SSO",
+		"forbidden_read_only":           "Read-only role: this action requires the admin role",
+		"page_sso_failed":               "SSO login failed",
+		"page_sso_provider_unreachable": "Could not reach the identity provider",
+		"page_sso_denied":               "Login was rejected or your account is not in an allowed group",
+		"page_back_to_dashboard":        "Back to admin panel",
+		"page_auth_failed":              "Authorization failed",
+		"page_auth_error":               "Error: %s",
+		"page_no_access_token":          "Could not obtain an access token",
+		"page_no_user_info":             "Could not fetch user info",
+		"page_save_failed":              "Save failed",
+		"page_save_failed_detail":       "Could not save the account",
+		"page_auth_success":             "Authorization successful",
+		"page_auth_success_heading":     "Authorization successful!",
+		"page_account":                  "Account",
+		"page_email":                    "Email",
+		"page_models":                   "Available models",
+		"page_models_count":             "%d",
+		"page_auto_close":               "This window will close in 3 seconds...",
+		"page_panel_not_found":          "Admin Panel Not Found",
+		"page_panel_not_embedded":       "The admin panel files are not embedded in this build.",
+		"page_panel_rebuild":            "Please rebuild with:",
+		"page_panel_api":                "API endpoints are available at:",
+	},
+	Chinese: {
+		"invalid_request":               "无效的请求",
+		"invalid_request_detail":        "无效的请求: %s",
+		"invalid_password":              "密码错误",
+		"unauthorized":                  "未授权",
+		"missing_api_key":               "缺少 Authorization 请求头",
+		"invalid_api_key":               "无效的 API 密钥",
+		"client_certificate_required":   "需要已映射到 API 密钥的客户端证书",
+		"invalid_simulation_input":      "rpm 和 tokens 必须为正数",
+		"anonymous_quota_exceeded":      "匿名访问的每日token额度已用完，请使用API密钥",
+		"invalid_image":                 "无法读取输入的图片",
+		"image_too_large":               "输入的图片超出大小限制",
+		"invalid_image_url":             "图片内容格式不正确",
+		"unsupported_image_url":         "图片仅支持 base64 data URL 形式",
+		"model_not_vision":              "所选模型不支持图片输入",
+		"parameter_out_of_range":        "采样参数超出该模型允许的范围",
+		"api_key_not_found":             "API 密钥不存在",
+		"key_not_found":                 "密钥不存在",
+		"key_generated":                 "密钥生成成功，请妥善保存！",
+		"failed_list_keys":              "获取密钥列表失败",
+		"failed_generate_key":           "生成密钥失败",
+		"failed_delete_key":             "删除密钥失败",
+		"key_template_not_found":        "密钥模板不存在",
+		"failed_save_key_template":      "保存密钥模板失败",
+		"api_key_expired":               "该 API 密钥已过期",
+		"model_not_allowed":             "该 API 密钥无权使用所请求的模型",
+		"conversations_disabled":        "未开启服务端会话（conversations.enabled）",
+		"invalid_conversation_id":       "conversation_id 只能包含 1-128 个字母、数字、'-' 或 '_'",
+		"conversation_id_in_use":        "该 conversation_id 已被其他 API 密钥使用",
+		"conversation_not_found":        "会话不存在",
+		"invalid_response_format":       "response_format 必须为 text、json_object，或带有 schema 对象的 json_schema",
+		"structured_output_invalid":     "模型输出不符合所要求的 JSON Schema",
+		"json_output_invalid":           "模型输出不是有效的 JSON",
+		"failed_get_stats":              "获取统计信息失败",
+		"account_not_found":             "账号不存在",
+		"invalid_account_id":            "无效的账号 ID",
+		"failed_read_accounts":          "读取账号失败",
+		"failed_parse_account":          "解析账号失败",
+		"failed_serialize_account":      "序列化账号失败",
+		"failed_save_account":           "保存账号失败",
+		"failed_delete_account":         "删除账号失败",
+		"opening_auth_window":           "正在打开授权窗口...",
+		"invalid_callback_url":          "无效的回调地址",
+		"no_code_in_callback":           "回调地址中没有授权码",
+		"failed_exchange_code":          "授权码换取令牌失败",
+		"failed_get_user_info":          "获取用户信息失败",
+		"failed_usage_history":          "获取使用历史失败",
+		"failed_refresh_history":        "获取令牌刷新历史失败",
+		"failed_refresh_models":         "刷新账号模型列表失败",
+		"notification_not_found":        "通知不存在",
+		"failed_update_notification":    "更新通知失败",
+		"failed_update_notifications":   "更新通知失败",
+		"failed_clear_notifications":    "清空通知失败",
+		"invalid_date":                  "日期无效，格式应为 YYYY-MM-DD",
+		"report_not_found":              "报告不存在",
+		"failed_list_reports":           "获取报告列表失败",
+		"failed_load_report":            "读取报告失败",
+		"failed_generate_report":        "生成报告失败",
+		"failed_save_routing":           "保存路由表失败",
+		"capture_not_found":             "抓包记录不存在",
+		"sso_disabled":                  "未启用 SSO 登录",
+		"password_login_disabled":       "已禁用密码登录，请使用 SSO 登录",
+		"forbidden_read_only":           "只读角色：该操作需要管理员角色",
+		"page_sso_failed":               "SSO 登录失败",
+		"page_sso_provider_unreachable": "无法连接身份提供方",
+		"page_sso_denied":               "登录被拒绝，或账号不在允许的用户组中",
+		"page_back_to_dashboard":        "返回管理面板",
+		"page_auth_failed":              "授权失败",
+		"page_auth_error":               "错误: %s",
+		"page_no_access_token":          "无法获取访问令牌",
+		"page_no_user_info":             "无法获取用户信息",
+		"page_save_failed":              "保存失败",
+		"page_save_failed_detail":       "无法保存账号信息",
+		"page_auth_success":             "授权成功",
+		"page_auth_success_heading":     "授权成功！",
+		"page_account":                  "账号",
+		"page_email":                    "邮箱",
+		"page_models":                   "可用模型",
+		"page_models_count":             "%d 个",
+		"page_auto_close":               "该窗口将在 3 秒后自动关闭...",
+		"page_panel_not_found":          "未找到管理面板",
+		"page_panel_not_embedded":       "当前构建未内置管理面板文件。",
+		"page_panel_rebuild":            "请使用以下命令重新构建：",
+		"page_panel_api":                "管理 API 地址：",
+	},
+}
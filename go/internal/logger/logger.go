@@ -163,6 +163,13 @@ func New(cfg config.LoggingConfig) (*zap.Logger, error) {
 		cores = append(cores, zapcore.NewCore(consoleEncoder, consoleWriter, level))
 	}
 
+	// 集中式日志投递目标
+	sinkCores, err := newSinkCores(cfg.Sinks, level, jsonEncoderConfig)
+	if err != nil {
+		return nil, err
+	}
+	cores = append(cores, sinkCores...)
+
 	// 创建 Tee core (多输出)
 	core := zapcore.NewTee(cores...)
 
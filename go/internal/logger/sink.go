@@ -0,0 +1,290 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"go.uber.org/zap/zapcore"
+)
+
+// 集中式日志投递：syslog、Loki push API 或通用 HTTP，无需额外的 sidecar 读取日志文件。
+// loki/http 在后台批量发送，发送失败或积压时丢弃日志而不阻塞请求处理。
+
+const (
+	defaultSinkBatchSize     = 100
+	defaultSinkFlushInterval = 2 * time.Second
+	// sinkBufferBatches bounds the entries held while a sink is unreachable
+	sinkBufferBatches = 10
+	lokiPushPath      = "/loki/api/v1/push"
+)
+
+// shippedEntry is one encoded log line waiting to be delivered
+type shippedEntry struct {
+	time  time.Time
+	level zapcore.Level
+	line  string
+}
+
+// sink delivers encoded log lines to one destination
+type sink interface {
+	send(entry shippedEntry)
+	// flush delivers everything buffered; called by Logger.Sync
+	flush() error
+}
+
+// sinkCore encodes entries with enc and hands them to a sink
+type sinkCore struct {
+	zapcore.LevelEnabler
+	enc  zapcore.Encoder
+	sink sink
+}
+
+func (c *sinkCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return &sinkCore{LevelEnabler: c.LevelEnabler, enc: enc, sink: c.sink}
+}
+
+func (c *sinkCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+func (c *sinkCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	line := strings.TrimSuffix(buf.String(), "\n")
+	buf.Free()
+	c.sink.send(shippedEntry{time: entry.Time, level: entry.Level, line: line})
+	return nil
+}
+
+func (c *sinkCore) Sync() error {
+	return c.sink.flush()
+}
+
+// newSinkCores builds a core per configured sink
+func newSinkCores(sinks []config.LogSinkConfig, defaultLevel zapcore.Level, encoderConfig zapcore.EncoderConfig) ([]zapcore.Core, error) {
+	var cores []zapcore.Core
+	for i, cfg := range sinks {
+		level := defaultLevel
+		if cfg.Level != "" {
+			parsed, err := zapcore.ParseLevel(cfg.Level)
+			if err != nil {
+				return nil, fmt.Errorf("logging.sinks[%d]: %w", i, err)
+			}
+			level = parsed
+		}
+
+		var s sink
+		var err error
+		switch cfg.Type {
+		case "syslog":
+			s, err = newSyslogSink(cfg)
+		case "loki":
+			s = newHTTPSink(cfg, lokiURL(cfg.URL), encodeLokiBatch(cfg.Labels))
+		case "http":
+			s = newHTTPSink(cfg, cfg.URL, encodeJSONBatch)
+		default:
+			err = fmt.Errorf("unknown type %q", cfg.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("logging.sinks[%d]: %w", i, err)
+		}
+		cores = append(cores, &sinkCore{LevelEnabler: level, enc: zapcore.NewJSONEncoder(encoderConfig), sink: s})
+	}
+	return cores, nil
+}
+
+// httpSink batches lines and POSTs them in the background
+type httpSink struct {
+	url     string
+	headers map[string]string
+	encode  func(batch []shippedEntry) (body []byte, contentType string, err error)
+	client  *http.Client
+
+	batchSize int
+	mu        sync.Mutex
+	pending   []shippedEntry
+	dropped   int
+	failing   bool
+	kick      chan struct{}
+	// sending serializes deliveries so batches arrive in order
+	sending sync.Mutex
+}
+
+func newHTTPSink(cfg config.LogSinkConfig, url string, encode func([]shippedEntry) ([]byte, string, error)) *httpSink {
+	s := &httpSink{
+		url:       url,
+		headers:   cfg.Headers,
+		encode:    encode,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		batchSize: cfg.BatchSize,
+		kick:      make(chan struct{}, 1),
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = defaultSinkBatchSize
+	}
+	interval := cfg.FlushInterval
+	if interval <= 0 {
+		interval = defaultSinkFlushInterval
+	}
+	go s.run(interval)
+	return s
+}
+
+func (s *httpSink) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.kick:
+		}
+		s.flush()
+	}
+}
+
+func (s *httpSink) send(entry shippedEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) >= s.batchSize*sinkBufferBatches {
+		s.dropped++
+		return
+	}
+	s.pending = append(s.pending, entry)
+	if len(s.pending) >= s.batchSize {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *httpSink) flush() error {
+	s.sending.Lock()
+	defer s.sending.Unlock()
+
+	for {
+		s.mu.Lock()
+		n := min(len(s.pending), s.batchSize)
+		batch := s.pending[:n:n]
+		s.pending = s.pending[n:]
+		dropped := s.dropped
+		s.dropped = 0
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		if dropped > 0 {
+			fmt.Fprintf(os.Stderr, "log sink %s: dropped %d log entries while unreachable\n", s.url, dropped)
+		}
+		if err := s.post(batch); err != nil {
+			s.reportFailure(err)
+			return err
+		}
+		s.reportFailure(nil)
+	}
+}
+
+func (s *httpSink) post(batch []shippedEntry) error {
+	body, contentType, err := s.encode(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// reportFailure writes to stderr when the sink starts or stops failing; the
+// logger itself can't be used without recursing into this sink
+func (s *httpSink) reportFailure(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err != nil && !s.failing:
+		fmt.Fprintf(os.Stderr, "log sink %s: delivery failed, entries are dropped until it recovers: %v\n", s.url, err)
+	case err == nil && s.failing:
+		fmt.Fprintf(os.Stderr, "log sink %s: delivery recovered\n", s.url)
+	}
+	s.failing = err != nil
+}
+
+// lokiURL appends the push path to a Loki base URL
+func lokiURL(base string) string {
+	base = strings.TrimSuffix(base, "/")
+	if strings.HasSuffix(base, lokiPushPath) {
+		return base
+	}
+	return base + lokiPushPath
+}
+
+// encodeLokiBatch groups lines into one stream per level
+func encodeLokiBatch(labels map[string]string) func([]shippedEntry) ([]byte, string, error) {
+	return func(batch []shippedEntry) ([]byte, string, error) {
+		type stream struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		}
+		byLevel := make(map[zapcore.Level]*stream)
+		var streams []*stream
+		for _, entry := range batch {
+			st, ok := byLevel[entry.level]
+			if !ok {
+				st = &stream{Stream: map[string]string{"app": "antigravity"}}
+				for k, v := range labels {
+					st.Stream[k] = v
+				}
+				st.Stream["level"] = entry.level.String()
+				byLevel[entry.level] = st
+				streams = append(streams, st)
+			}
+			st.Values = append(st.Values, [2]string{strconv.FormatInt(entry.time.UnixNano(), 10), entry.line})
+		}
+		body, err := json.Marshal(map[string]interface{}{"streams": streams})
+		return body, "application/json", err
+	}
+}
+
+// encodeJSONBatch sends the batch as a JSON array of log objects
+func encodeJSONBatch(batch []shippedEntry) ([]byte, string, error) {
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i, entry := range batch {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(entry.line)
+	}
+	b.WriteByte(']')
+	return b.Bytes(), "application/json", nil
+}
@@ -0,0 +1,56 @@
+//go:build !windows && !plan9
+
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"go.uber.org/zap/zapcore"
+)
+
+// syslogSink writes each line with the syslog severity of its level
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink(cfg config.LogSinkConfig) (sink, error) {
+	var network, addr string
+	if cfg.URL != "" {
+		u, err := url.Parse(cfg.URL)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, fmt.Errorf("invalid syslog url %q (expected udp://host:port or tcp://host:port)", cfg.URL)
+		}
+		network, addr = u.Scheme, u.Host
+	}
+	tag := cfg.Tag
+	if tag == "" {
+		tag = "antigravity"
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog: %w", err)
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) send(entry shippedEntry) {
+	switch {
+	case entry.level >= zapcore.DPanicLevel:
+		s.w.Crit(entry.line)
+	case entry.level == zapcore.ErrorLevel:
+		s.w.Err(entry.line)
+	case entry.level == zapcore.WarnLevel:
+		s.w.Warning(entry.line)
+	case entry.level == zapcore.InfoLevel:
+		s.w.Info(entry.line)
+	default:
+		s.w.Debug(entry.line)
+	}
+}
+
+func (s *syslogSink) flush() error {
+	return nil
+}
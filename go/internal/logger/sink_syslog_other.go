@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package logger
+
+import (
+	"errors"
+
+	"github.com/antigravity/api-proxy/internal/config"
+)
+
+func newSyslogSink(config.LogSinkConfig) (sink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,105 @@
+package logger
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// collector records the request bodies a sink posts
+type collector struct {
+	mu      sync.Mutex
+	bodies  [][]byte
+	headers []http.Header
+}
+
+func (c *collector) server(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		c.mu.Lock()
+		c.bodies = append(c.bodies, body)
+		c.headers = append(c.headers, r.Header.Clone())
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newSinkLogger(t *testing.T, sinks ...config.LogSinkConfig) *zap.Logger {
+	cores, err := newSinkCores(sinks, zap.InfoLevel, zap.NewProductionEncoderConfig())
+	require.NoError(t, err)
+	require.Len(t, cores, len(sinks))
+	return zap.New(cores[0])
+}
+
+func TestLokiSink_PushesStreamsPerLevel(t *testing.T) {
+	var got collector
+	srv := got.server(t)
+
+	log := newSinkLogger(t, config.LogSinkConfig{
+		Type:    "loki",
+		URL:     srv.URL,
+		Labels:  map[string]string{"env": "prod"},
+		Headers: map[string]string{"X-Scope-OrgID": "tenant-1"},
+	})
+	log.Info("started", zap.Int("port", 8045))
+	log.Warn("slow upstream")
+	log.Debug("below the sink level")
+	require.NoError(t, log.Sync())
+
+	require.Len(t, got.bodies, 1)
+	assert.Equal(t, "tenant-1", got.headers[0].Get("X-Scope-OrgID"))
+	var push struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	require.NoError(t, json.Unmarshal(got.bodies[0], &push))
+	require.Len(t, push.Streams, 2)
+	assert.Equal(t, map[string]string{"app": "antigravity", "env": "prod", "level": "info"}, push.Streams[0].Stream)
+	assert.Equal(t, "warn", push.Streams[1].Stream["level"])
+	require.Len(t, push.Streams[0].Values, 1)
+	assert.Contains(t, push.Streams[0].Values[0][1], `"msg":"started"`)
+	assert.Contains(t, push.Streams[0].Values[0][1], `"port":8045`)
+}
+
+func TestHTTPSink_BatchesInBackground(t *testing.T) {
+	var got collector
+	srv := got.server(t)
+
+	log := newSinkLogger(t, config.LogSinkConfig{Type: "http", URL: srv.URL, BatchSize: 2, FlushInterval: time.Hour})
+	log.Info("one")
+	log.With(zap.String("request_id", "abc")).Info("two")
+
+	// A full batch is sent without waiting for the flush interval
+	require.Eventually(t, func() bool {
+		got.mu.Lock()
+		defer got.mu.Unlock()
+		return len(got.bodies) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	var entries []map[string]interface{}
+	require.NoError(t, json.Unmarshal(got.bodies[0], &entries))
+	require.Len(t, entries, 2)
+	assert.Equal(t, "one", entries[0]["msg"])
+	assert.Equal(t, "abc", entries[1]["request_id"])
+}
+
+func TestNewSinkCores_RejectsInvalidSinks(t *testing.T) {
+	_, err := newSinkCores([]config.LogSinkConfig{{Type: "http", URL: "http://x", Level: "loud"}}, zap.InfoLevel, zap.NewProductionEncoderConfig())
+	assert.ErrorContains(t, err, "logging.sinks[0]")
+
+	_, err = newSinkCores([]config.LogSinkConfig{{Type: "syslog", URL: "ftp://logs"}}, zap.InfoLevel, zap.NewProductionEncoderConfig())
+	assert.Error(t, err)
+}
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // Account represents a user account with OAuth tokens
@@ -19,13 +21,25 @@ type Account struct {
 	RefreshStatus string           `json:"refreshStatus,omitempty"`
 	Usage         *UsageStats      `json:"usage,omitempty"`
 	ErrorTracking *ErrorTracking   `json:"errorTracking,omitempty"`
+
+	// ModelsUpdatedAt is when Models was last fetched (Unix ms); the list is only re-fetched when stale
+	ModelsUpdatedAt int64 `json:"modelsUpdatedAt,omitempty"`
+
+	// Proxy is the outbound proxy URL for this account's refresh and generate
+	// calls; empty means the global proxy.upstream.proxy_url
+	Proxy string `json:"proxy,omitempty"`
+
+	// Headers are extra headers for this account's Cloud Code requests; they
+	// override proxy.upstream.headers by name
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 // Model represents an AI model
 type Model struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	OwnedBy string `json:"owned_by"`
+	ID           string             `json:"id"`
+	Object       string             `json:"object"`
+	OwnedBy      string             `json:"owned_by"`
+	Capabilities *ModelCapabilities `json:"capabilities,omitempty"`
 }
 
 // UsageStats tracks account usage
@@ -46,6 +60,9 @@ type ErrorTracking struct {
 	RateLimitCount      int    `json:"rateLimitCount,omitempty"`
 	RateLimitBackoff    int64  `json:"rateLimitBackoff,omitempty"`
 	IsPermissionDenied  bool   `json:"isPermissionDenied,omitempty"`
+	// NeedsReauth is set when Google rejected the refresh token (invalid_grant);
+	// only a new login clears it
+	NeedsReauth bool `json:"needsReauth,omitempty"`
 }
 
 // IsExpired checks if the access token is expired
@@ -57,6 +74,34 @@ func (a *Account) IsExpired() bool {
 	return time.Now().After(expiryTime)
 }
 
+// ExpiresAt returns when the access token expires (zero time if unknown)
+func (a *Account) ExpiresAt() time.Time {
+	if a.Timestamp == 0 || a.ExpiresIn == 0 {
+		return time.Time{}
+	}
+	return time.Unix(a.Timestamp/1000, 0).Add(time.Duration(a.ExpiresIn) * time.Second)
+}
+
+// Clone returns a copy that can be mutated without affecting the original
+func (a *Account) Clone() *Account {
+	clone := *a
+	if a.Models != nil {
+		clone.Models = make(map[string]Model, len(a.Models))
+		for id, m := range a.Models {
+			clone.Models[id] = m
+		}
+	}
+	if a.Usage != nil {
+		usage := *a.Usage
+		clone.Usage = &usage
+	}
+	if a.ErrorTracking != nil {
+		tracking := *a.ErrorTracking
+		clone.ErrorTracking = &tracking
+	}
+	return &clone
+}
+
 // IsInCooldown checks if account is in error cooldown
 func (a *Account) IsInCooldown() bool {
 	if a.ErrorTracking == nil || a.ErrorTracking.FailedUntil == nil {
@@ -65,10 +110,16 @@ func (a *Account) IsInCooldown() bool {
 	return time.Now().Unix() < *a.ErrorTracking.FailedUntil
 }
 
+// NeedsReauth reports whether the refresh token was revoked or expired, so the
+// account must log in again before its access token runs out
+func (a *Account) NeedsReauth() bool {
+	return a.ErrorTracking != nil && a.ErrorTracking.NeedsReauth
+}
+
 // NeedsRefresh checks if account needs token refresh
 func (a *Account) NeedsRefresh() bool {
-	// 如果禁用或在冷却期，不刷新
-	if !a.Enable || a.IsInCooldown() {
+	// 如果禁用、在冷却期或需要重新登录，不刷新
+	if !a.Enable || a.IsInCooldown() || a.NeedsReauth() {
 		return false
 	}
 	// 如果还有30分钟就过期，需要刷新
@@ -81,18 +132,29 @@ func (a *Account) NeedsRefresh() bool {
 
 // RecordSuccess updates account status on successful operation
 func (a *Account) RecordSuccess() {
-	a.RefreshStatus = "success"
 	a.LastRefresh = time.Now().UnixMilli()
 	if a.ErrorTracking == nil {
 		a.ErrorTracking = &ErrorTracking{}
 	}
 	a.ErrorTracking.ConsecutiveFailures = 0
-	a.ErrorTracking.LastError = ""
-	a.ErrorTracking.LastErrorTime = nil
 	a.ErrorTracking.FailedUntil = nil
 	// Reset rate limit tracking on success
 	a.ErrorTracking.RateLimitCount = 0
 	a.ErrorTracking.RateLimitBackoff = 0
+	// 刷新令牌失效后，access token 仍可用到过期；请求成功不代表可以继续刷新
+	if a.ErrorTracking.NeedsReauth {
+		return
+	}
+	a.RefreshStatus = "success"
+	a.ErrorTracking.LastError = ""
+	a.ErrorTracking.LastErrorTime = nil
+}
+
+// ClearNeedsReauth resets the needs-reauth flag after a new login
+func (a *Account) ClearNeedsReauth() {
+	if a.ErrorTracking != nil {
+		a.ErrorTracking.NeedsReauth = false
+	}
 }
 
 // RecordFailure updates account status on failed operation
@@ -146,6 +208,20 @@ func (a *Account) RecordPermissionDenied() {
 	a.ErrorTracking.LastErrorTime = &now
 }
 
+// RecordNeedsReauth marks the refresh token as unusable (invalid_grant).
+// Unlike RecordFailure there is no cooldown: retrying cannot succeed, so the
+// account is left out of refreshes until it logs in again.
+func (a *Account) RecordNeedsReauth(err string) {
+	a.RefreshStatus = "needs_reauth"
+	if a.ErrorTracking == nil {
+		a.ErrorTracking = &ErrorTracking{}
+	}
+	a.ErrorTracking.NeedsReauth = true
+	a.ErrorTracking.LastError = err
+	now := time.Now().Unix()
+	a.ErrorTracking.LastErrorTime = &now
+}
+
 // RecordUsage updates usage statistics
 func (a *Account) RecordUsage(inputTokens, outputTokens int64) {
 	if a.Usage == nil {
@@ -158,3 +234,31 @@ func (a *Account) RecordUsage(inputTokens, outputTokens int64) {
 	now := time.Now().UnixMilli()
 	a.Usage.LastUsed = &now
 }
+
+// MaskEmail hides the local part of an address except its first character
+// ("foo@gmail.com" → "f***@gmail.com")
+func MaskEmail(email string) string {
+	if email == "" {
+		return ""
+	}
+	local, domain, found := strings.Cut(email, "@")
+	masked := "***"
+	if first, _ := utf8.DecodeRuneInString(local); local != "" {
+		masked = string(first) + masked
+	}
+	if found {
+		masked += "@" + domain
+	}
+	return masked
+}
+
+// MaskAccountID masks the address in an ID derived from the account email
+// ("foo@gmail.com_1a2b3c4d" → "f***@gmail.com_1a2b3c4d"); opaque IDs are
+// returned unchanged. The random suffix keeps masked IDs distinct.
+func MaskAccountID(id string) string {
+	i := strings.LastIndex(id, "_")
+	if i < 0 || !strings.Contains(id[:i], "@") {
+		return id
+	}
+	return MaskEmail(id[:i]) + id[i:]
+}
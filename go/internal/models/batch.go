@@ -0,0 +1,96 @@
+package models
+
+// Batch statuses (OpenAI Batch API)
+const (
+	BatchValidating = "validating"
+	BatchFailed     = "failed"
+	BatchInProgress = "in_progress"
+	BatchFinalizing = "finalizing"
+	BatchCompleted  = "completed"
+	BatchExpired    = "expired"
+	BatchCancelling = "cancelling"
+	BatchCancelled  = "cancelled"
+)
+
+// FileObject is an uploaded or generated file (OpenAI Files API)
+type FileObject struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"` // "file"
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"` // "batch" for inputs, "batch_output" for results
+}
+
+// Batch is an asynchronous group of chat completion requests (OpenAI Batch API)
+type Batch struct {
+	ID               string             `json:"id"`
+	Object           string             `json:"object"` // "batch"
+	Endpoint         string             `json:"endpoint"`
+	Errors           *BatchErrors       `json:"errors"`
+	InputFileID      string             `json:"input_file_id"`
+	CompletionWindow string             `json:"completion_window"`
+	Status           string             `json:"status"`
+	OutputFileID     *string            `json:"output_file_id"`
+	ErrorFileID      *string            `json:"error_file_id"`
+	CreatedAt        int64              `json:"created_at"`
+	InProgressAt     *int64             `json:"in_progress_at"`
+	ExpiresAt        int64              `json:"expires_at"`
+	FinalizingAt     *int64             `json:"finalizing_at"`
+	CompletedAt      *int64             `json:"completed_at"`
+	FailedAt         *int64             `json:"failed_at"`
+	ExpiredAt        *int64             `json:"expired_at"`
+	CancellingAt     *int64             `json:"cancelling_at"`
+	CancelledAt      *int64             `json:"cancelled_at"`
+	RequestCounts    BatchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string  `json:"metadata,omitempty"`
+}
+
+// BatchRequestCounts tracks per-request progress of a batch
+type BatchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// BatchErrors lists input validation errors of a failed batch
+type BatchErrors struct {
+	Object string       `json:"object"` // "list"
+	Data   []BatchError `json:"data"`
+}
+
+// BatchError is one validation error
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    *int   `json:"line,omitempty"`
+}
+
+// BatchRequestLine is one line of a batch input file
+type BatchRequestLine struct {
+	CustomID string                 `json:"custom_id"`
+	Method   string                 `json:"method"`
+	URL      string                 `json:"url"`
+	Body     map[string]interface{} `json:"body"`
+}
+
+// BatchResponseLine is one line of a batch output or error file
+type BatchResponseLine struct {
+	ID       string              `json:"id"`
+	CustomID string              `json:"custom_id"`
+	Response *BatchResponse      `json:"response"`
+	Error    *BatchResponseError `json:"error"`
+}
+
+// BatchResponse is the HTTP result of one batch request
+type BatchResponse struct {
+	StatusCode int         `json:"status_code"`
+	RequestID  string      `json:"request_id"`
+	Body       interface{} `json:"body"`
+}
+
+// BatchResponseError describes a batch request that produced no response
+type BatchResponseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
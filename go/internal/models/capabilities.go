@@ -0,0 +1,46 @@
+package models
+
+import "strings"
+
+// Thinking config styles
+const (
+	ThinkingStyleBudget = "budget" // thinkingBudget tokens (Gemini 2.5 and earlier)
+	ThinkingStyleLevel  = "level"  // thinkingLevel low/high (Gemini 3+)
+)
+
+// ModelCapabilities drives how requests for a model are translated
+type ModelCapabilities struct {
+	Thinking        bool   `json:"thinking"`                  // Thinking is enabled by default
+	ThinkingStyle   string `json:"thinkingStyle,omitempty"`   // ThinkingStyleBudget or ThinkingStyleLevel
+	ThinkingBudget  int    `json:"thinkingBudget,omitempty"`  // Default budget for ThinkingStyleBudget
+	Vision          bool   `json:"vision"`                    // Accepts image input
+	Tools           bool   `json:"tools"`                     // Accepts function declarations
+	MaxOutputTokens int    `json:"maxOutputTokens,omitempty"` // 0 = unknown
+	MaxInputTokens  int    `json:"maxInputTokens,omitempty"`  // Context window; 0 = unknown
+}
+
+// defaultThinkingBudget is used when metadata doesn't specify one
+const defaultThinkingBudget = 8192
+
+// defaultContextWindow is the input token limit of current Gemini models
+const defaultContextWindow = 1048576
+
+// DefaultCapabilities guesses capabilities from the model name.
+// Only used when the upstream model list carries no metadata for the model.
+func DefaultCapabilities(modelID string) ModelCapabilities {
+	caps := ModelCapabilities{
+		Thinking:       modelID == "gemini-2.5-pro" || strings.HasPrefix(modelID, "gemini-3-pro-"),
+		ThinkingStyle:  ThinkingStyleBudget,
+		ThinkingBudget: defaultThinkingBudget,
+		Vision:         true,
+		Tools:          true,
+	}
+	if strings.HasPrefix(modelID, "gemini-") {
+		caps.MaxInputTokens = defaultContextWindow
+	}
+	if strings.HasPrefix(modelID, "gemini-3-") {
+		caps.ThinkingStyle = ThinkingStyleLevel
+		caps.ThinkingBudget = 0
+	}
+	return caps
+}
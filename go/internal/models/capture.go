@@ -0,0 +1,31 @@
+package models
+
+// CaptureRecord is a full recording of one proxied request for debugging
+type CaptureRecord struct {
+	ID         string           `json:"id"`
+	Key        string           `json:"key"` // Masked API key
+	Path       string           `json:"path"`
+	Model      string           `json:"model"`
+	Stream     bool             `json:"stream"`
+	StartedAt  int64            `json:"startedAt"`
+	DurationMs int64            `json:"durationMs"`
+	Attempts   []CaptureAttempt `json:"attempts"`
+	// ClientResponse is exactly what was written to the client
+	ClientResponse string `json:"clientResponse"`
+	Truncated      bool   `json:"truncated"` // A stream exceeded the capture size limit
+}
+
+// CaptureAttempt records one upstream attempt of a captured request
+type CaptureAttempt struct {
+	AccountID string `json:"accountId"`
+	Request   string `json:"request"`
+	Status    int    `json:"status"`
+	Response  string `json:"response"` // Raw upstream body (SSE for streams)
+	Error     string `json:"error,omitempty"`
+}
+
+// CaptureTrigger arms recording of the next Remaining requests made with a key
+type CaptureTrigger struct {
+	Key       string `json:"key"` // Masked API key
+	Remaining int    `json:"remaining"`
+}
@@ -0,0 +1,10 @@
+package models
+
+// Conversation is the server-side message history kept under a client-chosen ID
+type Conversation struct {
+	ID        string                  `json:"id"`
+	Object    string                  `json:"object"` // always "conversation"
+	Messages  []ChatCompletionMessage `json:"messages"`
+	CreatedAt int64                   `json:"created_at"`
+	UpdatedAt int64                   `json:"updated_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "encoding/json"
+
+// GoogleRawRequest wraps a native Gemini request body for the Cloud Code API
+// without decoding it, so fields the proxy doesn't model are preserved
+type GoogleRawRequest struct {
+	Project   string          `json:"project"`
+	RequestID string          `json:"requestId"`
+	Request   json.RawMessage `json:"request"`
+	Model     string          `json:"model"`
+	UserAgent string          `json:"userAgent"`
+}
+
+// GoogleRawResponse is a Cloud Code response envelope whose inner native
+// Gemini response is kept as raw JSON
+type GoogleRawResponse struct {
+	Response json.RawMessage `json:"response"`
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"fmt"
+	"slices"
 	"time"
 )
 
@@ -12,6 +14,30 @@ type APIKey struct {
 	CreatedAt  int64      `json:"createdAt"`
 	LastUsed   *int64     `json:"lastUsed,omitempty"`
 	UsageCount int64      `json:"usageCount"`
+	// Static keys come from config.yaml and are never written to the key store
+	Static bool `json:"static,omitempty"`
+	// Models restricts the key to these model names; empty allows every model
+	Models []string `json:"models,omitempty"`
+	// ExpiresAt is the expiry time in Unix milliseconds; nil never expires
+	ExpiresAt *int64 `json:"expiresAt,omitempty"`
+	// Template is the template the key was created from
+	Template string `json:"template,omitempty"`
+	// ContextTrimming is the key's policy for prompts that exceed the model's
+	// context window; empty forwards them unchanged (upstream rejects them)
+	ContextTrimming string `json:"contextTrimming,omitempty"`
+}
+
+// Context trimming policies
+const (
+	// ContextTrimmingTruncate drops the oldest non-system messages
+	ContextTrimmingTruncate = "truncate"
+	// ContextTrimmingSummarize replaces the oldest messages with a summary from a cheap model
+	ContextTrimmingSummarize = "summarize"
+)
+
+// ValidContextTrimming reports whether policy is empty or a known policy
+func ValidContextTrimming(policy string) bool {
+	return policy == "" || policy == ContextTrimmingTruncate || policy == ContextTrimmingSummarize
 }
 
 // RateLimit defines rate limiting for an API key
@@ -35,3 +61,70 @@ func (k *APIKey) UpdateUsage() {
 	k.LastUsed = &now
 	k.UsageCount++
 }
+
+// Expired reports whether the key is past its expiry time
+func (k *APIKey) Expired() bool {
+	return k.ExpiresAt != nil && time.Now().UnixMilli() >= *k.ExpiresAt
+}
+
+// AllowsModel reports whether the key may use model
+func (k *APIKey) AllowsModel(model string) bool {
+	return len(k.Models) == 0 || slices.Contains(k.Models, model)
+}
+
+// KeyTemplate is a named bundle of key settings so operators issue consistent keys
+type KeyTemplate struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	RateLimit   *RateLimit `json:"rateLimit,omitempty"`
+	Models      []string   `json:"models,omitempty"`
+	// ExpiresInDays sets the key's expiry relative to its creation; 0 never expires
+	ExpiresInDays int `json:"expiresInDays,omitempty"`
+	// ContextTrimming is copied to the key, see APIKey.ContextTrimming
+	ContextTrimming string `json:"contextTrimming,omitempty"`
+}
+
+// Validate rejects templates without a name or with negative limits
+func (t *KeyTemplate) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if t.ExpiresInDays < 0 {
+		return fmt.Errorf("expiresInDays must not be negative")
+	}
+	if rl := t.RateLimit; rl != nil && rl.Enabled && (rl.MaxRequests <= 0 || rl.WindowMs <= 0) {
+		return fmt.Errorf("rateLimit: maxRequests and windowMs must be positive")
+	}
+	if !ValidContextTrimming(t.ContextTrimming) {
+		return fmt.Errorf("contextTrimming must be truncate or summarize")
+	}
+	for _, model := range t.Models {
+		if model == "" {
+			return fmt.Errorf("models: names must not be empty")
+		}
+	}
+	return nil
+}
+
+// DefaultKeyTemplates are offered until the operator saves their own
+func DefaultKeyTemplates() []KeyTemplate {
+	return []KeyTemplate{
+		{
+			Name:          "trial",
+			Description:   "Short-lived evaluation key: flash model only, 20 requests per minute, expires after 7 days",
+			RateLimit:     &RateLimit{Enabled: true, MaxRequests: 20, WindowMs: 60000},
+			Models:        []string{"gemini-2.5-flash"},
+			ExpiresInDays: 7,
+		},
+		{
+			Name:        "internal",
+			Description: "Internal services: all models, no limits, never expires",
+		},
+		{
+			Name:          "partner",
+			Description:   "External partners: all models, 300 requests per minute, expires after 90 days",
+			RateLimit:     &RateLimit{Enabled: true, MaxRequests: 300, WindowMs: 60000},
+			ExpiresInDays: 90,
+		},
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+// Notification kinds
+const (
+	NotifyAccountDisabled = "account_disabled"
+	NotifyRefreshFailed   = "refresh_failed"
+	NotifyPoolExhausted   = "pool_exhausted"
+	NotifyBudgetExceeded  = "budget_exceeded"
+	NotifyResourceLow     = "resource_low"
+	NotifyNeedsReauth     = "needs_reauth"
+)
+
+// Notification is a system event shown in the admin notification center
+type Notification struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	AccountID string `json:"accountId,omitempty"`
+	Message   string `json:"message"`
+	Count     int    `json:"count"` // Repeats folded into this entry while unread
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
+	Read      bool   `json:"read"`
+}
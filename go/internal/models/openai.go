@@ -5,14 +5,49 @@ type ChatCompletionRequest struct {
 	Model            string                  `json:"model"`
 	Messages         []ChatCompletionMessage `json:"messages"`
 	Stream           bool                    `json:"stream,omitempty"`
-	MaxTokens        int                     `json:"max_tokens,omitempty"`
-	Temperature      float64                 `json:"temperature,omitempty"`
-	TopP             float64                 `json:"top_p,omitempty"`
-	TopK             int                     `json:"top_k,omitempty"` // Google specific
+	// Sampling parameters are pointers so an explicit 0 (e.g. greedy temperature 0)
+	// is forwarded instead of being mistaken for "unset"
+	MaxTokens        *int                    `json:"max_tokens,omitempty"`
+	Temperature      *float64                `json:"temperature,omitempty"`
+	TopP             *float64                `json:"top_p,omitempty"`
+	TopK             *int                    `json:"top_k,omitempty"` // Google specific
 	Tools            []Tool                  `json:"tools,omitempty"`
 	ToolChoice       interface{}             `json:"tool_choice,omitempty"`
 	FrequencyPenalty float64                 `json:"frequency_penalty,omitempty"`
 	PresencePenalty  float64                 `json:"presence_penalty,omitempty"`
+	User             string                  `json:"user,omitempty"`       // End-user identifier for abuse attribution
+	Metadata         map[string]string       `json:"metadata,omitempty"`   // Caller-supplied tags, recorded in logs
+	ExtraBody        map[string]interface{}  `json:"extra_body,omitempty"` // Provider-specific options
+	Google           map[string]interface{}  `json:"google,omitempty"`     // Gemini-specific options (same as extra_body.google)
+	Modalities       []string                `json:"modalities,omitempty"` // Output types, e.g. ["text", "image"]
+	StreamOptions    *StreamOptions          `json:"stream_options,omitempty"`
+	Stop             interface{}             `json:"stop,omitempty"`    // string or []string; replaces the default stop sequences
+	Timeout          float64                 `json:"timeout,omitempty"` // Request budget in seconds; overrides X-Request-Timeout
+	// ConversationID continues a server-side conversation: messages holds only the new turn
+	ConversationID string `json:"conversation_id,omitempty"`
+	// ResponseFormat requests JSON output, optionally constrained by a schema
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+// ResponseFormat is OpenAI's response_format: "text", "json_object" or "json_schema"
+type ResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+// JSONSchemaFormat describes the schema of a json_schema response format.
+// With Strict set the proxy validates the output against Schema before returning it.
+type JSONSchemaFormat struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Schema      map[string]interface{} `json:"schema,omitempty"`
+	Strict      bool                   `json:"strict,omitempty"`
+}
+
+// StreamOptions configures streaming responses
+type StreamOptions struct {
+	// IncludeUsage adds a final chunk with empty choices and the token usage
+	IncludeUsage bool `json:"include_usage,omitempty"`
 }
 
 type ChatCompletionMessage struct {
@@ -22,18 +57,71 @@ type ChatCompletionMessage struct {
 	Name       string      `json:"name,omitempty"`
 	ToolCalls  []ToolCall  `json:"tool_calls,omitempty"`
 	ToolCallID string      `json:"tool_call_id,omitempty"`
+	Images     []ImagePart `json:"images,omitempty"` // Generated images (image-capable models)
+	// Annotations cite the web sources used by search-grounded answers
+	Annotations []Annotation `json:"annotations,omitempty"`
+	// ReasoningContent is the reasoning_content name some clients use for Reasoning
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+	// ReasoningSignature is Gemini's opaque thought signature; clients send it
+	// back with the message so the model can resume its reasoning
+	ReasoningSignature string `json:"reasoning_signature,omitempty"`
+}
+
+// Annotation is an OpenAI-style url_citation built from Gemini grounding metadata
+type Annotation struct {
+	Type        string      `json:"type"` // always "url_citation"
+	URLCitation URLCitation `json:"url_citation"`
+}
+
+// URLCitation points at a source for the content between StartIndex and EndIndex.
+// Indices are Gemini segment offsets (UTF-8 bytes of the answer text).
+type URLCitation struct {
+	URL        string `json:"url"`
+	Title      string `json:"title,omitempty"`
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+	Snippet    string `json:"snippet,omitempty"` // Supported answer text
+}
+
+// ImagePart is a generated image returned as a base64 data URL
+type ImagePart struct {
+	Type     string   `json:"type"` // always "image_url"
+	ImageURL ImageURL `json:"image_url"`
+}
+
+// NewImagePart builds an ImagePart from Gemini inline data
+func NewImagePart(mimeType, data string) ImagePart {
+	return ImagePart{
+		Type:     "image_url",
+		ImageURL: ImageURL{URL: "data:" + mimeType + ";base64," + data},
+	}
 }
 
 type ContentPart struct {
-	Type     string    `json:"type"`
-	Text     string    `json:"text,omitempty"`
-	ImageURL *ImageURL `json:"image_url,omitempty"`
+	Type       string      `json:"type"`
+	Text       string      `json:"text,omitempty"`
+	ImageURL   *ImageURL   `json:"image_url,omitempty"`
+	InputAudio *InputAudio `json:"input_audio,omitempty"`
+	File       *FileInput  `json:"file,omitempty"`
 }
 
 type ImageURL struct {
 	URL string `json:"url"`
 }
 
+// FileInput is a document attachment; FileData is a data URL or raw base64
+type FileInput struct {
+	FileData string `json:"file_data,omitempty"`
+	FileID   string `json:"file_id,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// InputAudio is base64-encoded audio input (format: wav, mp3, ...)
+type InputAudio struct {
+	Data   string `json:"data"`
+	Format string `json:"format"`
+}
+
 type Tool struct {
 	Type     string   `json:"type"`
 	Function Function `json:"function"`
@@ -45,10 +133,20 @@ type Function struct {
 	Parameters  interface{} `json:"parameters"`
 }
 
+// ToolCall is a function call made by the assistant. In stream chunks it is
+// split into deltas: the first one for an Index carries ID, Type and the
+// function name, later ones only more Arguments text.
 type ToolCall struct {
-	ID       string   `json:"id"`
-	Type     string   `json:"type"`
-	Function Function `json:"function"`
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall names the called function; Arguments is a JSON object as a string
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
 }
 
 // OpenAI Chat Completion Response
@@ -60,6 +158,8 @@ type ChatCompletionResponse struct {
 	Choices           []ChatCompletionChoice `json:"choices"`
 	Usage             *Usage                 `json:"usage,omitempty"`
 	SystemFingerprint string                 `json:"system_fingerprint,omitempty"`
+	// Error explains a partial answer (finish_reason "error")
+	Error *ErrorDetail `json:"error,omitempty"`
 }
 
 type ChatCompletionChoice struct {
@@ -82,6 +182,7 @@ type ChatCompletionChunk struct {
 	Model             string                      `json:"model"`
 	SystemFingerprint string                      `json:"system_fingerprint,omitempty"`
 	Choices           []ChatCompletionChunkChoice `json:"choices"`
+	Usage             *Usage                      `json:"usage,omitempty"` // Final chunk only, with stream_options.include_usage
 }
 
 type ChatCompletionChunkChoice struct {
@@ -91,10 +192,15 @@ type ChatCompletionChunkChoice struct {
 }
 
 type ChatCompletionDelta struct {
-	Role      string     `json:"role,omitempty"`
-	Content   string     `json:"content,omitempty"`
-	Reasoning string     `json:"reasoning,omitempty"` // Custom field for thinking models
-	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	Role      string      `json:"role,omitempty"`
+	Content   string      `json:"content,omitempty"`
+	Reasoning string      `json:"reasoning,omitempty"` // Custom field for thinking models
+	ToolCalls []ToolCall  `json:"tool_calls,omitempty"`
+	Images    []ImagePart `json:"images,omitempty"`
+	// Annotations are sent in a final chunk once grounding metadata arrives
+	Annotations []Annotation `json:"annotations,omitempty"`
+	// ReasoningSignature carries the thought signature of the part it arrived with
+	ReasoningSignature string `json:"reasoning_signature,omitempty"`
 }
 
 // Google Cloud Code API Request Structures (Internal)
@@ -113,6 +219,8 @@ type GoogleInner struct {
 	SystemInstruction *GoogleSystemInstruction `json:"systemInstruction,omitempty"`
 	Tools             []GoogleTool             `json:"tools,omitempty"`
 	ToolConfig        *GoogleToolConfig        `json:"toolConfig,omitempty"`
+	SafetySettings    []interface{}            `json:"safetySettings,omitempty"`
+	CachedContent     string                   `json:"cachedContent,omitempty"`
 }
 
 type GoogleContent struct {
@@ -123,9 +231,12 @@ type GoogleContent struct {
 type GooglePart struct {
 	Text             string                  `json:"text,omitempty"`
 	InlineData       *GoogleInlineData       `json:"inlineData,omitempty"`
+	FileData         *GoogleFileData         `json:"fileData,omitempty"`
 	FunctionCall     *GoogleFunctionCall     `json:"functionCall,omitempty"`
 	FunctionResponse *GoogleFunctionResponse `json:"functionResponse,omitempty"`
 	Thought          bool                    `json:"thought,omitempty"` // Check if this field exists
+	// ThoughtSignature is the encrypted reasoning state sent back on later turns
+	ThoughtSignature string `json:"thoughtSignature,omitempty"`
 }
 
 type GoogleInlineData struct {
@@ -133,6 +244,12 @@ type GoogleInlineData struct {
 	Data     string `json:"data"`
 }
 
+// GoogleFileData references a document by URI instead of inlining it
+type GoogleFileData struct {
+	MimeType string `json:"mimeType"`
+	FileURI  string `json:"fileUri"`
+}
+
 type GoogleFunctionCall struct {
 	ID   string                 `json:"id"`
 	Name string                 `json:"name"`
@@ -153,6 +270,12 @@ type GoogleGenerationConfig struct {
 	MaxOutputTokens *int                 `json:"maxOutputTokens,omitempty"`
 	StopSequences  []string              `json:"stopSequences,omitempty"`
 	ThinkingConfig *GoogleThinkingConfig `json:"thinkingConfig,omitempty"`
+	// ResponseModalities selects output types, e.g. ["TEXT", "IMAGE"]
+	ResponseModalities []string `json:"responseModalities,omitempty"`
+	// ResponseMimeType "application/json" makes the model answer with JSON only
+	ResponseMimeType string `json:"responseMimeType,omitempty"`
+	// ResponseJSONSchema constrains JSON output to a (standard) JSON Schema
+	ResponseJSONSchema map[string]interface{} `json:"responseJsonSchema,omitempty"`
 }
 
 type GoogleThinkingConfig struct {
@@ -167,9 +290,13 @@ type GoogleSystemInstruction struct {
 }
 
 type GoogleTool struct {
-	FunctionDeclarations []GoogleFunctionDeclaration `json:"functionDeclarations"`
+	FunctionDeclarations []GoogleFunctionDeclaration `json:"functionDeclarations,omitempty"`
+	GoogleSearch         *GoogleSearch               `json:"googleSearch,omitempty"`
 }
 
+// GoogleSearch enables search grounding; it has no options
+type GoogleSearch struct{}
+
 type GoogleFunctionDeclaration struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description,omitempty"`
@@ -190,13 +317,140 @@ type GoogleResponse struct {
 }
 
 type GoogleResponseInner struct {
-	Candidates    []GoogleCandidate `json:"candidates"`
-	UsageMetadata *GoogleUsage      `json:"usageMetadata,omitempty"`
+	Candidates     []GoogleCandidate     `json:"candidates"`
+	UsageMetadata  *GoogleUsage          `json:"usageMetadata,omitempty"`
+	PromptFeedback *GooglePromptFeedback `json:"promptFeedback,omitempty"`
+}
+
+// GooglePromptFeedback is set when the prompt itself was blocked; no candidates are returned then
+type GooglePromptFeedback struct {
+	BlockReason        string               `json:"blockReason,omitempty"`
+	BlockReasonMessage string               `json:"blockReasonMessage,omitempty"`
+	SafetyRatings      []GoogleSafetyRating `json:"safetyRatings,omitempty"`
+}
+
+// GoogleSafetyRating is the probability that content falls into a harm category
+type GoogleSafetyRating struct {
+	Category    string `json:"category"`    // e.g. HARM_CATEGORY_HATE_SPEECH
+	Probability string `json:"probability"` // NEGLIGIBLE, LOW, MEDIUM or HIGH
+	Blocked     bool   `json:"blocked,omitempty"`
 }
 
 type GoogleCandidate struct {
-	Content      GoogleContent `json:"content"`
-	FinishReason string        `json:"finishReason"`
+	Index             int                      `json:"index,omitempty"`
+	Content           GoogleContent            `json:"content"`
+	FinishReason      string                   `json:"finishReason"`
+	GroundingMetadata *GoogleGroundingMetadata `json:"groundingMetadata,omitempty"`
+	CitationMetadata  *GoogleCitationMetadata  `json:"citationMetadata,omitempty"`
+	SafetyRatings     []GoogleSafetyRating     `json:"safetyRatings,omitempty"`
+	AvgLogprobs       float64                  `json:"avgLogprobs,omitempty"` // Mean token log probability
+}
+
+// GoogleCitationMetadata lists sources the model recited from.
+// The Gemini API calls the list "citations"; older responses use "citationSources".
+type GoogleCitationMetadata struct {
+	Citations       []GoogleCitation `json:"citations,omitempty"`
+	CitationSources []GoogleCitation `json:"citationSources,omitempty"`
+}
+
+type GoogleCitation struct {
+	StartIndex int    `json:"startIndex"`
+	EndIndex   int    `json:"endIndex"`
+	URI        string `json:"uri"`
+	Title      string `json:"title,omitempty"`
+	License    string `json:"license,omitempty"`
+}
+
+// Annotations converts citations with a URI into url_citation annotations
+func (m *GoogleCitationMetadata) Annotations() []Annotation {
+	if m == nil {
+		return nil
+	}
+	var annotations []Annotation
+	for _, citation := range append(m.Citations, m.CitationSources...) {
+		if citation.URI == "" {
+			continue
+		}
+		annotations = append(annotations, Annotation{
+			Type: "url_citation",
+			URLCitation: URLCitation{
+				URL:        citation.URI,
+				Title:      citation.Title,
+				StartIndex: citation.StartIndex,
+				EndIndex:   citation.EndIndex,
+			},
+		})
+	}
+	return annotations
+}
+
+// GoogleGroundingMetadata describes the search results an answer is based on
+type GoogleGroundingMetadata struct {
+	WebSearchQueries  []string                 `json:"webSearchQueries,omitempty"`
+	GroundingChunks   []GoogleGroundingChunk   `json:"groundingChunks,omitempty"`
+	GroundingSupports []GoogleGroundingSupport `json:"groundingSupports,omitempty"`
+}
+
+type GoogleGroundingChunk struct {
+	Web *GoogleWebSource `json:"web,omitempty"`
+}
+
+type GoogleWebSource struct {
+	URI   string `json:"uri"`
+	Title string `json:"title"`
+}
+
+// GoogleGroundingSupport links a segment of the answer to grounding chunks
+type GoogleGroundingSupport struct {
+	Segment               GoogleSegment `json:"segment"`
+	GroundingChunkIndices []int         `json:"groundingChunkIndices"`
+}
+
+type GoogleSegment struct {
+	StartIndex int    `json:"startIndex"`
+	EndIndex   int    `json:"endIndex"`
+	Text       string `json:"text"`
+}
+
+// Annotations converts grounding supports into url_citation annotations,
+// one per (segment, source) pair. Sources without a supported segment are
+// still listed with zero indices so clients can show them.
+func (g *GoogleGroundingMetadata) Annotations() []Annotation {
+	if g == nil {
+		return nil
+	}
+
+	var annotations []Annotation
+	cited := make(map[int]bool)
+	for _, support := range g.GroundingSupports {
+		for _, idx := range support.GroundingChunkIndices {
+			if idx < 0 || idx >= len(g.GroundingChunks) || g.GroundingChunks[idx].Web == nil {
+				continue
+			}
+			cited[idx] = true
+			web := g.GroundingChunks[idx].Web
+			annotations = append(annotations, Annotation{
+				Type: "url_citation",
+				URLCitation: URLCitation{
+					URL:        web.URI,
+					Title:      web.Title,
+					StartIndex: support.Segment.StartIndex,
+					EndIndex:   support.Segment.EndIndex,
+					Snippet:    support.Segment.Text,
+				},
+			})
+		}
+	}
+	for idx, chunk := range g.GroundingChunks {
+		if cited[idx] || chunk.Web == nil {
+			continue
+		}
+		annotations = append(annotations, Annotation{
+			Type:        "url_citation",
+			URLCitation: URLCitation{URL: chunk.Web.URI, Title: chunk.Web.Title},
+		})
+	}
+	return annotations
 }
 
 type GoogleUsage struct {
@@ -0,0 +1,62 @@
+package models
+
+// Per-account outcomes of a refresh cycle
+const (
+	RefreshResultRefreshed = "refreshed"
+	RefreshResultFailed    = "failed"
+	RefreshResultFresh     = "skipped_fresh"    // token not due for refresh
+	RefreshResultDisabled  = "skipped_disabled" // account disabled
+	RefreshResultCooldown  = "skipped_cooldown" // account cooling down after errors
+	RefreshResultLoadError = "load_failed"      // account file unreadable
+	// RefreshResultNeedsReauth: the refresh token was rejected; the account must log in again
+	RefreshResultNeedsReauth = "needs_reauth"
+)
+
+// RefreshCycle summarizes one run of the background token refresh
+type RefreshCycle struct {
+	StartedAt  int64 `json:"started_at"` // Unix milliseconds
+	DurationMs int64 `json:"duration_ms"`
+	Refreshed  int   `json:"refreshed"`
+	Failed     int   `json:"failed"`
+	Skipped    int   `json:"skipped"`
+	// Error is set when the cycle could not run at all (e.g. listing accounts failed)
+	Error    string                 `json:"error,omitempty"`
+	Accounts []RefreshAccountResult `json:"accounts"`
+}
+
+// RefreshAccountResult is one account's outcome within a refresh cycle
+type RefreshAccountResult struct {
+	AccountID  string `json:"account_id"`
+	Result     string `json:"result"`
+	DurationMs int64  `json:"duration_ms,omitempty"` // Only for refresh attempts
+	Error      string `json:"error,omitempty"`
+}
+
+// RefreshSchedulerStatus is a snapshot of the background token refresh scheduler
+type RefreshSchedulerStatus struct {
+	// Running reports whether the scheduler goroutine is alive
+	Running         bool  `json:"running"`
+	IntervalSeconds int64 `json:"interval_seconds"`
+	StartedAt       int64 `json:"started_at,omitempty"` // Unix milliseconds, like all times below
+	// InProgress is set while a cycle runs; Stalled when it has run for too long
+	InProgress          bool  `json:"in_progress"`
+	CurrentRunStartedAt int64 `json:"current_run_started_at,omitempty"`
+	Stalled             bool  `json:"stalled"`
+	LastRunAt           int64 `json:"last_run_at,omitempty"`
+	NextRunAt           int64 `json:"next_run_at,omitempty"`
+	// LastPanic is the last panic recovered from a cycle
+	LastPanic string                `json:"last_panic,omitempty"`
+	LastCycle *RefreshCycle         `json:"last_cycle,omitempty"`
+	Accounts  []RefreshAccountState `json:"accounts"`
+}
+
+// RefreshAccountState is the latest refresh attempt of one account, whether
+// made by the scheduler or on demand
+type RefreshAccountState struct {
+	AccountID  string `json:"account_id"`
+	Email      string `json:"email,omitempty"`
+	Result     string `json:"result"` // RefreshResultRefreshed or RefreshResultFailed
+	At         int64  `json:"at"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
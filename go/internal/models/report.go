@@ -0,0 +1,21 @@
+package models
+
+// DailyReport summarizes one day of proxy activity
+type DailyReport struct {
+	Date          string          `json:"date"` // YYYY-MM-DD
+	GeneratedAt   int64           `json:"generatedAt"`
+	Requests      int64           `json:"requests"`
+	InputTokens   int64           `json:"inputTokens"`
+	OutputTokens  int64           `json:"outputTokens"`
+	TotalTokens   int64           `json:"totalTokens"`
+	EstimatedCost float64         `json:"estimatedCost"` // USD, 0 when no prices are configured
+	TopModels     []ReportCounter `json:"topModels"`
+	TopAccounts   []ReportCounter `json:"topAccounts"`
+	Incidents     []Notification  `json:"incidents"` // Account and pool events updated that day
+}
+
+// ReportCounter is a named request count in a report ranking
+type ReportCounter struct {
+	Name     string `json:"name"`
+	Requests int64  `json:"requests"`
+}
@@ -61,5 +61,10 @@ type ErrorResponse struct {
 type ErrorDetail struct {
 	Message string `json:"message"`
 	Type    string `json:"type"`
+	Param   string `json:"param,omitempty"` // Offending request field, e.g. messages[0].content[1]
 	Code    string `json:"code,omitempty"`
+	// Details carries diagnostic context (upstream body, last retry error); not part of the OpenAI schema
+	Details string `json:"details,omitempty"`
+	// RequestID matches the X-Request-Id response header, for quoting in bug reports
+	RequestID string `json:"request_id,omitempty"`
 }
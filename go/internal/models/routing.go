@@ -0,0 +1,130 @@
+package models
+
+import "fmt"
+
+// maxAliasDepth bounds alias chains (a → b → c)
+const maxAliasDepth = 8
+
+// RoutingTable maps requested model names to upstream models
+type RoutingTable struct {
+	// Aliases maps a client-facing name to another model name
+	Aliases map[string]string `json:"aliases"`
+	// Fallbacks lists models tried in order when a model fails upstream
+	Fallbacks map[string][]string `json:"fallbacks"`
+	// Capabilities overrides fetched/default capabilities per model
+	Capabilities map[string]ModelCapabilities `json:"capabilities"`
+	// Parameters bounds the sampling parameters a model accepts
+	Parameters map[string]ParameterBounds `json:"parameters"`
+}
+
+// ParameterBounds limits sampling parameters for one model. Out-of-range
+// values are clamped into the range, or rejected with a 400 when Reject is set.
+type ParameterBounds struct {
+	Temperature *Range `json:"temperature,omitempty"`
+	TopP        *Range `json:"topP,omitempty"`
+	TopK        *Range `json:"topK,omitempty"`
+	Reject      bool   `json:"reject,omitempty"`
+}
+
+// Range is an inclusive interval; a nil end is unbounded
+type Range struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// Contains reports whether v lies within the range (a nil range allows everything)
+func (r *Range) Contains(v float64) bool {
+	if r == nil {
+		return true
+	}
+	return (r.Min == nil || v >= *r.Min) && (r.Max == nil || v <= *r.Max)
+}
+
+// Clamp moves v into the range
+func (r *Range) Clamp(v float64) float64 {
+	if r == nil {
+		return v
+	}
+	if r.Min != nil && v < *r.Min {
+		return *r.Min
+	}
+	if r.Max != nil && v > *r.Max {
+		return *r.Max
+	}
+	return v
+}
+
+// Resolve follows aliases for model; unknown names are returned unchanged
+func (t *RoutingTable) Resolve(model string) string {
+	if t == nil {
+		return model
+	}
+	for i := 0; i < maxAliasDepth; i++ {
+		target, ok := t.Aliases[model]
+		if !ok {
+			break
+		}
+		model = target
+	}
+	return model
+}
+
+// Validate rejects empty names, alias cycles, self-fallbacks and unknown thinking styles
+func (t *RoutingTable) Validate() error {
+	for alias, target := range t.Aliases {
+		if alias == "" || target == "" {
+			return fmt.Errorf("aliases: names must not be empty")
+		}
+		// Walk the chain; revisiting a name means a cycle
+		seen := map[string]bool{alias: true}
+		for next, ok := target, true; ok; next, ok = t.Aliases[next] {
+			if seen[next] {
+				return fmt.Errorf("aliases: cycle through %q", alias)
+			}
+			seen[next] = true
+			if len(seen) > maxAliasDepth {
+				return fmt.Errorf("aliases: chain from %q is longer than %d", alias, maxAliasDepth)
+			}
+		}
+	}
+
+	for model, fallbacks := range t.Fallbacks {
+		if model == "" {
+			return fmt.Errorf("fallbacks: model name must not be empty")
+		}
+		for _, fallback := range fallbacks {
+			if fallback == "" {
+				return fmt.Errorf("fallbacks: %q has an empty fallback", model)
+			}
+			if fallback == model {
+				return fmt.Errorf("fallbacks: %q falls back to itself", model)
+			}
+		}
+	}
+
+	for model, caps := range t.Capabilities {
+		if model == "" {
+			return fmt.Errorf("capabilities: model name must not be empty")
+		}
+		switch caps.ThinkingStyle {
+		case "", ThinkingStyleBudget, ThinkingStyleLevel:
+		default:
+			return fmt.Errorf("capabilities: %q has invalid thinkingStyle %q", model, caps.ThinkingStyle)
+		}
+		if caps.ThinkingBudget < 0 || caps.MaxOutputTokens < 0 || caps.MaxInputTokens < 0 {
+			return fmt.Errorf("capabilities: %q has a negative token count", model)
+		}
+	}
+
+	for model, bounds := range t.Parameters {
+		if model == "" {
+			return fmt.Errorf("parameters: model name must not be empty")
+		}
+		for name, r := range map[string]*Range{"temperature": bounds.Temperature, "topP": bounds.TopP, "topK": bounds.TopK} {
+			if r != nil && r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+				return fmt.Errorf("parameters: %q has %s min above max", model, name)
+			}
+		}
+	}
+	return nil
+}
@@ -2,18 +2,21 @@ package oauth
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/antigravity/api-proxy/internal/models"
 	"github.com/antigravity/api-proxy/internal/storage"
+	"github.com/antigravity/api-proxy/internal/upstream"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 )
@@ -38,6 +41,12 @@ var googleOAuth2Endpoint = oauth2.Endpoint{
 	TokenURL: "https://oauth2.googleapis.com/token",
 }
 
+// DefaultAPIBaseURL is the Cloud Code environment queried for model lists
+const DefaultAPIBaseURL = "https://daily-cloudcode-pa.sandbox.googleapis.com"
+
+// DefaultModelsRefreshInterval is how long a fetched model list stays fresh
+const DefaultModelsRefreshInterval = 24 * time.Hour
+
 // Client handles OAuth operations
 type Client struct {
 	config       *oauth2.Config
@@ -45,15 +54,57 @@ type Client struct {
 	server       *http.Server
 	accountStore *storage.AccountStore
 	stopRefresh  chan struct{}
+	// refreshDone is closed once the scheduler goroutine has exited
+	refreshDone chan struct{}
+
+	// notifications receives refresh failure events (nil-safe)
+	notifications *storage.NotificationStore
+	// refreshHistory persists the outcome of each refresh cycle (nil-safe)
+	refreshHistory *storage.UsageStore
+	// remainingQuota estimates per-account token headroom (nil: unknown)
+	remainingQuota RemainingQuotaFunc
+	// maskEmails hides account emails in logs and notifications
+	maskEmails bool
+	// modelsRefreshInterval is the age after which a refresh fetches the model list again
+	modelsRefreshInterval time.Duration
+	// httpClient sends every OAuth-side request, with per-attempt timeouts and retries
+	httpClient *http.Client
+	// retryPolicy is httpClient's policy, reused for accounts with their own proxy
+	retryPolicy RetryPolicy
+	// apiBaseURL is the Cloud Code environment (antigravity.base_url)
+	apiBaseURL string
+
+	mu           sync.Mutex
 	currentIndex int
+	refreshing   map[string]bool // accounts with a background refresh in flight
+
+	// statusMu guards scheduler, the refresh scheduler's observable state
+	statusMu  sync.Mutex
+	scheduler schedulerState
+
+	// poolMu guards pool, the last PoolStatus result
+	poolMu sync.Mutex
+	pool   poolSnapshot
 }
 
+// poolSnapshot is a PoolStatus result and the store generation it was computed at
+type poolSnapshot struct {
+	status     PoolStatus
+	generation uint64
+	at         time.Time
+}
+
+// poolStatusTTL bounds how long PoolStatus reuses a result while the account
+// store is unchanged; it covers files edited outside the store and tokens
+// expiring
+const poolStatusTTL = time.Second
+
 // NewClient creates a new OAuth client
 func NewClient(serverPort int, accountsDir string, logger *zap.Logger) *Client {
 	// 构建回调URL - 使用主服务器端口和 /oauth-callback 路由
 	redirectURL := fmt.Sprintf("http://localhost:%d/oauth-callback", serverPort)
 
-	return &Client{
+	client := &Client{
 		config: &oauth2.Config{
 			ClientID:     oauthClientID,
 			ClientSecret: oauthClientSecret,
@@ -64,7 +115,37 @@ func NewClient(serverPort int, accountsDir string, logger *zap.Logger) *Client {
 		logger:       logger,
 		accountStore: storage.NewAccountStore(accountsDir),
 		stopRefresh:  make(chan struct{}),
+		refreshing:   make(map[string]bool),
+
+		modelsRefreshInterval: DefaultModelsRefreshInterval,
+		apiBaseURL:            DefaultAPIBaseURL,
 	}
+	client.SetRetryPolicy(RetryPolicy{})
+	return client
+}
+
+// SetAPIBaseURL points model list requests at another Cloud Code environment
+func (c *Client) SetAPIBaseURL(baseURL string) {
+	c.apiBaseURL = strings.TrimRight(baseURL, "/")
+}
+
+// SetRetryPolicy sets the timeouts and retries of OAuth-side HTTP calls
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = policy.withDefaults()
+	c.httpClient = &http.Client{Transport: &retryTransport{policy: c.retryPolicy, logger: c.logger}}
+}
+
+// httpClientFor returns the client for an account's OAuth-side calls: the
+// shared one, or one sending through the account's own outbound proxy
+func (c *Client) httpClientFor(account *models.Account) (*http.Client, error) {
+	if account.Proxy == "" {
+		return c.httpClient, nil
+	}
+	proxied, err := upstream.ClientFor(account.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("account proxy: %w", err)
+	}
+	return &http.Client{Transport: &retryTransport{policy: c.retryPolicy, logger: c.logger, base: proxied.Transport}}, nil
 }
 
 // GetAuthCodeURL 生成OAuth授权URL（公开方法供外部调用）
@@ -82,6 +163,58 @@ func (c *Client) GetOAuthConfig() *oauth2.Config {
 	return c.config
 }
 
+// Exchange 用授权码换取token，经由共享的上游连接池发送（失败时按重试策略重试）
+func (c *Client) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+	return c.config.Exchange(c.httpContext(ctx, c.httpClient), code)
+}
+
+// httpContext makes the oauth2 package send its token requests through the
+// retrying client instead of http.DefaultClient
+func (c *Client) httpContext(ctx context.Context, client *http.Client) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, client)
+}
+
+// SetNotificationStore routes refresh failure events to the admin notification center
+func (c *Client) SetNotificationStore(store *storage.NotificationStore) {
+	c.notifications = store
+}
+
+// SetRefreshHistory records every refresh cycle in the usage store
+func (c *Client) SetRefreshHistory(store *storage.UsageStore) {
+	c.refreshHistory = store
+}
+
+// SetEmailMasking hides account emails in logs and notifications (security.mask_emails)
+func (c *Client) SetEmailMasking(enabled bool) {
+	c.maskEmails = enabled
+}
+
+// displayEmail is the account email as it may appear in logs and notifications
+func (c *Client) displayEmail(account *models.Account) string {
+	if c.maskEmails {
+		return models.MaskEmail(account.Email)
+	}
+	return account.Email
+}
+
+// displayAccountID is the account ID as it may appear in logs and
+// notifications; older IDs contain the account email
+func (c *Client) displayAccountID(accountID string) string {
+	if c.maskEmails {
+		return models.MaskAccountID(accountID)
+	}
+	return accountID
+}
+
+// RemainingQuotaFunc reports an account's estimated remaining token quota.
+// ok=false means the quota is unknown and the account is always eligible.
+type RemainingQuotaFunc func(accountID string) (remaining int64, ok bool)
+
+// SetRemainingQuota enables quota-aware selection in GetTokenFor
+func (c *Client) SetRemainingQuota(fn RemainingQuotaFunc) {
+	c.remainingQuota = fn
+}
+
 // AccountStore returns the account store
 func (c *Client) AccountStore() *storage.AccountStore {
 	return c.accountStore
@@ -89,40 +222,51 @@ func (c *Client) AccountStore() *storage.AccountStore {
 
 // GetUserInfo 获取用户信息（公开方法）
 func (c *Client) GetUserInfo(accessToken string) (*UserInfo, error) {
-	return c.getUserInfo(accessToken)
+	return c.getUserInfo(context.Background(), accessToken)
 }
 
-// SaveAccountFromToken 从token和用户信息保存账号
+// GetUserInfoContext 获取用户信息，ctx取消时立即释放上游连接
+func (c *Client) GetUserInfoContext(ctx context.Context, accessToken string) (*UserInfo, error) {
+	return c.getUserInfo(ctx, accessToken)
+}
+
+// SaveAccountFromToken 从token和用户信息保存账号。
+// 同一邮箱重新登录时更新已有账号：保留用量统计，模型列表未过期时不再重新获取
 func (c *Client) SaveAccountFromToken(token *oauth2.Token, userInfo *UserInfo) (*models.Account, error) {
+	account := c.findAccountByEmail(userInfo.Email)
+	if account == nil {
+		// 创建账号对象
+		account = &models.Account{
+			AccountID: generateAccountID(),
+			Usage: &models.UsageStats{
+				TotalTokens:  0,
+				InputTokens:  0,
+				OutputTokens: 0,
+				RequestCount: 0,
+			},
+			ErrorTracking: &models.ErrorTracking{
+				ConsecutiveFailures: 0,
+			},
+		}
+	}
+	account.Email = userInfo.Email
+	account.Name = userInfo.Name
+	account.AccessToken = token.AccessToken
+	if token.RefreshToken != "" {
+		account.RefreshToken = token.RefreshToken
+	}
+	account.ExpiresIn = int(time.Until(token.Expiry).Seconds())
+	account.Timestamp = time.Now().UnixMilli()
+	account.Enable = true
+	account.ClearNeedsReauth()
+	account.RecordSuccess()
+
 	// 获取模型列表
-	modelList, err := c.fetchModels(token.AccessToken)
-	if err != nil {
-		c.logger.Warn("Failed to fetch models", zap.Error(err))
-		modelList = make(map[string]models.Model)
-	}
-
-	// 创建账号对象
-	account := &models.Account{
-		AccountID:     generateAccountID(userInfo.Email),
-		Email:         userInfo.Email,
-		Name:          userInfo.Name,
-		AccessToken:   token.AccessToken,
-		RefreshToken:  token.RefreshToken,
-		ExpiresIn:     int(time.Until(token.Expiry).Seconds()),
-		Timestamp:     time.Now().UnixMilli(),
-		Enable:        true,
-		Models:        modelList,
-		LastRefresh:   time.Now().UnixMilli(),
-		RefreshStatus: "success",
-		Usage: &models.UsageStats{
-			TotalTokens:  0,
-			InputTokens:  0,
-			OutputTokens: 0,
-			RequestCount: 0,
-		},
-		ErrorTracking: &models.ErrorTracking{
-			ConsecutiveFailures: 0,
-		},
+	if c.modelsStale(account) {
+		c.updateModels(context.Background(), account)
+	}
+	if account.Models == nil {
+		account.Models = make(map[string]models.Model)
 	}
 
 	// 保存账号
@@ -131,13 +275,119 @@ func (c *Client) SaveAccountFromToken(token *oauth2.Token, userInfo *UserInfo) (
 	}
 
 	c.logger.Info("Account saved successfully",
-		zap.String("email", account.Email),
-		zap.String("account_id", account.AccountID),
+		zap.String("email", c.displayEmail(account)),
+		zap.String("account_id", c.displayAccountID(account.AccountID)),
 		zap.Int("models", len(account.Models)))
 
 	return account, nil
 }
 
+// findAccountByEmail returns the stored account of email, or nil
+func (c *Client) findAccountByEmail(email string) *models.Account {
+	accountIDs, err := c.accountStore.List()
+	if err != nil {
+		return nil
+	}
+	for _, accountID := range accountIDs {
+		if account, err := c.accountStore.Load(accountID); err == nil && strings.EqualFold(account.Email, email) {
+			return account
+		}
+	}
+	return nil
+}
+
+// UserInfoForToken identifies the user of a freshly exchanged token. The ID
+// token of the exchange response already names the user, so the userinfo
+// endpoint is only called when it is missing.
+func (c *Client) UserInfoForToken(ctx context.Context, token *oauth2.Token) (*UserInfo, error) {
+	if userInfo, ok := userInfoFromIDToken(token); ok {
+		return userInfo, nil
+	}
+	return c.getUserInfo(ctx, token.AccessToken)
+}
+
+// userInfoFromIDToken reads email and name from the token's ID token. The
+// token came straight from Google's token endpoint over TLS, so its claims
+// are trusted without verifying the signature.
+func userInfoFromIDToken(token *oauth2.Token) (*UserInfo, bool) {
+	idToken, _ := token.Extra("id_token").(string)
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, false
+	}
+	var claims UserInfo
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Email == "" {
+		return nil, false
+	}
+	if claims.Name == "" {
+		claims.Name = claims.Email
+	}
+	return &claims, true
+}
+
+// SetModelsRefreshInterval sets how old an account's model list may get before
+// a token refresh fetches it again (negative: only on RefreshModels)
+func (c *Client) SetModelsRefreshInterval(interval time.Duration) {
+	c.modelsRefreshInterval = interval
+}
+
+// modelsStale reports whether the account's model list should be fetched again
+func (c *Client) modelsStale(account *models.Account) bool {
+	if len(account.Models) == 0 || account.ModelsUpdatedAt == 0 {
+		return true
+	}
+	if c.modelsRefreshInterval < 0 {
+		return false
+	}
+	return time.Since(time.UnixMilli(account.ModelsUpdatedAt)) >= c.modelsRefreshInterval
+}
+
+// updateModels fetches the account's model list. An empty answer keeps the
+// previous list, so a transient upstream error doesn't wipe it.
+func (c *Client) updateModels(ctx context.Context, account *models.Account) error {
+	client, err := c.httpClientFor(account)
+	if err != nil {
+		return err
+	}
+	modelList, err := c.fetchModels(ctx, client, account.AccessToken, account.Headers)
+	if err != nil {
+		c.logger.Warn("Failed to fetch models",
+			zap.String("account_id", c.displayAccountID(account.AccountID)),
+			zap.Error(err))
+		return err
+	}
+	if len(modelList) == 0 && len(account.Models) > 0 {
+		return fmt.Errorf("upstream returned no models")
+	}
+	account.Models = modelList
+	account.ModelsUpdatedAt = time.Now().UnixMilli()
+	return nil
+}
+
+// RefreshModels fetches an account's model list now, regardless of its age
+func (c *Client) RefreshModels(ctx context.Context, accountID string) (*models.Account, error) {
+	account, err := c.accountStore.Load(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if account.IsExpired() {
+		if err := c.RefreshToken(account); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.updateModels(ctx, account); err != nil {
+		return nil, err
+	}
+	if err := c.accountStore.Save(account); err != nil {
+		return nil, fmt.Errorf("failed to save account: %w", err)
+	}
+	return account, nil
+}
+
 // StartLoginFlow starts the OAuth login flow and waits for callback
 func (c *Client) StartLoginFlow() (*models.Account, error) {
 	state := generateState()
@@ -202,62 +452,28 @@ func (c *Client) handleCallback(w http.ResponseWriter, r *http.Request, expected
 	}
 
 	// 交换token
-	ctx := context.Background()
-	token, err := c.config.Exchange(ctx, code)
+	ctx := r.Context()
+	token, err := c.Exchange(ctx, code)
 	if err != nil {
 		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
 		return nil, fmt.Errorf("failed to exchange token: %w", err)
 	}
 
 	// 获取用户信息
-	userInfo, err := c.getUserInfo(token.AccessToken)
+	userInfo, err := c.UserInfoForToken(ctx, token)
 	if err != nil {
 		http.Error(w, "Failed to get user info", http.StatusInternalServerError)
 		return nil, fmt.Errorf("failed to get user info: %w", err)
 	}
 
-	// 获取模型列表
-	modelList, err := c.fetchModels(token.AccessToken)
-	if err != nil {
-		c.logger.Warn("Failed to fetch models", zap.Error(err))
-		modelList = make(map[string]models.Model) // 继续，使用空模型列表
-	}
-
-	// 创建账号对象
-	account := &models.Account{
-		AccountID:     generateAccountID(userInfo.Email),
-		Email:         userInfo.Email,
-		Name:          userInfo.Name,
-		AccessToken:   token.AccessToken,
-		RefreshToken:  token.RefreshToken,
-		ExpiresIn:     int(time.Until(token.Expiry).Seconds()),
-		Timestamp:     time.Now().UnixMilli(),
-		Enable:        true,
-		Models:        modelList,
-		LastRefresh:   time.Now().UnixMilli(),
-		RefreshStatus: "success",
-		Usage: &models.UsageStats{
-			TotalTokens:  0,
-			InputTokens:  0,
-			OutputTokens: 0,
-			RequestCount: 0,
-		},
-		ErrorTracking: &models.ErrorTracking{
-			ConsecutiveFailures: 0,
-		},
-	}
-
 	// 保存账号到文件
-	if err := c.accountStore.Save(account); err != nil {
+	account, err := c.SaveAccountFromToken(token, userInfo)
+	if err != nil {
 		c.logger.Error("Failed to save account", zap.Error(err))
 		http.Error(w, "Failed to save account", http.StatusInternalServerError)
-		return nil, fmt.Errorf("failed to save account: %w", err)
+		return nil, err
 	}
 
-	c.logger.Info("Account saved successfully",
-		zap.String("account_id", account.AccountID),
-		zap.String("email", account.Email))
-
 	// 返回成功页面
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	fmt.Fprintf(w, `
@@ -335,14 +551,30 @@ func (c *Client) handleCallback(w http.ResponseWriter, r *http.Request, expected
 			</div>
 		</body>
 		</html>
-	`, account.Email, account.AccountID, len(modelList))
+	`, account.Email, account.AccountID, len(account.Models))
 
 	return account, nil
 }
 
+// ErrNeedsReauth means an account's refresh token was rejected (invalid_grant)
+var ErrNeedsReauth = errors.New("refresh token rejected, account needs to log in again")
+
+// isInvalidGrant reports whether Google rejected the refresh token itself
+// (revoked, expired or reused) rather than failing transiently
+func isInvalidGrant(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant"
+}
+
 // RefreshToken refreshes a single account's token
-func (c *Client) RefreshToken(account *models.Account) error {
-	c.logger.Info("Refreshing token", zap.String("account_id", account.AccountID))
+func (c *Client) RefreshToken(account *models.Account) (err error) {
+	c.logger.Info("Refreshing token", zap.String("account_id", c.displayAccountID(account.AccountID)))
+	started := time.Now()
+	defer func() { c.recordRefreshAttempt(account.AccountID, started, err) }()
+
+	if account.NeedsReauth() {
+		return fmt.Errorf("%w: %s", ErrNeedsReauth, account.ErrorTracking.LastError)
+	}
 
 	// Create a new token source
 	token := &oauth2.Token{
@@ -350,15 +582,36 @@ func (c *Client) RefreshToken(account *models.Account) error {
 		Expiry:       time.Now(), // Force refresh
 	}
 
-	tokenSource := c.config.TokenSource(context.Background(), token)
+	// Refresh through the account's own proxy; never fall back to another route
+	httpClient, err := c.httpClientFor(account)
+	if err != nil {
+		account.RecordFailure(err.Error())
+		_ = c.accountStore.Save(account)
+		return err
+	}
+	tokenSource := c.config.TokenSource(c.httpContext(context.Background(), httpClient), token)
 	newToken, err := tokenSource.Token()
+	if isInvalidGrant(err) {
+		// Retrying cannot help: stop refreshing until the account logs in again
+		c.logger.Error("Refresh token rejected, account needs to log in again",
+			zap.String("account_id", c.displayAccountID(account.AccountID)),
+			zap.String("email", c.displayEmail(account)),
+			zap.Error(err))
+		account.RecordNeedsReauth(err.Error())
+		_ = c.accountStore.Save(account)
+		c.notifications.Add(models.NotifyNeedsReauth, c.displayAccountID(account.AccountID),
+			fmt.Sprintf("Refresh token of %s was revoked or expired; log in again to keep using the account", c.displayEmail(account)))
+		return fmt.Errorf("%w: %v", ErrNeedsReauth, err)
+	}
 	if err != nil {
 		c.logger.Error("Failed to refresh token",
-			zap.String("account_id", account.AccountID),
+			zap.String("account_id", c.displayAccountID(account.AccountID)),
 			zap.Error(err))
 		account.RecordFailure(err.Error())
 		// Save account with error status
 		_ = c.accountStore.Save(account)
+		c.notifications.Add(models.NotifyRefreshFailed, c.displayAccountID(account.AccountID),
+			fmt.Sprintf("Token refresh failed for %s: %v", c.displayEmail(account), err))
 		return err
 	}
 
@@ -370,12 +623,9 @@ func (c *Client) RefreshToken(account *models.Account) error {
 	account.ExpiresIn = int(time.Until(newToken.Expiry).Seconds())
 	account.Timestamp = time.Now().UnixMilli()
 
-	// Fetch updated models
-	models, err := c.fetchModels(account.AccessToken)
-	if err == nil {
-		account.Models = models
-	} else {
-		c.logger.Warn("Failed to update models during refresh", zap.Error(err))
+	// The model list rarely changes; only fetch it again once it is stale
+	if c.modelsStale(account) {
+		c.updateModels(context.Background(), account)
 	}
 
 	account.RecordSuccess()
@@ -386,76 +636,104 @@ func (c *Client) RefreshToken(account *models.Account) error {
 	}
 
 	c.logger.Info("Token refreshed successfully",
-		zap.String("account_id", account.AccountID),
+		zap.String("account_id", c.displayAccountID(account.AccountID)),
 		zap.Int("expires_in", account.ExpiresIn))
 
 	return nil
 }
 
-// RefreshAllTokens refreshes all accounts that need it
+// RefreshAllTokens refreshes all accounts that need it and records the cycle
 func (c *Client) RefreshAllTokens() {
 	c.logger.Info("Starting batch token refresh...")
+	started := time.Now()
+	cycle := models.RefreshCycle{StartedAt: started.UnixMilli(), Accounts: []models.RefreshAccountResult{}}
+	c.cycleStarted(started)
+	defer func() {
+		cycle.DurationMs = time.Since(started).Milliseconds()
+		c.cycleFinished(cycle)
+		if err := c.refreshHistory.RecordRefreshCycle(cycle); err != nil {
+			c.logger.Warn("Failed to record refresh cycle", zap.Error(err))
+		}
+	}()
+
 	accountIDs, err := c.accountStore.List()
 	if err != nil {
 		c.logger.Error("Failed to list accounts for refresh", zap.Error(err))
+		cycle.Error = err.Error()
 		return
 	}
 
-	successCount := 0
-	failCount := 0
-	skippedCount := 0
-
 	for _, accountID := range accountIDs {
+		result := models.RefreshAccountResult{AccountID: accountID}
 		account, err := c.accountStore.Load(accountID)
-		if err != nil {
+		switch {
+		case err != nil:
 			c.logger.Error("Failed to load account for refresh",
-				zap.String("account_id", accountID),
+				zap.String("account_id", c.displayAccountID(accountID)),
 				zap.Error(err))
-			continue
-		}
-
-		if !account.Enable {
-			skippedCount++
-			continue
-		}
-
-		if account.IsInCooldown() {
+			result.Result = models.RefreshResultLoadError
+			result.Error = err.Error()
+		case !account.Enable:
+			result.Result = models.RefreshResultDisabled
+		case account.NeedsReauth():
+			result.Result = models.RefreshResultNeedsReauth
+		case account.IsInCooldown():
 			c.logger.Info("Skipping account in cooldown",
-				zap.String("account_id", account.AccountID),
+				zap.String("account_id", c.displayAccountID(account.AccountID)),
 				zap.Int64("failed_until", *account.ErrorTracking.FailedUntil))
-			skippedCount++
-			continue
-		}
-
-		if account.NeedsRefresh() {
-			if err := c.RefreshToken(account); err != nil {
-				failCount++
+			result.Result = models.RefreshResultCooldown
+		case account.NeedsRefresh():
+			refreshStarted := time.Now()
+			err := c.RefreshToken(account)
+			result.DurationMs = time.Since(refreshStarted).Milliseconds()
+			if err != nil {
+				result.Result = models.RefreshResultFailed
+				result.Error = err.Error()
 			} else {
-				successCount++
+				result.Result = models.RefreshResultRefreshed
 			}
-		} else {
-			skippedCount++
+		default:
+			result.Result = models.RefreshResultFresh
 		}
+
+		switch result.Result {
+		case models.RefreshResultRefreshed:
+			cycle.Refreshed++
+		case models.RefreshResultFailed:
+			cycle.Failed++
+		case models.RefreshResultLoadError:
+		default:
+			cycle.Skipped++
+		}
+		cycle.Accounts = append(cycle.Accounts, result)
 	}
 
 	c.logger.Info("Batch refresh completed",
-		zap.Int("success", successCount),
-		zap.Int("failed", failCount),
-		zap.Int("skipped", skippedCount))
+		zap.Int("success", cycle.Refreshed),
+		zap.Int("failed", cycle.Failed),
+		zap.Int("skipped", cycle.Skipped),
+		zap.Duration("duration", time.Since(started)))
 }
 
 // StartBackgroundRefresh starts the background token refresh scheduler
 func (c *Client) StartBackgroundRefresh() {
-	ticker := time.NewTicker(30 * time.Minute)
+	ticker := time.NewTicker(refreshInterval)
+	c.setSchedulerRunning(true)
+	c.setNextRefresh(time.Now().Add(refreshInterval))
+	done := make(chan struct{})
+	c.refreshDone = done
 	go func() {
-		c.logger.Info("Background token refresh scheduler started (every 30m)")
+		defer close(done)
+		defer c.setSchedulerRunning(false)
+		c.logger.Info("Background token refresh scheduler started", zap.Duration("interval", refreshInterval))
 		// Run immediately on start
-		c.RefreshAllTokens()
+		c.runScheduledRefresh()
 
 		for {
 			select {
-			case <-ticker.C:
-				c.RefreshAllTokens()
+			case tick := <-ticker.C:
+				c.setNextRefresh(tick.Add(refreshInterval))
+				c.runScheduledRefresh()
 			case <-c.stopRefresh:
 				ticker.Stop()
 				c.logger.Info("Background token refresh scheduler stopped")
@@ -465,19 +743,23 @@ func (c *Client) StartBackgroundRefresh() {
 	}()
 }
 
-// StopBackgroundRefresh stops the background token refresh scheduler
+// StopBackgroundRefresh stops the background token refresh scheduler and
+// waits for a running cycle to finish, so nothing is written after it returns
 func (c *Client) StopBackgroundRefresh() {
 	close(c.stopRefresh)
+	if c.refreshDone != nil {
+		<-c.refreshDone
+	}
 }
 
-func (c *Client) getUserInfo(accessToken string) (*UserInfo, error) {
-	req, err := http.NewRequest("GET", "https://www.googleapis.com/oauth2/v2/userinfo", nil)
+func (c *Client) getUserInfo(ctx context.Context, accessToken string) (*UserInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://www.googleapis.com/oauth2/v2/userinfo", nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := upstream.Do(ctx, c.httpClient, req)
 	if err != nil {
 		return nil, err
 	}
@@ -500,20 +782,20 @@ func (c *Client) getUserInfo(accessToken string) (*UserInfo, error) {
 	return &userInfo, nil
 }
 
-func (c *Client) fetchModels(accessToken string) (map[string]models.Model, error) {
+func (c *Client) fetchModels(ctx context.Context, client *http.Client, accessToken string, headers map[string]string) (map[string]models.Model, error) {
 	reqBody := []byte("{}")
-	req, err := http.NewRequest("POST", "https://daily-cloudcode-pa.sandbox.googleapis.com/v1internal:fetchAvailableModels", bytes.NewReader(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiBaseURL+"/v1internal:fetchAvailableModels", bytes.NewReader(reqBody))
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Host", "daily-cloudcode-pa.sandbox.googleapis.com")
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/74.0.3729.169 Safari/537.3 antigravity/1.11.3")
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept-Encoding", "gzip")
+	upstream.ApplyHeaders(req.Header, headers)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := upstream.Do(ctx, client, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch models: %w", err)
 	}
@@ -527,20 +809,8 @@ func (c *Client) fetchModels(accessToken string) (map[string]models.Model, error
 		return make(map[string]models.Model), nil // 返回空列表继续
 	}
 
-	// 处理 gzip 压缩的响应
-	var reader io.Reader = resp.Body
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		gzReader, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
-		}
-		defer gzReader.Close()
-		reader = gzReader
-		c.logger.Debug("Response is gzip compressed, decompressing...")
-	}
-
-	// 读取完整响应用于调试
-	bodyBytes, err := io.ReadAll(reader)
+	// 读取完整响应用于调试（gzip 响应已由 upstream.Do 解压）
+	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
@@ -550,7 +820,7 @@ func (c *Client) fetchModels(accessToken string) (map[string]models.Model, error
 		zap.String("body_preview", string(bodyBytes[:min(200, len(bodyBytes))])))
 
 	var result struct {
-		Models map[string]interface{} `json:"models"`
+		Models map[string]upstreamModelInfo `json:"models"`
 	}
 	if err := json.Unmarshal(bodyBytes, &result); err != nil {
 		c.logger.Warn("Failed to decode models response",
@@ -565,11 +835,13 @@ func (c *Client) fetchModels(accessToken string) (map[string]models.Model, error
 	}
 
 	modelList := make(map[string]models.Model)
-	for modelID := range result.Models {
+	for modelID, info := range result.Models {
+		caps := info.capabilities(modelID)
 		modelList[modelID] = models.Model{
-			ID:      modelID,
-			Object:  "model",
-			OwnedBy: "google",
+			ID:           modelID,
+			Object:       "model",
+			OwnedBy:      "google",
+			Capabilities: &caps,
 		}
 	}
 
@@ -579,6 +851,61 @@ func (c *Client) fetchModels(accessToken string) (map[string]models.Model, error
 	return modelList, nil
 }
 
+// upstreamModelInfo is the per-model metadata returned by fetchAvailableModels.
+// Every field is optional; missing ones keep the name-based default.
+type upstreamModelInfo struct {
+	SupportsThinking *bool `json:"supportsThinking"`
+	ThinkingBudget   int   `json:"thinkingBudget"`
+	SupportsImages   *bool `json:"supportsImages"`
+	SupportsTools    *bool `json:"supportsTools"`
+	MaxOutputTokens  int   `json:"maxOutputTokens"`
+	MaxTokens        int   `json:"maxTokens"` // context window
+}
+
+// capabilities overlays the metadata on the defaults for modelID
+func (m upstreamModelInfo) capabilities(modelID string) models.ModelCapabilities {
+	caps := models.DefaultCapabilities(modelID)
+	if m.SupportsThinking != nil {
+		caps.Thinking = *m.SupportsThinking
+	}
+	if m.ThinkingBudget > 0 {
+		caps.ThinkingStyle = models.ThinkingStyleBudget
+		caps.ThinkingBudget = m.ThinkingBudget
+	}
+	if m.SupportsImages != nil {
+		caps.Vision = *m.SupportsImages
+	}
+	if m.SupportsTools != nil {
+		caps.Tools = *m.SupportsTools
+	}
+	if m.MaxOutputTokens > 0 {
+		caps.MaxOutputTokens = m.MaxOutputTokens
+	}
+	if m.MaxTokens > 0 {
+		caps.MaxInputTokens = m.MaxTokens
+	}
+	return caps
+}
+
+// ModelCapabilities returns capabilities from the fetched model metadata of
+// any account; ok is false when no account has metadata for modelID
+func (c *Client) ModelCapabilities(modelID string) (models.ModelCapabilities, bool) {
+	accountIDs, err := c.accountStore.List()
+	if err != nil {
+		return models.ModelCapabilities{}, false
+	}
+	for _, accountID := range accountIDs {
+		account, err := c.accountStore.Load(accountID)
+		if err != nil {
+			continue
+		}
+		if model, ok := account.Models[modelID]; ok && model.Capabilities != nil {
+			return *model.Capabilities, true
+		}
+	}
+	return models.ModelCapabilities{}, false
+}
+
 // 辅助函数：获取模型ID列表（用于日志）
 func getModelIDs(models map[string]models.Model) []string {
 	ids := make([]string, 0, len(models))
@@ -597,25 +924,49 @@ func min(a, b int) int {
 
 // GetToken returns a valid access token, rotating through available accounts
 func (c *Client) GetToken() (*models.Account, error) {
+	return c.GetTokenFor(0)
+}
+
+// ErrNoAccounts is returned by GetTokenFor when no account has been added yet
+var ErrNoAccounts = errors.New("no accounts configured")
+
+// GetTokenFor selects an account for a request estimated at estimatedTokens.
+// Accounts whose remaining quota can't cover the estimate are passed over;
+// if none can, the one with the most headroom is used rather than failing.
+func (c *Client) GetTokenFor(estimatedTokens int64) (*models.Account, error) {
+	return c.GetTokenExcluding(estimatedTokens, nil)
+}
+
+// GetTokenExcluding is GetTokenFor for a retry: accounts in exclude (already
+// attempted for this request) are only reused when no other account is usable.
+func (c *Client) GetTokenExcluding(estimatedTokens int64, exclude map[string]bool) (*models.Account, error) {
 	accountIDs, err := c.accountStore.List()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list accounts: %w", err)
 	}
 
 	if len(accountIDs) == 0 {
-		return nil, fmt.Errorf("no accounts available")
+		return nil, ErrNoAccounts
 	}
 
+	var fallback *models.Account
+	var fallbackRemaining int64
+	// reuse is the first usable account that was excluded
+	var reuse *models.Account
+
 	// Try up to len(accountIDs) times to find a valid token
 	for i := 0; i < len(accountIDs); i++ {
 		// Round-robin selection
+		c.mu.Lock()
 		c.currentIndex = (c.currentIndex + 1) % len(accountIDs)
-		accountID := accountIDs[c.currentIndex]
+		index := c.currentIndex
+		c.mu.Unlock()
+		accountID := accountIDs[index]
 
 		account, err := c.accountStore.Load(accountID)
 		if err != nil {
 			c.logger.Warn("Failed to load account during rotation",
-				zap.String("account_id", accountID),
+				zap.String("account_id", c.displayAccountID(accountID)),
 				zap.Error(err))
 			continue
 		}
@@ -623,50 +974,192 @@ func (c *Client) GetToken() (*models.Account, error) {
 		// Skip disabled accounts
 		if !account.Enable {
 			c.logger.Debug("Skipping disabled account",
-				zap.String("account_id", accountID),
-				zap.String("email", account.Email))
+				zap.String("account_id", c.displayAccountID(accountID)),
+				zap.String("email", c.displayEmail(account)))
 			continue
 		}
 
 		// Skip accounts with permission denied errors
 		if account.ErrorTracking != nil && account.ErrorTracking.IsPermissionDenied {
 			c.logger.Debug("Skipping account with permission denied",
-				zap.String("account_id", accountID),
-				zap.String("email", account.Email))
+				zap.String("account_id", c.displayAccountID(accountID)),
+				zap.String("email", c.displayEmail(account)))
+			continue
+		}
+
+		// 刷新令牌已失效的账号，access token 过期后无法再使用
+		if account.NeedsReauth() && account.IsExpired() {
+			c.logger.Debug("Skipping account that needs to log in again",
+				zap.String("account_id", c.displayAccountID(accountID)),
+				zap.String("email", c.displayEmail(account)))
 			continue
 		}
 
 		// Skip accounts in cooldown
 		if account.IsInCooldown() {
 			c.logger.Debug("Skipping account in cooldown",
-				zap.String("account_id", accountID),
-				zap.String("email", account.Email),
+				zap.String("account_id", c.displayAccountID(accountID)),
+				zap.String("email", c.displayEmail(account)),
 				zap.Int64("failed_until", *account.ErrorTracking.FailedUntil))
 			continue
 		}
 
+		// 本次请求已尝试过的账号：仅在没有其他可用账号时才再次使用
+		if exclude[accountID] {
+			if reuse == nil && !account.IsExpired() {
+				reuse = account
+			}
+			continue
+		}
+
 		// Check if token needs refresh
 		if account.NeedsRefresh() {
-			if err := c.RefreshToken(account); err != nil {
-				c.logger.Warn("Failed to refresh token during rotation",
-					zap.String("account_id", accountID),
-					zap.Error(err))
+			if account.IsExpired() {
+				// Token already expired: a synchronous refresh is unavoidable
+				if err := c.RefreshToken(account); err != nil {
+					c.logger.Warn("Failed to refresh token during rotation",
+						zap.String("account_id", c.displayAccountID(accountID)),
+						zap.Error(err))
+					continue
+				}
+			} else {
+				// Still valid: serve this request with the current token and
+				// refresh ahead of expiry off the request path
+				c.refreshInBackground(account.Clone())
+			}
+		}
+
+		// 预估额度不足的账号留作后备，优先选择能完整服务本次请求的账号
+		if estimatedTokens > 0 && c.remainingQuota != nil {
+			if remaining, ok := c.remainingQuota(accountID); ok && remaining < estimatedTokens {
+				c.logger.Debug("Skipping account with insufficient estimated quota",
+					zap.String("account_id", c.displayAccountID(accountID)),
+					zap.Int64("remaining", remaining),
+					zap.Int64("estimated_tokens", estimatedTokens))
+				if fallback == nil || remaining > fallbackRemaining {
+					fallback = account
+					fallbackRemaining = remaining
+				}
 				continue
 			}
 		}
 
 		c.logger.Info("Selected account for request",
-			zap.String("account_id", account.AccountID),
-			zap.String("email", account.Email),
-			zap.Int("index", c.currentIndex),
+			zap.String("account_id", c.displayAccountID(account.AccountID)),
+			zap.String("email", c.displayEmail(account)),
+			zap.Int("index", index),
 			zap.Int("total_accounts", len(accountIDs)))
 		
 		return account, nil
 	}
 
+	if fallback != nil {
+		c.logger.Info("No account has enough estimated quota, using the one with most headroom",
+			zap.String("account_id", c.displayAccountID(fallback.AccountID)),
+			zap.Int64("remaining", fallbackRemaining),
+			zap.Int64("estimated_tokens", estimatedTokens))
+		return fallback, nil
+	}
+
+	if reuse != nil {
+		c.logger.Debug("Every usable account was already attempted, reusing one",
+			zap.String("account_id", c.displayAccountID(reuse.AccountID)))
+		return reuse, nil
+	}
+
 	return nil, fmt.Errorf("no valid accounts available (all disabled, in cooldown, or failed refresh)")
 }
 
+// PoolStatus summarizes how many accounts can currently serve requests
+type PoolStatus struct {
+	Total     int
+	Available int
+	// NextAvailable is the earliest cooldown end among cooling accounts (zero if none)
+	NextAvailable time.Time
+}
+
+// PoolStatus reports the current state of the account pool. It runs on every
+// request, so a result is reused until an account changes, a cooldown ends or
+// poolStatusTTL passes.
+func (c *Client) PoolStatus() PoolStatus {
+	now := time.Now()
+	generation := c.accountStore.Generation()
+	c.poolMu.Lock()
+	snapshot := c.pool
+	c.poolMu.Unlock()
+	if !snapshot.at.IsZero() && snapshot.generation == generation && now.Sub(snapshot.at) < poolStatusTTL &&
+		(snapshot.status.NextAvailable.IsZero() || now.Before(snapshot.status.NextAvailable)) {
+		return snapshot.status
+	}
+
+	status := c.scanPool()
+	c.poolMu.Lock()
+	c.pool = poolSnapshot{status: status, generation: generation, at: now}
+	c.poolMu.Unlock()
+	return status
+}
+
+// scanPool computes PoolStatus from every account
+func (c *Client) scanPool() PoolStatus {
+	var status PoolStatus
+	accountIDs, err := c.accountStore.List()
+	if err != nil {
+		return status
+	}
+
+	for _, accountID := range accountIDs {
+		account, err := c.accountStore.Load(accountID)
+		if err != nil {
+			continue
+		}
+		status.Total++
+		if !account.Enable || (account.ErrorTracking != nil && account.ErrorTracking.IsPermissionDenied) {
+			continue
+		}
+		if account.NeedsReauth() && account.IsExpired() {
+			continue
+		}
+		if account.IsInCooldown() {
+			until := time.Unix(*account.ErrorTracking.FailedUntil, 0)
+			if status.NextAvailable.IsZero() || until.Before(status.NextAvailable) {
+				status.NextAvailable = until
+			}
+			continue
+		}
+		status.Available++
+	}
+	return status
+}
+
+// refreshInBackground refreshes an account's token asynchronously.
+// At most one background refresh runs per account at a time.
+func (c *Client) refreshInBackground(account *models.Account) {
+	c.mu.Lock()
+	if c.refreshing[account.AccountID] {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing[account.AccountID] = true
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, account.AccountID)
+			c.mu.Unlock()
+		}()
+
+		c.logger.Debug("Proactively refreshing token before expiry",
+			zap.String("account_id", c.displayAccountID(account.AccountID)),
+			zap.Time("expires_at", account.ExpiresAt()))
+		if err := c.RefreshToken(account); err != nil {
+			c.logger.Warn("Background token refresh failed",
+				zap.String("account_id", c.displayAccountID(account.AccountID)),
+				zap.Error(err))
+		}
+	}()
+}
+
 func (c *Client) shutdown() {
 	if c.server != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -687,10 +1180,12 @@ func generateState() string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
-func generateAccountID(email string) string {
-	b := make([]byte, 4)
+// generateAccountID returns a random ID. IDs used to start with the account
+// email, which then showed up in logs and file names; such accounts keep it.
+func generateAccountID() string {
+	b := make([]byte, 8)
 	rand.Read(b)
-	return fmt.Sprintf("%s_%x", email, b)
+	return fmt.Sprintf("acc_%x", b)
 }
 
 // extractPortFromRedirectURL extracts port from redirect URL
@@ -1,7 +1,15 @@
 package oauth
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -10,6 +18,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"golang.org/x/oauth2"
 )
 
 func setupTestClient(t *testing.T) (*Client, string) {
@@ -129,3 +138,495 @@ func TestGetToken_NoAvailable(t *testing.T) {
 	assert.Nil(t, acc)
 	assert.Contains(t, err.Error(), "no valid accounts available")
 }
+
+func TestGetToken_ExcludesAttempted(t *testing.T) {
+	client, tmpDir := setupTestClient(t)
+	defer os.RemoveAll(tmpDir)
+
+	store := client.AccountStore()
+
+	createTestAccount(t, store, "acc1", true, false)
+	createTestAccount(t, store, "acc2", true, false)
+	createTestAccount(t, store, "acc3", true, false)
+
+	// Round-robin would come back to the attempted accounts; exclusion skips them
+	exclude := map[string]bool{"acc1": true, "acc3": true}
+	for i := 0; i < 3; i++ {
+		acc, err := client.GetTokenExcluding(0, exclude)
+		require.NoError(t, err)
+		assert.Equal(t, "acc2", acc.AccountID)
+	}
+
+	// Once everything was attempted, an attempted account is reused rather than failing
+	exclude["acc2"] = true
+	acc, err := client.GetTokenExcluding(0, exclude)
+	require.NoError(t, err)
+	assert.NotNil(t, acc)
+}
+
+func TestModelCapabilities_FromMetadata(t *testing.T) {
+	var result struct {
+		Models map[string]upstreamModelInfo `json:"models"`
+	}
+	body := `{"models":{
+		"gemini-2.5-flash":{"supportsThinking":true,"thinkingBudget":1024,"maxOutputTokens":65535},
+		"gemini-3-pro-preview":{},
+		"text-only":{"supportsImages":false,"supportsTools":false}
+	}}`
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+
+	flash := result.Models["gemini-2.5-flash"].capabilities("gemini-2.5-flash")
+	assert.True(t, flash.Thinking) // Name heuristics alone would leave flash off
+	assert.Equal(t, models.ThinkingStyleBudget, flash.ThinkingStyle)
+	assert.Equal(t, 1024, flash.ThinkingBudget)
+	assert.Equal(t, 65535, flash.MaxOutputTokens)
+
+	// No metadata: name-based defaults
+	pro := result.Models["gemini-3-pro-preview"].capabilities("gemini-3-pro-preview")
+	assert.True(t, pro.Thinking)
+	assert.Equal(t, models.ThinkingStyleLevel, pro.ThinkingStyle)
+
+	textOnly := result.Models["text-only"].capabilities("text-only")
+	assert.False(t, textOnly.Vision)
+	assert.False(t, textOnly.Tools)
+
+	client, tmpDir := setupTestClient(t)
+	defer os.RemoveAll(tmpDir)
+	require.NoError(t, client.AccountStore().Save(&models.Account{
+		AccountID: "acc1",
+		Models:    map[string]models.Model{"gemini-2.5-flash": {ID: "gemini-2.5-flash", Capabilities: &flash}},
+	}))
+
+	caps, ok := client.ModelCapabilities("gemini-2.5-flash")
+	require.True(t, ok)
+	assert.Equal(t, flash, caps)
+	_, ok = client.ModelCapabilities("unknown")
+	assert.False(t, ok)
+}
+
+func TestRefreshAllTokens_RecordsCycle(t *testing.T) {
+	client, tmpDir := setupTestClient(t)
+	defer os.RemoveAll(tmpDir)
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+	}))
+	defer tokenServer.Close()
+	client.config.Endpoint.TokenURL = tokenServer.URL
+
+	usage := storage.NewUsageStore(t.TempDir())
+	client.SetRefreshHistory(usage)
+
+	store := client.AccountStore()
+	createTestAccount(t, store, "fresh", true, false)
+	createTestAccount(t, store, "disabled", false, false)
+	createTestAccount(t, store, "cooldown", true, true)
+	expired := &models.Account{AccountID: "expired", Enable: true, RefreshToken: "rt", ExpiresIn: 3600,
+		Timestamp: time.Now().Add(-2 * time.Hour).UnixMilli()}
+	require.NoError(t, store.Save(expired))
+
+	client.RefreshAllTokens()
+
+	cycles, err := usage.GetRefreshHistory(1)
+	require.NoError(t, err)
+	require.Len(t, cycles, 1)
+	cycle := cycles[0]
+	assert.Equal(t, 0, cycle.Refreshed)
+	assert.Equal(t, 1, cycle.Failed)
+	assert.Equal(t, 3, cycle.Skipped)
+
+	results := make(map[string]models.RefreshAccountResult)
+	for _, result := range cycle.Accounts {
+		results[result.AccountID] = result
+	}
+	assert.Equal(t, models.RefreshResultFresh, results["fresh"].Result)
+	assert.Equal(t, models.RefreshResultDisabled, results["disabled"].Result)
+	assert.Equal(t, models.RefreshResultCooldown, results["cooldown"].Result)
+	assert.Equal(t, models.RefreshResultFailed, results["expired"].Result)
+	assert.Contains(t, results["expired"].Error, "invalid_grant")
+}
+
+func TestUserInfoFromIDToken(t *testing.T) {
+	idToken := func(claims string) *oauth2.Token {
+		token := &oauth2.Token{AccessToken: "at"}
+		return token.WithExtra(map[string]interface{}{
+			"id_token": "header." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature",
+		})
+	}
+
+	info, ok := userInfoFromIDToken(idToken(`{"email":"a@example.com","name":"Alice"}`))
+	require.True(t, ok)
+	assert.Equal(t, &UserInfo{Email: "a@example.com", Name: "Alice"}, info)
+
+	info, ok = userInfoFromIDToken(idToken(`{"email":"a@example.com"}`))
+	require.True(t, ok)
+	assert.Equal(t, "a@example.com", info.Name, "name falls back to the email")
+
+	_, ok = userInfoFromIDToken(idToken(`{"sub":"123"}`))
+	assert.False(t, ok, "no email claim")
+	_, ok = userInfoFromIDToken(&oauth2.Token{AccessToken: "at"})
+	assert.False(t, ok, "no ID token")
+}
+
+func TestSaveAccountFromToken_ReloginKeepsAccount(t *testing.T) {
+	client, tmpDir := setupTestClient(t)
+	defer os.RemoveAll(tmpDir)
+	store := client.AccountStore()
+
+	fetchedAt := time.Now().Add(-time.Hour).UnixMilli()
+	existing := &models.Account{
+		AccountID:       "alice_1234",
+		Email:           "alice@example.com",
+		RefreshToken:    "old-refresh",
+		Models:          map[string]models.Model{"gemini-2.5-pro": {ID: "gemini-2.5-pro"}},
+		ModelsUpdatedAt: fetchedAt,
+		Usage:           &models.UsageStats{RequestCount: 42},
+	}
+	require.NoError(t, store.Save(existing))
+
+	// The model list is fresh, so no upstream call is needed
+	token := &oauth2.Token{AccessToken: "new-access", Expiry: time.Now().Add(time.Hour)}
+	account, err := client.SaveAccountFromToken(token, &UserInfo{Email: "Alice@example.com", Name: "Alice"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "alice_1234", account.AccountID, "re-login updates the existing account")
+	assert.Equal(t, "new-access", account.AccessToken)
+	assert.Equal(t, "old-refresh", account.RefreshToken, "kept when Google sends no new refresh token")
+	assert.True(t, account.Enable)
+	assert.Equal(t, int64(42), account.Usage.RequestCount)
+	assert.Equal(t, fetchedAt, account.ModelsUpdatedAt)
+	assert.Contains(t, account.Models, "gemini-2.5-pro")
+
+	ids, err := store.List()
+	require.NoError(t, err)
+	assert.Len(t, ids, 1)
+
+	// New accounts get IDs that don't contain the address
+	assert.Regexp(t, `^acc_[0-9a-f]{16}$`, generateAccountID())
+}
+
+func TestModelsStale(t *testing.T) {
+	client, tmpDir := setupTestClient(t)
+	defer os.RemoveAll(tmpDir)
+
+	withModels := func(age time.Duration) *models.Account {
+		return &models.Account{
+			Models:          map[string]models.Model{"m": {ID: "m"}},
+			ModelsUpdatedAt: time.Now().Add(-age).UnixMilli(),
+		}
+	}
+	assert.True(t, client.modelsStale(&models.Account{}), "never fetched")
+	assert.False(t, client.modelsStale(withModels(time.Hour)))
+	assert.True(t, client.modelsStale(withModels(25*time.Hour)))
+
+	client.SetModelsRefreshInterval(-1)
+	assert.False(t, client.modelsStale(withModels(30*24*time.Hour)), "only refreshed manually")
+	assert.True(t, client.modelsStale(&models.Account{}), "an empty list is always fetched")
+}
+
+func TestRefreshToken_HungEndpointsTimeOut(t *testing.T) {
+	client, tmpDir := setupTestClient(t)
+	defer os.RemoveAll(tmpDir)
+
+	// The token and model list endpoints accept the request and never answer
+	hang := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body) // lets the server notice the client hanging up
+		<-r.Context().Done()
+	})
+	tokenServer := httptest.NewServer(hang)
+	defer tokenServer.Close()
+	client.config.Endpoint.TokenURL = tokenServer.URL
+	client.SetRetryPolicy(RetryPolicy{Timeout: 50 * time.Millisecond, MaxRetries: 1, Backoff: time.Millisecond})
+
+	account := &models.Account{AccountID: "hung", Enable: true, RefreshToken: "rt"}
+	require.NoError(t, client.AccountStore().Save(account))
+
+	start := time.Now()
+	err := client.RefreshToken(account)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second, "the refresh must not wait for the endpoint forever")
+	assert.Equal(t, "failed", account.RefreshStatus)
+
+	client.SetAPIBaseURL(tokenServer.URL)
+	start = time.Now()
+	_, err = client.fetchModels(context.Background(), client.httpClient, "token", nil)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestRefreshStatus(t *testing.T) {
+	client, tmpDir := setupTestClient(t)
+	defer os.RemoveAll(tmpDir)
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+	}))
+	defer tokenServer.Close()
+	client.config.Endpoint.TokenURL = tokenServer.URL
+
+	expired := &models.Account{AccountID: "expired", Enable: true, RefreshToken: "rt", ExpiresIn: 3600,
+		Timestamp: time.Now().Add(-2 * time.Hour).UnixMilli()}
+	require.NoError(t, client.AccountStore().Save(expired))
+
+	status := client.RefreshStatus()
+	assert.False(t, status.Running)
+	assert.Nil(t, status.LastCycle)
+
+	client.StartBackgroundRefresh()
+	require.Eventually(t, func() bool { return client.RefreshStatus().LastCycle != nil }, 5*time.Second, 10*time.Millisecond)
+
+	status = client.RefreshStatus()
+	assert.True(t, status.Running)
+	assert.False(t, status.InProgress)
+	assert.False(t, status.Stalled)
+	assert.Equal(t, int64(1800), status.IntervalSeconds)
+	assert.Equal(t, status.LastCycle.StartedAt, status.LastRunAt)
+	assert.Greater(t, status.NextRunAt, time.Now().UnixMilli())
+	require.Len(t, status.Accounts, 1)
+	assert.Equal(t, "expired", status.Accounts[0].AccountID)
+	assert.Equal(t, models.RefreshResultFailed, status.Accounts[0].Result)
+	assert.Contains(t, status.Accounts[0].Error, "invalid_grant")
+
+	client.StopBackgroundRefresh()
+	require.Eventually(t, func() bool { return !client.RefreshStatus().Running }, time.Second, 10*time.Millisecond)
+	assert.Zero(t, client.RefreshStatus().NextRunAt)
+}
+
+func TestRefreshToken_InvalidGrantNeedsReauth(t *testing.T) {
+	client, tmpDir := setupTestClient(t)
+	defer os.RemoveAll(tmpDir)
+
+	calls := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error": "invalid_grant", "error_description": "Token has been expired or revoked."}`))
+	}))
+	defer tokenServer.Close()
+	client.config.Endpoint.TokenURL = tokenServer.URL
+
+	usage := storage.NewUsageStore(t.TempDir())
+	client.SetRefreshHistory(usage)
+
+	store := client.AccountStore()
+	account := &models.Account{AccountID: "revoked", Email: "revoked@example.com", Enable: true, RefreshToken: "rt",
+		AccessToken: "at", ExpiresIn: 3600, Timestamp: time.Now().Add(-50 * time.Minute).UnixMilli()}
+	require.NoError(t, store.Save(account))
+
+	err := client.RefreshToken(account)
+	assert.ErrorIs(t, err, ErrNeedsReauth)
+	require.NotZero(t, calls)
+	refreshCalls := calls
+
+	saved, err := store.Load("revoked")
+	require.NoError(t, err)
+	assert.True(t, saved.NeedsReauth())
+	assert.Equal(t, "needs_reauth", saved.RefreshStatus)
+	assert.False(t, saved.IsInCooldown(), "no cooldown: the account is not retried at all")
+	assert.False(t, saved.NeedsRefresh())
+
+	// A successful request with the still valid access token keeps the flag
+	saved.RecordSuccess()
+	assert.True(t, saved.NeedsReauth())
+	require.NoError(t, store.Save(saved))
+
+	// The background refresh no longer calls Google for the account
+	client.RefreshAllTokens()
+	assert.Equal(t, refreshCalls, calls)
+	cycles, err := usage.GetRefreshHistory(1)
+	require.NoError(t, err)
+	require.Len(t, cycles, 1)
+	require.Len(t, cycles[0].Accounts, 1)
+	assert.Equal(t, models.RefreshResultNeedsReauth, cycles[0].Accounts[0].Result)
+	assert.Equal(t, 1, cycles[0].Skipped)
+
+	// Logging in again clears it
+	relogin, err := client.SaveAccountFromToken(&oauth2.Token{AccessToken: "new", RefreshToken: "rt2", Expiry: time.Now().Add(time.Hour)},
+		&UserInfo{Email: "revoked@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "revoked", relogin.AccountID)
+	assert.False(t, relogin.NeedsReauth())
+	assert.Equal(t, "success", relogin.RefreshStatus)
+}
+
+func TestRefreshToken_UsesAccountProxy(t *testing.T) {
+	client, tmpDir := setupTestClient(t)
+	defer os.RemoveAll(tmpDir)
+
+	google := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1internal:fetchAvailableModels" {
+			w.Write([]byte(`{"models": {"gemini-2.5-pro": {}}}`))
+			return
+		}
+		w.Write([]byte(`{"access_token": "new-at", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer google.Close()
+	client.config.Endpoint.TokenURL = google.URL + "/token"
+	client.SetAPIBaseURL(google.URL)
+
+	var relayed []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		relayed = append(relayed, r.URL.Path)
+		out := r.Clone(r.Context())
+		out.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer proxy.Close()
+
+	account := &models.Account{AccountID: "proxied", Enable: true, RefreshToken: "rt", Proxy: proxy.URL}
+	require.NoError(t, client.AccountStore().Save(account))
+	require.NoError(t, client.RefreshToken(account))
+	assert.Equal(t, "new-at", account.AccessToken)
+	assert.Equal(t, []string{"/token", "/v1internal:fetchAvailableModels"}, relayed)
+
+	// A broken proxy fails the refresh instead of going direct
+	account.Proxy = "ftp://proxy"
+	assert.Error(t, client.RefreshToken(account))
+	assert.Len(t, relayed, 2)
+}
+
+func TestGetToken_RefreshesInBackgroundBeforeExpiry(t *testing.T) {
+	client, tmpDir := setupTestClient(t)
+	defer os.RemoveAll(tmpDir)
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"new_token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+	client.config.Endpoint.TokenURL = tokenServer.URL
+
+	// Ten minutes left: inside the refresh window but still usable
+	store := client.AccountStore()
+	require.NoError(t, store.Save(&models.Account{
+		AccountID: "acc1", Enable: true, AccessToken: "old_token", RefreshToken: "rt",
+		ExpiresIn: 600, Timestamp: time.Now().UnixMilli(),
+		Models: map[string]models.Model{"gemini-2.5-flash": {ID: "gemini-2.5-flash"}}, ModelsUpdatedAt: time.Now().UnixMilli(),
+	}))
+
+	// The request is served with the current token at once
+	account, err := client.GetToken()
+	require.NoError(t, err)
+	assert.Equal(t, "old_token", account.AccessToken)
+
+	require.Eventually(t, func() bool {
+		saved, err := store.Load("acc1")
+		return err == nil && saved.AccessToken == "new_token"
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Recording the request's outcome on its stale copy keeps the new token
+	_, err = store.Update(account.AccountID, func(saved *models.Account) { saved.RecordFailure("HTTP 500") })
+	require.NoError(t, err)
+	require.NoError(t, store.RecordSuccess(account))
+	saved, err := store.Load("acc1")
+	require.NoError(t, err)
+	assert.Equal(t, "new_token", saved.AccessToken)
+	assert.False(t, saved.NeedsRefresh(), "the next request does not refresh again")
+}
+
+func TestStopBackgroundRefresh_WaitsForRunningCycle(t *testing.T) {
+	client, tmpDir := setupTestClient(t)
+	defer os.RemoveAll(tmpDir)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"new_token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+	client.config.Endpoint.TokenURL = tokenServer.URL
+
+	store := client.AccountStore()
+	require.NoError(t, store.Save(&models.Account{
+		AccountID: "acc1", Enable: true, AccessToken: "old_token", RefreshToken: "rt",
+		ExpiresIn: 3600, Timestamp: time.Now().Add(-2 * time.Hour).UnixMilli(),
+		Models: map[string]models.Model{"gemini-2.5-flash": {ID: "gemini-2.5-flash"}}, ModelsUpdatedAt: time.Now().UnixMilli(),
+	}))
+
+	// The scheduler refreshes at once; stop it while the token request hangs
+	client.StartBackgroundRefresh()
+	<-started
+	stopped := make(chan struct{})
+	go func() {
+		client.StopBackgroundRefresh()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Error("StopBackgroundRefresh returned while a refresh was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+
+	// The cycle's write landed before Stop returned and nothing follows it
+	snapshot := func() map[string]string {
+		files := make(map[string]string)
+		filepath.WalkDir(tmpDir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				data, _ := os.ReadFile(path)
+				files[path] = string(data)
+			}
+			return nil
+		})
+		return files
+	}
+	saved, err := store.Load("acc1")
+	require.NoError(t, err)
+	assert.Equal(t, "new_token", saved.AccessToken)
+	before := snapshot()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, before, snapshot())
+}
+
+func TestPoolStatus_ReusedUntilAccountsChange(t *testing.T) {
+	client, tmpDir := setupTestClient(t)
+	defer os.RemoveAll(tmpDir)
+	store := client.AccountStore()
+	createTestAccount(t, store, "acc1", true, false)
+	createTestAccount(t, store, "acc2", true, false)
+
+	assert.Equal(t, PoolStatus{Total: 2, Available: 2}, client.PoolStatus())
+
+	// A file written behind the store's back is not re-read on every call...
+	account, err := store.Load("acc2")
+	require.NoError(t, err)
+	account.Enable = false
+	data, err := json.Marshal(account)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "acc2.json"), data, 0644))
+	assert.Equal(t, 2, client.PoolStatus().Available)
+
+	// ...but a change through the store is seen right away
+	createTestAccount(t, store, "acc1", false, false)
+	assert.Equal(t, PoolStatus{Total: 2, Available: 0}, client.PoolStatus())
+
+	// and external edits after poolStatusTTL at the latest
+	account.Enable = true
+	data, err = json.Marshal(account)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "acc2.json"), data, 0644))
+	client.poolMu.Lock()
+	client.pool.at = time.Now().Add(-poolStatusTTL)
+	client.poolMu.Unlock()
+	assert.Equal(t, 1, client.PoolStatus().Available)
+}
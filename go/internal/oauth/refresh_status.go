@@ -0,0 +1,133 @@
+package oauth
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"go.uber.org/zap"
+)
+
+// 刷新调度状态：记录后台刷新协程是否存活、上次/下次运行时间、当前轮次已运行多久，以及每个账号最近一次刷新的结果，
+// 卡住的刷新任务可以在令牌过期之前被发现
+
+// refreshInterval is how often the background scheduler runs
+const refreshInterval = 30 * time.Minute
+
+// refreshStallAfter marks a cycle that has run this long as stalled
+const refreshStallAfter = 10 * time.Minute
+
+// schedulerState is guarded by Client.statusMu
+type schedulerState struct {
+	running      bool
+	startedAt    time.Time
+	inProgress   bool
+	currentStart time.Time
+	nextRun      time.Time
+	lastPanic    string
+	lastCycle    *models.RefreshCycle
+	// attempts holds the latest refresh attempt per account
+	attempts map[string]models.RefreshAccountState
+}
+
+// RefreshStatus returns a snapshot of the refresh scheduler
+func (c *Client) RefreshStatus() models.RefreshSchedulerStatus {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+
+	state := &c.scheduler
+	status := models.RefreshSchedulerStatus{
+		Running:         state.running,
+		IntervalSeconds: int64(refreshInterval / time.Second),
+		InProgress:      state.inProgress,
+		LastPanic:       state.lastPanic,
+		Accounts:        []models.RefreshAccountState{},
+	}
+	if !state.startedAt.IsZero() {
+		status.StartedAt = state.startedAt.UnixMilli()
+	}
+	if state.inProgress {
+		status.CurrentRunStartedAt = state.currentStart.UnixMilli()
+		status.Stalled = time.Since(state.currentStart) > refreshStallAfter
+	}
+	if state.running && !state.nextRun.IsZero() {
+		status.NextRunAt = state.nextRun.UnixMilli()
+	}
+	if state.lastCycle != nil {
+		cycle := *state.lastCycle
+		status.LastCycle = &cycle
+		status.LastRunAt = cycle.StartedAt
+	}
+	for _, attempt := range state.attempts {
+		status.Accounts = append(status.Accounts, attempt)
+	}
+	sort.Slice(status.Accounts, func(i, j int) bool { return status.Accounts[i].AccountID < status.Accounts[j].AccountID })
+	return status
+}
+
+// recordRefreshAttempt remembers the outcome of one account refresh
+func (c *Client) recordRefreshAttempt(accountID string, started time.Time, err error) {
+	attempt := models.RefreshAccountState{
+		AccountID:  accountID,
+		Result:     models.RefreshResultRefreshed,
+		At:         started.UnixMilli(),
+		DurationMs: time.Since(started).Milliseconds(),
+	}
+	if err != nil {
+		attempt.Result = models.RefreshResultFailed
+		attempt.Error = err.Error()
+	}
+
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	if c.scheduler.attempts == nil {
+		c.scheduler.attempts = make(map[string]models.RefreshAccountState)
+	}
+	c.scheduler.attempts[accountID] = attempt
+}
+
+// cycleStarted and cycleFinished bracket a RefreshAllTokens run
+func (c *Client) cycleStarted(started time.Time) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	c.scheduler.inProgress = true
+	c.scheduler.currentStart = started
+}
+
+func (c *Client) cycleFinished(cycle models.RefreshCycle) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	c.scheduler.inProgress = false
+	c.scheduler.lastCycle = &cycle
+}
+
+// setSchedulerRunning marks the scheduler goroutine alive or gone
+func (c *Client) setSchedulerRunning(running bool) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	c.scheduler.running = running
+	if running {
+		c.scheduler.startedAt = time.Now()
+	}
+}
+
+func (c *Client) setNextRefresh(next time.Time) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	c.scheduler.nextRun = next
+}
+
+// runScheduledRefresh runs one cycle; a panic is logged and kept in the
+// status instead of killing the scheduler
+func (c *Client) runScheduledRefresh() {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logger.Error("Token refresh cycle panicked", zap.Any("panic", r))
+			c.statusMu.Lock()
+			c.scheduler.lastPanic = fmt.Sprint(r)
+			c.statusMu.Unlock()
+		}
+	}()
+	c.RefreshAllTokens()
+}
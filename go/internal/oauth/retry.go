@@ -0,0 +1,173 @@
+package oauth
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/upstream"
+	"go.uber.org/zap"
+)
+
+// OAuth侧的所有HTTP调用（令牌交换与刷新、用户信息、模型列表）都经过 retryTransport：
+// 每次尝试有独立的超时，网络错误和 408/429/5xx 按指数退避（带随机抖动）有限次重试
+
+// RetryPolicy bounds OAuth-side HTTP calls; zero fields use the defaults
+type RetryPolicy struct {
+	// Timeout limits each attempt, including reading the response body
+	Timeout time.Duration
+	// MaxRetries is the number of retries after the first attempt; negative disables retries
+	MaxRetries int
+	// Backoff is the delay before the first retry, doubled for each further retry
+	Backoff time.Duration
+}
+
+// Retry defaults
+const (
+	DefaultRetryTimeout = 15 * time.Second
+	DefaultMaxRetries   = 2
+	DefaultRetryBackoff = 500 * time.Millisecond
+	maxRetryBackoff     = 8 * time.Second
+)
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Timeout == 0 {
+		p.Timeout = DefaultRetryTimeout
+	}
+	if p.MaxRetries == 0 {
+		p.MaxRetries = DefaultMaxRetries
+	}
+	if p.MaxRetries < 0 {
+		p.MaxRetries = 0
+	}
+	if p.Backoff == 0 {
+		p.Backoff = DefaultRetryBackoff
+	}
+	return p
+}
+
+// retryTransport sends requests through the shared upstream pool, retrying
+// transient failures. Requests with a body are only retried when it can be
+// replayed (GetBody is set, as for http.NewRequest with an in-memory body).
+type retryTransport struct {
+	policy RetryPolicy
+	logger *zap.Logger
+	// base is the transport to use; nil means the shared upstream pool
+	base http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = upstream.Client().Transport
+	}
+	parent := req.Context()
+
+	for attempt := 0; ; attempt++ {
+		attemptReq, err := rewind(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(parent, t.policy.Timeout)
+		resp, err := base.RoundTrip(attemptReq.WithContext(ctx))
+
+		last := attempt >= t.policy.MaxRetries || parent.Err() != nil || (req.Body != nil && req.GetBody == nil)
+		if err == nil && (last || !retryableStatus(resp.StatusCode)) {
+			// The attempt's deadline also covers reading the body
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		if last {
+			cancel()
+			return nil, err
+		}
+
+		delay := retryDelay(t.policy.Backoff, attempt)
+		fields := []zap.Field{
+			zap.String("host", req.URL.Host),
+			zap.String("path", req.URL.Path),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("delay", delay),
+		}
+		if err != nil {
+			fields = append(fields, zap.Error(err))
+		} else {
+			fields = append(fields, zap.Int("status", resp.StatusCode))
+			if after := retryAfter(resp); after > delay {
+				delay = after
+			}
+			upstream.DrainAndClose(resp)
+		}
+		cancel()
+		t.logger.Warn("OAuth request failed, retrying", fields...)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-parent.Done():
+			timer.Stop()
+			return nil, parent.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// rewind returns the request for an attempt, with a fresh body for retries
+func rewind(req *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 0 || req.Body == nil || req.GetBody == nil {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.Body = body
+	return clone, nil
+}
+
+// retryableStatus reports statuses worth another attempt
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay is the exponential backoff for attempt, with up to 50% random jitter
+func retryDelay(backoff time.Duration, attempt int) time.Duration {
+	delay := backoff << attempt
+	if delay <= 0 || delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryAfter reads a Retry-After header in seconds, capped at the maximum backoff
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	if delay := time.Duration(seconds) * time.Second; delay < maxRetryBackoff {
+		return delay
+	}
+	return maxRetryBackoff
+}
+
+// cancelBody releases the attempt's context once the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,261 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/antigravity/api-proxy/internal/storage"
+	"github.com/antigravity/api-proxy/internal/upstream"
+	"go.uber.org/zap"
+)
+
+// topN limits the model/account rankings in a report
+const topN = 5
+
+// Generator builds daily summary reports from the usage and notification stores
+type Generator struct {
+	cfg         config.ReportsConfig
+	usageStore  *storage.UsageStore
+	notifyStore *storage.NotificationStore
+	logger      *zap.Logger
+	// maskAccountIDs hides the email in older account IDs (security.mask_emails)
+	maskAccountIDs bool
+
+	mu   sync.Mutex
+	stop chan struct{}
+}
+
+// NewGenerator creates a report generator
+func NewGenerator(cfg config.ReportsConfig, usageStore *storage.UsageStore, notifyStore *storage.NotificationStore, logger *zap.Logger) *Generator {
+	return &Generator{
+		cfg:         cfg,
+		usageStore:  usageStore,
+		notifyStore: notifyStore,
+		logger:      logger,
+	}
+}
+
+// SetAccountIDMasking hides account emails contained in account IDs
+// (security.mask_emails); call before Start
+func (g *Generator) SetAccountIDMasking(enabled bool) {
+	g.maskAccountIDs = enabled
+}
+
+// Generate builds the report for date (YYYY-MM-DD), writes it to the report
+// directory and pushes it to the webhook when configured
+func (g *Generator) Generate(ctx context.Context, date string) (*models.DailyReport, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid report date %q: %w", date, err)
+	}
+
+	records, err := g.usageStore.GetUsageForDate(date)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.DailyReport{
+		Date:        date,
+		GeneratedAt: time.Now().UnixMilli(),
+		Incidents:   []models.Notification{},
+	}
+	modelCounts := make(map[string]int64)
+	accountCounts := make(map[string]int64)
+	for _, record := range records {
+		report.Requests += record.RequestCount
+		report.InputTokens += record.InputTokens
+		report.OutputTokens += record.OutputTokens
+		report.TotalTokens += record.TotalTokens
+		accountID := record.AccountID
+		if g.maskAccountIDs {
+			accountID = models.MaskAccountID(accountID)
+		}
+		accountCounts[accountID] += record.RequestCount
+		for model, count := range record.Models {
+			modelCounts[model] += count
+		}
+	}
+	report.EstimatedCost = float64(report.InputTokens)/1e6*g.cfg.InputCostPerMillion +
+		float64(report.OutputTokens)/1e6*g.cfg.OutputCostPerMillion
+	report.TopModels = topCounters(modelCounts)
+	report.TopAccounts = topCounters(accountCounts)
+
+	// 当天有更新的通知视为事故
+	start, end := day.UnixMilli(), day.AddDate(0, 0, 1).UnixMilli()
+	for _, n := range g.notifyStore.List(false) {
+		if n.UpdatedAt >= start && n.UpdatedAt < end {
+			report.Incidents = append(report.Incidents, n)
+		}
+	}
+
+	if err := g.save(report); err != nil {
+		return nil, err
+	}
+
+	if g.cfg.WebhookURL != "" {
+		if err := g.push(ctx, report); err != nil {
+			// 推送失败不影响本地报告
+			g.logger.Warn("Failed to push daily report", zap.String("date", date), zap.Error(err))
+		}
+	}
+	return report, nil
+}
+
+// Load reads a previously generated report
+func (g *Generator) Load(date string) (*models.DailyReport, error) {
+	data, err := os.ReadFile(g.reportPath(date))
+	if err != nil {
+		return nil, err
+	}
+
+	var report models.DailyReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal report: %w", err)
+	}
+	return &report, nil
+}
+
+// List returns the dates of all stored reports, newest first
+func (g *Generator) List() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(g.cfg.Dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reports: %w", err)
+	}
+
+	dates := make([]string, 0, len(matches))
+	for _, match := range matches {
+		dates = append(dates, strings.TrimSuffix(filepath.Base(match), ".json"))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
+	return dates, nil
+}
+
+// Start runs the daily job in the background when reports are enabled.
+// The previous day's report is generated at cfg.Hour local time.
+func (g *Generator) Start() {
+	if !g.cfg.Enabled {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stop != nil {
+		return
+	}
+	g.stop = make(chan struct{})
+	stop := g.stop
+
+	go func() {
+		g.logger.Info("Daily report scheduler started", zap.Int("hour", g.cfg.Hour))
+		for {
+			timer := time.NewTimer(time.Until(nextRun(time.Now(), g.cfg.Hour)))
+			select {
+			case <-timer.C:
+				date := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+				if _, err := g.Generate(context.Background(), date); err != nil {
+					g.logger.Error("Failed to generate daily report", zap.String("date", date), zap.Error(err))
+				} else {
+					g.logger.Info("Daily report generated", zap.String("date", date))
+				}
+			case <-stop:
+				timer.Stop()
+				g.logger.Info("Daily report scheduler stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background job; safe to call when it was never started
+func (g *Generator) Stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stop != nil {
+		close(g.stop)
+		g.stop = nil
+	}
+}
+
+func (g *Generator) save(report *models.DailyReport) error {
+	if err := os.MkdirAll(g.cfg.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create reports directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+
+	if err := os.WriteFile(g.reportPath(report.Date), data, 0644); err != nil {
+		return fmt.Errorf("failed to write report file: %w", err)
+	}
+	return nil
+}
+
+func (g *Generator) push(ctx context.Context, report *models.DailyReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", g.cfg.WebhookURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := upstream.Do(ctx, http.DefaultClient, req)
+	if err != nil {
+		return err
+	}
+	defer upstream.DrainAndClose(resp)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (g *Generator) reportPath(date string) string {
+	return filepath.Join(g.cfg.Dir, date+".json")
+}
+
+// nextRun returns the next time after now at hour:00 local time
+func nextRun(now time.Time, hour int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// topCounters returns the highest counts first, ties broken by name
+func topCounters(counts map[string]int64) []models.ReportCounter {
+	result := make([]models.ReportCounter, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, models.ReportCounter{Name: name, Requests: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Requests != result[j].Requests {
+			return result[i].Requests > result[j].Requests
+		}
+		return result[i].Name < result[j].Name
+	})
+	if len(result) > topN {
+		result = result[:topN]
+	}
+	return result
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 单账号并发限制：每个账号同时进行的上游请求数不超过 proxy.max_concurrent_per_account，
+// 名额一直占用到响应（包括整个流）结束。选号时优先跳过已满的账号，所有可用账号都满时等待其他请求释放名额，
+// 避免并行请求集中压在一个账号上触发429，而其他账号闲置
+
+// accountSlots counts in-flight upstream requests per account
+type accountSlots struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+	// released is closed (and replaced) whenever a slot frees up
+	released chan struct{}
+}
+
+func newAccountSlots() *accountSlots {
+	return &accountSlots{inFlight: make(map[string]int), released: make(chan struct{})}
+}
+
+// acquire takes a slot of accountID; limit <= 0 means unlimited. When the
+// account is full it returns false and a channel closed on the next release.
+func (a *accountSlots) acquire(accountID string, limit int) (bool, <-chan struct{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if limit > 0 && a.inFlight[accountID] >= limit {
+		return false, a.released
+	}
+	a.inFlight[accountID]++
+	return true, nil
+}
+
+// release frees a slot taken by acquire
+func (a *accountSlots) release(accountID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.inFlight[accountID] <= 1 {
+		delete(a.inFlight, accountID)
+	} else {
+		a.inFlight[accountID]--
+	}
+	close(a.released)
+	a.released = make(chan struct{})
+}
+
+// full returns the accounts that have reached limit
+func (a *accountSlots) full(limit int) map[string]bool {
+	if limit <= 0 {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	full := make(map[string]bool)
+	for accountID, n := range a.inFlight {
+		if n >= limit {
+			full[accountID] = true
+		}
+	}
+	return full
+}
+
+// accountConcurrency is the per-account in-flight limit (0 = unlimited)
+func (s *Server) accountConcurrency() int {
+	if s.cfg == nil {
+		return 0
+	}
+	return s.cfg.Proxy.MaxConcurrentPerAccount
+}
+
+// acquireAccount picks an account for the next attempt and takes one of its
+// slots; the returned release func must be called once the response is done.
+// Accounts at their limit are passed over, and when every usable account is
+// full it waits for a slot until the request ends.
+func (s *Server) acquireAccount(c *gin.Context, pr *proxyRequest) (*models.Account, func(), error) {
+	limit := s.accountConcurrency()
+	for {
+		exclude := pr.attempted
+		if full := s.accountSlots.full(limit); len(full) > 0 {
+			for accountID := range pr.attempted {
+				full[accountID] = true
+			}
+			exclude = full
+		}
+
+		account, err := s.oauthClient.GetTokenExcluding(pr.estimatedTokens, exclude)
+		if err != nil {
+			return nil, nil, err
+		}
+		ok, released := s.accountSlots.acquire(account.AccountID, limit)
+		if ok {
+			return account, func() { s.accountSlots.release(account.AccountID) }, nil
+		}
+
+		s.requestLogger(c).Debug("Every usable account is at its concurrency limit, waiting",
+			zap.String("account_id", s.displayAccountID(account.AccountID)),
+			zap.Int("limit", limit))
+		select {
+		case <-released:
+		case <-c.Request.Context().Done():
+			return nil, nil, context.Cause(c.Request.Context())
+		}
+	}
+}
+
+// acquireSlot takes a slot of an already chosen account, waiting while it is
+// at its limit until the request ends
+func (s *Server) acquireSlot(c *gin.Context, accountID string) (func(), error) {
+	limit := s.accountConcurrency()
+	for {
+		ok, released := s.accountSlots.acquire(accountID, limit)
+		if ok {
+			return func() { s.accountSlots.release(accountID) }, nil
+		}
+		select {
+		case <-released:
+		case <-c.Request.Context().Done():
+			return nil, context.Cause(c.Request.Context())
+		}
+	}
+}
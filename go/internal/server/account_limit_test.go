@@ -0,0 +1,64 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntegration_AccountConcurrencyLimit(t *testing.T) {
+	h := newTestHarness(t)
+	h.cfg.Proxy.MaxConcurrentPerAccount = 1
+	h.addAccount("acc1")
+	h.addAccount("acc2")
+
+	var mu sync.Mutex
+	inFlight := make(map[string]int)
+	peak := 0
+	gate := make(chan struct{})
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		token := r.Header.Get("Authorization")
+		mu.Lock()
+		inFlight[token]++
+		peak = max(peak, inFlight[token])
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight[token]--
+			mu.Unlock()
+		}()
+
+		select {
+		case <-gate:
+		case <-r.Context().Done():
+			return
+		}
+		writeSSE(w, sseEvents(textEvent("ok"), usageEvent(1, 1)))
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 3)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = h.chat(helloRequest).Code
+		}()
+	}
+
+	// Two requests occupy both accounts; the third waits for a free slot
+	assert.Eventually(t, func() bool { return h.calls.Load() == 2 }, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(2), h.calls.Load())
+
+	close(gate)
+	wg.Wait()
+	assert.Equal(t, []int{200, 200, 200}, codes)
+	assert.Equal(t, int64(3), h.calls.Load())
+	assert.Equal(t, 1, peak, "no account ran two requests at once")
+}
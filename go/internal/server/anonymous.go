@@ -0,0 +1,129 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// 匿名访问模式：面向本机单用户部署，不携带API Key的请求按客户端IP计为匿名用户，
+// 以严格的每分钟请求数和每日token额度限制，避免误暴露到公网后被滥用。
+// 每个客户端每天第一次因额度用尽被拒绝时记一条 budget_exceeded 通知
+
+// anonymousKeyPrefix marks anonymous identities in client_key and rate limit keys
+const anonymousKeyPrefix = "anonymous:"
+
+// anonymousQuota tracks tokens used per client IP for the current day
+type anonymousQuota struct {
+	mu       sync.Mutex
+	day      string
+	used     map[string]int64
+	notified map[string]bool // Identities already reported as over budget today
+}
+
+func newAnonymousQuota() *anonymousQuota {
+	return &anonymousQuota{used: make(map[string]int64), notified: make(map[string]bool)}
+}
+
+// rollover resets the counters when the day changes; callers hold mu
+func (q *anonymousQuota) rollover() {
+	if today := time.Now().Format("2006-01-02"); today != q.day {
+		q.day = today
+		q.used = make(map[string]int64)
+		q.notified = make(map[string]bool)
+	}
+}
+
+// exhausted reports whether identity has used its daily tokens
+func (q *anonymousQuota) exhausted(identity string, limit int64) bool {
+	if limit <= 0 {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	return q.used[identity] >= limit
+}
+
+// firstRejection reports whether this is the first time today identity is
+// turned away for its budget
+func (q *anonymousQuota) firstRejection(identity string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	if q.notified[identity] {
+		return false
+	}
+	q.notified[identity] = true
+	return true
+}
+
+// add charges tokens to identity
+func (q *anonymousQuota) add(identity string, tokens int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	q.used[identity] += tokens
+}
+
+// authenticateAnonymous admits a request without an API key when anonymous
+// mode is on. It reports false (after writing the response) when the
+// client's daily token budget is spent.
+func (s *Server) authenticateAnonymous(c *gin.Context) bool {
+	anon := s.cfg.Security.Anonymous
+	identity := anonymousKeyPrefix + c.ClientIP()
+
+	if s.anonymousQuotaSpent(c, identity) {
+		return false
+	}
+
+	// The per-IP request limit reuses the per-key limiter
+	key := &models.APIKey{Key: identity, Name: "anonymous"}
+	if anon.RequestsPerMinute > 0 {
+		key.RateLimit = &models.RateLimit{
+			Enabled:     true,
+			MaxRequests: anon.RequestsPerMinute,
+			WindowMs:    int(time.Minute.Milliseconds()),
+		}
+	}
+	c.Set("api_key", key)
+	c.Set("api_key_source", "anonymous")
+	c.Set("client_key", identity)
+	return true
+}
+
+// anonymousQuotaSpent answers with a 429 and reports true when identity has
+// used its daily tokens
+func (s *Server) anonymousQuotaSpent(c *gin.Context, identity string) bool {
+	limit := s.cfg.Security.Anonymous.DailyTokens
+	if !s.anonymous.exhausted(identity, limit) {
+		return false
+	}
+	if s.anonymous.firstRejection(identity) {
+		s.notifyStore.Add(models.NotifyBudgetExceeded, "", fmt.Sprintf("Anonymous client %s used its daily budget of %d tokens",
+			c.ClientIP(), limit))
+	}
+	apiError(c, 429, models.ErrorDetail{
+		Message: s.t(c, "anonymous_quota_exceeded"),
+		Type:    errTypeRateLimit,
+		Code:    "anonymous_quota_exceeded",
+	})
+	c.Abort()
+	return true
+}
+
+// requireAPIKey rejects anonymous callers on endpoints that store data or
+// run unattended work (files, batches)
+func (s *Server) requireAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("api_key_source") == "anonymous" {
+			openAIError(c, 401, "missing_api_key", s.t(c, "missing_api_key"))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
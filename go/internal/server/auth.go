@@ -0,0 +1,265 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 可插拔认证链：每种认证方式（配置文件密钥、具名静态密钥、密钥库、mTLS 客户端证书、匿名访问、OIDC 会话、管理密码）
+// 都是一个 authenticator，各路由组按顺序组合自己需要的认证器。第一个接受或拒绝请求的认证器决定结果，
+// 都不认识的请求由链的 reject 回复。新增认证方式只需实现 authenticator 并加入对应的链，不必修改中间件
+
+// authOutcome is an authenticator's verdict on a request
+type authOutcome int
+
+const (
+	// authSkip means the credential is not one the authenticator handles
+	authSkip authOutcome = iota
+	// authOK means the request is authenticated and its identity is in the context
+	authOK
+	// authDenied means the request is rejected and the response is written
+	authDenied
+)
+
+// credential is what the request presented, passed along the chain
+type credential struct {
+	// token is the API key or admin token; an authenticator may replace it,
+	// e.g. a client certificate mapped to an API key
+	token string
+}
+
+// authenticator checks one kind of credential
+type authenticator interface {
+	authenticate(c *gin.Context, cred *credential) authOutcome
+}
+
+// authFunc adapts a function to authenticator
+type authFunc func(c *gin.Context, cred *credential) authOutcome
+
+func (f authFunc) authenticate(c *gin.Context, cred *credential) authOutcome {
+	return f(c, cred)
+}
+
+// authChain is the authentication of one route group
+type authChain struct {
+	// credential reads the presented token from the request
+	credential     func(c *gin.Context) string
+	authenticators []authenticator
+	// reject answers a request no authenticator accepted or denied
+	reject func(c *gin.Context, cred *credential)
+}
+
+// identify runs the authenticators in order until one accepts or denies the
+// request; authSkip means none did and nothing was written
+func (a *authChain) identify(c *gin.Context) (authOutcome, *credential) {
+	cred := &credential{token: a.credential(c)}
+	for _, auth := range a.authenticators {
+		if outcome := auth.authenticate(c, cred); outcome != authSkip {
+			return outcome, cred
+		}
+	}
+	return authSkip, cred
+}
+
+// check authenticates the request, answering and aborting it when that fails
+func (a *authChain) check(c *gin.Context) bool {
+	outcome, cred := a.identify(c)
+	switch outcome {
+	case authOK:
+		return true
+	case authSkip:
+		a.reject(c, cred)
+	}
+	c.Abort()
+	return false
+}
+
+// middleware admits only authenticated requests
+func (a *authChain) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if a.check(c) {
+			c.Next()
+		}
+	}
+}
+
+// ==================== API 认证 ====================
+
+// apiAuthChain authenticates /v1 and /v1beta: a client certificate or bearer
+// key, then anonymous access, then the config key, named keys and the key store
+func (s *Server) apiAuthChain() *authChain {
+	return &authChain{
+		credential: requestAPIKey,
+		authenticators: []authenticator{
+			s.clientCertAuth(),
+			s.anonymousAuth(),
+			s.requireAPIKeyAuth(),
+			s.configKeyAuth(),
+			s.staticKeyAuth(),
+			s.keyStoreAuth(),
+		},
+		reject: func(c *gin.Context, cred *credential) {
+			s.requestLogger(c).Warn("Invalid API key attempt",
+				zap.String("key_prefix", maskAPIKey(cred.token)),
+				zap.String("client_ip", c.ClientIP()))
+			openAIError(c, 401, "invalid_api_key", s.t(c, "invalid_api_key"))
+		},
+	}
+}
+
+// clientCertAuth maps a verified client certificate to its API key, which
+// stands in for the bearer key; with require_client_cert it is mandatory
+func (s *Server) clientCertAuth() authenticator {
+	return authFunc(func(c *gin.Context, cred *credential) authOutcome {
+		if apiKey, identity := s.clientCertKey(c); apiKey != "" {
+			c.Set("client_cert", identity)
+			cred.token = apiKey
+			return authSkip
+		}
+		if s.cfg.Server.TLS.RequireClientCert && !isInternalRequest(c) {
+			openAIError(c, 401, "client_certificate_required", s.t(c, "client_certificate_required"))
+			return authDenied
+		}
+		return authSkip
+	})
+}
+
+// anonymousAuth admits keyless requests when anonymous access is on
+func (s *Server) anonymousAuth() authenticator {
+	return authFunc(func(c *gin.Context, cred *credential) authOutcome {
+		if cred.token != "" || !s.cfg.Security.Anonymous.Enabled || s.cfg.Server.TLS.RequireClientCert {
+			return authSkip
+		}
+		if s.authenticateAnonymous(c) {
+			return authOK
+		}
+		return authDenied
+	})
+}
+
+// requireAPIKeyAuth rejects requests that presented no key at all
+func (s *Server) requireAPIKeyAuth() authenticator {
+	return authFunc(func(c *gin.Context, cred *credential) authOutcome {
+		if cred.token != "" {
+			return authSkip
+		}
+		openAIError(c, 401, "missing_api_key", s.t(c, "missing_api_key"))
+		return authDenied
+	})
+}
+
+// configKeyAuth accepts security.api_key (backward compatibility)
+func (s *Server) configKeyAuth() authenticator {
+	return authFunc(func(c *gin.Context, cred *credential) authOutcome {
+		if s.cfg.Security.APIKey == "" {
+			return authSkip
+		}
+		if cred.token != s.cfg.Security.APIKey {
+			s.requestLogger(c).Debug("Config API key check failed",
+				zap.String("config_key_prefix", maskAPIKey(s.cfg.Security.APIKey)),
+				zap.String("provided_key_prefix", maskAPIKey(cred.token)))
+			return authSkip
+		}
+		s.requestLogger(c).Info("API request authenticated with config API key",
+			zap.String("client_ip", c.ClientIP()))
+		c.Set("api_key_source", "config")
+		c.Set("client_key", cred.token)
+		return authOK
+	})
+}
+
+// staticKeyAuth accepts the named keys declared in security.api_keys; they
+// carry their own limits but no persisted usage
+func (s *Server) staticKeyAuth() authenticator {
+	return authFunc(func(c *gin.Context, cred *credential) authOutcome {
+		key, ok := s.staticKey(cred.token)
+		if !ok {
+			return authSkip
+		}
+		c.Set("api_key", key)
+		c.Set("api_key_source", "config")
+		c.Set("client_key", cred.token)
+		return authOK
+	})
+}
+
+// keyStoreAuth accepts the dynamic keys created through the admin API and
+// records their usage
+func (s *Server) keyStoreAuth() authenticator {
+	return authFunc(func(c *gin.Context, cred *credential) authOutcome {
+		key, err := s.keyStore.Load(cred.token)
+		if err != nil {
+			return authSkip
+		}
+
+		if key.Expired() {
+			s.requestLogger(c).Warn("Expired API key used",
+				zap.String("key_prefix", maskAPIKey(cred.token)),
+				zap.String("client_ip", c.ClientIP()))
+			openAIError(c, 401, "api_key_expired", s.t(c, "api_key_expired"))
+			return authDenied
+		}
+
+		// Update usage for dynamic keys
+		key.UpdateUsage()
+		if err := s.keyStore.Save(key); err != nil {
+			s.requestLogger(c).Error("Failed to update key usage", zap.Error(err))
+		}
+
+		c.Set("api_key", key)
+		c.Set("api_key_source", "database")
+		c.Set("client_key", cred.token)
+		return authOK
+	})
+}
+
+// ==================== 管理认证 ====================
+
+// adminAuthChain authenticates the admin API by X-Admin-Token: an SSO session
+// or the token of the shared admin password
+func (s *Server) adminAuthChain() *authChain {
+	return &authChain{
+		credential: func(c *gin.Context) string {
+			return c.GetHeader("X-Admin-Token")
+		},
+		authenticators: []authenticator{
+			s.oidcSessionAuth(),
+			s.adminPasswordAuth(),
+		},
+		reject: func(c *gin.Context, cred *credential) {
+			if cred.token != "" {
+				s.requestLogger(c).Warn("Invalid admin token attempt",
+					zap.String("client_ip", c.ClientIP()))
+			}
+			c.JSON(401, gin.H{"error": s.t(c, "unauthorized")})
+		},
+	}
+}
+
+// oidcSessionAuth accepts the session token of an OIDC login
+func (s *Server) oidcSessionAuth() authenticator {
+	return authFunc(func(c *gin.Context, cred *credential) authOutcome {
+		if s.oidc == nil || cred.token == "" {
+			return authSkip
+		}
+		session, ok := s.oidc.session(cred.token)
+		if !ok {
+			return authSkip
+		}
+		c.Set("admin_user", session.User)
+		c.Set("admin_role", session.Role)
+		return authOK
+	})
+}
+
+// adminPasswordAuth accepts the token issued for the shared admin password
+func (s *Server) adminPasswordAuth() authenticator {
+	return authFunc(func(c *gin.Context, cred *credential) authOutcome {
+		if cred.token == "" || !s.passwordLoginEnabled() || cred.token != generateToken(s.cfg.Security.AdminPassword) {
+			return authSkip
+		}
+		c.Set("admin_user", "admin")
+		c.Set("admin_role", roleAdmin)
+		return authOK
+	})
+}
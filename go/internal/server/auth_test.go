@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthChain_FirstVerdictWins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var reached []string
+	record := func(name string, outcome authOutcome) authenticator {
+		return authFunc(func(c *gin.Context, cred *credential) authOutcome {
+			reached = append(reached, name)
+			if outcome == authDenied {
+				c.JSON(403, gin.H{"error": name})
+			}
+			if outcome == authOK {
+				c.Set("user", name+":"+cred.token)
+			}
+			return outcome
+		})
+	}
+	serve := func(chain *authChain) *httptest.ResponseRecorder {
+		reached = nil
+		router := gin.New()
+		router.GET("/", chain.middleware(), func(c *gin.Context) {
+			c.String(200, c.GetString("user"))
+		})
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Token", "abc")
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	chain := func(authenticators ...authenticator) *authChain {
+		return &authChain{
+			credential:     func(c *gin.Context) string { return c.GetHeader("X-Token") },
+			authenticators: authenticators,
+			reject:         func(c *gin.Context, cred *credential) { c.JSON(http.StatusUnauthorized, gin.H{}) },
+		}
+	}
+
+	rec := serve(chain(record("a", authSkip), record("b", authOK), record("c", authOK)))
+	assert.Equal(t, 200, rec.Code)
+	assert.Equal(t, "b:abc", rec.Body.String())
+	assert.Equal(t, []string{"a", "b"}, reached)
+
+	rec = serve(chain(record("a", authDenied), record("b", authOK)))
+	assert.Equal(t, 403, rec.Code)
+	assert.Equal(t, []string{"a"}, reached)
+
+	rec = serve(chain(record("a", authSkip)))
+	assert.Equal(t, 401, rec.Code, "unclaimed requests are rejected")
+}
+
+func TestIntegration_APIAuthChain(t *testing.T) {
+	h := newTestHarness(t)
+	h.addAccount("acc1")
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, sseEvents(textEvent("Hello"), usageEvent(3, 2)))
+	}
+
+	rec := httptest.NewRecorder()
+	h.server.Router().ServeHTTP(rec, httptest.NewRequest("GET", "/v1/models", nil))
+	assert.Equal(t, 401, rec.Code)
+	assert.Contains(t, rec.Body.String(), "missing_api_key")
+
+	rec = h.chatAs("sk-unknown", helloRequest)
+	assert.Equal(t, 401, rec.Code)
+	assert.Contains(t, rec.Body.String(), "invalid_api_key")
+
+	rec = h.chat(helloRequest)
+	assert.Equal(t, 200, rec.Code, rec.Body.String())
+}
@@ -0,0 +1,686 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// 轻量级批处理（兼容OpenAI Batch API）：上传JSONL请求文件后异步执行，
+// 每条请求都经过正常的 /v1/chat/completions 处理链（认证、限流、账号轮换、用量统计），
+// 结果写入输出文件。服务重启时未完成的批次会从头重新执行。
+// 文件和批次只按密钥的哈希记录归属，不把密钥本身写入磁盘；重启后按哈希在现有密钥中找回创建者
+
+const (
+	// maxBatchFileSize bounds uploaded batch input files
+	maxBatchFileSize = 100 << 20
+	// maxBatchRequests bounds the number of lines in one batch
+	maxBatchRequests = 50000
+	// batchCompletionWindow is the only supported completion_window
+	batchCompletionWindow = 24 * time.Hour
+	// batchItemRetries is how often a rate-limited line is retried
+	batchItemRetries = 3
+	// batchSaveInterval throttles progress writes while a batch runs
+	batchSaveInterval = time.Second
+	// batchOwnerPrefix marks hashed owners; older records hold the key itself
+	batchOwnerPrefix = "sha256:"
+)
+
+// batchOwner identifies the client key owning batch files and batches on disk
+func batchOwner(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return batchOwnerPrefix + hex.EncodeToString(sum[:])
+}
+
+// requestBatchOwner is the batchOwner of the request's client key
+func requestBatchOwner(c *gin.Context) string {
+	return batchOwner(clientKey(c))
+}
+
+// migrateBatchOwner hashes an owner stored before owners were hashed
+func migrateBatchOwner(owner string) string {
+	if strings.HasPrefix(owner, batchOwnerPrefix) {
+		return owner
+	}
+	return batchOwner(owner)
+}
+
+// batchOwnerKey finds the client key behind owner among the configured and
+// stored API keys, so a resumed batch can replay its lines
+func (s *Server) batchOwnerKey(owner string) (string, bool) {
+	candidates := []string{s.cfg.Security.APIKey}
+	for _, key := range s.cfg.Security.APIKeys {
+		candidates = append(candidates, key.Key)
+	}
+	if keys, err := s.keyStore.List(); err == nil {
+		for _, key := range keys {
+			candidates = append(candidates, key.Key)
+		}
+	}
+	for _, key := range candidates {
+		if key != "" && batchOwner(key) == owner {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// batchRunner executes batches in the background
+type batchRunner struct {
+	s   *Server
+	sem chan struct{} // Limits concurrent requests across all batches
+
+	ctx  context.Context // Cancelled on server shutdown
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+
+	mu         sync.Mutex
+	cancels    map[string]context.CancelFunc // Running batches by ID
+	cancelling map[string]int64              // Running batches cancelled by the client, with the cancel time
+}
+
+func newBatchRunner(s *Server, concurrency int) *batchRunner {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	return &batchRunner{
+		s:          s,
+		sem:        make(chan struct{}, concurrency),
+		ctx:        ctx,
+		stop:       stop,
+		cancels:    make(map[string]context.CancelFunc),
+		cancelling: make(map[string]int64),
+	}
+}
+
+// Resume restarts batches interrupted by a previous shutdown, after hashing
+// the owners of records saved with the plain key
+func (r *batchRunner) Resume() {
+	if err := r.s.batchStore.MigrateOwners(migrateBatchOwner); err != nil {
+		r.s.logger.Warn("Failed to migrate batch owners", zap.Error(err))
+	}
+	all, err := r.s.batchStore.AllBatches()
+	if err != nil {
+		r.s.logger.Warn("Failed to load batches", zap.Error(err))
+		return
+	}
+	for owner, batches := range all {
+		for _, batch := range batches {
+			switch batch.Status {
+			case models.BatchValidating, models.BatchInProgress, models.BatchFinalizing:
+				key, ok := r.s.batchOwnerKey(owner)
+				if !ok {
+					r.fail(owner, batch, models.BatchError{Code: "invalid_api_key", Message: "The API key that created this batch no longer exists"})
+					continue
+				}
+				r.Start(owner, key, batch)
+			case models.BatchCancelling:
+				r.finish(owner, batch, models.BatchCancelled, nil)
+			}
+		}
+	}
+}
+
+// Start runs a batch in the background; key is the client key behind owner,
+// used to replay the lines
+func (r *batchRunner) Start(owner, key string, batch *models.Batch) {
+	ctx, cancel := context.WithDeadline(r.ctx, time.Unix(batch.ExpiresAt, 0))
+	r.mu.Lock()
+	r.cancels[batch.ID] = cancel
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer func() {
+			r.mu.Lock()
+			delete(r.cancels, batch.ID)
+			delete(r.cancelling, batch.ID)
+			r.mu.Unlock()
+			cancel()
+		}()
+		r.run(ctx, owner, key, batch)
+	}()
+}
+
+// Cancel saves a running batch in its cancelling state and stops it; it
+// reports whether the batch was running. Until the batch finishes, the runner
+// keeps saving it as cancelling rather than in progress, so status reads and
+// a restart (which finishes cancelling batches) see the cancellation.
+func (r *batchRunner) Cancel(owner string, batch *models.Batch) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cancel, ok := r.cancels[batch.ID]
+	if !ok {
+		return false
+	}
+	r.cancelling[batch.ID] = *batch.CancellingAt
+	if err := r.s.batchStore.SaveBatch(owner, batch); err != nil {
+		r.s.logger.Error("Failed to save batch", zap.String("batch_id", batch.ID), zap.Error(err))
+	}
+	cancel()
+	return true
+}
+
+// Close stops all batches without changing their status so they resume on restart
+func (r *batchRunner) Close() {
+	r.stop()
+	r.wg.Wait()
+}
+
+func (r *batchRunner) run(ctx context.Context, owner, key string, batch *models.Batch) {
+	logger := r.s.logger.With(zap.String("batch_id", batch.ID))
+
+	content, err := r.s.batchStore.ReadFileContent(owner, batch.InputFileID)
+	if err != nil {
+		r.fail(owner, batch, models.BatchError{Code: "invalid_file", Message: "Input file could not be read"})
+		return
+	}
+	lines, errs := parseBatchInput(content, batch.Endpoint)
+	if len(errs) > 0 {
+		r.fail(owner, batch, errs...)
+		return
+	}
+
+	now := time.Now().Unix()
+	batch.Status = models.BatchInProgress
+	batch.InProgressAt = &now
+	batch.RequestCounts = models.BatchRequestCounts{Total: len(lines)}
+	r.save(owner, batch)
+	logger.Info("Batch started", zap.Int("requests", len(lines)))
+
+	results := make([]*models.BatchResponseLine, len(lines))
+	var mu sync.Mutex
+	lastSave := time.Now()
+	var wg sync.WaitGroup
+
+dispatch:
+	for i, line := range lines {
+		select {
+		case r.sem <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
+
+		wg.Add(1)
+		go func(i int, line models.BatchRequestLine) {
+			defer wg.Done()
+			defer func() { <-r.sem }()
+
+			result, ok := r.execute(ctx, key, line)
+			if !ok {
+				return // Stopped before a response arrived
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[i] = result
+			if result.Response != nil && result.Response.StatusCode == 200 {
+				batch.RequestCounts.Completed++
+			} else {
+				batch.RequestCounts.Failed++
+			}
+			if time.Since(lastSave) >= batchSaveInterval {
+				lastSave = time.Now()
+				r.save(owner, batch)
+			}
+		}(i, line)
+	}
+	wg.Wait()
+
+	switch {
+	case r.ctx.Err() != nil:
+		// Server shutting down: leave the batch in progress so Resume restarts it
+		logger.Info("Batch interrupted by shutdown")
+		return
+	case ctx.Err() == context.DeadlineExceeded:
+		r.finish(owner, batch, models.BatchExpired, results)
+	case ctx.Err() != nil:
+		r.finish(owner, batch, models.BatchCancelled, results)
+	default:
+		r.finish(owner, batch, models.BatchCompleted, results)
+	}
+	logger.Info("Batch finished",
+		zap.String("status", batch.Status),
+		zap.Int("completed", batch.RequestCounts.Completed),
+		zap.Int("failed", batch.RequestCounts.Failed))
+}
+
+// execute sends one line through the chat completion handler chain,
+// authenticated with key. ok is false when ctx ended before any response was
+// produced.
+func (r *batchRunner) execute(ctx context.Context, key string, line models.BatchRequestLine) (*models.BatchResponseLine, bool) {
+	// Batch requests always return complete responses
+	line.Body["stream"] = false
+	body, err := json.Marshal(line.Body)
+	if err != nil {
+		return &models.BatchResponseLine{
+			ID:       "batch_req_" + uuid.New().String(),
+			CustomID: line.CustomID,
+			Error:    &models.BatchResponseError{Code: "invalid_body", Message: err.Error()},
+		}, true
+	}
+
+	var w *batchResponseWriter
+	for attempt := 0; ; attempt++ {
+		// Marked internal: the owner key was already authenticated when the batch was created
+		req, err := http.NewRequestWithContext(context.WithValue(ctx, internalRequest{}, true), "POST", line.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, false
+		}
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set("Content-Type", "application/json")
+
+		w = newBatchResponseWriter()
+		r.s.router.ServeHTTP(w, req)
+		if ctx.Err() != nil {
+			return nil, false
+		}
+
+		// Back off on per-key or pool rate limits instead of failing the line
+		if w.status != 429 || attempt >= batchItemRetries {
+			break
+		}
+		wait := 5 * time.Second
+		if seconds, err := strconv.Atoi(w.header.Get("Retry-After")); err == nil && seconds > 0 {
+			wait = time.Duration(seconds) * time.Second
+		}
+		if !sleepCtx(ctx, wait) {
+			return nil, false
+		}
+	}
+
+	var respBody interface{}
+	if err := json.Unmarshal(w.body.Bytes(), &respBody); err != nil {
+		respBody = w.body.String()
+	}
+	return &models.BatchResponseLine{
+		ID:       "batch_req_" + uuid.New().String(),
+		CustomID: line.CustomID,
+		Response: &models.BatchResponse{
+			StatusCode: w.status,
+			RequestID:  "req_" + uuid.New().String(),
+			Body:       respBody,
+		},
+	}, true
+}
+
+// finish writes output/error files and moves the batch to its final status
+func (r *batchRunner) finish(owner string, batch *models.Batch, status string, results []*models.BatchResponseLine) {
+	now := time.Now().Unix()
+	batch.FinalizingAt = &now
+	batch.Status = models.BatchFinalizing
+	r.save(owner, batch)
+
+	var output, errorsOut bytes.Buffer
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			continue
+		}
+		if result.Response != nil && result.Response.StatusCode == 200 {
+			output.Write(data)
+			output.WriteByte('\n')
+		} else {
+			errorsOut.Write(data)
+			errorsOut.WriteByte('\n')
+		}
+	}
+	if output.Len() > 0 {
+		batch.OutputFileID = r.saveOutput(owner, batch, "output", output.Bytes())
+	}
+	if errorsOut.Len() > 0 {
+		batch.ErrorFileID = r.saveOutput(owner, batch, "errors", errorsOut.Bytes())
+	}
+
+	now = time.Now().Unix()
+	batch.Status = status
+	switch status {
+	case models.BatchCompleted:
+		batch.CompletedAt = &now
+	case models.BatchExpired:
+		batch.ExpiredAt = &now
+	case models.BatchCancelled:
+		batch.CancelledAt = &now
+	}
+	r.save(owner, batch)
+}
+
+func (r *batchRunner) fail(owner string, batch *models.Batch, errs ...models.BatchError) {
+	now := time.Now().Unix()
+	batch.Status = models.BatchFailed
+	batch.FailedAt = &now
+	batch.Errors = &models.BatchErrors{Object: "list", Data: errs}
+	r.save(owner, batch)
+}
+
+func (r *batchRunner) saveOutput(owner string, batch *models.Batch, kind string, content []byte) *string {
+	file := &models.FileObject{
+		ID:        "file-" + uuid.New().String(),
+		Object:    "file",
+		Bytes:     int64(len(content)),
+		CreatedAt: time.Now().Unix(),
+		Filename:  fmt.Sprintf("%s_%s.jsonl", batch.ID, kind),
+		Purpose:   "batch_output",
+	}
+	if err := r.s.batchStore.SaveFile(owner, file, content); err != nil {
+		r.s.logger.Error("Failed to save batch output", zap.String("batch_id", batch.ID), zap.Error(err))
+		return nil
+	}
+	return &file.ID
+}
+
+func (r *batchRunner) save(owner string, batch *models.Batch) {
+	// Held while saving so a save can't land between Cancel's save and the runner seeing it
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cancelledAt, cancelled := r.cancelling[batch.ID]; cancelled {
+		// Progress saved after the client cancelled must not undo the cancelling status
+		if batch.Status == models.BatchValidating || batch.Status == models.BatchInProgress {
+			batch.Status = models.BatchCancelling
+		}
+		if batch.CancellingAt == nil {
+			batch.CancellingAt = &cancelledAt
+		}
+	}
+	if err := r.s.batchStore.SaveBatch(owner, batch); err != nil {
+		r.s.logger.Error("Failed to save batch", zap.String("batch_id", batch.ID), zap.Error(err))
+	}
+}
+
+// parseBatchInput decodes and validates a JSONL batch input file
+func parseBatchInput(content []byte, endpoint string) ([]models.BatchRequestLine, []models.BatchError) {
+	var lines []models.BatchRequestLine
+	var errs []models.BatchError
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), maxBatchFileSize)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		n := lineNo
+
+		var line models.BatchRequestLine
+		switch {
+		case json.Unmarshal(text, &line) != nil:
+			errs = append(errs, models.BatchError{Code: "invalid_json_line", Message: "Line is not valid JSON", Line: &n})
+		case line.CustomID == "":
+			errs = append(errs, models.BatchError{Code: "missing_custom_id", Message: "custom_id is required", Line: &n})
+		case seen[line.CustomID]:
+			errs = append(errs, models.BatchError{Code: "duplicate_custom_id", Message: "custom_id must be unique", Line: &n})
+		case line.Method != "POST":
+			errs = append(errs, models.BatchError{Code: "invalid_method", Message: "method must be POST", Line: &n})
+		case line.URL != endpoint:
+			errs = append(errs, models.BatchError{Code: "mismatched_url", Message: "url must match the batch endpoint " + endpoint, Line: &n})
+		case line.Body == nil:
+			errs = append(errs, models.BatchError{Code: "missing_body", Message: "body is required", Line: &n})
+		default:
+			seen[line.CustomID] = true
+			lines = append(lines, line)
+		}
+
+		// Stop collecting after a screenful of errors
+		if len(errs) >= 100 {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, models.BatchError{Code: "invalid_file", Message: err.Error()})
+	}
+	if len(errs) == 0 && len(lines) == 0 {
+		errs = append(errs, models.BatchError{Code: "empty_file", Message: "Input file contains no requests"})
+	}
+	if len(lines) > maxBatchRequests {
+		errs = append(errs, models.BatchError{Code: "too_many_requests", Message: fmt.Sprintf("A batch may contain at most %d requests", maxBatchRequests)})
+	}
+	return lines, errs
+}
+
+// batchResponseWriter captures an in-process handler response
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBatchResponseWriter() *batchResponseWriter {
+	return &batchResponseWriter{header: make(http.Header), status: 200}
+}
+
+func (w *batchResponseWriter) Header() http.Header         { return w.header }
+func (w *batchResponseWriter) Write(p []byte) (int, error) { return w.body.Write(p) }
+func (w *batchResponseWriter) WriteHeader(status int)      { w.status = status }
+
+// ==================== Files / Batches API ====================
+
+func (s *Server) uploadFile(c *gin.Context) {
+	purpose := c.PostForm("purpose")
+	if purpose != "batch" {
+		openAIError(c, 400, "invalid_purpose", "Only purpose=batch is supported")
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		openAIError(c, 400, "missing_file", "A file is required")
+		return
+	}
+	if header.Size > maxBatchFileSize {
+		openAIError(c, 400, "file_too_large", fmt.Sprintf("Files may be at most %d bytes", maxBatchFileSize))
+		return
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		openAIError(c, 400, "invalid_file", "Failed to read file")
+		return
+	}
+	defer f.Close()
+	content, err := io.ReadAll(io.LimitReader(f, maxBatchFileSize))
+	if err != nil {
+		openAIError(c, 400, "invalid_file", "Failed to read file")
+		return
+	}
+
+	file := &models.FileObject{
+		ID:        "file-" + uuid.New().String(),
+		Object:    "file",
+		Bytes:     int64(len(content)),
+		CreatedAt: time.Now().Unix(),
+		Filename:  header.Filename,
+		Purpose:   purpose,
+	}
+	if err := s.batchStore.SaveFile(requestBatchOwner(c), file, content); err != nil {
+		s.logger.Error("Failed to save file", zap.Error(err))
+		openAIError(c, 500, "server_error", "Failed to save file")
+		return
+	}
+	c.JSON(200, file)
+}
+
+func (s *Server) listFiles(c *gin.Context) {
+	files, err := s.batchStore.ListFiles(requestBatchOwner(c))
+	if err != nil {
+		s.logger.Error("Failed to list files", zap.Error(err))
+		openAIError(c, 500, "server_error", "Failed to list files")
+		return
+	}
+	c.JSON(200, gin.H{"object": "list", "data": files})
+}
+
+func (s *Server) getFile(c *gin.Context) {
+	file, err := s.batchStore.LoadFile(requestBatchOwner(c), c.Param("id"))
+	if err != nil {
+		openAIError(c, 404, "file_not_found", "No such file")
+		return
+	}
+	c.JSON(200, file)
+}
+
+func (s *Server) getFileContent(c *gin.Context) {
+	content, err := s.batchStore.ReadFileContent(requestBatchOwner(c), c.Param("id"))
+	if err != nil {
+		openAIError(c, 404, "file_not_found", "No such file")
+		return
+	}
+	c.Data(200, "application/jsonl", content)
+}
+
+func (s *Server) deleteFile(c *gin.Context) {
+	id := c.Param("id")
+	if err := s.batchStore.DeleteFile(requestBatchOwner(c), id); err != nil {
+		if os.IsNotExist(err) {
+			openAIError(c, 404, "file_not_found", "No such file")
+			return
+		}
+		s.logger.Error("Failed to delete file", zap.Error(err))
+		openAIError(c, 500, "server_error", "Failed to delete file")
+		return
+	}
+	c.JSON(200, gin.H{"id": id, "object": "file", "deleted": true})
+}
+
+func (s *Server) createBatch(c *gin.Context) {
+	var req struct {
+		InputFileID      string            `json:"input_file_id" binding:"required"`
+		Endpoint         string            `json:"endpoint" binding:"required"`
+		CompletionWindow string            `json:"completion_window" binding:"required"`
+		Metadata         map[string]string `json:"metadata"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		openAIError(c, 400, "invalid_request", "Invalid request: "+err.Error())
+		return
+	}
+	if req.Endpoint != "/v1/chat/completions" {
+		openAIError(c, 400, "invalid_endpoint", "Only /v1/chat/completions is supported")
+		return
+	}
+	if req.CompletionWindow != "24h" {
+		openAIError(c, 400, "invalid_completion_window", "completion_window must be 24h")
+		return
+	}
+
+	owner := requestBatchOwner(c)
+	if _, err := s.batchStore.LoadFile(owner, req.InputFileID); err != nil {
+		openAIError(c, 404, "file_not_found", "No such input file")
+		return
+	}
+
+	now := time.Now()
+	batch := &models.Batch{
+		ID:               "batch_" + uuid.New().String(),
+		Object:           "batch",
+		Endpoint:         req.Endpoint,
+		InputFileID:      req.InputFileID,
+		CompletionWindow: req.CompletionWindow,
+		Status:           models.BatchValidating,
+		CreatedAt:        now.Unix(),
+		ExpiresAt:        now.Add(batchCompletionWindow).Unix(),
+		Metadata:         req.Metadata,
+	}
+	if err := s.batchStore.SaveBatch(owner, batch); err != nil {
+		s.logger.Error("Failed to save batch", zap.Error(err))
+		openAIError(c, 500, "server_error", "Failed to create batch")
+		return
+	}
+
+	// The runner owns its own copy from here on
+	started := *batch
+	s.batches.Start(owner, clientKey(c), &started)
+	c.JSON(200, batch)
+}
+
+func (s *Server) listBatches(c *gin.Context) {
+	batches, err := s.batchStore.ListBatches(requestBatchOwner(c))
+	if err != nil {
+		s.logger.Error("Failed to list batches", zap.Error(err))
+		openAIError(c, 500, "server_error", "Failed to list batches")
+		return
+	}
+
+	// Cursor pagination: ?after=<batch id>&limit=<n>
+	if after := c.Query("after"); after != "" {
+		for i, batch := range batches {
+			if batch.ID == after {
+				batches = batches[i+1:]
+				break
+			}
+		}
+	}
+	limit := 20
+	if n, err := strconv.Atoi(c.Query("limit")); err == nil && n > 0 && n <= 100 {
+		limit = n
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+
+	resp := gin.H{"object": "list", "data": batches, "has_more": hasMore}
+	if len(batches) > 0 {
+		resp["first_id"] = batches[0].ID
+		resp["last_id"] = batches[len(batches)-1].ID
+	}
+	c.JSON(200, resp)
+}
+
+func (s *Server) getBatch(c *gin.Context) {
+	batch, err := s.batchStore.LoadBatch(requestBatchOwner(c), c.Param("id"))
+	if err != nil {
+		openAIError(c, 404, "batch_not_found", "No such batch")
+		return
+	}
+	c.JSON(200, batch)
+}
+
+func (s *Server) cancelBatch(c *gin.Context) {
+	owner := requestBatchOwner(c)
+	batch, err := s.batchStore.LoadBatch(owner, c.Param("id"))
+	if err != nil {
+		openAIError(c, 404, "batch_not_found", "No such batch")
+		return
+	}
+	if batch.Status != models.BatchValidating && batch.Status != models.BatchInProgress {
+		openAIError(c, 409, "batch_not_cancellable", "Batch is already "+batch.Status)
+		return
+	}
+
+	// A running batch is saved as cancelling right away; the runner records
+	// the final status once in-flight requests stop
+	now := time.Now().Unix()
+	batch.Status = models.BatchCancelling
+	batch.CancellingAt = &now
+	if !s.batches.Cancel(owner, batch) {
+		batch.Status = models.BatchCancelled
+		batch.CancelledAt = &now
+		if err := s.batchStore.SaveBatch(owner, batch); err != nil {
+			s.logger.Error("Failed to save batch", zap.Error(err))
+		}
+	}
+	c.JSON(200, batch)
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// uploadBatchFile uploads content through POST /v1/files
+func uploadBatchFile(t *testing.T, h *testHarness, content string) models.FileObject {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("purpose", "batch")
+	fw, err := mw.CreateFormFile("file", "input.jsonl")
+	require.NoError(t, err)
+	fw.Write([]byte(content))
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/v1/files", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := h.api(req)
+	require.Equal(t, 200, rec.Code, rec.Body.String())
+
+	var file models.FileObject
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &file))
+	return file
+}
+
+// createBatch creates a batch and waits until it reaches a final status
+func createBatch(t *testing.T, h *testHarness, fileID string) models.Batch {
+	t.Helper()
+	data, _ := json.Marshal(map[string]string{
+		"input_file_id":     fileID,
+		"endpoint":          "/v1/chat/completions",
+		"completion_window": "24h",
+	})
+	rec := h.api(httptest.NewRequest("POST", "/v1/batches", bytes.NewReader(data)))
+	require.Equal(t, 200, rec.Code, rec.Body.String())
+
+	var batch models.Batch
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &batch))
+
+	require.Eventually(t, func() bool {
+		rec := h.api(httptest.NewRequest("GET", "/v1/batches/"+batch.ID, nil))
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &batch))
+		return batch.Status == models.BatchCompleted || batch.Status == models.BatchFailed
+	}, 5*time.Second, 10*time.Millisecond)
+	return batch
+}
+
+func batchLine(customID, content string) string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"custom_id": customID,
+		"method":    "POST",
+		"url":       "/v1/chat/completions",
+		"body": map[string]interface{}{
+			"model":    "gemini-2.0-flash",
+			"messages": []map[string]string{{"role": "user", "content": content}},
+			"stream":   true, // Ignored: batch requests never stream
+		},
+	})
+	return string(data)
+}
+
+func TestBatch_ProcessesRequests(t *testing.T) {
+	h := newTestHarness(t)
+	h.addAccount("acc1")
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		var req models.GoogleRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		text := req.Request.Contents[0].Parts[0].Text
+		if text == "fail" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"not found"}`))
+			return
+		}
+		writeSSE(w, sseEvents(textEvent("echo "+text), usageEvent(1, 1)))
+	}
+
+	input := strings.Join([]string{batchLine("a", "one"), batchLine("b", "two"), batchLine("c", "fail")}, "\n")
+	file := uploadBatchFile(t, h, input)
+	assert.Equal(t, "batch", file.Purpose)
+
+	batch := createBatch(t, h, file.ID)
+	assert.Equal(t, models.BatchCompleted, batch.Status)
+	assert.Equal(t, models.BatchRequestCounts{Total: 3, Completed: 2, Failed: 1}, batch.RequestCounts)
+	require.NotNil(t, batch.OutputFileID)
+	require.NotNil(t, batch.ErrorFileID)
+
+	rec := h.api(httptest.NewRequest("GET", "/v1/files/"+*batch.OutputFileID+"/content", nil))
+	require.Equal(t, 200, rec.Code)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 2)
+	contents := map[string]string{}
+	for _, line := range lines {
+		var result struct {
+			CustomID string `json:"custom_id"`
+			Response struct {
+				StatusCode int                           `json:"status_code"`
+				Body       models.ChatCompletionResponse `json:"body"`
+			} `json:"response"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &result))
+		assert.Equal(t, 200, result.Response.StatusCode)
+		contents[result.CustomID] = result.Response.Body.Choices[0].Message.Content.(string)
+	}
+	assert.Equal(t, map[string]string{"a": "echo one", "b": "echo two"}, contents)
+
+	rec = h.api(httptest.NewRequest("GET", "/v1/files/"+*batch.ErrorFileID+"/content", nil))
+	assert.Contains(t, rec.Body.String(), `"custom_id":"c"`)
+	assert.Contains(t, rec.Body.String(), `"status_code":404`)
+
+	rec = h.api(httptest.NewRequest("GET", "/v1/batches", nil))
+	assert.Contains(t, rec.Body.String(), batch.ID)
+}
+
+func TestBatch_InvalidInputFails(t *testing.T) {
+	h := newTestHarness(t)
+
+	input := batchLine("a", "one") + "\nnot json\n" + batchLine("a", "dup")
+	batch := createBatch(t, h, uploadBatchFile(t, h, input).ID)
+
+	assert.Equal(t, models.BatchFailed, batch.Status)
+	require.NotNil(t, batch.Errors)
+	require.Len(t, batch.Errors.Data, 2)
+	assert.Equal(t, "invalid_json_line", batch.Errors.Data[0].Code)
+	assert.Equal(t, 2, *batch.Errors.Data[0].Line)
+	assert.Equal(t, "duplicate_custom_id", batch.Errors.Data[1].Code)
+	assert.Equal(t, int64(0), h.calls.Load())
+}
+
+func TestBatch_CancelRunningBatch(t *testing.T) {
+	h := newTestHarness(t)
+	h.addAccount("acc1")
+	started := make(chan struct{}, 1)
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices a closed connection once the body is read
+		io.Copy(io.Discard, r.Body)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}
+
+	file := uploadBatchFile(t, h, batchLine("a", "one")+"\n"+batchLine("b", "two"))
+	data, _ := json.Marshal(map[string]string{"input_file_id": file.ID, "endpoint": "/v1/chat/completions", "completion_window": "24h"})
+	rec := h.api(httptest.NewRequest("POST", "/v1/batches", bytes.NewReader(data)))
+	require.Equal(t, 200, rec.Code, rec.Body.String())
+	var batch models.Batch
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &batch))
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the batch never sent a request")
+	}
+
+	rec = h.api(httptest.NewRequest("POST", "/v1/batches/"+batch.ID+"/cancel", nil))
+	require.Equal(t, 200, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &batch))
+	assert.Equal(t, models.BatchCancelling, batch.Status)
+
+	// From the cancel on, the batch never reads as in progress again
+	require.Eventually(t, func() bool {
+		rec := h.api(httptest.NewRequest("GET", "/v1/batches/"+batch.ID, nil))
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &batch))
+		require.NotEqual(t, models.BatchInProgress, batch.Status)
+		return batch.Status == models.BatchCancelled
+	}, 5*time.Second, time.Millisecond)
+	assert.NotNil(t, batch.CancellingAt)
+	assert.NotNil(t, batch.CancelledAt)
+}
+
+func TestBatch_OwnerKeyNeverStored(t *testing.T) {
+	h := newTestHarness(t)
+	h.addAccount("acc1")
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, sseEvents(textEvent("done"), usageEvent(1, 1)))
+	}
+	stored := func() string {
+		var all strings.Builder
+		for _, dir := range []string{"files", "jobs"} {
+			matches, _ := filepath.Glob(filepath.Join(h.cfg.Storage.DataDir, "batches", dir, "*.json"))
+			for _, match := range matches {
+				data, err := os.ReadFile(match)
+				require.NoError(t, err)
+				all.Write(data)
+			}
+		}
+		return all.String()
+	}
+
+	file := uploadBatchFile(t, h, batchLine("a", "one"))
+	batch := createBatch(t, h, file.ID)
+	assert.Equal(t, models.BatchCompleted, batch.Status)
+	assert.NotContains(t, stored(), harnessAPIKey)
+	assert.Contains(t, stored(), batchOwner(harnessAPIKey))
+
+	// Records saved with the plain key before owners were hashed are migrated
+	// on resume and keep running with the key they belong to
+	legacyFile := &models.FileObject{ID: "file-legacy", Object: "file", Purpose: "batch"}
+	require.NoError(t, h.server.batchStore.SaveFile(harnessAPIKey, legacyFile, []byte(batchLine("a", "one"))))
+	legacy := &models.Batch{ID: "batch_legacy", Object: "batch", Endpoint: "/v1/chat/completions", InputFileID: legacyFile.ID,
+		Status: models.BatchInProgress, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	require.NoError(t, h.server.batchStore.SaveBatch(harnessAPIKey, legacy))
+	orphan := &models.Batch{ID: "batch_orphan", Object: "batch", Endpoint: "/v1/chat/completions", InputFileID: "file-gone",
+		Status: models.BatchInProgress, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	require.NoError(t, h.server.batchStore.SaveBatch("sk-deleted", orphan))
+
+	h.server.batches.Resume()
+	require.Eventually(t, func() bool {
+		rec := h.api(httptest.NewRequest("GET", "/v1/batches/batch_legacy", nil))
+		return strings.Contains(rec.Body.String(), `"status":"completed"`)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotContains(t, stored(), harnessAPIKey)
+	assert.NotContains(t, stored(), "sk-deleted")
+
+	// A batch whose key is gone can't be replayed
+	all, err := h.server.batchStore.AllBatches()
+	require.NoError(t, err)
+	require.Len(t, all[batchOwner("sk-deleted")], 1)
+	failed := all[batchOwner("sk-deleted")][0]
+	assert.Equal(t, models.BatchFailed, failed.Status)
+	require.NotNil(t, failed.Errors)
+	assert.Equal(t, "invalid_api_key", failed.Errors.Data[0].Code)
+}
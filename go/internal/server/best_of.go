@@ -0,0 +1,359 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// 多候选择优：非流式请求带 extra_body.best_of = N 时，并行生成 N 个候选（尽量分散到不同账号），
+// 按启发式规则或评审模型打分（extra_body.best_of_scorer，默认 proxy.best_of.scorer），只返回得分最高的一个。
+// usage 为所有候选及评审调用的总消耗，响应头 X-Best-Of 给出参与评选的候选数
+
+// bestOfHeader reports how many candidates were scored
+const bestOfHeader = "X-Best-Of"
+
+const (
+	bestOfHeuristic = "heuristic"
+	bestOfJudge     = "judge"
+)
+
+// Judge prompt budgets, in characters
+const (
+	judgeTranscriptChars = 20000
+	judgeCandidateChars  = 8000
+)
+
+const judgeInstructions = "You compare candidate answers to the last message of a conversation. " +
+	"Pick the most correct, complete and helpful one. Answer with the candidate number only."
+
+var judgeChoicePattern = regexp.MustCompile(`\d+`)
+
+// bestOfOptions reads the best_of extension parameters; n is 0 when best-of is off
+func (s *Server) bestOfOptions(req *models.ChatCompletionRequest) (n int, scorer string, err error) {
+	opts := providerOptions(req)
+	value, ok := opts["bestOf"]
+	if !ok || value == nil {
+		return 0, "", nil
+	}
+	number, ok := value.(float64)
+	if !ok || number != math.Trunc(number) {
+		return 0, "", fmt.Errorf("best_of must be an integer")
+	}
+	n = int(number)
+	if limit := s.cfg.Proxy.BestOf.MaxCandidates; n < 1 || n > limit {
+		return 0, "", fmt.Errorf("best_of must be between 1 and %d", limit)
+	}
+	if n == 1 {
+		return 0, "", nil
+	}
+	if req.Stream {
+		return 0, "", fmt.Errorf("best_of is not supported for streaming requests")
+	}
+
+	scorer = s.cfg.Proxy.BestOf.Scorer
+	if value, ok := opts["bestOfScorer"].(string); ok && value != "" {
+		if value != bestOfHeuristic && value != bestOfJudge {
+			return 0, "", fmt.Errorf("best_of_scorer must be %q or %q", bestOfHeuristic, bestOfJudge)
+		}
+		scorer = value
+	}
+	return n, scorer, nil
+}
+
+// bestOfCandidate is one generated answer
+type bestOfCandidate struct {
+	account   *models.Account
+	resp      *models.GoogleResponseInner
+	err       error
+	content   string
+	reasoning string
+	images    []models.ImagePart
+	// finishReason is Gemini's, e.g. STOP or MAX_TOKENS
+	finishReason string
+	avgLogprobs  float64
+}
+
+// bestOfCompletion answers a chat request with the best of n parallel candidates.
+// Fallback models are not tried; a candidate fails only its own slot.
+func (s *Server) bestOfCompletion(c *gin.Context, req *models.ChatCompletionRequest, model string, n int, scorer string, timeout time.Duration) {
+	attemptReq := *req
+	attemptReq.Model = model
+	googleReq := s.transformRequest(&attemptReq)
+
+	candidates, err := s.bestOfAccounts(estimateRequestTokens(req), n)
+	if err != nil {
+		helperCallError(c, err, timeout, "Failed to generate candidates")
+		return
+	}
+	var wg sync.WaitGroup
+	for _, cand := range candidates {
+		wg.Add(1)
+		go func(cand *bestOfCandidate) {
+			defer wg.Done()
+			release, err := s.acquireSlot(c, cand.account.AccountID)
+			if err != nil {
+				cand.err = err
+				return
+			}
+			defer release()
+			cand.resp, cand.err = s.generateWith(c, cand.account, googleReq)
+		}(cand)
+	}
+	wg.Wait()
+
+	// Usage is recorded sequentially: candidates may share an account
+	usage := &models.Usage{}
+	var ok []*bestOfCandidate
+	var firstErr error
+	var blocked *models.GooglePromptFeedback
+	for _, cand := range candidates {
+		if cand.err != nil {
+			if firstErr == nil {
+				firstErr = cand.err
+			}
+			if c.Request.Context().Err() == nil {
+				s.penalizeHelperCall(c, cand.account, cand.err)
+			}
+			continue
+		}
+		addUsage(usage, s.recordResponseUsage(c, cand.account, model, cand.resp))
+		if feedback := cand.resp.PromptFeedback; feedback != nil && feedback.BlockReason != "" {
+			blocked = feedback
+			continue
+		}
+		cand.extract()
+		if cand.content != "" || len(cand.images) > 0 {
+			ok = append(ok, cand)
+		}
+	}
+	if len(ok) == 0 {
+		switch {
+		case blocked != nil:
+			apiError(c, 400, blockedPromptError(blocked))
+		case firstErr != nil:
+			helperCallError(c, firstErr, timeout, "Failed to generate candidates")
+		default:
+			apiError(c, 502, models.ErrorDetail{Message: "All candidates were empty", Type: errTypeUpstream, Code: "upstream_error"})
+		}
+		return
+	}
+
+	// Strict json_schema output: only valid candidates take part
+	if structured := structuredOutputFor(c); structured != nil {
+		var valid []*bestOfCandidate
+		var problem error
+		for _, cand := range ok {
+			content, err := structured.check(cand.content)
+			if err != nil {
+				problem = err
+				continue
+			}
+			cand.content = content
+			valid = append(valid, cand)
+		}
+		if len(valid) == 0 {
+			apiError(c, 502, models.ErrorDetail{
+				Message: s.t(c, "structured_output_invalid"),
+				Type:    errTypeUpstream,
+				Code:    "structured_output_invalid",
+				Details: problem.Error(),
+			})
+			return
+		}
+		ok = valid
+	}
+
+	best := bestHeuristic(ok)
+	if scorer == bestOfJudge && len(ok) > 1 {
+		choice, judgeUsage, err := s.judgeCandidates(c, req.Messages, ok)
+		addUsage(usage, judgeUsage)
+		if err != nil {
+			s.requestLogger(c).Warn("Best-of judge failed, using the heuristic choice", zap.Error(err))
+		} else {
+			best = choice
+		}
+	}
+	winner := ok[best]
+	s.requestLogger(c).Info("Best-of candidate selected",
+		zap.String("model", model),
+		zap.String("scorer", scorer),
+		zap.Int("candidates", len(ok)),
+		zap.Int("requested", n),
+		zap.String("account_id", s.displayAccountID(winner.account.AccountID)))
+
+	finishReason := "stop"
+	if winner.finishReason == "MAX_TOKENS" {
+		finishReason = "length"
+	}
+	candidate := winner.resp.Candidates[0]
+	var annotations []models.Annotation
+	if candidate.GroundingMetadata != nil {
+		annotations = candidate.GroundingMetadata.Annotations()
+	}
+	annotations = append(annotations, candidate.CitationMetadata.Annotations()...)
+
+	c.Set(assistantReplyKey, models.ChatCompletionMessage{Role: "assistant", Content: winner.content})
+	c.Header(bestOfHeader, strconv.Itoa(len(ok)))
+	c.JSON(200, models.ChatCompletionResponse{
+		ID:      "chatcmpl-" + uuid.New().String(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Choices: []models.ChatCompletionChoice{
+			{
+				Index: 0,
+				Message: models.ChatCompletionMessage{
+					Role:        "assistant",
+					Content:     winner.content,
+					Reasoning:   winner.reasoning,
+					Images:      winner.images,
+					Annotations: annotations,
+				},
+				FinishReason: finishReason,
+			},
+		},
+		Usage: usage,
+	})
+}
+
+// bestOfAccounts assigns an account to each of n candidates, spreading them
+// over distinct accounts while there are enough. Candidates on the same
+// account share one *models.Account so its usage is recorded correctly.
+func (s *Server) bestOfAccounts(estimatedTokens int64, n int) ([]*bestOfCandidate, error) {
+	used := make(map[string]*models.Account)
+	candidates := make([]*bestOfCandidate, 0, n)
+	for i := 0; i < n; i++ {
+		// Accounts at their concurrency limit are only shared once no other is left
+		exclude := usedIDs(used)
+		for id := range s.accountSlots.full(s.accountConcurrency()) {
+			exclude[id] = true
+		}
+		account, err := s.oauthClient.GetTokenExcluding(estimatedTokens, exclude)
+		if err != nil && len(exclude) > len(used) {
+			account, err = s.oauthClient.GetTokenExcluding(estimatedTokens, usedIDs(used))
+		}
+		if err != nil {
+			if len(candidates) == 0 {
+				return nil, err
+			}
+			account = candidates[i%len(candidates)].account
+		}
+		if shared, ok := used[account.AccountID]; ok {
+			account = shared
+		}
+		used[account.AccountID] = account
+		candidates = append(candidates, &bestOfCandidate{account: account})
+	}
+	return candidates, nil
+}
+
+func usedIDs(accounts map[string]*models.Account) map[string]bool {
+	ids := make(map[string]bool, len(accounts))
+	for id := range accounts {
+		ids[id] = true
+	}
+	return ids
+}
+
+// extract reads the answer of the first upstream candidate
+func (cand *bestOfCandidate) extract() {
+	if len(cand.resp.Candidates) == 0 {
+		return
+	}
+	candidate := cand.resp.Candidates[0]
+	for _, part := range candidate.Content.Parts {
+		if part.Thought {
+			cand.reasoning += part.Text
+		} else {
+			cand.content += part.Text
+		}
+		if part.InlineData != nil && strings.HasPrefix(part.InlineData.MimeType, "image/") {
+			cand.images = append(cand.images, models.NewImagePart(part.InlineData.MimeType, part.InlineData.Data))
+		}
+	}
+	cand.finishReason = candidate.FinishReason
+	cand.avgLogprobs = candidate.AvgLogprobs
+}
+
+// bestHeuristic returns the index of the highest scored candidate: completed
+// answers beat truncated or filtered ones, then the model's own confidence
+// (mean token log probability) decides, and length breaks ties
+func bestHeuristic(candidates []*bestOfCandidate) int {
+	longest := 1
+	for _, cand := range candidates {
+		longest = max(longest, len([]rune(cand.content)))
+	}
+	best, bestScore := 0, math.Inf(-1)
+	for i, cand := range candidates {
+		var score float64
+		switch cand.finishReason {
+		case "STOP", "":
+			score = 2
+		case "MAX_TOKENS":
+			score = 1
+		}
+		score += math.Max(cand.avgLogprobs, -1)
+		score += 0.1 * float64(len([]rune(cand.content))) / float64(longest)
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// judgeCandidates asks the judge model to pick the best candidate
+func (s *Server) judgeCandidates(c *gin.Context, messages []models.ChatCompletionMessage, candidates []*bestOfCandidate) (int, *models.Usage, error) {
+	var prompt strings.Builder
+	transcript, _ := truncateToolResult(conversationTranscript(messages), judgeTranscriptChars, true)
+	prompt.WriteString("Conversation:\n\n" + transcript + "Candidate answers:\n\n")
+	for i, cand := range candidates {
+		content, _ := truncateToolResult(cand.content, judgeCandidateChars, true)
+		fmt.Fprintf(&prompt, "[%d]\n%s\n\n", i+1, content)
+	}
+
+	model, _ := s.route(s.cfg.Proxy.BestOf.JudgeModel)
+	resp, usage, err := s.generateOnce(c, s.transformRequest(&models.ChatCompletionRequest{
+		Model: model,
+		Messages: []models.ChatCompletionMessage{
+			{Role: "system", Content: judgeInstructions},
+			{Role: "user", Content: prompt.String()},
+		},
+	}))
+	if err != nil {
+		return 0, nil, err
+	}
+	var answer string
+	if len(resp.Candidates) > 0 {
+		for _, part := range resp.Candidates[0].Content.Parts {
+			if !part.Thought {
+				answer += part.Text
+			}
+		}
+	}
+	choice, err := strconv.Atoi(judgeChoicePattern.FindString(answer))
+	if err != nil || choice < 1 || choice > len(candidates) {
+		return 0, usage, errors.New("judge answered without a valid candidate number: " + strconv.Quote(answer))
+	}
+	return choice - 1, usage, nil
+}
+
+// addUsage adds delta to total
+func addUsage(total, delta *models.Usage) {
+	if delta == nil {
+		return
+	}
+	total.PromptTokens += delta.PromptTokens
+	total.CompletionTokens += delta.CompletionTokens
+	total.TotalTokens += delta.TotalTokens
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBestHeuristic(t *testing.T) {
+	candidates := func(list ...bestOfCandidate) []*bestOfCandidate {
+		out := make([]*bestOfCandidate, len(list))
+		for i := range list {
+			out[i] = &list[i]
+		}
+		return out
+	}
+
+	// A completed answer beats a longer truncated one
+	assert.Equal(t, 1, bestHeuristic(candidates(
+		bestOfCandidate{content: "a much longer but truncated answer", finishReason: "MAX_TOKENS"},
+		bestOfCandidate{content: "short", finishReason: "STOP"},
+	)))
+	// Filtered answers score lowest
+	assert.Equal(t, 0, bestHeuristic(candidates(
+		bestOfCandidate{content: "cut", finishReason: "MAX_TOKENS"},
+		bestOfCandidate{content: "blocked", finishReason: "SAFETY"},
+	)))
+	// Among completed answers the model's confidence decides
+	assert.Equal(t, 0, bestHeuristic(candidates(
+		bestOfCandidate{content: "confident", finishReason: "STOP", avgLogprobs: -0.1},
+		bestOfCandidate{content: "unsure but longer", finishReason: "STOP", avgLogprobs: -0.8},
+	)))
+	// Length breaks ties
+	assert.Equal(t, 1, bestHeuristic(candidates(
+		bestOfCandidate{content: "short", finishReason: "STOP"},
+		bestOfCandidate{content: "longer answer", finishReason: "STOP"},
+	)))
+}
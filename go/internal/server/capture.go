@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// 调试抓包：管理员为某个API Key开启"抓取接下来N个请求"，
+// 记录每次上游尝试的请求/原始响应以及写给客户端的完整数据，可在后台下载
+
+const (
+	// maxCaptures bounds how many recordings are kept in memory (oldest dropped first)
+	maxCaptures = 20
+	// maxCaptureBytes bounds each recorded body; longer streams are truncated
+	maxCaptureBytes = 2 << 20
+)
+
+// captureStore holds armed triggers and finished recordings
+type captureStore struct {
+	mu      sync.Mutex
+	armed   map[string]int          // API key → requests left to capture
+	records []*models.CaptureRecord // oldest first
+}
+
+func newCaptureStore() *captureStore {
+	return &captureStore{armed: make(map[string]int)}
+}
+
+// Arm captures the next count requests made with key; count <= 0 disarms it
+func (s *captureStore) Arm(key string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if count <= 0 {
+		delete(s.armed, key)
+		return
+	}
+	s.armed[key] = count
+}
+
+// Triggers returns the armed triggers with masked keys
+func (s *captureStore) Triggers() []models.CaptureTrigger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	triggers := make([]models.CaptureTrigger, 0, len(s.armed))
+	for key, remaining := range s.armed {
+		triggers = append(triggers, models.CaptureTrigger{Key: maskAPIKey(key), Remaining: remaining})
+	}
+	return triggers
+}
+
+// List returns recordings newest first
+func (s *captureStore) List() []*models.CaptureRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]*models.CaptureRecord, 0, len(s.records))
+	for i := len(s.records) - 1; i >= 0; i-- {
+		result = append(result, s.records[i])
+	}
+	return result
+}
+
+// Get returns one recording
+func (s *captureStore) Get(id string) (*models.CaptureRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, record := range s.records {
+		if record.ID == id {
+			return record, true
+		}
+	}
+	return nil, false
+}
+
+// Clear drops all recordings (armed triggers are kept)
+func (s *captureStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = nil
+}
+
+// begin starts a capture when the request's key is armed and hooks the
+// client writer; it returns nil when nothing is to be recorded
+func (s *captureStore) begin(c *gin.Context, pr *proxyRequest) *capture {
+	key := clientKey(c)
+
+	s.mu.Lock()
+	remaining, ok := s.armed[key]
+	if ok {
+		if remaining <= 1 {
+			delete(s.armed, key)
+		} else {
+			s.armed[key] = remaining - 1
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	cp := &capture{
+		start: time.Now(),
+		record: models.CaptureRecord{
+			ID:        uuid.New().String(),
+			Key:       maskAPIKey(key),
+			Path:      c.Request.URL.Path,
+			Model:     pr.model,
+			Stream:    pr.stream,
+			StartedAt: time.Now().UnixMilli(),
+			Attempts:  []models.CaptureAttempt{},
+		},
+	}
+	c.Writer = &captureWriter{ResponseWriter: c.Writer, buf: &cp.client}
+	return cp
+}
+
+// finish stores a completed capture
+func (s *captureStore) finish(cp *capture) {
+	record := cp.result()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	if len(s.records) > maxCaptures {
+		s.records = s.records[len(s.records)-maxCaptures:]
+	}
+}
+
+// capture records one request; all methods are safe on a nil *capture
+type capture struct {
+	start    time.Time
+	record   models.CaptureRecord
+	upstream []*captureBuffer // raw response of each attempt
+	client   captureBuffer
+}
+
+// attempt starts recording a new upstream attempt
+func (cp *capture) attempt(accountID string, reqBody []byte) {
+	if cp == nil {
+		return
+	}
+	cp.record.Attempts = append(cp.record.Attempts, models.CaptureAttempt{
+		AccountID: accountID,
+		Request:   string(reqBody),
+	})
+	cp.upstream = append(cp.upstream, &captureBuffer{})
+}
+
+// attemptError records a transport error for the current attempt
+func (cp *capture) attemptError(err error) {
+	if cp == nil || len(cp.record.Attempts) == 0 {
+		return
+	}
+	cp.record.Attempts[len(cp.record.Attempts)-1].Error = err.Error()
+}
+
+// upstreamBody records the status of the current attempt and returns a
+// reader that copies everything read from body into the capture
+func (cp *capture) upstreamBody(status int, body io.Reader) io.Reader {
+	if cp == nil || len(cp.record.Attempts) == 0 {
+		return body
+	}
+	cp.record.Attempts[len(cp.record.Attempts)-1].Status = status
+	return io.TeeReader(body, cp.upstream[len(cp.upstream)-1])
+}
+
+func (cp *capture) result() *models.CaptureRecord {
+	record := cp.record
+	record.DurationMs = time.Since(cp.start).Milliseconds()
+	for i, buf := range cp.upstream {
+		record.Attempts[i].Response, record.Truncated = buf.String(record.Truncated)
+	}
+	record.ClientResponse, record.Truncated = cp.client.String(record.Truncated)
+	return &record
+}
+
+// captureBuffer keeps up to maxCaptureBytes and never fails writes
+type captureBuffer struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *captureBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := maxCaptureBytes - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	b.buf.Write(p)
+	return len(p), nil
+}
+
+// String returns the content and ORs its truncation flag into truncated
+func (b *captureBuffer) String(truncated bool) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String(), truncated || b.truncated
+}
+
+// captureWriter copies everything written to the client into a capture
+type captureWriter struct {
+	gin.ResponseWriter
+	buf *captureBuffer
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	w.buf.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.buf.Write([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Unwrap lets http.ResponseController reach the connection for write deadlines
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// 生效配置摘要：启动时输出实际加载的配置文件、监听地址、存储位置、已加载的账号和密钥数量以及启用的认证方式，
+// /admin/status 中也返回同样的内容，用于排查"到底加载了哪个配置文件"之类的问题。摘要中不包含任何密钥或密码
+
+// ConfigSummary is the effective configuration as the server sees it
+type ConfigSummary struct {
+	// ConfigFile is the config file that was loaded; empty when running on defaults
+	ConfigFile   string   `json:"configFile"`
+	EnvOverrides []string `json:"envOverrides"`
+	Listen       string   `json:"listen"`
+	TLS          bool     `json:"tls"`
+	MTLS         bool     `json:"mtls"`
+
+	Storage StorageSummary `json:"storage"`
+
+	Accounts          int `json:"accounts"`
+	AccountsAvailable int `json:"accountsAvailable"`
+	// DynamicKeys are keys created through the admin API; StaticKeys come from security.api_keys
+	DynamicKeys int `json:"dynamicKeys"`
+	StaticKeys  int `json:"staticKeys"`
+
+	// AuthModes lists how API and admin callers can authenticate
+	AuthModes []string `json:"authModes"`
+	Upstream  string   `json:"upstream"`
+	// OutboundProxy is the global proxy URL with its password hidden
+	OutboundProxy string `json:"outboundProxy,omitempty"`
+}
+
+// StorageSummary describes where state is kept
+type StorageSummary struct {
+	Backend     string `json:"backend"`
+	DataDir     string `json:"dataDir"`
+	AccountsDir string `json:"accountsDir"`
+	KeysDir     string `json:"keysDir"`
+}
+
+// ConfigSummary summarizes the effective configuration
+func (s *Server) ConfigSummary() ConfigSummary {
+	cfg := s.cfg
+	pool := s.oauthClient.PoolStatus()
+	summary := ConfigSummary{
+		ConfigFile:   cfg.File,
+		EnvOverrides: append([]string{}, cfg.EnvOverrides...),
+		Listen:       fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
+		TLS:          cfg.Server.TLS.CertFile != "",
+		MTLS:         cfg.Server.TLS.ClientCAFile != "",
+		Storage: StorageSummary{
+			Backend:     "file",
+			DataDir:     cfg.Storage.DataDir,
+			AccountsDir: cfg.Storage.AccountsDir,
+			KeysDir:     cfg.Storage.KeysDir,
+		},
+		Accounts:          pool.Total,
+		AccountsAvailable: pool.Available,
+		StaticKeys:        len(cfg.Security.APIKeys),
+		Upstream:          strings.TrimSuffix(s.upstreamURL, streamGeneratePath),
+	}
+	if keys, err := s.keyStore.List(); err == nil {
+		summary.DynamicKeys = len(keys)
+	}
+	if u, err := url.Parse(cfg.Proxy.Upstream.ProxyURL); err == nil && u.Host != "" {
+		summary.OutboundProxy = u.Redacted()
+	}
+
+	// 认证方式
+	modes := []string{}
+	if cfg.Security.APIKey != "" {
+		modes = append(modes, "config_api_key")
+	}
+	if len(cfg.Security.APIKeys) > 0 {
+		modes = append(modes, "static_api_keys")
+	}
+	modes = append(modes, "dynamic_api_keys")
+	if cfg.Security.Anonymous.Enabled {
+		modes = append(modes, "anonymous")
+	}
+	if cfg.Security.AdminPassword != "" {
+		modes = append(modes, "admin_password")
+	}
+	if cfg.Security.OIDC.Enabled {
+		modes = append(modes, "oidc")
+	}
+	if summary.MTLS {
+		modes = append(modes, "mtls")
+	}
+	summary.AuthModes = modes
+	return summary
+}
+
+// LogConfigSummary writes the effective configuration to the log at startup
+func (s *Server) LogConfigSummary() {
+	summary := s.ConfigSummary()
+	configFile := summary.ConfigFile
+	if configFile == "" {
+		configFile = "(none, using defaults)"
+	}
+	s.logger.Info("Effective configuration",
+		zap.String("config_file", configFile),
+		zap.Strings("env_overrides", summary.EnvOverrides),
+		zap.String("listen", summary.Listen),
+		zap.Bool("tls", summary.TLS),
+		zap.String("storage", summary.Storage.Backend),
+		zap.String("data_dir", summary.Storage.DataDir),
+		zap.String("accounts_dir", summary.Storage.AccountsDir),
+		zap.Int("accounts", summary.Accounts),
+		zap.Int("accounts_available", summary.AccountsAvailable),
+		zap.Int("dynamic_keys", summary.DynamicKeys),
+		zap.Int("static_keys", summary.StaticKeys),
+		zap.Strings("auth_modes", summary.AuthModes),
+		zap.String("upstream", summary.Upstream),
+		zap.String("outbound_proxy", summary.OutboundProxy))
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/antigravity/api-proxy/internal/models"
+)
+
+// audioMimeTypes maps OpenAI input_audio formats to Gemini MIME types
+var audioMimeTypes = map[string]string{
+	"wav":  "audio/wav",
+	"mp3":  "audio/mp3",
+	"aiff": "audio/aiff",
+	"aac":  "audio/aac",
+	"ogg":  "audio/ogg",
+	"flac": "audio/flac",
+}
+
+// convertContentPart converts one OpenAI content part into a Gemini part.
+// Unsupported or malformed parts return ok=false and are skipped.
+func convertContentPart(partMap map[string]interface{}) (models.GooglePart, bool) {
+	switch partMap["type"] {
+	case "text":
+		if text, ok := partMap["text"].(string); ok {
+			return models.GooglePart{Text: text}, true
+		}
+
+	case "image_url":
+		// Images (or documents) as data URLs; validateContent rejects anything else up front
+		if mimeType, data, ok := parseDataURL(imagePartURL(partMap)); ok {
+			if strings.HasPrefix(mimeType, "image/") || documentMimeTypes[mimeType] {
+				return inlinePart(mimeType, data), true
+			}
+		}
+
+	case "file":
+		// {"type":"file","file":{"file_data":"data:application/pdf;base64,...","filename":"a.pdf"}}
+		if file, ok := partMap["file"].(map[string]interface{}); ok {
+			return convertFilePart(file)
+		}
+
+	case "input_audio":
+		// {"type":"input_audio","input_audio":{"data":"<base64>","format":"wav"}}
+		if audio, ok := partMap["input_audio"].(map[string]interface{}); ok {
+			data, _ := audio["data"].(string)
+			format, _ := audio["format"].(string)
+			mimeType, known := audioMimeTypes[strings.ToLower(format)]
+			if data != "" && known {
+				return inlinePart(mimeType, data), true
+			}
+		}
+	}
+
+	return models.GooglePart{}, false
+}
+
+// imagePartURL returns the URL of an image_url part: {"url": ...} or, as some
+// clients send it, the bare string
+func imagePartURL(partMap map[string]interface{}) string {
+	switch v := partMap["image_url"].(type) {
+	case map[string]interface{}:
+		url, _ := v["url"].(string)
+		return url
+	case string:
+		return v
+	}
+	return ""
+}
+
+// contentError is a problem with the request's content parts, reported to the
+// client as invalid_request_error instead of silently dropping the part
+type contentError struct {
+	code    string
+	param   string // e.g. messages[1].content[0]
+	message string
+}
+
+func (e *contentError) Error() string { return e.message }
+
+func contentParam(message, part int) string {
+	return fmt.Sprintf("messages[%d].content[%d]", message, part)
+}
+
+// validateContent rejects image and file parts the request can't be served
+// with: malformed data URLs, remote URLs, unsupported types, and images sent
+// to a model without vision. image_url parts given as a bare string are
+// rewritten to the {"url": ...} form the later stages read.
+func (s *Server) validateContent(req *models.ChatCompletionRequest, model string) *contentError {
+	caps := s.modelCapabilities(strings.TrimSuffix(strings.TrimSuffix(model, "-thinking"), imageOutputSuffix))
+
+	for i, msg := range req.Messages {
+		items, ok := msg.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		for j, item := range items {
+			partMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			isImage := false
+			switch partMap["type"] {
+			case "image_url":
+				url := imagePartURL(partMap)
+				if _, ok := partMap["image_url"].(string); ok {
+					partMap["image_url"] = map[string]interface{}{"url": url}
+				}
+				if url == "" {
+					return &contentError{code: "invalid_image_url", param: contentParam(i, j),
+						message: "image_url part has no url"}
+				}
+				if !strings.HasPrefix(url, "data:") {
+					return &contentError{code: "unsupported_image_url", param: contentParam(i, j),
+						message: "remote image URLs are not supported; send the image as a base64 data URL"}
+				}
+				mimeType, _, ok := parseDataURL(url)
+				if !ok {
+					return &contentError{code: "invalid_image_url", param: contentParam(i, j),
+						message: "malformed data URL, expected data:<mime type>;base64,<data>"}
+				}
+				if !strings.HasPrefix(mimeType, "image/") && !documentMimeTypes[mimeType] {
+					return &contentError{code: "invalid_image_url", param: contentParam(i, j),
+						message: fmt.Sprintf("unsupported MIME type %q", mimeType)}
+				}
+				isImage = strings.HasPrefix(mimeType, "image/")
+
+			case "file":
+				file, _ := partMap["file"].(map[string]interface{})
+				fileData, _ := file["file_data"].(string)
+				if !strings.HasPrefix(fileData, "data:") {
+					break // Raw base64 (typed by filename) or a file_id
+				}
+				mimeType, _, ok := parseDataURL(fileData)
+				if !ok {
+					return &contentError{code: "invalid_file_data", param: contentParam(i, j),
+						message: "malformed data URL in file_data, expected data:<mime type>;base64,<data>"}
+				}
+				if !strings.HasPrefix(mimeType, "image/") && !documentMimeTypes[mimeType] {
+					return &contentError{code: "invalid_file_data", param: contentParam(i, j),
+						message: fmt.Sprintf("unsupported MIME type %q", mimeType)}
+				}
+				isImage = strings.HasPrefix(mimeType, "image/")
+			}
+
+			if isImage && !caps.Vision {
+				return &contentError{code: "model_not_vision", param: contentParam(i, j),
+					message: fmt.Sprintf("model %s does not accept image input; choose a vision-capable model or set proxy.vision_model to upgrade such requests automatically", model)}
+			}
+		}
+	}
+	return nil
+}
+
+// documentMimeTypes lists the non-image document types Gemini accepts
+var documentMimeTypes = map[string]bool{
+	"application/pdf":      true,
+	"application/json":     true,
+	"application/rtf":      true,
+	"text/plain":           true,
+	"text/html":            true,
+	"text/css":             true,
+	"text/csv":             true,
+	"text/markdown":        true,
+	"text/xml":             true,
+	"text/javascript":      true,
+	"text/x-python":        true,
+	"application/x-python": true,
+}
+
+// documentExtensions maps filename extensions to document MIME types
+var documentExtensions = map[string]string{
+	".pdf":  "application/pdf",
+	".json": "application/json",
+	".rtf":  "application/rtf",
+	".txt":  "text/plain",
+	".html": "text/html",
+	".htm":  "text/html",
+	".css":  "text/css",
+	".csv":  "text/csv",
+	".md":   "text/markdown",
+	".xml":  "text/xml",
+	".js":   "text/javascript",
+	".py":   "text/x-python",
+}
+
+// convertFilePart handles OpenAI "file" parts: inline file_data, or a file_id
+// that is a gs:// or https:// URI (sent to Gemini as fileData)
+func convertFilePart(file map[string]interface{}) (models.GooglePart, bool) {
+	filename, _ := file["filename"].(string)
+
+	if fileData, ok := file["file_data"].(string); ok && fileData != "" {
+		if mimeType, data, ok := parseDataURL(fileData); ok {
+			if documentMimeTypes[mimeType] || strings.HasPrefix(mimeType, "image/") {
+				return inlinePart(mimeType, data), true
+			}
+			return models.GooglePart{}, false
+		}
+		// Raw base64: infer the type from the filename
+		if mimeType, ok := documentExtensions[strings.ToLower(path.Ext(filename))]; ok {
+			return inlinePart(mimeType, fileData), true
+		}
+		return models.GooglePart{}, false
+	}
+
+	if fileID, ok := file["file_id"].(string); ok {
+		if strings.HasPrefix(fileID, "gs://") || strings.HasPrefix(fileID, "https://") {
+			name := filename
+			if name == "" {
+				name = fileID
+			}
+			if mimeType, ok := documentExtensions[strings.ToLower(path.Ext(name))]; ok {
+				return models.GooglePart{
+					FileData: &models.GoogleFileData{
+						MimeType: mimeType,
+						FileURI:  fileID,
+					},
+				}, true
+			}
+		}
+	}
+
+	return models.GooglePart{}, false
+}
+
+// parseDataURL splits "data:<mime>;base64,<data>"
+func parseDataURL(url string) (mimeType, data string, ok bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return "", "", false
+	}
+	partsStr := strings.SplitN(url, ";base64,", 2)
+	if len(partsStr) != 2 || partsStr[1] == "" {
+		return "", "", false
+	}
+	mimeType = strings.ToLower(strings.TrimPrefix(partsStr[0], "data:"))
+	return mimeType, partsStr[1], true
+}
+
+func inlinePart(mimeType, data string) models.GooglePart {
+	return models.GooglePart{
+		InlineData: &models.GoogleInlineData{
+			MimeType: mimeType,
+			Data:     data,
+		},
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 上下文窗口裁剪：密钥开启 contextTrimming 后，估算的提示词超出模型上下文窗口时不再让上游报错，
+// 而是丢弃最早的非系统消息（truncate），或先用低成本模型把这些消息压缩为摘要（summarize）。
+// 系统消息和最后一条消息始终保留，响应头 X-Context-Trimmed 给出被移除的消息数
+
+// contextTrimmedHeader reports how many messages were removed to fit the context window
+const contextTrimmedHeader = "X-Context-Trimmed"
+
+// summaryReserveTokens is left free for the summary that replaces the dropped messages
+const summaryReserveTokens = 2048
+
+// contextTrimmingPolicy returns the trimming policy of the request's API key
+func contextTrimmingPolicy(c *gin.Context) string {
+	value, ok := c.Get("api_key")
+	if !ok {
+		return ""
+	}
+	return value.(*models.APIKey).ContextTrimming
+}
+
+// trimContext applies the API key's trimming policy when the prompt is
+// estimated to exceed the context window of model
+func (s *Server) trimContext(c *gin.Context, req *models.ChatCompletionRequest, model string) {
+	policy := contextTrimmingPolicy(c)
+	if policy == "" {
+		return
+	}
+	window := int64(s.modelCapabilities(model).MaxInputTokens)
+	if window <= 0 || estimatePromptTokens(req) <= window {
+		return
+	}
+
+	budget := window
+	if policy == models.ContextTrimmingSummarize {
+		budget -= summaryReserveTokens
+	}
+	kept, dropped := trimToBudget(req, budget)
+	if len(dropped) == 0 {
+		return
+	}
+
+	if policy == models.ContextTrimmingSummarize {
+		summary, _, err := s.summarizeMessages(c, s.cfg.Proxy.ContextSummaryModel, "", nil, dropped)
+		if err != nil {
+			// 摘要失败时仍然裁剪，只是丢失了早期消息的内容
+			s.requestLogger(c).Warn("Failed to summarize trimmed messages, dropping them instead", zap.Error(err))
+		} else {
+			kept = append([]models.ChatCompletionMessage{summaryMessage(summary)}, kept...)
+		}
+	}
+
+	s.requestLogger(c).Info("Trimmed prompt to fit the context window",
+		zap.String("model", model),
+		zap.String("policy", policy),
+		zap.Int64("context_window", window),
+		zap.Int("dropped_messages", len(dropped)))
+	req.Messages = kept
+	c.Header(contextTrimmedHeader, strconv.Itoa(len(dropped)))
+}
+
+// trimToBudget drops the oldest non-system messages until the estimated prompt
+// fits budget. System messages and the last message are always kept, and tool
+// results whose call was dropped go with it.
+func trimToBudget(req *models.ChatCompletionRequest, budget int64) (kept, dropped []models.ChatCompletionMessage) {
+	messageTokens := func(msg models.ChatCompletionMessage) int64 {
+		return estimatePromptTokens(&models.ChatCompletionRequest{Messages: []models.ChatCompletionMessage{msg}})
+	}
+	total := estimatePromptTokens(req)
+
+	drop := make([]bool, len(req.Messages))
+	trimmed := false
+	for i, msg := range req.Messages[:len(req.Messages)-1] {
+		if total <= budget {
+			break
+		}
+		if msg.Role == "system" || msg.Role == "developer" {
+			continue
+		}
+		drop[i] = true
+		trimmed = true
+		total -= messageTokens(msg)
+	}
+	if !trimmed {
+		return req.Messages, nil
+	}
+	// The oldest remaining non-system message must not be an orphaned tool result
+	for i, msg := range req.Messages {
+		if drop[i] || msg.Role == "system" || msg.Role == "developer" {
+			continue
+		}
+		if msg.Role != "tool" || i == len(req.Messages)-1 {
+			break
+		}
+		drop[i] = true
+	}
+
+	for i, msg := range req.Messages {
+		if drop[i] {
+			dropped = append(dropped, msg)
+		} else {
+			kept = append(kept, msg)
+		}
+	}
+	return kept, dropped
+}
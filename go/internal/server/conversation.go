@@ -0,0 +1,166 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/antigravity/api-proxy/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 服务端会话状态：客户端只发送 conversation_id 和最新消息，代理保存之前的轮次，
+// 在服务端拼出完整的上下文，减小瘦客户端的请求体；同一会话固定使用同一个上游 sessionId。
+// 需开启 conversations.enabled，会话归属创建它的 API Key
+
+// conversationIDKey holds the conversation ID of the current request on the gin context
+const conversationIDKey = "conversation_id"
+
+// assistantReplyKey holds the assistant message written to the client, for the conversation history
+const assistantReplyKey = "assistant_reply"
+
+// conversationIDPattern restricts client-chosen IDs to safe file names
+var conversationIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// beginConversation prepends the stored history to req.Messages and returns the
+// messages the client sent, to be stored with the reply. ok is false when an
+// error response was written.
+func (s *Server) beginConversation(c *gin.Context, req *models.ChatCompletionRequest) (sent []models.ChatCompletionMessage, ok bool) {
+	if s.conversations == nil {
+		apiError(c, 400, models.ErrorDetail{
+			Message: s.t(c, "conversations_disabled"),
+			Type:    errTypeInvalidRequest,
+			Param:   "conversation_id",
+			Code:    "conversations_disabled",
+		})
+		return nil, false
+	}
+	if c.GetString("api_key_source") == "anonymous" {
+		openAIError(c, 401, "missing_api_key", s.t(c, "missing_api_key"))
+		return nil, false
+	}
+	if !conversationIDPattern.MatchString(req.ConversationID) {
+		apiError(c, 400, models.ErrorDetail{
+			Message: s.t(c, "invalid_conversation_id"),
+			Type:    errTypeInvalidRequest,
+			Param:   "conversation_id",
+			Code:    "invalid_conversation_id",
+		})
+		return nil, false
+	}
+
+	conv, err := s.conversations.Load(clientKey(c), req.ConversationID)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// A new ID starts a new conversation
+	case errors.Is(err, storage.ErrConversationOwned):
+		apiError(c, 409, models.ErrorDetail{
+			Message: s.t(c, "conversation_id_in_use"),
+			Type:    errTypeInvalidRequest,
+			Param:   "conversation_id",
+			Code:    "conversation_id_in_use",
+		})
+		return nil, false
+	case err != nil:
+		s.requestLogger(c).Error("Failed to load conversation", zap.String("conversation_id", req.ConversationID), zap.Error(err))
+		apiError(c, 500, models.ErrorDetail{Message: "Failed to load the conversation.", Type: errTypeServer, Code: "internal_error"})
+		return nil, false
+	}
+
+	sent = req.Messages
+	if conv != nil {
+		req.Messages = append(slices.Clone(conv.Messages), sent...)
+	}
+	c.Set(conversationIDKey, req.ConversationID)
+	c.Header("X-Conversation-Id", req.ConversationID)
+	return sent, true
+}
+
+// finishConversation stores the sent messages and the assistant's reply; failed
+// or incomplete responses leave the conversation unchanged so the client can retry
+func (s *Server) finishConversation(c *gin.Context, id string, sent []models.ChatCompletionMessage) {
+	value, ok := c.Get(assistantReplyKey)
+	if !ok || c.Writer.Status() != 200 {
+		return
+	}
+	reply := value.(models.ChatCompletionMessage)
+	messages := append(slices.Clone(sent), reply)
+	if _, err := s.conversations.Append(clientKey(c), id, messages, s.cfg.Conversations.MaxMessages); err != nil {
+		s.requestLogger(c).Warn("Failed to save conversation", zap.String("conversation_id", id), zap.Error(err))
+	}
+}
+
+// conversationRecorder collects the streamed assistant reply
+type conversationRecorder struct {
+	reply models.ChatCompletionMessage
+	text  strings.Builder
+}
+
+// Process accumulates content and tool calls; chunks pass through unchanged
+func (r *conversationRecorder) Process(chunk *models.ChatCompletionChunk) []*models.ChatCompletionChunk {
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		r.text.WriteString(choice.Delta.Content)
+		if choice.Delta.ReasoningSignature != "" {
+			r.reply.ReasoningSignature = choice.Delta.ReasoningSignature
+		}
+		for _, call := range choice.Delta.ToolCalls {
+			r.addToolCall(call)
+		}
+	}
+	return []*models.ChatCompletionChunk{chunk}
+}
+
+// addToolCall merges a streamed tool call delta into the reply: deltas with
+// the index of a known call only add argument text
+func (r *conversationRecorder) addToolCall(delta models.ToolCall) {
+	if delta.Index != nil && *delta.Index < len(r.reply.ToolCalls) {
+		r.reply.ToolCalls[*delta.Index].Function.Arguments += delta.Function.Arguments
+		return
+	}
+	delta.Index = nil
+	r.reply.ToolCalls = append(r.reply.ToolCalls, delta)
+}
+
+// Flush has nothing buffered
+func (r *conversationRecorder) Flush() []*models.ChatCompletionChunk {
+	return nil
+}
+
+// Reply returns the collected assistant message
+func (r *conversationRecorder) Reply() models.ChatCompletionMessage {
+	reply := r.reply
+	reply.Role = "assistant"
+	reply.Content = r.text.String()
+	return reply
+}
+
+// getConversation returns a conversation's stored messages
+func (s *Server) getConversation(c *gin.Context) {
+	if s.conversations == nil {
+		openAIError(c, 404, "conversation_not_found", s.t(c, "conversation_not_found"))
+		return
+	}
+	conv, err := s.conversations.Load(clientKey(c), c.Param("id"))
+	if err != nil {
+		openAIError(c, 404, "conversation_not_found", s.t(c, "conversation_not_found"))
+		return
+	}
+	c.JSON(200, conv)
+}
+
+// deleteConversation forgets a conversation
+func (s *Server) deleteConversation(c *gin.Context) {
+	id := c.Param("id")
+	if s.conversations == nil || s.conversations.Delete(clientKey(c), id) != nil {
+		openAIError(c, 404, "conversation_not_found", s.t(c, "conversation_not_found"))
+		return
+	}
+	c.JSON(200, gin.H{"id": id, "object": "conversation.deleted", "deleted": true})
+}
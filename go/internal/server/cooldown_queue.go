@@ -0,0 +1,73 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 冷却排队：所有账号都在冷却（限流）时，请求不立即失败，而是等待最早的冷却结束后再选号。
+// 每个请求最多等待 proxy.cooldown_queue.max_wait，同时排队的请求数不超过 max_queued，
+// 超出任一限制时按原来的方式返回429
+
+// cooldownQueue counts the requests waiting for an account to leave cooldown
+type cooldownQueue struct {
+	waiting atomic.Int64
+	queued  atomic.Int64 // requests that ever waited, for metrics
+}
+
+// enter takes a queue slot; it fails when max requests are already waiting
+func (q *cooldownQueue) enter(max int) bool {
+	if n := q.waiting.Add(1); max > 0 && n > int64(max) {
+		q.waiting.Add(-1)
+		return false
+	}
+	q.queued.Add(1)
+	return true
+}
+
+func (q *cooldownQueue) leave() {
+	q.waiting.Add(-1)
+}
+
+// waitForCooldown holds the request until the earliest account cooldown ends.
+// It returns true when the caller should pick an account again, and false
+// when queueing is off, the wait would exceed the request's queue budget, the
+// queue is full or the request ended while waiting.
+func (s *Server) waitForCooldown(c *gin.Context, pr *proxyRequest) bool {
+	if s.cfg == nil || !s.cfg.Proxy.CooldownQueue.Enabled {
+		return false
+	}
+	queueCfg := s.cfg.Proxy.CooldownQueue
+
+	// Only a pool that is entirely cooling down will recover by waiting
+	pool := s.oauthClient.PoolStatus()
+	if pool.Available > 0 || pool.NextAvailable.IsZero() {
+		return false
+	}
+
+	// The wait budget covers every time this request queues
+	if pr.queueDeadline.IsZero() {
+		pr.queueDeadline = time.Now().Add(queueCfg.MaxWait)
+	}
+	if pool.NextAvailable.After(pr.queueDeadline) {
+		s.requestLogger(c).Warn("Earliest account cooldown ends after the queue deadline",
+			zap.Time("next_available", pool.NextAvailable),
+			zap.Duration("max_wait", queueCfg.MaxWait))
+		return false
+	}
+
+	if !s.cooldownQueue.enter(queueCfg.MaxQueued) {
+		s.requestLogger(c).Warn("Cooldown queue is full", zap.Int("max_queued", queueCfg.MaxQueued))
+		return false
+	}
+	defer s.cooldownQueue.leave()
+
+	wait := time.Until(pool.NextAvailable)
+	s.requestLogger(c).Info("All accounts cooling down, queueing request",
+		zap.Duration("wait", wait),
+		zap.Int64("waiting", s.cooldownQueue.waiting.Load()))
+	return sleepCtx(c.Request.Context(), wait)
+}
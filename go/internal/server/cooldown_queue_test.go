@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// coolDown puts an account into cooldown until the given Unix second
+func (h *testHarness) coolDown(id string, until int64) {
+	h.t.Helper()
+	account := h.loadAccount(id)
+	account.RecordFailure("rate limited")
+	account.ErrorTracking.FailedUntil = &until
+	require.NoError(h.t, h.server.oauthClient.AccountStore().Save(account))
+}
+
+func TestIntegration_CooldownQueueWaitsForAccount(t *testing.T) {
+	h := newTestHarness(t)
+	h.cfg.Proxy.CooldownQueue = config.CooldownQueueConfig{Enabled: true, MaxWait: 5 * time.Second, MaxQueued: 10}
+	h.addAccount("acc1")
+	until := time.Now().Unix() + 1
+	h.coolDown("acc1", until)
+
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, sseEvents(textEvent("Hello"), usageEvent(3, 2)))
+	}
+
+	rec := h.chat(helloRequest)
+	assert.Equal(t, 200, rec.Code, rec.Body.String())
+	assert.GreaterOrEqual(t, time.Now().Unix(), until, "the request waited for the cooldown to end")
+	assert.Equal(t, int64(1), h.calls.Load())
+	assert.Equal(t, int64(1), h.server.cooldownQueue.queued.Load())
+	assert.Equal(t, int64(0), h.server.cooldownQueue.waiting.Load())
+}
+
+func TestIntegration_CooldownQueueLimits(t *testing.T) {
+	h := newTestHarness(t)
+	h.cfg.Proxy.CooldownQueue = config.CooldownQueueConfig{Enabled: true, MaxWait: 5 * time.Second, MaxQueued: 1}
+	h.addAccount("acc1")
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		t.Error("upstream must not be called while the account cools down")
+	}
+
+	// The cooldown ends after the request's wait budget
+	h.coolDown("acc1", time.Now().Add(time.Hour).Unix())
+	start := time.Now()
+	rec := h.chat(helloRequest)
+	assert.Equal(t, 429, rec.Code)
+	assert.Contains(t, rec.Body.String(), "no_accounts_available")
+	assert.Less(t, time.Since(start), time.Second, "no point in waiting")
+
+	// The queue is full
+	h.coolDown("acc1", time.Now().Unix()+1)
+	h.server.cooldownQueue.waiting.Store(1)
+	rec = h.chat(helloRequest)
+	assert.Equal(t, 429, rec.Code)
+	assert.Equal(t, int64(0), h.server.cooldownQueue.queued.Load())
+	h.server.cooldownQueue.waiting.Store(0)
+
+	// Disabled queueing fails right away
+	h.cfg.Proxy.CooldownQueue.Enabled = false
+	rec = h.chat(helloRequest)
+	assert.Equal(t, 429, rec.Code)
+	assert.Equal(t, int64(0), h.calls.Load())
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// /v1 的所有错误统一使用 OpenAI 格式（models.ErrorResponse），
+// SDK 依赖 error.message / error.type / error.code 解析错误，临时拼的 {"error": "..."} 会让它们崩溃。
+// /v1beta 使用 Google 的错误格式，见 geminiError。
+
+// OpenAI error types
+const (
+	errTypeInvalidRequest = "invalid_request_error"
+	errTypeRateLimit      = "rate_limit_error"
+	errTypeServer         = "server_error"
+	errTypeUpstream       = "upstream_error"
+	errTypeUnavailable    = "service_unavailable"
+	errTypePermission     = "permission_error"
+)
+
+// apiError writes an OpenAI-format error body
+func apiError(c *gin.Context, status int, detail models.ErrorDetail) {
+	detail.RequestID = requestID(c)
+	c.JSON(status, models.ErrorResponse{Error: detail})
+}
+
+// openAIError writes an invalid_request_error
+func openAIError(c *gin.Context, status int, code, message string) {
+	apiError(c, status, models.ErrorDetail{Message: message, Type: errTypeInvalidRequest, Code: code})
+}
+
+// isOpenAIPath reports whether a request targets the OpenAI-compatible API
+func isOpenAIPath(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.URL.Path, "/v1/")
+}
+
+// recoveryHandler answers a panic; API clients get an OpenAI error body
+func recoveryHandler(c *gin.Context, _ any) {
+	if isOpenAIPath(c) {
+		apiError(c, 500, models.ErrorDetail{Message: "Internal server error", Type: errTypeServer, Code: "internal_error"})
+	}
+	c.AbortWithStatus(500)
+}
+
+// noRoute answers unknown paths; unknown /v1 endpoints get an OpenAI error body
+func noRoute(c *gin.Context) {
+	if isOpenAIPath(c) {
+		openAIError(c, 404, "unknown_url", "Unknown request URL: "+c.Request.Method+" "+c.Request.URL.Path)
+		return
+	}
+	c.String(404, "404 page not found")
+}
+
+// googleAPIError is a non-200 response from the Google upstream
+type googleAPIError struct {
+	status int
+	body   []byte
+	header http.Header // Kept by helper calls for the 429 cooldown (Retry-After)
+}
+
+func (e *googleAPIError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.status, e.body)
+}
+
+// 上游错误内容脱敏：Google 的错误消息和 details 中可能带有账号邮箱、GCP 项目ID/编号和令牌，
+// 这些内容会写入日志和账号的失败记录，并原样返回给客户端，读取后先统一替换为占位符
+
+// upstreamSecretPatterns are replaced in upstream error bodies; the replacements
+// contain no quotes so JSON bodies stay valid
+var upstreamSecretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// OAuth access and refresh tokens, API keys
+	{regexp.MustCompile(`ya29\.[0-9A-Za-z._\-]+`), "[REDACTED_TOKEN]"},
+	{regexp.MustCompile(`1//[0-9A-Za-z_\-]{20,}`), "[REDACTED_TOKEN]"},
+	{regexp.MustCompile(`AIza[0-9A-Za-z_\-]{35}`), "[REDACTED_KEY]"},
+	{regexp.MustCompile(`(?i)(bearer\s+)[0-9A-Za-z._\-~+/]+=*`), "${1}[REDACTED_TOKEN]"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`), "[REDACTED_EMAIL]"},
+	// "projects/my-project-123", "consumer": "projects/123456789"
+	{regexp.MustCompile(`(projects/)[A-Za-z0-9._:\-]+`), "${1}[REDACTED_PROJECT]"},
+	// "project my-project-123", "project_id": "my-project-123", "project number 123456789";
+	// IDs must contain a hyphen or be numeric so ordinary words after "project" survive
+	{regexp.MustCompile("(?i)(\\bproject(?:[ _-]?(?:id|number))?[\"']?(?:\\s*[:=]\\s*|\\s+)[\"'`]?)([a-z][a-z0-9]*-[a-z0-9-]*[a-z0-9]|[0-9]{6,})"), "${1}[REDACTED_PROJECT]"},
+}
+
+// sanitizeUpstreamError strips account-identifying data from an upstream error body
+func sanitizeUpstreamError(body []byte) []byte {
+	for _, p := range upstreamSecretPatterns {
+		body = p.re.ReplaceAll(body, []byte(p.repl))
+	}
+	return body
+}
+
+// googleErrorBody is Google's structured error; some endpoints wrap it in an array
+type googleErrorBody struct {
+	Error struct {
+		Code    int                 `json:"code"`
+		Message string              `json:"message"`
+		Status  string              `json:"status"`
+		Details []googleErrorDetail `json:"details"`
+	} `json:"error"`
+}
+
+// googleErrorDetail is one google.rpc detail message (RetryInfo, ErrorInfo, ...)
+type googleErrorDetail struct {
+	Type string `json:"@type"`
+	// RetryDelay is set on google.rpc.RetryInfo, e.g. "34.074824224s"
+	RetryDelay string `json:"retryDelay"`
+	// Metadata of google.rpc.ErrorInfo; quota errors carry quotaResetDelay
+	Metadata map[string]string `json:"metadata"`
+}
+
+// parseGoogleError decodes a structured Google error, plain or wrapped in an array
+func parseGoogleError(body []byte) (googleErrorBody, bool) {
+	var parsed googleErrorBody
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		var wrapped []googleErrorBody
+		if json.Unmarshal(body, &wrapped) != nil || len(wrapped) == 0 {
+			return googleErrorBody{}, false
+		}
+		parsed = wrapped[0]
+	}
+	return parsed, true
+}
+
+// googleRetryDelay returns how long Google asks the caller to wait: the
+// google.rpc.RetryInfo retryDelay, else ErrorInfo's quotaResetDelay. It is 0
+// when the body names no delay.
+func googleRetryDelay(body []byte) time.Duration {
+	parsed, ok := parseGoogleError(body)
+	if !ok {
+		return 0
+	}
+	var quotaReset time.Duration
+	for _, detail := range parsed.Error.Details {
+		if strings.HasSuffix(detail.Type, "google.rpc.RetryInfo") {
+			if delay, err := time.ParseDuration(detail.RetryDelay); err == nil && delay > 0 {
+				return delay
+			}
+		}
+		if delay, err := time.ParseDuration(detail.Metadata["quotaResetDelay"]); err == nil && delay > 0 && quotaReset == 0 {
+			quotaReset = delay
+		}
+	}
+	return quotaReset
+}
+
+// translateGoogleError maps a structured Google error to the OpenAI status and
+// error object a client SDK expects. ok is false when body isn't a Google error.
+func translateGoogleError(status int, body []byte) (int, models.ErrorDetail, bool) {
+	parsed, ok := parseGoogleError(body)
+	if !ok {
+		return 0, models.ErrorDetail{}, false
+	}
+	e := parsed.Error
+	if e.Status == "" && e.Message == "" {
+		return 0, models.ErrorDetail{}, false
+	}
+
+	message := e.Message
+	if message == "" {
+		message = "Upstream returned " + e.Status
+	}
+	lower := strings.ToLower(message)
+	detail := models.ErrorDetail{Message: message, Type: errTypeInvalidRequest}
+
+	switch e.Status {
+	case "INVALID_ARGUMENT", "FAILED_PRECONDITION", "OUT_OF_RANGE":
+		switch {
+		case strings.Contains(lower, "token") && (strings.Contains(lower, "exceed") || strings.Contains(lower, "too long")):
+			detail.Code = "context_length_exceeded"
+		case strings.Contains(lower, "safety") || strings.Contains(lower, "blocked"):
+			detail.Code = "content_policy_violation"
+		default:
+			detail.Code = "invalid_request"
+		}
+		return 400, detail, true
+	case "RESOURCE_EXHAUSTED":
+		detail.Type, detail.Code = errTypeRateLimit, "rate_limit_exceeded"
+		return 429, detail, true
+	case "NOT_FOUND":
+		detail.Code = "model_not_found"
+		return 404, detail, true
+	case "PERMISSION_DENIED":
+		detail.Type, detail.Code = errTypePermission, "permission_denied"
+		return 403, detail, true
+	case "UNAUTHENTICATED":
+		// The failing credential is the proxy's account, not the client's key
+		detail.Type, detail.Code = errTypeUpstream, "upstream_auth_failed"
+		return 502, detail, true
+	case "DEADLINE_EXCEEDED":
+		detail.Type, detail.Code = errTypeServer, "timeout"
+		return 504, detail, true
+	case "UNAVAILABLE":
+		detail.Type, detail.Code = errTypeUnavailable, "service_unavailable"
+		return 503, detail, true
+	}
+
+	// Unknown status: keep the HTTP class of the upstream response
+	if status >= 500 {
+		detail.Type, detail.Code = errTypeServer, "server_error"
+		return 500, detail, true
+	}
+	detail.Code = "invalid_request"
+	return status, detail, true
+}
+
+// blockedPromptError is returned when Google refuses the prompt (promptFeedback.blockReason)
+func blockedPromptError(feedback *models.GooglePromptFeedback) models.ErrorDetail {
+	message := "The prompt was blocked by the upstream safety filters (" + feedback.BlockReason + ")."
+	if feedback.BlockReasonMessage != "" {
+		message += " " + feedback.BlockReasonMessage
+	}
+	return models.ErrorDetail{Message: message, Type: errTypeInvalidRequest, Code: "content_policy_violation"}
+}
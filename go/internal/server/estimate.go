@@ -0,0 +1,163 @@
+package server
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/antigravity/api-proxy/internal/models"
+)
+
+// token数预估：选号时判断账号剩余额度，以及上游未返回 usageMetadata 时的用量记录。
+// 按文字类型分别估算：中日韩文字约每字一个token，英文单词和代码标识符约每4个字符一个token，
+// 标点和符号各算一个token，比按总字节数/4 准确得多（CJK 每字3字节，代码符号密集）。
+// 开启 proxy.count_tokens 时，用量记录优先使用上游 countTokens 的精确结果，见 usage.go
+
+const (
+	// charsPerToken is the usual ratio for runs of Latin letters
+	charsPerToken = 4
+	// digitsPerToken: numbers are split into short groups
+	digitsPerToken = 3
+	// mediaPartTokens approximates one image/audio/document part
+	mediaPartTokens = 258
+)
+
+// estimateTextTokens estimates the tokens of text by script
+func estimateTextTokens(text string) int64 {
+	var e tokenEstimator
+	e.Write(text)
+	return e.Tokens()
+}
+
+// tokenEstimator is estimateTextTokens for text that arrives in pieces, such
+// as a streamed answer: a word or number split across pieces counts as if the
+// text were whole, and only the run in progress is kept
+type tokenEstimator struct {
+	tokens                 int64
+	letters, digits, other int64
+}
+
+// Write adds the next piece of text
+func (e *tokenEstimator) Write(text string) {
+	for _, r := range text {
+		switch {
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || r == '_'):
+			if e.digits > 0 || e.other > 0 {
+				e.flush()
+			}
+			e.letters++
+		case unicode.IsDigit(r):
+			if e.letters > 0 || e.other > 0 {
+				e.flush()
+			}
+			e.digits++
+		case isCJK(r):
+			e.flush()
+			e.tokens++
+		case unicode.IsLetter(r) || unicode.IsMark(r):
+			// Other scripts (Cyrillic, accented Latin, ...) tokenize into shorter pieces
+			if e.letters > 0 || e.digits > 0 {
+				e.flush()
+			}
+			e.other++
+		case r == '\n':
+			e.flush()
+			e.tokens++
+		case unicode.IsSpace(r):
+			// A single space joins the next word's token; indentation runs are their own token
+			e.flush()
+		default:
+			// Punctuation, operators, emoji
+			e.flush()
+			e.tokens++
+		}
+	}
+}
+
+// Tokens returns the estimate for the text written so far
+func (e *tokenEstimator) Tokens() int64 {
+	end := *e
+	end.flush()
+	return end.tokens
+}
+
+// flush counts the run in progress
+func (e *tokenEstimator) flush() {
+	// Common short words are a single token; longer ones split every ~4 letters
+	if e.letters > 0 {
+		e.tokens += max(1, (e.letters+2)/charsPerToken)
+	}
+	e.tokens += ceilDiv(e.digits, digitsPerToken) + ceilDiv(e.other, 2)
+	e.letters, e.digits, e.other = 0, 0, 0
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+func ceilDiv(n, d int64) int64 {
+	return (n + d - 1) / d
+}
+
+// estimatePromptTokens estimates the prompt of a chat request
+func estimatePromptTokens(req *models.ChatCompletionRequest) int64 {
+	var tokens, media int64
+	for _, msg := range req.Messages {
+		switch v := msg.Content.(type) {
+		case string:
+			tokens += estimateTextTokens(v)
+		case []interface{}:
+			for _, item := range v {
+				partMap, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if text, ok := partMap["text"].(string); ok {
+					tokens += estimateTextTokens(text)
+				} else {
+					media++
+				}
+			}
+		}
+	}
+	for _, t := range req.Tools {
+		tokens += estimateTextTokens(t.Function.Name) + estimateTextTokens(t.Function.Description)
+	}
+	return tokens + media*mediaPartTokens
+}
+
+// estimateContentsTokens estimates a native Gemini prompt
+func estimateContentsTokens(contents []models.GoogleContent) int64 {
+	var tokens int64
+	for _, content := range contents {
+		tokens += estimatePartsTokens(content.Parts)
+	}
+	return tokens
+}
+
+func estimatePartsTokens(parts []models.GooglePart) int64 {
+	var tokens int64
+	for _, part := range parts {
+		switch {
+		case part.Text != "":
+			tokens += estimateTextTokens(part.Text)
+		case part.InlineData != nil || part.FileData != nil:
+			tokens += mediaPartTokens
+		}
+	}
+	return tokens
+}
+
+// estimateRequestTokens estimates prompt plus requested output tokens
+func estimateRequestTokens(req *models.ChatCompletionRequest) int64 {
+	tokens := estimatePromptTokens(req)
+	if req.MaxTokens != nil {
+		tokens += int64(*req.MaxTokens)
+	}
+	return tokens
+}
+
+// estimateRawTokens estimates a native request from its encoded size
+func estimateRawTokens(body []byte) int64 {
+	return int64(len(body)) / charsPerToken
+}
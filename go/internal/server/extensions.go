@@ -0,0 +1,114 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/antigravity/api-proxy/internal/models"
+	"go.uber.org/zap"
+)
+
+// providerOptions collects Gemini-specific options from the request.
+// Sources, later ones winning: extra_body, extra_body.google, google.
+// Keys are accepted in camelCase or snake_case.
+func providerOptions(req *models.ChatCompletionRequest) map[string]interface{} {
+	opts := make(map[string]interface{})
+	merge := func(src map[string]interface{}) {
+		for k, v := range src {
+			if k == "google" {
+				continue
+			}
+			opts[normalizeOptionKey(k)] = v
+		}
+	}
+
+	merge(req.ExtraBody)
+	if google, ok := req.ExtraBody["google"].(map[string]interface{}); ok {
+		merge(google)
+	}
+	merge(req.Google)
+	return opts
+}
+
+// normalizeOptionKey converts snake_case to camelCase
+func normalizeOptionKey(key string) string {
+	parts := strings.Split(key, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// applyProviderOptions merges the whitelisted passthrough fields into the
+// upstream payload; anything else is ignored so clients can't break the
+// request envelope.
+func (s *Server) applyProviderOptions(req *models.ChatCompletionRequest, inner *models.GoogleInner) {
+	for key, value := range providerOptions(req) {
+		switch key {
+		case "safetySettings":
+			if settings, ok := value.([]interface{}); ok {
+				inner.SafetySettings = settings
+			}
+		case "cachedContent":
+			if name, ok := value.(string); ok {
+				inner.CachedContent = name
+			}
+		case "responseModalities":
+			if modalities, ok := value.([]interface{}); ok {
+				inner.GenerationConfig.ResponseModalities = nil
+				for _, m := range modalities {
+					if str, ok := m.(string); ok {
+						inner.GenerationConfig.ResponseModalities = append(inner.GenerationConfig.ResponseModalities, strings.ToUpper(str))
+					}
+				}
+			}
+		case "defaultStopSequences":
+			// Read by stopSequences while building the generation config
+		case "bestOf", "bestOfScorer":
+			// Read by bestOfOptions; best-of runs several upstream requests
+		default:
+			s.logger.Debug("Ignoring unsupported provider option", zap.String("key", key))
+		}
+	}
+}
+
+// stopSequences picks the upstream stop sequences: the request's own "stop"
+// wins; otherwise the configured defaults, unless disabled in config or by
+// extra_body.default_stop_sequences (which also re-enables them per request).
+func (s *Server) stopSequences(req *models.ChatCompletionRequest) []string {
+	switch stop := req.Stop.(type) {
+	case string:
+		if stop != "" {
+			return []string{stop}
+		}
+	case []interface{}:
+		var out []string
+		for _, item := range stop {
+			if str, ok := item.(string); ok && str != "" {
+				out = append(out, str)
+			}
+		}
+		if len(out) > 0 {
+			return out
+		}
+	}
+
+	var defaults []string
+	enabled := true
+	if s.cfg != nil {
+		defaults = s.cfg.Proxy.StopSequences
+		enabled = !s.cfg.Proxy.DisableStopSequences
+	}
+	if override, ok := providerOptions(req)["defaultStopSequences"].(bool); ok {
+		enabled = override
+	}
+	if !enabled {
+		return nil
+	}
+	if len(defaults) == 0 {
+		defaults = config.DefaultStopSequences
+	}
+	return defaults
+}
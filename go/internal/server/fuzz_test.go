@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"go.uber.org/zap"
+)
+
+// Run with: go test ./internal/server -run=^$ -fuzz=FuzzTransformRequest -fuzztime=30s
+
+func FuzzTransformRequest(f *testing.F) {
+	f.Add(`{"model":"gemini-2.5-pro","messages":[{"role":"user","content":"hi"}]}`)
+	f.Add(`{"model":"x-thinking","messages":[{"role":"system","content":[{"type":"text","text":1}]},{"role":"developer","content":null}]}`)
+	f.Add(`{"model":"m","messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"data:image/png;base64,"}}]}]}`)
+	f.Add(`{"model":"m","messages":[{"role":"user","content":[{"type":"image_url","image_url":"data:;base64,;base64,"}]}]}`)
+	f.Add(`{"model":"m","messages":[{"role":"user","content":[{"type":"file","file":{"file_data":"data:application/pdf","filename":".."}}]}]}`)
+	f.Add(`{"model":"m","messages":[{"role":"user","content":[{"type":"input_audio","input_audio":{"format":["wav"]}}, 7, null]}]}`)
+	f.Add(`{"model":"m","messages":[],"tools":[{"type":"function","function":{"name":""}}]}`)
+
+	s := &Server{logger: zap.NewNop()}
+	f.Fuzz(func(t *testing.T, body string) {
+		var req models.ChatCompletionRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			return
+		}
+		googleReq := s.transformRequest(&req)
+		if _, err := json.Marshal(googleReq); err != nil {
+			t.Fatalf("transformed request must marshal: %v", err)
+		}
+	})
+}
+
+func FuzzParseDataURL(f *testing.F) {
+	f.Add("data:image/png;base64,AAAA")
+	f.Add("data:;base64,")
+	f.Add("data:application/pdf;base64,;base64,x")
+	f.Add("https://example.com/a.png")
+
+	f.Fuzz(func(t *testing.T, url string) {
+		mimeType, data, ok := parseDataURL(url)
+		if ok && data == "" {
+			t.Fatalf("ok result must carry data (mime %q)", mimeType)
+		}
+	})
+}
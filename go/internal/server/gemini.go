@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/antigravity/api-proxy/internal/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// 原生Gemini API透传：请求体原样包装后发往上游，响应去掉Cloud Code外层后返回，
+// 只复用账号轮换、认证和重试，不做OpenAI格式转换
+
+// geminiAction handles POST /v1beta/models/{model}:{generateContent|streamGenerateContent}
+func (s *Server) geminiAction(c *gin.Context) {
+	model, method, ok := strings.Cut(strings.TrimPrefix(c.Param("action"), "/"), ":")
+	if !ok || model == "" || (method != "generateContent" && method != "streamGenerateContent") {
+		geminiError(c, 404, "NOT_FOUND", "Unsupported method, expected {model}:generateContent or {model}:streamGenerateContent")
+		return
+	}
+	stream := method == "streamGenerateContent"
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil || !json.Valid(body) {
+		geminiError(c, 400, "INVALID_ARGUMENT", "Invalid JSON payload")
+		return
+	}
+	body = s.truncateFunctionResponses(c, body)
+
+	url := s.upstreamURL
+	if !stream {
+		url = s.generateURL()
+	}
+
+	timeout, err := s.requestTimeout(c, 0)
+	if err != nil {
+		geminiError(c, 400, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+	attempts, err := s.requestAttempts(c)
+	if err != nil {
+		geminiError(c, 400, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+
+	resolved, fallbacks := s.route(model)
+	if !keyAllowsModel(c, model, resolved) {
+		geminiError(c, 403, "PERMISSION_DENIED", s.t(c, "model_not_allowed"))
+		return
+	}
+
+	pr := &proxyRequest{
+		model:           resolved,
+		fallbacks:       fallbacks,
+		stream:          stream,
+		generate:        !stream,
+		url:             url,
+		timeout:         timeout,
+		attempts:        attempts,
+		estimatedTokens: estimateRawTokens(body),
+		respond: func(c *gin.Context, body io.Reader, account *models.Account, canRetry bool) error {
+			if stream {
+				s.handleGeminiStream(c, body, model, account)
+				return nil
+			}
+			s.handleGeminiResponse(c, body, model, account)
+			return nil
+		},
+		upstreamError: func(c *gin.Context, status int, body []byte) {
+			// Upstream errors are already in Google's format
+			c.Data(status, "application/json", body)
+		},
+		exhausted: func(c *gin.Context, status int, message, code string, lastErr error) {
+			if status == 429 {
+				geminiError(c, status, "RESOURCE_EXHAUSTED", message)
+				return
+			}
+			if code == "timeout" {
+				geminiError(c, status, "DEADLINE_EXCEEDED", message)
+				return
+			}
+			geminiError(c, status, "UNAVAILABLE", message)
+		},
+	}
+	pr.body = func() ([]byte, error) {
+		return json.Marshal(&models.GoogleRawRequest{
+			Project:   generateProjectID(),
+			RequestID: "agent-" + uuid.New().String(),
+			Request:   body,
+			Model:     pr.model,
+			UserAgent: "antigravity",
+		})
+	}
+	var native struct {
+		Contents          []models.GoogleContent          `json:"contents"`
+		SystemInstruction *models.GoogleSystemInstruction `json:"systemInstruction"`
+	}
+	json.Unmarshal(body, &native)
+	prompt := &promptUsage{estimate: estimateContentsTokens(native.Contents)}
+	if native.SystemInstruction != nil {
+		prompt.estimate += estimatePartsTokens(native.SystemInstruction.Parts)
+	}
+	prompt.countRequest = func() (string, json.RawMessage, error) {
+		contents, err := json.Marshal(native.Contents)
+		return pr.model, contents, err
+	}
+	c.Set(promptUsageKey, prompt)
+	pr.streamTimeout, pr.splitTimeout = s.streamTimeout(c, 0)
+	s.proxyWithRetry(c, pr)
+}
+
+// handleGeminiResponse unwraps a generateContent response
+func (s *Server) handleGeminiResponse(c *gin.Context, body io.Reader, model string, account *models.Account) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		geminiError(c, 502, "UNAVAILABLE", "Failed to read upstream response")
+		return
+	}
+
+	inner := unwrapGeminiResponse(data)
+	var usage usageTracker
+	observeGeminiUsage(&usage, inner)
+	s.recordGeminiUsage(c, account, model, &usage)
+	c.Data(200, "application/json", inner)
+}
+
+// handleGeminiStream unwraps each SSE event of a streamGenerateContent response
+func (s *Server) handleGeminiStream(c *gin.Context, body io.Reader, model string, account *models.Account) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	sw := s.newClientStream(c.Writer)
+	var usage usageTracker
+	scanner := sse.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		dataStr := strings.TrimPrefix(line, "data: ")
+		if dataStr == "[DONE]" {
+			break
+		}
+
+		inner := unwrapGeminiResponse([]byte(dataStr))
+		observeGeminiUsage(&usage, inner)
+		if err := sw.WriteEvent(inner); err != nil {
+			s.requestLogger(c).Warn("Gemini stream stopped early",
+				zap.String("account_id", s.displayAccountID(account.AccountID)),
+				zap.Error(err))
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		s.requestLogger(c).Warn("Gemini upstream stream broke off",
+			zap.String("account_id", s.displayAccountID(account.AccountID)),
+			zap.Error(err))
+		if errors.Is(err, sse.ErrLineTooLong) {
+			detail := sseLineTooLongError(c)
+			if data, err := json.Marshal(gin.H{"error": gin.H{"code": 502, "message": detail.Message, "status": "INTERNAL"}}); err == nil {
+				sw.WriteEvent(data)
+			}
+		}
+	}
+
+	s.recordGeminiUsage(c, account, model, &usage)
+	sw.Close()
+}
+
+// recordGeminiUsage records the tokens accumulated over the response
+func (s *Server) recordGeminiUsage(c *gin.Context, account *models.Account, model string, usage *usageTracker) {
+	s.settleUsage(c, account, model, usage)
+}
+
+// observeGeminiUsage feeds a native response (or stream event) to usage
+func observeGeminiUsage(usage *usageTracker, data []byte) {
+	var resp models.GoogleResponseInner
+	if err := json.Unmarshal(data, &resp); err == nil {
+		usage.Observe(&resp)
+	}
+}
+
+// unwrapGeminiResponse strips the Cloud Code {"response": ...} envelope;
+// payloads without it are returned unchanged
+func unwrapGeminiResponse(data []byte) []byte {
+	var envelope models.GoogleRawResponse
+	if err := json.Unmarshal(data, &envelope); err == nil && len(envelope.Response) > 0 {
+		return envelope.Response
+	}
+	return data
+}
+
+// geminiError writes an error in the Google API error format
+func geminiError(c *gin.Context, code int, status, message string) {
+	c.JSON(code, gin.H{
+		"error": gin.H{
+			"code":    code,
+			"message": message,
+			"status":  status,
+		},
+	})
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antigravity/api-proxy/internal/models"
+)
+
+// 非流式上游：stream: false 的请求默认调用 generateContent，上游一次返回完整结果，
+// 不再逐个解析 SSE 事件再拼接；响应体不完整时整体解析失败并按原有逻辑换账号重试，
+// 不会返回只有一半内容的回答。proxy.non_stream_upstream: stream 可恢复原来的流式聚合方式
+
+// generateResponse decodes a generateContent body as a single response; it
+// is the sse.Responses of a non-streaming attempt
+type generateResponse struct {
+	body io.Reader
+	read bool
+	err  error
+}
+
+func (r *generateResponse) Next() *models.GoogleResponse {
+	if r.read {
+		return nil
+	}
+	r.read = true
+	var resp models.GoogleResponse
+	if err := json.NewDecoder(r.body).Decode(&resp); err != nil {
+		r.err = fmt.Errorf("invalid generateContent response: %w", err)
+		return nil
+	}
+	return &resp
+}
+
+func (r *generateResponse) Err() error {
+	return r.err
+}
+
+// generateNonStream reports whether non-streaming chat requests call generateContent
+func (s *Server) generateNonStream() bool {
+	return s.cfg != nil && s.cfg.Proxy.NonStreamUpstream == "generate"
+}
+
+// generateURL is the non-streaming form of the upstream endpoint
+func (s *Server) generateURL() string {
+	return strings.Replace(s.upstreamURL, ":streamGenerateContent?alt=sse", ":generateContent", 1)
+}
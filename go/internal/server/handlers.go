@@ -1,7 +1,7 @@
 package server
 
 import (
-	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,6 +18,7 @@ import (
 	"github.com/antigravity/api-proxy/internal/models"
 	"github.com/antigravity/api-proxy/internal/oauth"
 	"github.com/antigravity/api-proxy/internal/storage"
+	"github.com/antigravity/api-proxy/internal/upstream"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
@@ -28,15 +30,20 @@ func (s *Server) adminLogin(c *gin.Context) {
 		Password string `json:"password" binding:"required"`
 	}
 
+	if !s.passwordLoginEnabled() {
+		c.JSON(403, gin.H{"error": s.t(c, "password_login_disabled")})
+		return
+	}
+
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request"})
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_request")})
 		return
 	}
 
 	// 验证密码
 	if req.Password != s.cfg.Security.AdminPassword {
 		s.logger.Warn("Failed login attempt")
-		c.JSON(401, gin.H{"error": "Invalid password"})
+		c.JSON(401, gin.H{"error": s.t(c, "invalid_password")})
 		return
 	}
 
@@ -51,24 +58,19 @@ func (s *Server) adminLogin(c *gin.Context) {
 }
 
 func (s *Server) adminLogout(c *gin.Context) {
+	if s.oidc != nil {
+		s.oidc.end(c.GetHeader("X-Admin-Token"))
+	}
 	c.JSON(200, gin.H{"success": true})
 }
 
 func (s *Server) adminVerify(c *gin.Context) {
-	token := c.GetHeader("X-Admin-Token")
-	if token == "" {
+	if outcome, _ := s.adminAuthChain().identify(c); outcome != authOK {
 		c.JSON(401, gin.H{"valid": false})
 		return
 	}
 
-	expectedToken := generateToken(s.cfg.Security.AdminPassword)
-
-	if token != expectedToken {
-		c.JSON(401, gin.H{"valid": false})
-		return
-	}
-
-	c.JSON(200, gin.H{"valid": true})
+	c.JSON(200, gin.H{"valid": true, "user": c.GetString("admin_user"), "role": c.GetString("admin_role")})
 }
 
 // ==================== Token 管理 ====================
@@ -84,7 +86,7 @@ func (s *Server) listTokens(c *gin.Context) {
 			return
 		}
 		s.logger.Error("Failed to read accounts directory", zap.Error(err))
-		c.JSON(500, gin.H{"error": "Failed to read accounts"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_read_accounts")})
 		return
 	}
 
@@ -108,6 +110,19 @@ func (s *Server) listTokens(c *gin.Context) {
 			continue
 		}
 
+		// 隐私模式：name 缺省时与邮箱相同，一并隐藏；旧账号的ID以邮箱开头，同样隐藏
+		if s.cfg.Security.MaskEmails {
+			if id, ok := account["accountId"].(string); ok {
+				account["accountId"] = models.MaskAccountID(id)
+			}
+			if email, ok := account["email"].(string); ok {
+				if account["name"] == email {
+					account["name"] = models.MaskEmail(email)
+				}
+				account["email"] = models.MaskEmail(email)
+			}
+		}
+
 		// 计算模型数量
 		modelCount := 0
 		if models, ok := account["models"].(map[string]interface{}); ok {
@@ -115,6 +130,13 @@ func (s *Server) listTokens(c *gin.Context) {
 		}
 		account["modelCount"] = modelCount
 
+		// 出站代理可能带有账号密码，只显示脱敏后的地址
+		if proxy, ok := account["proxy"].(string); ok {
+			if u, err := url.Parse(proxy); err == nil {
+				account["proxy"] = u.Redacted()
+			}
+		}
+
 		// 添加创建时间（使用timestamp字段）
 		if timestamp, ok := account["timestamp"].(float64); ok {
 			account["created"] = time.Unix(int64(timestamp/1000), 0).Format("2006-01-02 15:04:05")
@@ -152,7 +174,7 @@ func (s *Server) triggerOAuthLogin(c *gin.Context) {
 	c.JSON(200, gin.H{
 		"url":     authURL,
 		"state":   state,
-		"message": "Opening authorization window...",
+		"message": s.t(c, "opening_auth_window"),
 	})
 }
 
@@ -162,20 +184,20 @@ func (s *Server) addTokenFromCallback(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request"})
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_request")})
 		return
 	}
 
 	// Parse the URL to get the code
 	parsedURL, err := url.Parse(req.CallbackUrl)
 	if err != nil {
-		c.JSON(400, gin.H{"error": "Invalid callback URL"})
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_callback_url")})
 		return
 	}
 
 	code := parsedURL.Query().Get("code")
 	if code == "" {
-		c.JSON(400, gin.H{"error": "No code found in callback URL"})
+		c.JSON(400, gin.H{"error": s.t(c, "no_code_in_callback")})
 		return
 	}
 
@@ -183,18 +205,18 @@ func (s *Server) addTokenFromCallback(c *gin.Context) {
 	client := oauth.NewClient(s.cfg.Server.Port, s.cfg.Storage.AccountsDir, s.logger)
 
 	// Exchange code for token
-	token, err := client.GetOAuthConfig().Exchange(context.Background(), code)
+	token, err := client.Exchange(c.Request.Context(), code)
 	if err != nil {
 		s.logger.Error("Failed to exchange code", zap.Error(err))
-		c.JSON(500, gin.H{"error": "Failed to exchange code for token"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_exchange_code")})
 		return
 	}
 
-	// Get user info
-	userInfo, err := client.GetUserInfo(token.AccessToken)
+	// Get user info (from the ID token when present)
+	userInfo, err := client.UserInfoForToken(c.Request.Context(), token)
 	if err != nil {
 		s.logger.Error("Failed to get user info", zap.Error(err))
-		c.JSON(500, gin.H{"error": "Failed to get user info"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_get_user_info")})
 		return
 	}
 
@@ -202,39 +224,53 @@ func (s *Server) addTokenFromCallback(c *gin.Context) {
 	account, err := client.SaveAccountFromToken(token, userInfo)
 	if err != nil {
 		s.logger.Error("Failed to save account", zap.Error(err))
-		c.JSON(500, gin.H{"error": "Failed to save account"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_save_account")})
 		return
 	}
 
 	s.logger.Info("Account added successfully",
-		zap.String("email", account.Email),
-		zap.String("account_id", account.AccountID))
+		zap.String("email", s.displayEmail(account.Email)),
+		zap.String("account_id", s.displayAccountID(account.AccountID)))
 
 	c.JSON(200, gin.H{
 		"success": true,
 		"account": gin.H{
-			"id":    account.AccountID,
-			"email": account.Email,
+			"id":    s.displayAccountID(account.AccountID),
+			"email": s.displayEmail(account.Email),
 			"name":  account.Name,
 		},
 	})
 }
 
 func (s *Server) toggleToken(c *gin.Context) {
-	accountID := c.Param("id")
+	accountID := s.resolveAccountID(c.Param("id"))
 
 	// Validate account ID to prevent path traversal
 	if !validateAccountID(accountID) {
-		c.JSON(400, gin.H{"error": "Invalid account ID"})
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_account_id")})
 		return
 	}
 
 	var req struct {
-		Enable bool `json:"enable"`
+		Enable *bool `json:"enable"`
+		// Proxy sets the account's outbound proxy; "" clears it
+		Proxy *string `json:"proxy"`
+		// Headers replaces the account's extra upstream headers; {} clears them
+		Headers map[string]string `json:"headers"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request"})
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_request")})
+		return
+	}
+	if req.Proxy != nil && *req.Proxy != "" {
+		if _, err := upstream.ParseProxyURL(*req.Proxy); err != nil {
+			c.JSON(400, gin.H{"error": s.t(c, "invalid_request_detail", err.Error())})
+			return
+		}
+	}
+	if err := upstream.ValidateHeaders(req.Headers); err != nil {
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_request_detail", err.Error())})
 		return
 	}
 
@@ -242,58 +278,105 @@ func (s *Server) toggleToken(c *gin.Context) {
 	filePath := filepath.Join(s.cfg.Storage.AccountsDir, accountID+".json")
 	data, err := os.ReadFile(filePath)
 	if err != nil {
-		c.JSON(404, gin.H{"error": "Account not found"})
+		c.JSON(404, gin.H{"error": s.t(c, "account_not_found")})
 		return
 	}
 
 	var account map[string]interface{}
 	if err := json.Unmarshal(data, &account); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to parse account"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_parse_account")})
 		return
 	}
 
-	// 更新enable状态
-	account["enable"] = req.Enable
+	// 更新enable状态、出站代理和自定义请求头
+	if req.Enable != nil {
+		account["enable"] = *req.Enable
+	}
+	if req.Proxy != nil {
+		if *req.Proxy == "" {
+			delete(account, "proxy")
+		} else {
+			account["proxy"] = *req.Proxy
+		}
+	}
+	if req.Headers != nil {
+		if len(req.Headers) == 0 {
+			delete(account, "headers")
+		} else {
+			account["headers"] = req.Headers
+		}
+	}
 
 	// 写回文件
 	updatedData, err := json.MarshalIndent(account, "", "  ")
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to serialize account"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_serialize_account")})
 		return
 	}
 
 	if err := os.WriteFile(filePath, updatedData, 0644); err != nil {
-		c.JSON(500, gin.H{"error": "Failed to save account"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_save_account")})
 		return
 	}
 
-	s.logger.Info("Token toggled",
-		zap.String("account_id", accountID),
-		zap.Bool("enable", req.Enable))
+	s.logger.Info("Token updated",
+		zap.String("account_id", s.displayAccountID(accountID)),
+		zap.Any("enable", req.Enable),
+		zap.Bool("proxy_changed", req.Proxy != nil),
+		zap.Bool("headers_changed", req.Headers != nil))
 
 	c.JSON(200, gin.H{"success": true})
 }
 
+// refreshTokenModels fetches an account's model list now; token refreshes
+// only do so once the list is older than oauth.models_refresh_interval
+func (s *Server) refreshTokenModels(c *gin.Context) {
+	accountID := s.resolveAccountID(c.Param("id"))
+	if !validateAccountID(accountID) {
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_account_id")})
+		return
+	}
+	if _, err := s.oauthClient.AccountStore().Load(accountID); err != nil {
+		c.JSON(404, gin.H{"error": s.t(c, "account_not_found")})
+		return
+	}
+
+	account, err := s.oauthClient.RefreshModels(c.Request.Context(), accountID)
+	if err != nil {
+		s.requestLogger(c).Warn("Failed to refresh models",
+			zap.String("account_id", s.displayAccountID(accountID)),
+			zap.Error(err))
+		c.JSON(502, gin.H{"error": s.t(c, "failed_refresh_models")})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"success":         true,
+		"models":          len(account.Models),
+		"modelsUpdatedAt": account.ModelsUpdatedAt,
+	})
+}
+
 func (s *Server) deleteToken(c *gin.Context) {
-	accountID := c.Param("id")
+	accountID := s.resolveAccountID(c.Param("id"))
 
 	// Validate account ID to prevent path traversal
 	if !validateAccountID(accountID) {
-		c.JSON(400, gin.H{"error": "Invalid account ID"})
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_account_id")})
 		return
 	}
 
 	filePath := filepath.Join(s.cfg.Storage.AccountsDir, accountID+".json")
 	if err := os.Remove(filePath); err != nil {
 		if os.IsNotExist(err) {
-			c.JSON(404, gin.H{"error": "Account not found"})
+			c.JSON(404, gin.H{"error": s.t(c, "account_not_found")})
 			return
 		}
-		c.JSON(500, gin.H{"error": "Failed to delete account"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_delete_account")})
 		return
 	}
 
-	s.logger.Info("Token deleted", zap.String("account_id", accountID))
+	s.logger.Info("Token deleted", zap.String("account_id", s.displayAccountID(accountID)))
 	c.JSON(200, gin.H{"success": true})
 }
 
@@ -430,7 +513,7 @@ func (s *Server) getUsageHistory(c *gin.Context) {
 	history, err := s.usageStore.GetUsageHistory(30)
 	if err != nil {
 		s.logger.Error("Failed to get usage history", zap.Error(err))
-		c.JSON(500, gin.H{"error": "Failed to get usage history"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_usage_history")})
 		return
 	}
 
@@ -467,6 +550,51 @@ func (s *Server) getUsageHistory(c *gin.Context) {
 	c.JSON(200, result)
 }
 
+// getUsageByUser breaks usage down by end user (OpenAI "user" field) and account
+// over the last ?days= days (default 7, at most 90)
+func (s *Server) getUsageByUser(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days < 1 {
+		days = 7
+	}
+	days = min(days, 90)
+
+	history, err := s.usageStore.GetUsageHistory(days)
+	if err != nil {
+		s.logger.Error("Failed to get usage history", zap.Error(err))
+		c.JSON(500, gin.H{"error": s.t(c, "failed_usage_history")})
+		return
+	}
+
+	// Account emails make the breakdown readable in the dashboard
+	emails := make(map[string]string)
+	store := s.oauthClient.AccountStore()
+	if ids, err := store.List(); err == nil {
+		for _, id := range ids {
+			if account, err := store.Load(id); err == nil {
+				emails[s.displayAccountID(id)] = s.displayEmail(account.Email)
+			}
+		}
+	}
+
+	users := storage.SummarizeUsers(history)
+	if s.cfg.Security.MaskEmails {
+		for i := range users {
+			masked := make(map[string]storage.UserAccountUsage, len(users[i].Accounts))
+			for id, usage := range users[i].Accounts {
+				masked[models.MaskAccountID(id)] = usage
+			}
+			users[i].Accounts = masked
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"days":     days,
+		"users":    users,
+		"accounts": emails,
+	})
+}
+
 func (s *Server) getUsage(c *gin.Context) {
 	// 获取真实的系统使用情况
 	var m runtime.MemStats
@@ -486,7 +614,7 @@ func (s *Server) listKeys(c *gin.Context) {
 	keys, err := s.keyStore.List()
 	if err != nil {
 		s.logger.Error("Failed to list keys", zap.Error(err))
-		c.JSON(500, gin.H{"error": "Failed to list keys"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_list_keys")})
 		return
 	}
 
@@ -494,11 +622,29 @@ func (s *Server) listKeys(c *gin.Context) {
 	var response []gin.H
 	for _, key := range keys {
 		response = append(response, gin.H{
-			"key":        key.Key,
-			"name":       key.Name,
-			"createdAt":  key.CreatedAt,
-			"lastUsed":   key.LastUsed,
-			"usageCount": key.UsageCount,
+			"key":             key.Key,
+			"name":            key.Name,
+			"createdAt":       key.CreatedAt,
+			"lastUsed":        key.LastUsed,
+			"usageCount":      key.UsageCount,
+			"rateLimit":       key.RateLimit,
+			"models":          key.Models,
+			"expiresAt":       key.ExpiresAt,
+			"expired":         key.Expired(),
+			"template":        key.Template,
+			"contextTrimming": key.ContextTrimming,
+		})
+	}
+
+	// Keys declared in config.yaml are listed read-only and masked
+	for _, static := range s.cfg.Security.APIKeys {
+		key, _ := s.staticKey(static.Key)
+		response = append(response, gin.H{
+			"key":             maskAPIKey(key.Key),
+			"name":            key.Name,
+			"rateLimit":       key.RateLimit,
+			"contextTrimming": key.ContextTrimming,
+			"static":          true,
 		})
 	}
 
@@ -511,7 +657,14 @@ func (s *Server) listKeys(c *gin.Context) {
 
 func (s *Server) generateKey(c *gin.Context) {
 	var req struct {
-		Name string `json:"name"`
+		Name      string            `json:"name"`
+		RateLimit *models.RateLimit `json:"rateLimit"` // Optional per-key request limit
+		// Template fills in rateLimit, models and expiry; fields set in the request win
+		Template      string   `json:"template"`
+		Models        []string `json:"models"`
+		ExpiresInDays *int     `json:"expiresInDays"`
+		// ContextTrimming: "truncate" or "summarize" when the prompt exceeds the context window
+		ContextTrimming string `json:"contextTrimming"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -523,39 +676,129 @@ func (s *Server) generateKey(c *gin.Context) {
 	now := time.Now().UnixMilli()
 
 	apiKey := &models.APIKey{
-		Key:        keyString,
-		Name:       req.Name,
-		CreatedAt:  now,
-		UsageCount: 0,
+		Key:             keyString,
+		Name:            req.Name,
+		RateLimit:       req.RateLimit,
+		CreatedAt:       now,
+		UsageCount:      0,
+		Models:          req.Models,
+		ContextTrimming: req.ContextTrimming,
+	}
+
+	expiresInDays := 0
+	if req.Template != "" {
+		template, ok := s.templates.Get(req.Template)
+		if !ok {
+			c.JSON(404, gin.H{"error": s.t(c, "key_template_not_found")})
+			return
+		}
+		apiKey.Template = template.Name
+		if apiKey.RateLimit == nil {
+			apiKey.RateLimit = template.RateLimit
+		}
+		if apiKey.Models == nil {
+			apiKey.Models = template.Models
+		}
+		if apiKey.ContextTrimming == "" {
+			apiKey.ContextTrimming = template.ContextTrimming
+		}
+		expiresInDays = template.ExpiresInDays
+	}
+	if req.ExpiresInDays != nil {
+		expiresInDays = *req.ExpiresInDays
+	}
+	if expiresInDays < 0 {
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_request_detail", "expiresInDays must not be negative")})
+		return
+	}
+	if !models.ValidContextTrimming(apiKey.ContextTrimming) {
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_request_detail", "contextTrimming must be truncate or summarize")})
+		return
+	}
+	if expiresInDays > 0 {
+		expiresAt := time.UnixMilli(now).AddDate(0, 0, expiresInDays).UnixMilli()
+		apiKey.ExpiresAt = &expiresAt
 	}
 
 	// Save the key
 	if err := s.keyStore.Save(apiKey); err != nil {
 		s.logger.Error("Failed to save key", zap.Error(err))
-		c.JSON(500, gin.H{"error": "Failed to generate key"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_generate_key")})
 		return
 	}
 
-	s.logger.Info("API key generated", zap.String("key", keyString), zap.String("name", req.Name))
+	s.logger.Info("API key generated",
+		zap.String("key", keyString),
+		zap.String("name", req.Name),
+		zap.String("template", apiKey.Template))
 
 	c.JSON(200, gin.H{
-		"key":       keyString,
-		"name":      req.Name,
-		"createdAt": now,
-		"message":   "Key generated successfully. Save it securely!",
+		"key":             keyString,
+		"name":            req.Name,
+		"createdAt":       now,
+		"template":        apiKey.Template,
+		"rateLimit":       apiKey.RateLimit,
+		"models":          apiKey.Models,
+		"expiresAt":       apiKey.ExpiresAt,
+		"contextTrimming": apiKey.ContextTrimming,
+		"message":         s.t(c, "key_generated"),
 	})
 }
 
+// ==================== 密钥模板 ====================
+
+func (s *Server) listKeyTemplates(c *gin.Context) {
+	c.JSON(200, s.templates.List())
+}
+
+// saveKeyTemplate creates or replaces a template; existing keys keep their settings
+func (s *Server) saveKeyTemplate(c *gin.Context) {
+	var template models.KeyTemplate
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_request_detail", err.Error())})
+		return
+	}
+	if err := template.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.templates.Save(template); err != nil {
+		s.logger.Error("Failed to save key template", zap.Error(err))
+		c.JSON(500, gin.H{"error": s.t(c, "failed_save_key_template")})
+		return
+	}
+
+	s.logger.Info("Key template saved", zap.String("name", template.Name))
+	c.JSON(200, &template)
+}
+
+func (s *Server) deleteKeyTemplate(c *gin.Context) {
+	name := c.Param("name")
+	if err := s.templates.Delete(name); err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(404, gin.H{"error": s.t(c, "key_template_not_found")})
+			return
+		}
+		s.logger.Error("Failed to delete key template", zap.Error(err))
+		c.JSON(500, gin.H{"error": s.t(c, "failed_save_key_template")})
+		return
+	}
+
+	s.logger.Info("Key template deleted", zap.String("name", name))
+	c.JSON(200, gin.H{"success": true})
+}
+
 func (s *Server) deleteKey(c *gin.Context) {
 	keyString := c.Param("key")
 
 	if err := s.keyStore.Delete(keyString); err != nil {
 		if os.IsNotExist(err) {
-			c.JSON(404, gin.H{"error": "Key not found"})
+			c.JSON(404, gin.H{"error": s.t(c, "key_not_found")})
 			return
 		}
 		s.logger.Error("Failed to delete key", zap.Error(err))
-		c.JSON(500, gin.H{"error": "Failed to delete key"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_delete_key")})
 		return
 	}
 
@@ -567,7 +810,7 @@ func (s *Server) getKeyStats(c *gin.Context) {
 	keys, err := s.keyStore.List()
 	if err != nil {
 		s.logger.Error("Failed to get key stats", zap.Error(err))
-		c.JSON(500, gin.H{"error": "Failed to get stats"})
+		c.JSON(500, gin.H{"error": s.t(c, "failed_get_stats")})
 		return
 	}
 
@@ -618,7 +861,7 @@ func (s *Server) getSystemStatus(c *gin.Context) {
 	pid := os.Getpid()
 	systemMemory := fmt.Sprintf("%.2f GB", float64(m.Sys)/1024/1024/1024)
 
-	c.JSON(200, gin.H{
+	status := gin.H{
 		"cpu":          cpuUsage,
 		"memory":       memoryUsage,
 		"uptime":       uptime,
@@ -629,7 +872,13 @@ func (s *Server) getSystemStatus(c *gin.Context) {
 		"platform":     platform,
 		"pid":          pid,
 		"systemMemory": systemMemory,
-	})
+	}
+	if s.updates != nil {
+		status["update"] = s.updates.Status()
+	}
+	status["resources"] = s.resources.Status()
+	status["config"] = s.ConfigSummary()
+	c.JSON(200, status)
 }
 
 // ==================== 设置 ====================
@@ -659,6 +908,196 @@ func (s *Server) saveSettings(c *gin.Context) {
 	c.JSON(200, gin.H{"success": true})
 }
 
+// ==================== 通知中心 ====================
+
+func (s *Server) listNotifications(c *gin.Context) {
+	unreadOnly := c.Query("unread") == "true"
+	c.JSON(200, gin.H{
+		"unread": s.notifyStore.UnreadCount(),
+		"items":  s.notifyStore.List(unreadOnly),
+	})
+}
+
+func (s *Server) markNotificationRead(c *gin.Context) {
+	if err := s.notifyStore.MarkRead(c.Param("id")); err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(404, gin.H{"error": s.t(c, "notification_not_found")})
+			return
+		}
+		s.logger.Error("Failed to update notification", zap.Error(err))
+		c.JSON(500, gin.H{"error": s.t(c, "failed_update_notification")})
+		return
+	}
+	c.JSON(200, gin.H{"success": true})
+}
+
+func (s *Server) markAllNotificationsRead(c *gin.Context) {
+	if err := s.notifyStore.MarkAllRead(); err != nil {
+		s.logger.Error("Failed to update notifications", zap.Error(err))
+		c.JSON(500, gin.H{"error": s.t(c, "failed_update_notifications")})
+		return
+	}
+	c.JSON(200, gin.H{"success": true})
+}
+
+func (s *Server) clearNotifications(c *gin.Context) {
+	if err := s.notifyStore.Clear(); err != nil {
+		s.logger.Error("Failed to clear notifications", zap.Error(err))
+		c.JSON(500, gin.H{"error": s.t(c, "failed_clear_notifications")})
+		return
+	}
+	c.JSON(200, gin.H{"success": true})
+}
+
+// ==================== 每日报告 ====================
+
+func (s *Server) listReports(c *gin.Context) {
+	dates, err := s.reports.List()
+	if err != nil {
+		s.logger.Error("Failed to list reports", zap.Error(err))
+		c.JSON(500, gin.H{"error": s.t(c, "failed_list_reports")})
+		return
+	}
+	c.JSON(200, gin.H{"reports": dates})
+}
+
+func (s *Server) getReport(c *gin.Context) {
+	date := c.Param("date")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_date")})
+		return
+	}
+
+	report, err := s.reports.Load(date)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(404, gin.H{"error": s.t(c, "report_not_found")})
+			return
+		}
+		s.logger.Error("Failed to load report", zap.Error(err))
+		c.JSON(500, gin.H{"error": s.t(c, "failed_load_report")})
+		return
+	}
+	c.JSON(200, report)
+}
+
+// generateReport builds a report on demand; defaults to yesterday
+func (s *Server) generateReport(c *gin.Context) {
+	var req struct {
+		Date string `json:"date"`
+	}
+	c.ShouldBindJSON(&req)
+	if req.Date == "" {
+		req.Date = time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_date")})
+		return
+	}
+
+	report, err := s.reports.Generate(c.Request.Context(), req.Date)
+	if err != nil {
+		s.logger.Error("Failed to generate report", zap.Error(err))
+		c.JSON(500, gin.H{"error": s.t(c, "failed_generate_report")})
+		return
+	}
+	c.JSON(200, report)
+}
+
+// ==================== 模型路由 ====================
+
+func (s *Server) getRouting(c *gin.Context) {
+	c.JSON(200, s.routing.Get())
+}
+
+// updateRouting replaces the routing table; changes apply to the next request
+func (s *Server) updateRouting(c *gin.Context) {
+	var table models.RoutingTable
+	if err := c.ShouldBindJSON(&table); err != nil {
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_request_detail", err.Error())})
+		return
+	}
+	if err := table.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.routing.Set(&table); err != nil {
+		s.logger.Error("Failed to save routing table", zap.Error(err))
+		c.JSON(500, gin.H{"error": s.t(c, "failed_save_routing")})
+		return
+	}
+
+	s.logger.Info("Routing table updated",
+		zap.Int("aliases", len(table.Aliases)),
+		zap.Int("fallbacks", len(table.Fallbacks)),
+		zap.Int("capabilities", len(table.Capabilities)))
+	c.JSON(200, &table)
+}
+
+// ==================== 调试抓包 ====================
+
+// armCapture records the next N requests made with an API key
+func (s *Server) armCapture(c *gin.Context) {
+	var req struct {
+		Key   string `json:"key" binding:"required"`
+		Count int    `json:"count"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_request_detail", err.Error())})
+		return
+	}
+	if req.Count == 0 {
+		req.Count = 1
+	}
+	if req.Key != s.cfg.Security.APIKey && !s.keyStore.Exists(req.Key) {
+		c.JSON(404, gin.H{"error": s.t(c, "api_key_not_found")})
+		return
+	}
+
+	s.captures.Arm(req.Key, req.Count)
+	s.logger.Info("Request capture armed",
+		zap.String("key_prefix", maskAPIKey(req.Key)),
+		zap.Int("count", req.Count))
+	c.JSON(200, gin.H{"success": true})
+}
+
+func (s *Server) listCaptures(c *gin.Context) {
+	captures := []gin.H{}
+	for _, record := range s.captures.List() {
+		captures = append(captures, gin.H{
+			"id":         record.ID,
+			"key":        record.Key,
+			"path":       record.Path,
+			"model":      record.Model,
+			"stream":     record.Stream,
+			"startedAt":  record.StartedAt,
+			"durationMs": record.DurationMs,
+			"attempts":   len(record.Attempts),
+			"truncated":  record.Truncated,
+		})
+	}
+	c.JSON(200, gin.H{
+		"armed":    s.captures.Triggers(),
+		"captures": captures,
+	})
+}
+
+func (s *Server) downloadCapture(c *gin.Context) {
+	record, ok := s.captures.Get(c.Param("id"))
+	if !ok {
+		c.JSON(404, gin.H{"error": s.t(c, "capture_not_found")})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="capture-%s.json"`, record.ID))
+	c.IndentedJSON(200, record)
+}
+
+func (s *Server) clearCaptures(c *gin.Context) {
+	s.captures.Clear()
+	c.JSON(200, gin.H{"success": true})
+}
+
 // ==================== 工具函数 ====================
 
 func generateToken(password string) string {
@@ -668,15 +1107,48 @@ func generateToken(password string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// generateRandomString returns length characters drawn uniformly from
+// [a-zA-Z0-9] with crypto/rand; API keys and OAuth states must not be guessable
 func generateRandomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[i%len(charset)]
+	// Bytes at or above the largest multiple of len(charset) are dropped so every character is equally likely
+	const limit = 256 - 256%len(charset)
+	b := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(b) < length {
+		rand.Read(buf) // Never returns an error since Go 1.24
+		for _, v := range buf {
+			if int(v) < limit && len(b) < length {
+				b = append(b, charset[int(v)%len(charset)])
+			}
+		}
 	}
 	return string(b)
 }
 
+// resolveAccountID maps an ID from the admin API back to the stored one: in
+// privacy mode the API lists older, email-based IDs masked
+func (s *Server) resolveAccountID(id string) string {
+	if !s.cfg.Security.MaskEmails {
+		return id
+	}
+	ids, err := s.oauthClient.AccountStore().List()
+	if err != nil {
+		return id
+	}
+	for _, stored := range ids {
+		if stored == id {
+			return id
+		}
+	}
+	for _, stored := range ids {
+		if models.MaskAccountID(stored) == id {
+			return stored
+		}
+	}
+	return id
+}
+
 // validateAccountID checks if the account ID is safe to use in file paths
 // Prevents path traversal attacks by rejecting IDs containing path separators or special characters
 func validateAccountID(accountID string) bool {
@@ -695,10 +1167,11 @@ func validateAccountID(accountID string) bool {
 		return false
 	}
 	
-	// Only allow alphanumeric characters, underscores, hyphens, and dots
+	// Only allow alphanumeric characters, underscores, hyphens, and dots, plus
+	// the address characters of older IDs that start with the account email
 	for _, c := range accountID {
 		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || 
-			 (c >= '0' && c <= '9') || c == '_' || c == '-' || c == '.') {
+			 (c >= '0' && c <= '9') || c == '_' || c == '-' || c == '.' || c == '@' || c == '+') {
 			return false
 		}
 	}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const harnessAPIKey = "sk-harness"
+
+// testHarness runs the full gin server against a fake Google upstream
+type testHarness struct {
+	t        *testing.T
+	server   *Server
+	upstream *httptest.Server
+	cfg      *config.Config
+	calls    atomic.Int64
+
+	// handler serves upstream requests; swap it per test
+	handler http.HandlerFunc
+}
+
+func newTestHarness(t *testing.T) *testHarness {
+	t.Helper()
+
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Server: config.ServerConfig{Port: 8045, Mode: "test"},
+		Security: config.SecurityConfig{
+			APIKey:        harnessAPIKey,
+			AdminPassword: "admin",
+		},
+		Storage: config.StorageConfig{
+			DataDir:     dir,
+			AccountsDir: filepath.Join(dir, "accounts"),
+			KeysDir:     filepath.Join(dir, "keys"),
+			UsageDir:    filepath.Join(dir, "usage"),
+			LogsDir:     filepath.Join(dir, "logs"),
+		},
+		Proxy: config.ProxyConfig{DeveloperRole: "system"},
+		Reports: config.ReportsConfig{
+			Dir:                 filepath.Join(dir, "reports"),
+			InputCostPerMillion: 1,
+		},
+	}
+
+	h := &testHarness{t: t, cfg: cfg}
+	h.upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.calls.Add(1)
+		h.handler(w, r)
+	}))
+	t.Cleanup(h.upstream.Close)
+
+	srv, err := New(cfg, zap.NewNop())
+	require.NoError(t, err)
+	srv.upstreamURL = h.upstream.URL + "/v1internal:streamGenerateContent?alt=sse"
+	t.Cleanup(srv.Close)
+	h.server = srv
+
+	return h
+}
+
+// addAccount stores an enabled account whose access token is "token-<id>"
+func (h *testHarness) addAccount(id string) {
+	h.t.Helper()
+	account := &models.Account{
+		AccountID:   id,
+		Email:       id + "@example.com",
+		Enable:      true,
+		AccessToken: "token-" + id,
+		ExpiresIn:   3600,
+		Timestamp:   time.Now().UnixMilli(),
+		Usage:       &models.UsageStats{},
+	}
+	require.NoError(h.t, h.server.oauthClient.AccountStore().Save(account))
+}
+
+// loadAccount reads an account back from the store
+func (h *testHarness) loadAccount(id string) *models.Account {
+	h.t.Helper()
+	account, err := h.server.oauthClient.AccountStore().Load(id)
+	require.NoError(h.t, err)
+	return account
+}
+
+// chat posts a chat completion request through the full middleware chain
+func (h *testHarness) chat(body interface{}) *httptest.ResponseRecorder {
+	h.t.Helper()
+	return h.chatAs(harnessAPIKey, body)
+}
+
+// chatAs posts a chat completion request authenticated with apiKey
+func (h *testHarness) chatAs(apiKey string, body interface{}) *httptest.ResponseRecorder {
+	h.t.Helper()
+	data, err := json.Marshal(body)
+	require.NoError(h.t, err)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	h.server.Router().ServeHTTP(rec, req)
+	return rec
+}
+
+// gemini posts a raw native Gemini request authenticated the way google-genai SDKs do
+func (h *testHarness) gemini(path, body string) *httptest.ResponseRecorder {
+	h.t.Helper()
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("x-goog-api-key", harnessAPIKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	h.server.Router().ServeHTTP(rec, req)
+	return rec
+}
+
+// api sends a request authenticated with the harness API key
+func (h *testHarness) api(req *http.Request) *httptest.ResponseRecorder {
+	h.t.Helper()
+	req.Header.Set("Authorization", "Bearer "+harnessAPIKey)
+	rec := httptest.NewRecorder()
+	h.server.Router().ServeHTTP(rec, req)
+	return rec
+}
+
+// sseEvents renders upstream SSE events from raw JSON payloads
+func sseEvents(events ...string) string {
+	var b strings.Builder
+	for _, e := range events {
+		fmt.Fprintf(&b, "data: %s\n\n", e)
+	}
+	return b.String()
+}
+
+// textEvent is an upstream event carrying one text part
+func textEvent(text string) string {
+	data, _ := json.Marshal(text)
+	return fmt.Sprintf(`{"response":{"candidates":[{"content":{"role":"model","parts":[{"text":%s}]}}]}}`, data)
+}
+
+// usageEvent is an upstream event carrying only usage metadata
+func usageEvent(prompt, candidates int) string {
+	return fmt.Sprintf(`{"response":{"candidates":[{"content":{"role":"model","parts":[]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":%d,"candidatesTokenCount":%d,"totalTokenCount":%d}}}`,
+		prompt, candidates, prompt+candidates)
+}
+
+// writeSSE replies with an SSE body
+func writeSSE(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(200)
+	w.Write([]byte(body))
+}
+
+// admin sends an authenticated admin API request
+func (h *testHarness) admin(method, path string, body interface{}) *httptest.ResponseRecorder {
+	h.t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(h.t, err)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("X-Admin-Token", generateToken(h.cfg.Security.AdminPassword))
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	h.server.Router().ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/antigravity/api-proxy/internal/upstream"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 对冲请求：开启 proxy.hedge_delay 后，所选账号在该时间内没有返回响应头时，用第二个账号发出同样的请求，
+// 先成功响应的一方胜出，另一方立即取消。用少量额外的上游请求换取更低的尾延迟，适合交互式客户端。
+// 第二个账号只从当前空闲的账号中选择，不会排队等待；先失败的一方在另一方仍在进行时不会直接返回给客户端，
+// 但限流冷却、403 禁用和失败计数照常记录在它的账号上。
+// 非流式的 generateContent 请求要等整个回答生成完才返回响应头，不做对冲
+
+// hedgeStats counts hedged attempts for /metrics
+type hedgeStats struct {
+	fired atomic.Int64 // second requests sent
+	won   atomic.Int64 // second requests that answered first
+}
+
+// hedgeResult is the outcome of one of the racing requests
+type hedgeResult struct {
+	resp    *http.Response
+	err     error
+	account *models.Account
+	// cancel and release end the request and free its account slot
+	cancel  context.CancelFunc
+	release func()
+}
+
+// usable reports whether the result can answer the client
+func (r hedgeResult) usable() bool {
+	return r.err == nil && r.resp.StatusCode == http.StatusOK
+}
+
+// discard releases a losing request in the background
+func (r hedgeResult) discard() {
+	r.cancel()
+	upstream.DrainAndClose(r.resp)
+	r.release()
+}
+
+// penalizeLoser applies the account bookkeeping of a failed attempt to a
+// request whose failure was not returned to runAttempt
+func (s *Server) penalizeLoser(log *zap.Logger, r hedgeResult, blameModel bool) {
+	if r.err != nil {
+		s.recordFailure(r.account, fmt.Sprintf("request failed: %v", r.err))
+		return
+	}
+	body, _ := io.ReadAll(r.resp.Body)
+	s.penalizeAccount(log, r.account, r.resp.StatusCode, r.resp.Header, sanitizeUpstreamError(body), blameModel)
+}
+
+// hedgeDelay is how long an attempt may go without response headers before a
+// second account is tried (0 = no hedging)
+func (s *Server) hedgeDelay() time.Duration {
+	if s.cfg == nil {
+		return 0
+	}
+	return s.cfg.Proxy.HedgeDelay
+}
+
+// sendHedged sends req on account and, with hedging on, the same body on a
+// second account once the first has not answered within the hedge delay. It
+// returns the winning response and account and a func releasing the winner's
+// hedge resources (a no-op when the first request won).
+func (s *Server) sendHedged(c *gin.Context, pr *proxyRequest, ctx context.Context, cancel context.CancelFunc, account *models.Account, req *http.Request, body []byte) (*http.Response, *models.Account, func(), error) {
+	// A generateContent call is slow by design until it is done, not stuck
+	delay := s.hedgeDelay()
+	if delay <= 0 || pr.generate {
+		resp, err := s.doUpstream(ctx, cancel, pr, account, req)
+		return resp, account, func() {}, err
+	}
+
+	results := make(chan hedgeResult, 2)
+	go func() {
+		resp, err := s.doUpstream(ctx, cancel, pr, account, req)
+		results <- hedgeResult{resp: resp, err: err, account: account, cancel: cancel, release: func() {}}
+	}()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.resp, r.account, func() {}, r.err
+	case <-timer.C:
+	}
+
+	pending := 1
+	cancelHedge, ok := s.startHedge(c, pr, account, body, results)
+	if ok {
+		pending++
+	}
+
+	// The first usable response wins; a failure only counts when nothing else is pending
+	for {
+		r := <-results
+		pending--
+		if !r.usable() && pending > 0 {
+			s.requestLogger(c).Debug("Hedged request failed, waiting for the other one",
+				zap.String("account_id", s.displayAccountID(r.account.AccountID)),
+				zap.Error(r.err))
+			if c.Request.Context().Err() == nil {
+				s.penalizeLoser(s.requestLogger(c), r, len(pr.fallbacks) > 0)
+			}
+			go r.discard()
+			continue
+		}
+		if pending > 0 {
+			// Stop the loser now; its result is released once it returns
+			if r.account == account {
+				cancelHedge()
+			} else {
+				cancel()
+			}
+			// c and pr may be reused once the handler returns
+			log, blameModel := s.requestLogger(c), len(pr.fallbacks) > 0
+			go func() {
+				loser := <-results
+				// An error is most likely our own cancellation; an error status arrived before it
+				if loser.err == nil && !loser.usable() {
+					s.penalizeLoser(log, loser, blameModel)
+				}
+				loser.discard()
+			}()
+		}
+		if r.account == account {
+			return r.resp, r.account, func() {}, r.err
+		}
+		if r.usable() {
+			s.hedges.won.Add(1)
+			s.requestLogger(c).Info("Hedged request answered first",
+				zap.String("account_id", s.displayAccountID(r.account.AccountID)),
+				zap.String("email", s.displayEmail(r.account.Email)))
+		}
+		return r.resp, r.account, func() { r.cancel(); r.release() }, r.err
+	}
+}
+
+// startHedge sends the second request on another idle account and returns
+// its cancel func; false means no account was free and nothing was sent
+func (s *Server) startHedge(c *gin.Context, pr *proxyRequest, first *models.Account, body []byte, results chan<- hedgeResult) (context.CancelFunc, bool) {
+	limit := s.accountConcurrency()
+	exclude := map[string]bool{first.AccountID: true}
+	for accountID := range pr.attempted {
+		exclude[accountID] = true
+	}
+	for accountID := range s.accountSlots.full(limit) {
+		exclude[accountID] = true
+	}
+	account, err := s.oauthClient.GetTokenExcluding(pr.estimatedTokens, exclude)
+	// Excluded accounts are only returned when nothing else is usable
+	if err != nil || exclude[account.AccountID] {
+		return nil, false
+	}
+	if ok, _ := s.accountSlots.acquire(account.AccountID, limit); !ok {
+		return nil, false
+	}
+	release := func() { s.accountSlots.release(account.AccountID) }
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	req, err := http.NewRequestWithContext(ctx, "POST", pr.url, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		release()
+		return nil, false
+	}
+	s.setUpstreamHeaders(req, account)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	if s.cfg == nil || !s.cfg.Proxy.RetrySameAccount {
+		if pr.attempted == nil {
+			pr.attempted = make(map[string]bool)
+		}
+		pr.attempted[account.AccountID] = true
+	}
+	s.hedges.fired.Add(1)
+	s.requestLogger(c).Info("No response yet, hedging on a second account",
+		zap.String("account_id", s.displayAccountID(account.AccountID)),
+		zap.String("email", s.displayEmail(account.Email)),
+		zap.String("first_account_id", s.displayAccountID(first.AccountID)))
+
+	go func() {
+		resp, err := s.doUpstream(ctx, cancel, pr, account, req)
+		results <- hedgeResult{resp: resp, err: err, account: account, cancel: cancel, release: release}
+	}()
+	return cancel, true
+}
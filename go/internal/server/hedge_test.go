@@ -0,0 +1,118 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegration_HedgedRequest(t *testing.T) {
+	h := newTestHarness(t)
+	h.addAccount("acc1")
+	h.addAccount("acc2")
+	h.cfg.Proxy.HedgeDelay = 20 * time.Millisecond
+
+	// The first account called stalls until it is cancelled; the hedge answers at once
+	var first atomic.Value
+	cancelled := make(chan string, 1)
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		// The server only notices a closed connection once the body is read
+		io.Copy(io.Discard, r.Body)
+		if first.CompareAndSwap(nil, token) {
+			select {
+			case <-r.Context().Done():
+				cancelled <- token
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		writeSSE(w, sseEvents(textEvent("fast"), usageEvent(1, 1)))
+	}
+
+	start := time.Now()
+	rec := h.chat(helloRequest)
+	require.Equal(t, 200, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "fast")
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.Equal(t, int64(2), h.calls.Load())
+
+	select {
+	case token := <-cancelled:
+		assert.Equal(t, first.Load(), token, "the slow request is cancelled")
+	case <-time.After(2 * time.Second):
+		t.Fatal("the losing request was not cancelled")
+	}
+	assert.Equal(t, int64(1), h.server.hedges.fired.Load())
+	assert.Equal(t, int64(1), h.server.hedges.won.Load())
+
+	// A quick answer needs no hedge
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, sseEvents(textEvent("quick"), usageEvent(1, 1)))
+	}
+	rec = h.chat(helloRequest)
+	require.Equal(t, 200, rec.Code)
+	assert.Equal(t, int64(3), h.calls.Load())
+	assert.Equal(t, int64(1), h.server.hedges.fired.Load())
+}
+
+func TestIntegration_HedgeWaitsOutFirstFailure(t *testing.T) {
+	h := newTestHarness(t)
+	h.addAccount("acc1")
+	h.addAccount("acc2")
+	h.cfg.Proxy.HedgeDelay = 20 * time.Millisecond
+
+	// The first account answers late with an error; the hedge answers later still, successfully
+	var first atomic.Value
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if first.CompareAndSwap(nil, token) {
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(503)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+		writeSSE(w, sseEvents(textEvent("hedge"), usageEvent(1, 1)))
+	}
+
+	rec := h.chat(helloRequest)
+	require.Equal(t, 200, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "hedge")
+	assert.Equal(t, int64(2), h.calls.Load(), "no retry was needed")
+}
+
+func TestIntegration_HedgeLoserIsCooledDown(t *testing.T) {
+	h := newTestHarness(t)
+	h.addAccount("acc1")
+	h.addAccount("acc2")
+	h.cfg.Proxy.HedgeDelay = 20 * time.Millisecond
+
+	// The first account answers 429 while the hedge is still running; the hedge then succeeds
+	var first atomic.Value
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if first.CompareAndSwap(nil, token) {
+			time.Sleep(50 * time.Millisecond)
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(429)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+		writeSSE(w, sseEvents(textEvent("hedge"), usageEvent(1, 1)))
+	}
+
+	rec := h.chat(helloRequest)
+	require.Equal(t, 200, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), "hedge")
+
+	loser := h.loadAccount(strings.TrimPrefix(first.Load().(string), "token-"))
+	assert.True(t, loser.IsInCooldown(), "the rate-limited account is cooled down")
+	require.NotNil(t, loser.ErrorTracking)
+	assert.Equal(t, 1, loser.ErrorTracking.RateLimitCount)
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/antigravity/api-proxy/internal/models"
+)
+
+// 内联图片在构建上游请求前统一校验：超出 inlineData 限制的图片在上游只会得到含糊的400，
+// 这里提前给出明确错误，或按配置缩小并重新编码
+
+const (
+	// maxImagePixels guards against decompression bombs before a full decode
+	maxImagePixels = 64 << 20
+	// resizeAttempts bounds how often an image is shrunk further to fit MaxBytes
+	resizeAttempts = 4
+)
+
+// prepareImages validates (and, if enabled, shrinks) every inline image in the
+// request. Data URLs are rewritten in place so each retry attempt reuses the result.
+func (s *Server) prepareImages(req *models.ChatCompletionRequest) *contentError {
+	limits := s.cfg.Proxy.Images
+	if limits.MaxBytes == 0 && limits.MaxDimension == 0 {
+		return nil
+	}
+
+	for i, msg := range req.Messages {
+		items, ok := msg.Content.([]interface{})
+		if !ok {
+			continue
+		}
+		for j, item := range items {
+			partMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			// Images arrive as image_url parts or as file parts carrying image data
+			var holder map[string]interface{}
+			var field string
+			switch partMap["type"] {
+			case "image_url":
+				holder, _ = partMap["image_url"].(map[string]interface{})
+				field = "url"
+			case "file":
+				holder, _ = partMap["file"].(map[string]interface{})
+				field = "file_data"
+			}
+			if holder == nil {
+				continue
+			}
+			url, _ := holder[field].(string)
+			mimeType, data, ok := parseDataURL(url)
+			if !ok || !strings.HasPrefix(mimeType, "image/") {
+				continue
+			}
+
+			newMime, newData, err := fitImage(mimeType, data, limits)
+			if err != nil {
+				err.param = contentParam(i, j)
+				return err
+			}
+			if newData != data {
+				holder[field] = "data:" + newMime + ";base64," + newData
+			}
+		}
+	}
+	return nil
+}
+
+// fitImage returns the image unchanged when it is within limits, otherwise a
+// downscaled re-encoding, or an error when neither is possible
+func fitImage(mimeType, data string, limits config.ImageConfig) (string, string, *contentError) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", "", &contentError{code: "invalid_image", message: "image data is not valid base64"}
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		// Formats the standard library can't decode (webp, heic...) are only size-checked
+		if mimeType == "image/png" || mimeType == "image/jpeg" || mimeType == "image/gif" {
+			return "", "", &contentError{code: "invalid_image", message: fmt.Sprintf("%s image could not be decoded", mimeType)}
+		}
+		if limits.MaxBytes > 0 && len(raw) > limits.MaxBytes {
+			return "", "", &contentError{code: "image_too_large", message: fmt.Sprintf(
+				"%s image is %d bytes, the limit is %d and this format can't be resized", mimeType, len(raw), limits.MaxBytes)}
+		}
+		return mimeType, data, nil
+	}
+
+	tooBig := limits.MaxBytes > 0 && len(raw) > limits.MaxBytes
+	tooWide := limits.MaxDimension > 0 && (cfg.Width > limits.MaxDimension || cfg.Height > limits.MaxDimension)
+	if !tooBig && !tooWide {
+		return mimeType, data, nil
+	}
+	if !limits.Resize || cfg.Width*cfg.Height > maxImagePixels {
+		return "", "", &contentError{code: "image_too_large", message: fmt.Sprintf(
+			"image is %dx%d (%d bytes), the limit is %d pixels per side and %d bytes",
+			cfg.Width, cfg.Height, len(raw), limits.MaxDimension, limits.MaxBytes)}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return "", "", &contentError{code: "invalid_image", message: fmt.Sprintf("%s image could not be decoded", format)}
+	}
+
+	width, height := fitDimensions(cfg.Width, cfg.Height, limits.MaxDimension)
+	for attempt := 0; attempt < resizeAttempts; attempt++ {
+		newMime, encoded, err := encodeImage(downscale(img, width, height), format, limits.JPEGQuality)
+		if err != nil {
+			return "", "", &contentError{code: "invalid_image", message: "image could not be re-encoded"}
+		}
+		if limits.MaxBytes == 0 || len(encoded) <= limits.MaxBytes {
+			return newMime, base64.StdEncoding.EncodeToString(encoded), nil
+		}
+		// Still over the byte limit: shrink further
+		width, height = max(1, width*3/4), max(1, height*3/4)
+	}
+	return "", "", &contentError{code: "image_too_large", message: fmt.Sprintf(
+		"image could not be reduced below %d bytes", limits.MaxBytes)}
+}
+
+// fitDimensions scales width x height to fit within maxSide, keeping the aspect ratio
+func fitDimensions(width, height, maxSide int) (int, int) {
+	if maxSide <= 0 || (width <= maxSide && height <= maxSide) {
+		return width, height
+	}
+	if width >= height {
+		return maxSide, max(1, height*maxSide/width)
+	}
+	return max(1, width*maxSide/height), maxSide
+}
+
+// encodeImage keeps PNG for images with transparency and uses JPEG otherwise
+func encodeImage(img *image.RGBA, format string, quality int) (string, []byte, error) {
+	var buf bytes.Buffer
+	if format != "jpeg" && !img.Opaque() {
+		err := png.Encode(&buf, img)
+		return "image/png", buf.Bytes(), err
+	}
+	if quality <= 0 {
+		quality = jpeg.DefaultQuality
+	}
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	return "image/jpeg", buf.Bytes(), err
+}
+
+// downscale resizes src to width x height by averaging the source pixels that
+// fall into each destination pixel (a box filter, adequate for shrinking)
+func downscale(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	}
+	srcW, srcH := rgba.Bounds().Dx(), rgba.Bounds().Dy()
+	if srcW == width && srcH == height {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcH/height, max((y+1)*srcH/height, y*srcH/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcW/width, max((x+1)*srcW/width, x*srcW/width+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
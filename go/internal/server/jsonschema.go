@@ -0,0 +1,299 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 结构化输出校验用的 JSON Schema 子集：覆盖 OpenAI strict 模式允许的关键字
+// （type、properties、required、additionalProperties、items、enum、const、anyOf、$ref 等）
+// 以及常用的长度、数值范围约束；不认识的关键字忽略
+
+// schemaError locates the first violation, e.g. `$.items[2].name: expected string`
+type schemaError struct {
+	path    string
+	message string
+}
+
+func (e *schemaError) Error() string {
+	return e.path + ": " + e.message
+}
+
+// validateJSONSchema checks value (as decoded by encoding/json) against schema
+func validateJSONSchema(schema map[string]interface{}, value interface{}) error {
+	v := schemaValidator{root: schema}
+	return v.validate(schema, value, "$", 0)
+}
+
+// maxSchemaDepth stops self-referencing schemas from recursing forever
+const maxSchemaDepth = 64
+
+type schemaValidator struct {
+	root map[string]interface{}
+}
+
+func (v *schemaValidator) validate(schema map[string]interface{}, value interface{}, path string, depth int) error {
+	if depth > maxSchemaDepth {
+		return &schemaError{path, "schema nesting too deep"}
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := v.resolve(ref)
+		if err != nil {
+			return &schemaError{path, err.Error()}
+		}
+		return v.validate(target, value, path, depth+1)
+	}
+
+	if err := checkType(schema["type"], value, path); err != nil {
+		return err
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &schemaError{path, "value is not one of the allowed enum values"}
+		}
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		return &schemaError{path, fmt.Sprintf("expected constant %v", constant)}
+	}
+
+	for _, sub := range schemaList(schema["allOf"]) {
+		if err := v.validate(sub, value, path, depth+1); err != nil {
+			return err
+		}
+	}
+	for _, key := range []string{"anyOf", "oneOf"} {
+		subs := schemaList(schema[key])
+		if len(subs) == 0 {
+			continue
+		}
+		matches := 0
+		var firstErr error
+		for _, sub := range subs {
+			if err := v.validate(sub, value, path, depth+1); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			matches++
+		}
+		if matches == 0 {
+			return &schemaError{path, fmt.Sprintf("value matches none of the %s schemas (first mismatch: %v)", key, firstErr)}
+		}
+		if key == "oneOf" && matches > 1 {
+			return &schemaError{path, "value matches more than one oneOf schema"}
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return v.validateObject(schema, value, path, depth)
+	case []interface{}:
+		return v.validateArray(schema, value, path, depth)
+	case string:
+		return validateString(schema, value, path)
+	case float64:
+		return validateNumber(schema, value, path)
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateObject(schema, obj map[string]interface{}, path string, depth int) error {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, present := obj[key]; !present {
+					return &schemaError{path, fmt.Sprintf("missing required property %q", key)}
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		childPath := path + "." + key
+		if sub, ok := properties[key].(map[string]interface{}); ok {
+			if err := v.validate(sub, obj[key], childPath, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, declared := properties[key]; declared {
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				return &schemaError{path, fmt.Sprintf("unexpected property %q", key)}
+			}
+		case map[string]interface{}:
+			if err := v.validate(extra, obj[key], childPath, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateArray(schema map[string]interface{}, arr []interface{}, path string, depth int) error {
+	if n, ok := schemaNumber(schema, "minItems"); ok && float64(len(arr)) < n {
+		return &schemaError{path, fmt.Sprintf("expected at least %v items, got %d", n, len(arr))}
+	}
+	if n, ok := schemaNumber(schema, "maxItems"); ok && float64(len(arr)) > n {
+		return &schemaError{path, fmt.Sprintf("expected at most %v items, got %d", n, len(arr))}
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range arr {
+			if err := v.validate(items, item, path+"["+strconv.Itoa(i)+"]", depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateString(schema map[string]interface{}, s, path string) error {
+	length := float64(utf8.RuneCountInString(s))
+	if n, ok := schemaNumber(schema, "minLength"); ok && length < n {
+		return &schemaError{path, fmt.Sprintf("expected at least %v characters", n)}
+	}
+	if n, ok := schemaNumber(schema, "maxLength"); ok && length > n {
+		return &schemaError{path, fmt.Sprintf("expected at most %v characters", n)}
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err == nil && !re.MatchString(s) {
+			return &schemaError{path, fmt.Sprintf("string does not match pattern %q", pattern)}
+		}
+	}
+	return nil
+}
+
+func validateNumber(schema map[string]interface{}, n float64, path string) error {
+	if min, ok := schemaNumber(schema, "minimum"); ok && n < min {
+		return &schemaError{path, fmt.Sprintf("expected a value >= %v", min)}
+	}
+	if max, ok := schemaNumber(schema, "maximum"); ok && n > max {
+		return &schemaError{path, fmt.Sprintf("expected a value <= %v", max)}
+	}
+	if min, ok := schemaNumber(schema, "exclusiveMinimum"); ok && n <= min {
+		return &schemaError{path, fmt.Sprintf("expected a value > %v", min)}
+	}
+	if max, ok := schemaNumber(schema, "exclusiveMaximum"); ok && n >= max {
+		return &schemaError{path, fmt.Sprintf("expected a value < %v", max)}
+	}
+	return nil
+}
+
+// checkType validates the "type" keyword, a single type name or a list of them
+func checkType(spec interface{}, value interface{}, path string) error {
+	var types []string
+	switch spec := spec.(type) {
+	case string:
+		types = []string{spec}
+	case []interface{}:
+		for _, t := range spec {
+			if name, ok := t.(string); ok {
+				types = append(types, name)
+			}
+		}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	for _, t := range types {
+		if jsonTypeMatches(t, value) {
+			return nil
+		}
+	}
+	return &schemaError{path, fmt.Sprintf("expected %s, got %s", strings.Join(types, " or "), jsonTypeName(value))}
+}
+
+func jsonTypeMatches(t string, value interface{}) bool {
+	switch t {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonTypeName(value) == t
+	}
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// resolve follows a local reference such as "#/$defs/Item"
+func (v *schemaValidator) resolve(ref string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("unsupported $ref %q (only local references are supported)", ref)
+	}
+	var node interface{} = v.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		node = obj[token]
+	}
+	target, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unresolvable $ref %q", ref)
+	}
+	return target, nil
+}
+
+func schemaList(value interface{}) []map[string]interface{} {
+	list, _ := value.([]interface{})
+	var out []map[string]interface{}
+	for _, item := range list {
+		if schema, ok := item.(map[string]interface{}); ok {
+			out = append(out, schema)
+		}
+	}
+	return out
+}
+
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	n, ok := schema[key].(float64)
+	return n, ok
+}
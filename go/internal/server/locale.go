@@ -0,0 +1,37 @@
+package server
+
+import (
+	"html"
+
+	"github.com/antigravity/api-proxy/internal/i18n"
+	"github.com/gin-gonic/gin"
+)
+
+// lang negotiates the response language from Accept-Language, falling back to server.locale
+func (s *Server) lang(c *gin.Context) string {
+	fallback := ""
+	if s.cfg != nil {
+		fallback = s.cfg.Server.Locale
+	}
+	return i18n.Match(c.GetHeader("Accept-Language"), fallback)
+}
+
+// t translates a message key for the current request
+func (s *Server) t(c *gin.Context, key string, args ...interface{}) string {
+	return i18n.T(s.lang(c), key, args...)
+}
+
+// renderMessagePage writes a minimal HTML page with a heading, a message and
+// a link back to the admin panel. Text is escaped.
+func (s *Server) renderMessagePage(c *gin.Context, status int, icon, title, message string) {
+	lang := s.lang(c)
+	page := `<html lang="` + lang + `">
+<head><meta charset="utf-8"><title>` + html.EscapeString(title) + `</title></head>
+<body style="font-family: Arial; padding: 50px; text-align: center;">
+	<h1>` + icon + ` ` + html.EscapeString(title) + `</h1>
+	<p>` + html.EscapeString(message) + `</p>
+	<p><a href="/ui/index.html">` + i18n.T(lang, "page_back_to_dashboard") + `</a></p>
+</body>
+</html>`
+	c.Data(status, "text/html; charset=utf-8", []byte(page))
+}
@@ -1,8 +1,12 @@
 package server
 
 import (
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/antigravity/api-proxy/internal/models"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
@@ -12,7 +16,7 @@ func (s *Server) loggerMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 		path := c.Request.URL.Path
-		query := c.Request.URL.RawQuery
+		query := redactQuery(c.Request.URL.RawQuery)
 
 		c.Next()
 
@@ -21,15 +25,42 @@ func (s *Server) loggerMiddleware() gin.HandlerFunc {
 		method := c.Request.Method
 		clientIP := c.ClientIP()
 
-		s.logger.Info("HTTP Request",
+		fields := []zap.Field{
 			zap.String("method", method),
 			zap.String("path", path),
 			zap.String("query", query),
 			zap.Int("status", statusCode),
 			zap.Duration("latency", latency),
 			zap.String("client_ip", clientIP),
-		)
+		}
+		// End-user attribution set by the chat handler (OpenAI "user"/"metadata" fields)
+		if user := c.GetString("request_user"); user != "" {
+			fields = append(fields, zap.String("user", user))
+		}
+		if metadata, ok := c.Get("request_metadata"); ok {
+			fields = append(fields, zap.Any("metadata", metadata))
+		}
+
+		s.requestLogger(c).Info("HTTP Request", fields...)
+	}
+}
+
+// redactQuery masks the ?key= API key google-genai clients send, so it never reaches the logs
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if name == "key" {
+			params[i] = "key=[REDACTED_KEY]"
+		}
 	}
+	return strings.Join(params, "&")
 }
 
 // corsMiddleware handles CORS
@@ -53,8 +84,9 @@ func (s *Server) corsMiddleware() gin.HandlerFunc {
 				c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 			}
 			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-			c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Admin-Token")
+			c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Admin-Token, x-goog-api-key")
 			c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
+			c.Writer.Header().Set("Access-Control-Expose-Headers", requestIDHeader)
 		}
 
 		if c.Request.Method == "OPTIONS" {
@@ -66,106 +98,112 @@ func (s *Server) corsMiddleware() gin.HandlerFunc {
 	}
 }
 
-// apiKeyAuthMiddleware validates API key for API requests
+// apiKeyAuthMiddleware validates API key for API requests (see apiAuthChain)
 func (s *Server) apiKeyAuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get API key from Authorization header
-		authHeader := c.GetHeader("Authorization")
-		
-		if authHeader == "" {
-			c.JSON(401, gin.H{
-				"error": gin.H{
-					"message": "Missing Authorization header",
-					"type":    "invalid_request_error",
-					"code":    "missing_api_key",
-				},
-			})
-			c.Abort()
-			return
-		}
-
-		// Extract Bearer token
-		apiKey := ""
-		if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-			apiKey = authHeader[7:]
-		} else {
-			apiKey = authHeader
-		}
-
+	return s.apiAuthChain().middleware()
+}
 
-		// First, check if it matches the static API key from config (backward compatibility)
-		if s.cfg.Security.APIKey != "" && apiKey == s.cfg.Security.APIKey {
-			s.logger.Info("API request authenticated with config API key",
-				zap.String("client_ip", c.ClientIP()))
-			c.Set("api_key_source", "config")
-			c.Next()
-			return
+// staticKey looks apiKey up in security.api_keys
+func (s *Server) staticKey(apiKey string) (*models.APIKey, bool) {
+	for _, static := range s.cfg.Security.APIKeys {
+		if static.Key != apiKey {
+			continue
 		}
-
-		// Log for debugging if config key doesn't match
-		if s.cfg.Security.APIKey != "" {
-			s.logger.Debug("Config API key check failed",
-				zap.String("config_key_prefix", maskAPIKey(s.cfg.Security.APIKey)),
-				zap.String("provided_key_prefix", maskAPIKey(apiKey)))
+		key := &models.APIKey{Key: static.Key, Name: static.Name, Static: true, ContextTrimming: static.ContextTrimming}
+		if static.MaxRequests > 0 {
+			key.RateLimit = &models.RateLimit{
+				Enabled:     true,
+				MaxRequests: static.MaxRequests,
+				WindowMs:    int(static.Window.Milliseconds()),
+			}
 		}
+		return key, true
+	}
+	return nil, false
+}
 
-		// Second, validate against dynamic API keys from keyStore
-		key, err := s.keyStore.Load(apiKey)
-		if err != nil {
-			s.logger.Warn("Invalid API key attempt",
-				zap.String("key_prefix", maskAPIKey(apiKey)),
-				zap.String("client_ip", c.ClientIP()))
-			
-			c.JSON(401, gin.H{
-				"error": gin.H{
-					"message": "Invalid API key",
-					"type":    "invalid_request_error",
-					"code":    "invalid_api_key",
-				},
-			})
-			c.Abort()
-			return
-		}
+// keyAllowsModel reports whether the request's API key may use a model; keys
+// listing allowed models match either the requested name or what it resolves to
+func keyAllowsModel(c *gin.Context, requested, resolved string) bool {
+	value, ok := c.Get("api_key")
+	if !ok {
+		return true
+	}
+	key := value.(*models.APIKey)
+	return key.AllowsModel(requested) || key.AllowsModel(resolved)
+}
 
-		// Update usage for dynamic keys
-		key.UpdateUsage()
-		if err := s.keyStore.Save(key); err != nil {
-			s.logger.Error("Failed to update key usage", zap.Error(err))
-		}
+// requestAPIKey extracts the API key from the Authorization header.
+// google-genai SDKs send the key as x-goog-api-key or ?key= instead.
+func requestAPIKey(c *gin.Context) string {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		authHeader = c.GetHeader("x-goog-api-key")
+	}
+	if authHeader == "" {
+		authHeader = c.Query("key")
+	}
 
-		// Store key in context for later use
-		c.Set("api_key", key)
-		c.Set("api_key_source", "database")
-		
-		c.Next()
+	// Extract Bearer token
+	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
+		return authHeader[7:]
 	}
+	return authHeader
 }
 
-// adminAuthMiddleware checks admin authentication
+// adminAuthMiddleware checks admin authentication (password token or SSO session, see adminAuthChain)
 func (s *Server) adminAuthMiddleware() gin.HandlerFunc {
+	chain := s.adminAuthChain()
 	return func(c *gin.Context) {
-		token := c.GetHeader("X-Admin-Token")
+		if !chain.check(c) {
+			return
+		}
 
-		if token == "" {
-			c.JSON(401, gin.H{"error": "Unauthorized"})
+		// 只读角色只能访问GET接口
+		if c.GetString("admin_role") == roleViewer && c.Request.Method != http.MethodGet {
+			c.JSON(403, gin.H{"error": s.t(c, "forbidden_read_only")})
 			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
 
-		// Validate token against the expected admin token
-		expectedToken := generateToken(s.cfg.Security.AdminPassword)
-		if token != expectedToken {
-			s.logger.Warn("Invalid admin token attempt",
-				zap.String("client_ip", c.ClientIP()))
-			c.JSON(401, gin.H{"error": "Unauthorized"})
+// adminOnly restricts a read endpoint to the admin role (e.g. ones exposing secrets)
+func (s *Server) adminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("admin_role") != roleAdmin {
+			c.JSON(403, gin.H{"error": s.t(c, "forbidden_read_only")})
 			c.Abort()
 			return
 		}
-
 		c.Next()
 	}
 }
 
+// passwordLoginEnabled reports whether the shared admin password is accepted
+func (s *Server) passwordLoginEnabled() bool {
+	return s.oidc == nil || !s.cfg.Security.OIDC.DisablePassword
+}
+
+// displayEmail is an account email as shown in admin responses, logs and
+// headers; masked when security.mask_emails is on
+func (s *Server) displayEmail(email string) string {
+	if s.cfg.Security.MaskEmails {
+		return models.MaskEmail(email)
+	}
+	return email
+}
+
+// displayAccountID is an account ID as it appears in admin APIs, logs and
+// notifications; IDs of accounts added before opaque IDs contain the email
+func (s *Server) displayAccountID(accountID string) string {
+	if s.cfg.Security.MaskEmails {
+		return models.MaskAccountID(accountID)
+	}
+	return accountID
+}
+
 // maskAPIKey returns a masked version of the API key for logging
 func maskAPIKey(key string) string {
 	if len(key) <= 8 {
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// 内容审核：POST /v1/moderations 兼容 OpenAI Moderation API。每条输入经低成本模型（proxy.moderation_model）
+// 处理一次，安全过滤全部设为 BLOCK_NONE 只取评分，再把 Gemini 的 safetyRatings 映射为 OpenAI 的审核类别，
+// 依赖审核接口把关内容的应用无需再配置第二个 Base URL。每条输入都是一次上游调用，
+// 因此条数和总文本长度有上限，超出第一条的部分同样计入密钥的请求限流
+
+// moderationCategories are the OpenAI moderation categories, all reported in every result
+var moderationCategories = []string{
+	"harassment", "harassment/threatening", "hate", "hate/threatening",
+	"illicit", "illicit/violent", "self-harm", "self-harm/intent", "self-harm/instructions",
+	"sexual", "sexual/minors", "violence", "violence/graphic",
+}
+
+// geminiHarmCategories maps Gemini harm categories to OpenAI categories;
+// civic integrity has no OpenAI counterpart
+var geminiHarmCategories = map[string][]string{
+	"HARM_CATEGORY_HARASSMENT":        {"harassment"},
+	"HARM_CATEGORY_HATE_SPEECH":       {"hate"},
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT": {"sexual"},
+	"HARM_CATEGORY_DANGEROUS_CONTENT": {"illicit", "violence", "self-harm"},
+}
+
+// harmProbabilityScores turns Gemini's probability buckets into category scores
+var harmProbabilityScores = map[string]float64{
+	"NEGLIGIBLE": 0.01,
+	"LOW":        0.25,
+	"MEDIUM":     0.6,
+	"HIGH":       0.9,
+}
+
+// moderationFlagScore is the score from which a category is flagged (MEDIUM and above)
+const moderationFlagScore = 0.5
+
+// moderationSafetySettings disable blocking so every input gets ratings instead of an error
+var moderationSafetySettings = func() []interface{} {
+	var settings []interface{}
+	for _, category := range []string{"HARM_CATEGORY_HARASSMENT", "HARM_CATEGORY_HATE_SPEECH", "HARM_CATEGORY_SEXUALLY_EXPLICIT", "HARM_CATEGORY_DANGEROUS_CONTENT"} {
+		settings = append(settings, map[string]interface{}{"category": category, "threshold": "BLOCK_NONE"})
+	}
+	return settings
+}()
+
+// Limits on one moderation request: every item is a separate upstream call
+const (
+	moderationMaxInputs     = 32
+	moderationMaxInputBytes = 128 * 1024 // Text across all items
+)
+
+// moderationEchoTokens bounds the echoed output; ratings mostly come from the prompt
+const moderationEchoTokens = 256
+
+type moderationRequest struct {
+	Input json.RawMessage `json:"input"` // string, []string or []content part
+	Model string          `json:"model,omitempty"`
+}
+
+type moderationResult struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
+
+type moderationResponse struct {
+	ID      string             `json:"id"`
+	Model   string             `json:"model"`
+	Results []moderationResult `json:"results"`
+}
+
+// moderations handles POST /v1/moderations
+func (s *Server) moderations(c *gin.Context) {
+	var req moderationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		openAIError(c, 400, "invalid_request", "Invalid request body: "+err.Error())
+		return
+	}
+	inputs, err := moderationInputs(req.Input)
+	if err != nil {
+		apiError(c, 400, models.ErrorDetail{Message: err.Error(), Type: errTypeInvalidRequest, Param: "input", Code: "invalid_request"})
+		return
+	}
+	// The first item was counted by the rate limit middleware
+	if !s.chargeRequests(c, len(inputs)-1) {
+		return
+	}
+
+	model, _ := s.route(s.cfg.Proxy.ModerationModel)
+	timeout, err := s.requestTimeout(c, 0)
+	if err != nil {
+		openAIError(c, 400, "invalid_timeout", err.Error())
+		return
+	}
+	cancel := withDeadline(c, timeout)
+	defer cancel()
+
+	resp := moderationResponse{ID: "modr-" + uuid.New().String(), Model: req.Model}
+	if resp.Model == "" {
+		resp.Model = model
+	}
+	echoTokens := moderationEchoTokens
+	for i, input := range inputs {
+		// Items run one after another, so a spent anonymous budget stops the rest
+		if i > 0 && c.GetString("api_key_source") == "anonymous" && s.anonymousQuotaSpent(c, clientKey(c)) {
+			return
+		}
+		googleReq := s.transformRequest(&models.ChatCompletionRequest{
+			Model:     model,
+			MaxTokens: &echoTokens,
+			Messages: []models.ChatCompletionMessage{
+				{Role: "system", Content: "Repeat the user's message verbatim."},
+				{Role: "user", Content: input},
+			},
+		})
+		googleReq.Request.SafetySettings = moderationSafetySettings
+		result, _, err := s.generateOnce(c, googleReq)
+		if err != nil {
+			helperCallError(c, err, timeout, "Failed to moderate the input")
+			return
+		}
+		resp.Results = append(resp.Results, moderationFromRatings(result))
+	}
+	c.JSON(200, resp)
+}
+
+// moderationInputs splits the input into the items that get their own result:
+// each string of a list, or one multi-part (text and image) input. Requests
+// over moderationMaxInputs items or moderationMaxInputBytes of text fail.
+func moderationInputs(raw json.RawMessage) ([]interface{}, error) {
+	inputs, err := splitModerationInput(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(inputs) > moderationMaxInputs {
+		return nil, fmt.Errorf("input has %d items, at most %d are allowed", len(inputs), moderationMaxInputs)
+	}
+	size := 0
+	for _, input := range inputs {
+		switch v := input.(type) {
+		case string:
+			size += len(v)
+		case []interface{}:
+			for _, part := range v {
+				text, _ := part.(map[string]interface{})["text"].(string)
+				size += len(text)
+			}
+		}
+	}
+	if size > moderationMaxInputBytes {
+		return nil, fmt.Errorf("input has %d bytes of text, at most %d are allowed", size, moderationMaxInputBytes)
+	}
+	return inputs, nil
+}
+
+// splitModerationInput decodes the input into its items
+func splitModerationInput(raw json.RawMessage) ([]interface{}, error) {
+	var input interface{}
+	if len(raw) == 0 || json.Unmarshal(raw, &input) != nil {
+		return nil, fmt.Errorf("input is required")
+	}
+	switch v := input.(type) {
+	case string:
+		return []interface{}{v}, nil
+	case []interface{}:
+		if len(v) == 0 {
+			return nil, fmt.Errorf("input must not be empty")
+		}
+		if _, isPart := v[0].(map[string]interface{}); isPart {
+			for _, item := range v {
+				if _, ok := item.(map[string]interface{}); !ok {
+					return nil, fmt.Errorf("input must not mix strings and content parts")
+				}
+			}
+			return []interface{}{v}, nil
+		}
+		for _, item := range v {
+			if _, ok := item.(string); !ok {
+				return nil, fmt.Errorf("input must be a string, a list of strings or a list of content parts")
+			}
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("input must be a string, a list of strings or a list of content parts")
+}
+
+// moderationFromRatings builds an OpenAI result from the prompt and candidate safety ratings
+func moderationFromRatings(resp *models.GoogleResponseInner) moderationResult {
+	result := moderationResult{
+		Categories:     make(map[string]bool, len(moderationCategories)),
+		CategoryScores: make(map[string]float64, len(moderationCategories)),
+	}
+	for _, category := range moderationCategories {
+		result.Categories[category] = false
+		result.CategoryScores[category] = 0
+	}
+
+	var ratings []models.GoogleSafetyRating
+	if resp.PromptFeedback != nil {
+		ratings = append(ratings, resp.PromptFeedback.SafetyRatings...)
+		// A prompt blocked for any reason is flagged even without a matching category
+		result.Flagged = resp.PromptFeedback.BlockReason != ""
+	}
+	if len(resp.Candidates) > 0 {
+		ratings = append(ratings, resp.Candidates[0].SafetyRatings...)
+	}
+	for _, rating := range ratings {
+		score := harmProbabilityScores[rating.Probability]
+		if rating.Blocked {
+			score = max(score, harmProbabilityScores["HIGH"])
+		}
+		for _, category := range geminiHarmCategories[rating.Category] {
+			if score > result.CategoryScores[category] {
+				result.CategoryScores[category] = score
+			}
+			if score >= moderationFlagScore {
+				result.Categories[category] = true
+				result.Flagged = true
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/gin-gonic/gin"
+)
+
+// 机器对机器部署可使用mTLS：客户端证书经CA校验后，按证书身份映射到一个API密钥，
+// 之后与Bearer密钥走相同的限流、用量统计逻辑
+
+// internalRequest marks requests the server replays on a client's behalf (batches)
+type internalRequest struct{}
+
+func isInternalRequest(c *gin.Context) bool {
+	internal, _ := c.Request.Context().Value(internalRequest{}).(bool)
+	return internal
+}
+
+// NewTLSConfig builds the listener TLS config. With a client CA, certificates
+// are verified when presented but not demanded at the handshake, so browsers
+// can still reach the admin panel; /v1 enforces them in the auth middleware.
+func NewTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
+
+// certIdentities lists the names a certificate can be mapped by
+func certIdentities(cert *x509.Certificate) []string {
+	sum := sha256.Sum256(cert.Raw)
+	identities := []string{"sha256:" + hex.EncodeToString(sum[:])}
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, strings.ToLower(cert.Subject.CommonName))
+	}
+	return identities
+}
+
+// clientCertKey returns the API key mapped to the request's verified client
+// certificate, and the identity that matched
+func (s *Server) clientCertKey(c *gin.Context) (apiKey, identity string) {
+	state := c.Request.TLS
+	if state == nil || len(state.VerifiedChains) == 0 || len(s.cfg.Server.TLS.ClientCerts) == 0 {
+		return "", ""
+	}
+
+	// viper lowercases map keys, so lookups are case-insensitive
+	mapping := make(map[string]string, len(s.cfg.Server.TLS.ClientCerts))
+	for id, key := range s.cfg.Server.TLS.ClientCerts {
+		mapping[strings.ToLower(id)] = key
+	}
+	for _, id := range certIdentities(state.VerifiedChains[0][0]) {
+		if key, ok := mapping[id]; ok {
+			return key, id
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clientCert creates a self-signed certificate; verification itself is the
+// TLS stack's job, the middleware only reads VerifiedChains
+func clientCert(t *testing.T, commonName string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+// chatWithCert posts a chat request over a (simulated) mTLS connection.
+// verified=false models a certificate the TLS stack did not verify.
+func (h *testHarness) chatWithCert(cert *x509.Certificate, verified bool) *httptest.ResponseRecorder {
+	h.t.Helper()
+	data, _ := json.Marshal(helloRequest)
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if verified {
+		req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+	rec := httptest.NewRecorder()
+	h.server.Router().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMTLS_ClientCertificateMapsToKey(t *testing.T) {
+	h := newTestHarness(t)
+	h.addAccount("acc1")
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, sseEvents(textEvent("hi")))
+	}
+	h.cfg.Server.TLS.ClientCerts = map[string]string{"batch-worker": harnessAPIKey}
+
+	rec := h.chatWithCert(clientCert(t, "Batch-Worker"), true)
+	assert.Equal(t, 200, rec.Code, rec.Body.String())
+
+	// Unverified or unmapped certificates don't authenticate
+	rec = h.chatWithCert(clientCert(t, "batch-worker"), false)
+	assert.Equal(t, 401, rec.Code)
+	rec = h.chatWithCert(clientCert(t, "someone-else"), true)
+	assert.Equal(t, 401, rec.Code)
+
+	// Fingerprint mapping
+	cert := clientCert(t, "")
+	h.cfg.Server.TLS.ClientCerts = map[string]string{certIdentities(cert)[0]: harnessAPIKey}
+	rec = h.chatWithCert(cert, true)
+	assert.Equal(t, 200, rec.Code, rec.Body.String())
+
+	// Bearer keys keep working unless certificates are required
+	assert.Equal(t, 200, h.chat(helloRequest).Code)
+}
+
+func TestMTLS_RequireClientCert(t *testing.T) {
+	h := newTestHarness(t)
+	h.addAccount("acc1")
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, sseEvents(textEvent("hi")))
+	}
+	h.cfg.Server.TLS.RequireClientCert = true
+	h.cfg.Server.TLS.ClientCerts = map[string]string{"batch-worker": harnessAPIKey}
+
+	rec := h.chat(helloRequest)
+	assert.Equal(t, 401, rec.Code)
+	assert.Contains(t, rec.Body.String(), "client_certificate_required")
+	assert.Zero(t, h.calls.Load())
+
+	rec = h.chatWithCert(clientCert(t, "batch-worker"), true)
+	assert.Equal(t, 200, rec.Code, rec.Body.String())
+
+	// The admin panel is unaffected
+	assert.Equal(t, 200, h.admin("GET", "/admin/keys", nil).Code)
+}
@@ -1,9 +1,10 @@
 package server
 
 import (
-	"context"
 	"fmt"
+	"html"
 
+	"github.com/antigravity/api-proxy/internal/i18n"
 	"github.com/antigravity/api-proxy/internal/oauth"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -17,15 +18,7 @@ func (s *Server) handleOAuthCallback(c *gin.Context) {
 	if code == "" {
 		errorMsg := c.Query("error")
 		s.logger.Error("OAuth callback error", zap.String("error", errorMsg))
-		errorHTML := fmt.Sprintf(`<html>
-<head><title>授权失败</title></head>
-<body style="font-family: Arial; padding: 50px; text-align: center;">
-	<h1>❌ 授权失败</h1>
-	<p>错误: %s</p>
-	<p><a href="/ui/index.html">返回管理面板</a></p>
-</body>
-</html>`, errorMsg)
-		c.Data(200, "text/html; charset=utf-8", []byte(errorHTML))
+		s.renderMessagePage(c, 200, "❌", s.t(c, "page_auth_failed"), s.t(c, "page_auth_error", errorMsg))
 		return
 	}
 
@@ -33,34 +26,18 @@ func (s *Server) handleOAuthCallback(c *gin.Context) {
 	client := oauth.NewClient(s.cfg.Server.Port, s.cfg.Storage.AccountsDir, s.logger)
 
 	// 交换code获取token
-	token, err := client.GetOAuthConfig().Exchange(context.Background(), code)
+	token, err := client.Exchange(c.Request.Context(), code)
 	if err != nil {
 		s.logger.Error("Failed to exchange code", zap.Error(err))
-		errorHTML := `<html>
-<head><title>授权失败</title></head>
-<body style="font-family: Arial; padding: 50px; text-align: center;">
-	<h1>❌ 授权失败</h1>
-	<p>无法获取访问令牌</p>
-	<p><a href="/ui/index.html">返回管理面板</a></p>
-</body>
-</html>`
-		c.Data(200, "text/html; charset=utf-8", []byte(errorHTML))
+		s.renderMessagePage(c, 200, "❌", s.t(c, "page_auth_failed"), s.t(c, "page_no_access_token"))
 		return
 	}
 
 	// 获取用户信息
-	userInfo, err := client.GetUserInfo(token.AccessToken)
+	userInfo, err := client.UserInfoForToken(c.Request.Context(), token)
 	if err != nil {
 		s.logger.Error("Failed to get user info", zap.Error(err))
-		errorHTML := `<html>
-<head><title>授权失败</title></head>
-<body style="font-family: Arial; padding: 50px; text-align: center;">
-	<h1>❌ 授权失败</h1>
-	<p>无法获取用户信息</p>
-	<p><a href="/ui/index.html">返回管理面板</a></p>
-</body>
-</html>`
-		c.Data(200, "text/html; charset=utf-8", []byte(errorHTML))
+		s.renderMessagePage(c, 200, "❌", s.t(c, "page_auth_failed"), s.t(c, "page_no_user_info"))
 		return
 	}
 
@@ -68,28 +45,22 @@ func (s *Server) handleOAuthCallback(c *gin.Context) {
 	account, err := client.SaveAccountFromToken(token, userInfo)
 	if err != nil {
 		s.logger.Error("Failed to save account", zap.Error(err))
-		errorHTML := `<html>
-<head><title>保存失败</title></head>
-<body style="font-family: Arial; padding: 50px; text-align: center;">
-	<h1>⚠️ 保存失败</h1>
-	<p>无法保存账号信息</p>
-	<p><a href="/ui/index.html">返回管理面板</a></p>
-</body>
-</html>`
-		c.Data(200, "text/html; charset=utf-8", []byte(errorHTML))
+		s.renderMessagePage(c, 200, "⚠️", s.t(c, "page_save_failed"), s.t(c, "page_save_failed_detail"))
 		return
 	}
 
 	s.logger.Info("OAuth login successful",
-		zap.String("email", account.Email),
-		zap.String("account_id", account.AccountID),
+		zap.String("email", s.displayEmail(account.Email)),
+		zap.String("account_id", s.displayAccountID(account.AccountID)),
 		zap.Int("models", len(account.Models)))
 
 	// 返回成功页面（自动关闭）
+	lang := s.lang(c)
 	successHTML := fmt.Sprintf(`<!DOCTYPE html>
-<html>
+<html lang="%s">
 <head>
-    <title>授权成功</title>
+    <meta charset="utf-8">
+    <title>%s</title>
     <style>
         body { font-family: Arial, sans-serif; display: flex; justify-content: center; align-items: center; height: 100vh; margin: 0; background: linear-gradient(135deg, #667eea 0%%, #764ba2 100%%); }
         .container { background: white; padding: 40px; border-radius: 10px; box-shadow: 0 10px 40px rgba(0,0,0,0.2); text-align: center; }
@@ -101,16 +72,21 @@ func (s *Server) handleOAuthCallback(c *gin.Context) {
 <body>
     <div class="container">
         <div class="success">✓</div>
-        <h1>授权成功！</h1>
-        <p>账号: <strong>%s</strong></p>
-        <p>邮箱: <strong>%s</strong></p>
-        <p>可用模型: <strong>%d</strong> 个</p>
-        <p>该窗口将在 3 秒后自动关闭...</p>
+        <h1>%s</h1>
+        <p>%s: <strong>%s</strong></p>
+        <p>%s: <strong>%s</strong></p>
+        <p>%s: <strong>%s</strong></p>
+        <p>%s</p>
     </div>
     <script>
         setTimeout(() => window.close(), 3000);
     </script>
 </body>
-</html>`, account.Name, account.Email, len(account.Models))
+</html>`, lang, i18n.T(lang, "page_auth_success"),
+		i18n.T(lang, "page_auth_success_heading"),
+		i18n.T(lang, "page_account"), html.EscapeString(account.Name),
+		i18n.T(lang, "page_email"), html.EscapeString(account.Email),
+		i18n.T(lang, "page_models"), i18n.T(lang, "page_models_count", len(account.Models)),
+		i18n.T(lang, "page_auto_close"))
 	c.Data(200, "text/html; charset=utf-8", []byte(successHTML))
 }
@@ -0,0 +1,373 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+// 管理面板的OIDC单点登录：授权码流程，ID Token直接从token端点经TLS获取，
+// 按OIDC规范可用TLS校验代替签名校验；仍然校验 iss/aud/exp/nonce。
+// 登录成功后按用户组映射角色并创建服务端会话，会话token与密码token一样通过 X-Admin-Token 传递。
+
+const (
+	roleAdmin  = "admin"
+	roleViewer = "viewer"
+
+	// oidcStateTTL bounds how long a login may take at the provider
+	oidcStateTTL = 10 * time.Minute
+)
+
+// adminSession is an SSO login; sessions live in memory and end on restart
+type adminSession struct {
+	User    string
+	Role    string
+	Expires time.Time
+}
+
+// oidcPending is a login started at /admin/oidc/login, keyed by state
+type oidcPending struct {
+	nonce   string
+	expires time.Time
+}
+
+// oidcDiscovery is the subset of the provider metadata we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// oidcAuth holds provider metadata, pending logins and SSO sessions
+type oidcAuth struct {
+	cfg config.OIDCConfig
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	pending   map[string]oidcPending
+	sessions  map[string]*adminSession
+}
+
+func newOIDCAuth(cfg config.OIDCConfig) *oidcAuth {
+	return &oidcAuth{
+		cfg:      cfg,
+		pending:  make(map[string]oidcPending),
+		sessions: make(map[string]*adminSession),
+	}
+}
+
+// discover fetches and caches the provider metadata
+func (o *oidcAuth) discover(ctx context.Context) (*oidcDiscovery, error) {
+	o.mu.Lock()
+	cached := o.discovery
+	o.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	url := strings.TrimSuffix(o.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	var doc oidcDiscovery
+	if err := getJSON(ctx, url, "", &doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" {
+		return nil, errors.New("oidc discovery: missing endpoints")
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != strings.TrimSuffix(o.cfg.Issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery: issuer mismatch %q", doc.Issuer)
+	}
+
+	o.mu.Lock()
+	o.discovery = &doc
+	o.mu.Unlock()
+	return &doc, nil
+}
+
+func (o *oidcAuth) oauthConfig(doc *oidcDiscovery) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     o.cfg.ClientID,
+		ClientSecret: o.cfg.ClientSecret,
+		RedirectURL:  o.cfg.RedirectURL,
+		Scopes:       o.cfg.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  doc.AuthorizationEndpoint,
+			TokenURL: doc.TokenEndpoint,
+		},
+	}
+}
+
+// begin records a pending login and returns the provider URL to redirect to
+func (o *oidcAuth) begin(ctx context.Context) (string, error) {
+	doc, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	state, nonce := randomHex(16), randomHex(16)
+	o.mu.Lock()
+	now := time.Now()
+	for key, p := range o.pending {
+		if now.After(p.expires) {
+			delete(o.pending, key)
+		}
+	}
+	o.pending[state] = oidcPending{nonce: nonce, expires: now.Add(oidcStateTTL)}
+	o.mu.Unlock()
+
+	return o.oauthConfig(doc).AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce)), nil
+}
+
+// complete exchanges the code, validates the ID token and maps the user to a role.
+// It returns the session token on success.
+func (o *oidcAuth) complete(ctx context.Context, state, code string) (token string, session *adminSession, err error) {
+	o.mu.Lock()
+	pending, ok := o.pending[state]
+	delete(o.pending, state)
+	o.mu.Unlock()
+	if !ok || time.Now().After(pending.expires) {
+		return "", nil, errors.New("unknown or expired login state")
+	}
+
+	doc, err := o.discover(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	tok, err := o.oauthConfig(doc).Exchange(ctx, code)
+	if err != nil {
+		return "", nil, fmt.Errorf("code exchange: %w", err)
+	}
+
+	rawIDToken, _ := tok.Extra("id_token").(string)
+	claims, err := o.idTokenClaims(rawIDToken, doc.Issuer, pending.nonce)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Some providers only expose groups via userinfo
+	if _, ok := claims[o.cfg.GroupsClaim]; !ok && doc.UserinfoEndpoint != "" {
+		var userinfo map[string]interface{}
+		if err := getJSON(ctx, doc.UserinfoEndpoint, tok.AccessToken, &userinfo); err == nil {
+			if groups, ok := userinfo[o.cfg.GroupsClaim]; ok {
+				claims[o.cfg.GroupsClaim] = groups
+			}
+		}
+	}
+
+	user := claimString(claims, "email")
+	if user == "" {
+		user = claimString(claims, "sub")
+	}
+	role := o.role(claimStrings(claims, o.cfg.GroupsClaim))
+	if role == "" {
+		return "", nil, fmt.Errorf("user %s is not in an allowed group", user)
+	}
+
+	now := time.Now()
+	session = &adminSession{User: user, Role: role, Expires: now.Add(o.cfg.SessionTTL)}
+	token = randomHex(32)
+	o.mu.Lock()
+	// Sessions nobody logs out of would otherwise stay until restart
+	for key, s := range o.sessions {
+		if now.After(s.Expires) {
+			delete(o.sessions, key)
+		}
+	}
+	o.sessions[token] = session
+	o.mu.Unlock()
+	return token, session, nil
+}
+
+// idTokenClaims decodes the ID token payload and checks iss, aud, exp and nonce
+func (o *oidcAuth) idTokenClaims(raw, issuer, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("missing or malformed id_token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("id_token payload: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("id_token payload: %w", err)
+	}
+
+	if claimString(claims, "iss") != issuer {
+		return nil, errors.New("id_token issuer mismatch")
+	}
+	if !slices.Contains(claimStrings(claims, "aud"), o.cfg.ClientID) {
+		return nil, errors.New("id_token audience mismatch")
+	}
+	if exp, ok := claims["exp"].(float64); !ok || time.Now().Unix() > int64(exp) {
+		return nil, errors.New("id_token expired")
+	}
+	if claimString(claims, "nonce") != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// role maps groups to a role; admin wins over viewer, "" means no access
+func (o *oidcAuth) role(groups []string) string {
+	member := func(allowed []string) bool {
+		for _, g := range allowed {
+			if g == "*" || slices.Contains(groups, g) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case member(o.cfg.AdminGroups):
+		return roleAdmin
+	case member(o.cfg.ViewerGroups):
+		return roleViewer
+	}
+	return ""
+}
+
+// session returns a live session for token
+func (o *oidcAuth) session(token string) (*adminSession, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	session, ok := o.sessions[token]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(session.Expires) {
+		delete(o.sessions, token)
+		return nil, false
+	}
+	return session, true
+}
+
+// end removes a session (logout)
+func (o *oidcAuth) end(token string) {
+	o.mu.Lock()
+	delete(o.sessions, token)
+	o.mu.Unlock()
+}
+
+// getJSON fetches url (optionally with a bearer token) and decodes the JSON body
+func getJSON(ctx context.Context, url, bearer string, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %s: HTTP %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func claimString(claims map[string]interface{}, key string) string {
+	s, _ := claims[key].(string)
+	return s
+}
+
+// claimStrings reads a claim that may be a string or a list of strings
+func claimStrings(claims map[string]interface{}, key string) []string {
+	switch v := claims[key].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ==================== Handlers ====================
+
+// oidcInfo tells the login page which login methods are available
+func (s *Server) oidcInfo(c *gin.Context) {
+	c.JSON(200, gin.H{
+		"sso":      s.oidc != nil,
+		"password": s.oidc == nil || !s.cfg.Security.OIDC.DisablePassword,
+	})
+}
+
+// oidcLogin redirects the browser to the identity provider
+func (s *Server) oidcLogin(c *gin.Context) {
+	if s.oidc == nil {
+		c.JSON(404, gin.H{"error": s.t(c, "sso_disabled")})
+		return
+	}
+	url, err := s.oidc.begin(c.Request.Context())
+	if err != nil {
+		s.logger.Error("OIDC login failed", zap.Error(err))
+		s.renderMessagePage(c, 502, "❌", s.t(c, "page_sso_failed"), s.t(c, "page_sso_provider_unreachable"))
+		return
+	}
+	c.Redirect(http.StatusFound, url)
+}
+
+// oidcCallback completes the login and hands the session token to the admin UI
+func (s *Server) oidcCallback(c *gin.Context) {
+	if s.oidc == nil {
+		c.JSON(404, gin.H{"error": s.t(c, "sso_disabled")})
+		return
+	}
+	if errMsg := c.Query("error"); errMsg != "" {
+		s.renderMessagePage(c, 400, "❌", s.t(c, "page_sso_failed"), s.t(c, "page_auth_error", errMsg))
+		return
+	}
+
+	token, session, err := s.oidc.complete(c.Request.Context(), c.Query("state"), c.Query("code"))
+	if err != nil {
+		s.logger.Warn("OIDC callback rejected", zap.Error(err))
+		s.renderMessagePage(c, 403, "❌", s.t(c, "page_sso_failed"), s.t(c, "page_sso_denied"))
+		return
+	}
+
+	s.logger.Info("Admin logged in via SSO",
+		zap.String("user", session.User),
+		zap.String("role", session.Role))
+
+	// 会话token写入localStorage后回到管理面板，与密码登录共用同一套前端逻辑
+	page := `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>` + html.EscapeString(s.t(c, "page_auth_success")) + `</title></head>
+<body><script>
+localStorage.setItem('adminToken', '` + token + `');
+window.location.replace('/ui/index.html');
+</script></body></html>`
+	c.Data(200, "text/html; charset=utf-8", []byte(page))
+}
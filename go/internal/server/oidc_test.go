@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOIDCProvider serves discovery, token and userinfo endpoints.
+// The ID token is unsigned; the proxy relies on TLS to the token endpoint.
+type fakeOIDCProvider struct {
+	*httptest.Server
+	nonce  string
+	groups []string
+}
+
+func newFakeOIDCProvider(t *testing.T) *fakeOIDCProvider {
+	p := &fakeOIDCProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"userinfo_endpoint":      p.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := json.Marshal(map[string]interface{}{
+			"iss":   p.URL,
+			"aud":   "proxy-client",
+			"sub":   "user-1",
+			"email": "ops@example.com",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": p.nonce,
+		})
+		idToken := "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"groups": p.groups})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// enableOIDC switches the harness server to SSO against provider
+func enableOIDC(h *testHarness, provider *fakeOIDCProvider, disablePassword bool) {
+	h.cfg.Security.OIDC = config.OIDCConfig{
+		Enabled:         true,
+		Issuer:          provider.URL,
+		ClientID:        "proxy-client",
+		RedirectURL:     "http://localhost:8045/admin/oidc/callback",
+		Scopes:          []string{"openid", "email"},
+		GroupsClaim:     "groups",
+		AdminGroups:     []string{"ops"},
+		ViewerGroups:    []string{"staff"},
+		DisablePassword: disablePassword,
+		SessionTTL:      time.Hour,
+	}
+	h.server.oidc = newOIDCAuth(h.cfg.Security.OIDC)
+}
+
+// ssoLogin runs the browser side of the login and returns the callback response
+func ssoLogin(t *testing.T, h *testHarness, provider *fakeOIDCProvider) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := h.api(httptest.NewRequest("GET", "/admin/oidc/login", nil))
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/authorize", location.Path)
+	provider.nonce = location.Query().Get("nonce")
+
+	state := location.Query().Get("state")
+	return h.api(httptest.NewRequest("GET", "/admin/oidc/callback?code=abc&state="+state, nil))
+}
+
+func adminRequest(h *testHarness, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("X-Admin-Token", token)
+	rec := httptest.NewRecorder()
+	h.server.Router().ServeHTTP(rec, req)
+	return rec
+}
+
+var sessionTokenPattern = regexp.MustCompile(`setItem\('adminToken', '([0-9a-f]+)'\)`)
+
+func TestOIDC_GroupRoleMapping(t *testing.T) {
+	h := newTestHarness(t)
+	provider := newFakeOIDCProvider(t)
+	enableOIDC(h, provider, true)
+
+	rec := h.api(httptest.NewRequest("GET", "/admin/oidc", nil))
+	assert.JSONEq(t, `{"sso":true,"password":false}`, rec.Body.String())
+
+	// Viewer: read-only
+	provider.groups = []string{"staff"}
+	rec = ssoLogin(t, h, provider)
+	require.Equal(t, 200, rec.Code, rec.Body.String())
+	match := sessionTokenPattern.FindStringSubmatch(rec.Body.String())
+	require.Len(t, match, 2)
+	viewer := match[1]
+
+	rec = adminRequest(h, "GET", "/admin/verify", viewer)
+	assert.JSONEq(t, `{"valid":true,"user":"ops@example.com","role":"viewer"}`, rec.Body.String())
+	assert.Equal(t, 200, adminRequest(h, "GET", "/admin/keys/stats", viewer).Code)
+	assert.Equal(t, 403, adminRequest(h, "POST", "/admin/keys/generate", viewer).Code)
+	// Endpoints returning secrets stay admin-only
+	for _, path := range []string{"/admin/settings", "/admin/keys", "/admin/tokens", "/admin/captures/any"} {
+		assert.Equal(t, 403, adminRequest(h, "GET", path, viewer).Code, path)
+	}
+
+	// Admin group gets write access
+	provider.groups = []string{"staff", "ops"}
+	rec = ssoLogin(t, h, provider)
+	admin := sessionTokenPattern.FindStringSubmatch(rec.Body.String())[1]
+	assert.NotEqual(t, 403, adminRequest(h, "POST", "/admin/keys/generate", admin).Code)
+	assert.Equal(t, 200, adminRequest(h, "GET", "/admin/keys", admin).Code)
+	assert.Equal(t, 200, adminRequest(h, "GET", "/admin/tokens", admin).Code)
+
+	// Users outside the mapped groups are rejected
+	provider.groups = []string{"contractors"}
+	rec = ssoLogin(t, h, provider)
+	assert.Equal(t, 403, rec.Code)
+
+	// The shared password is disabled
+	assert.Equal(t, 403, h.admin("POST", "/admin/login", map[string]string{"password": "admin"}).Code)
+	assert.Equal(t, 401, h.admin("GET", "/admin/keys", nil).Code)
+
+	// Logout ends the session
+	adminRequest(h, "POST", "/admin/logout", viewer)
+	assert.Equal(t, 401, adminRequest(h, "GET", "/admin/keys", viewer).Code)
+
+	// Expired sessions are dropped at the next login even if never used again
+	h.server.oidc.mu.Lock()
+	h.server.oidc.sessions[admin].Expires = time.Now().Add(-time.Second)
+	h.server.oidc.mu.Unlock()
+	provider.groups = []string{"ops"}
+	require.Equal(t, 200, ssoLogin(t, h, provider).Code)
+	h.server.oidc.mu.Lock()
+	assert.NotContains(t, h.server.oidc.sessions, admin)
+	assert.Len(t, h.server.oidc.sessions, 1)
+	h.server.oidc.mu.Unlock()
+}
+
+func TestOIDC_RejectsReplayedState(t *testing.T) {
+	h := newTestHarness(t)
+	provider := newFakeOIDCProvider(t)
+	enableOIDC(h, provider, false)
+	provider.groups = []string{"ops"}
+
+	rec := h.api(httptest.NewRequest("GET", "/admin/oidc/login", nil))
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	provider.nonce = location.Query().Get("nonce")
+	callback := "/admin/oidc/callback?code=abc&state=" + location.Query().Get("state")
+
+	assert.Equal(t, 200, h.api(httptest.NewRequest("GET", callback, nil)).Code)
+	assert.Equal(t, 403, h.api(httptest.NewRequest("GET", callback, nil)).Code)
+
+	// A wrong nonce in the ID token is rejected too
+	rec = h.api(httptest.NewRequest("GET", "/admin/oidc/login", nil))
+	location, _ = url.Parse(rec.Header().Get("Location"))
+	provider.nonce = "forged"
+	rec = h.api(httptest.NewRequest("GET", "/admin/oidc/callback?code=abc&state="+location.Query().Get("state"), nil))
+	assert.Equal(t, 403, rec.Code)
+
+	// Password login still works when not disabled
+	assert.Equal(t, 200, h.admin("GET", "/admin/keys", nil).Code)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"go.uber.org/zap"
+)
+
+// 采样参数范围：路由表的 parameters 为每个模型设置 temperature/top_p/top_k 的取值范围。
+// 超出范围的值默认在本地截断到范围内；设置了 reject 的模型直接返回400并说明哪个参数超出范围，
+// 而不是把请求发到上游后得到一个含糊的错误
+
+// parameterBounds looks up the bounds of model, ignoring the -thinking and
+// image output suffixes
+func (s *Server) parameterBounds(model string) (models.ParameterBounds, bool) {
+	params := s.routingTable().Parameters
+	if bounds, ok := params[model]; ok {
+		return bounds, true
+	}
+	base := strings.TrimSuffix(strings.TrimSuffix(model, "-thinking"), imageOutputSuffix)
+	bounds, ok := params[base]
+	return bounds, ok
+}
+
+// checkParameterBounds rejects out-of-range sampling parameters for models
+// whose bounds are set to reject; other models are clamped in transformRequest
+func (s *Server) checkParameterBounds(req *models.ChatCompletionRequest, model string) *contentError {
+	bounds, ok := s.parameterBounds(model)
+	if !ok || !bounds.Reject {
+		return nil
+	}
+	check := func(param string, value *float64, r *models.Range) *contentError {
+		if value == nil || r.Contains(*value) {
+			return nil
+		}
+		return &contentError{
+			code:    "parameter_out_of_range",
+			param:   param,
+			message: fmt.Sprintf("%s %s is outside %s for model %s", param, strconv.FormatFloat(*value, 'g', -1, 64), describeRange(r), model),
+		}
+	}
+	if err := check("temperature", req.Temperature, bounds.Temperature); err != nil {
+		return err
+	}
+	if err := check("top_p", req.TopP, bounds.TopP); err != nil {
+		return err
+	}
+	if req.TopK != nil {
+		topK := float64(*req.TopK)
+		return check("top_k", &topK, bounds.TopK)
+	}
+	return nil
+}
+
+// clampParameters moves the generation config's sampling parameters into
+// the model's bounds
+func (s *Server) clampParameters(model string, genConfig *models.GoogleGenerationConfig) {
+	bounds, ok := s.parameterBounds(model)
+	if !ok {
+		return
+	}
+	clamp := func(param string, value *float64, r *models.Range) *float64 {
+		if value == nil || r.Contains(*value) {
+			return value
+		}
+		clamped := r.Clamp(*value)
+		s.logger.Debug("Clamping sampling parameter to the model's range",
+			zap.String("model", model),
+			zap.String("param", param),
+			zap.Float64("value", *value),
+			zap.Float64("clamped", clamped))
+		return &clamped
+	}
+	genConfig.Temperature = clamp("temperature", genConfig.Temperature, bounds.Temperature)
+	genConfig.TopP = clamp("top_p", genConfig.TopP, bounds.TopP)
+	if genConfig.TopK != nil {
+		topK := float64(*genConfig.TopK)
+		if clamped := clamp("top_k", &topK, bounds.TopK); *clamped != topK {
+			// Round into the range: up from a minimum, down from a maximum
+			k := int(math.Floor(*clamped))
+			if *clamped > topK {
+				k = int(math.Ceil(*clamped))
+			}
+			genConfig.TopK = &k
+		}
+	}
+}
+
+// describeRange renders r as e.g. [0, 1] or [0, ∞)
+func describeRange(r *models.Range) string {
+	low, high := "(-∞", "∞)"
+	if r.Min != nil {
+		low = "[" + strconv.FormatFloat(*r.Min, 'g', -1, 64)
+	}
+	if r.Max != nil {
+		high = strconv.FormatFloat(*r.Max, 'g', -1, 64) + "]"
+	}
+	return low + ", " + high
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// Playground：管理员在 /ui/playground/ 直接试用模型，无需curl和API Key。
+// 请求使用管理员登录态认证，走与 /v1/chat/completions 完全相同的转换和账号轮换流程，
+// 并在响应头中返回实际使用的账号
+
+// playgroundClientKey attributes playground traffic in stream limits and captures
+const playgroundClientKey = "playground"
+
+// playgroundChat proxies a chat completion for the admin playground
+func (s *Server) playgroundChat(c *gin.Context) {
+	c.Set("playground", true)
+	c.Set("api_key_source", "playground")
+	c.Set("client_key", playgroundClientKey)
+	c.Set("request_user", "playground:"+c.GetString("admin_user"))
+	s.chatCompletions(c)
+}
@@ -1,283 +1,787 @@
 package server
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"math/rand"
 	"net/http"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/antigravity/api-proxy/internal/oauth"
+	"github.com/antigravity/api-proxy/internal/sse"
+	"github.com/antigravity/api-proxy/internal/upstream"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+// imageOutputSuffix is a model-name suffix that enables TEXT+IMAGE output
+const imageOutputSuffix = "-image-output"
+
+// Upstream defaults, overridden by the antigravity config section
 const (
-	googleAPIURL = "https://daily-cloudcode-pa.sandbox.googleapis.com/v1internal:streamGenerateContent?alt=sse"
-	googleHost   = "daily-cloudcode-pa.sandbox.googleapis.com"
-	userAgent    = "antigravity/1.11.3 windows/amd64"
+	defaultBaseURL     = "https://daily-cloudcode-pa.sandbox.googleapis.com"
+	defaultUserAgent   = "antigravity/1.11.3 windows/amd64"
+	streamGeneratePath = "/v1internal:streamGenerateContent?alt=sse"
 )
 
 // chatCompletions handles the chat completion request
 func (s *Server) chatCompletions(c *gin.Context) {
 	var req models.ChatCompletionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid request: " + err.Error()})
+		openAIError(c, 400, "invalid_request", "Invalid request: "+err.Error())
 		return
 	}
 
-	const maxRetries = 5
-	var lastErr error
+	// Attribute the request to the end user for request logs and usage records
+	if req.User != "" {
+		c.Set("request_user", req.User)
+	}
+	if len(req.Metadata) > 0 {
+		c.Set("request_metadata", req.Metadata)
+	}
 
-	// Retry loop for handling transient errors and account rotation
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		// Get a valid token
-		account, err := s.oauthClient.GetToken()
-		if err != nil {
-			s.logger.Error("Failed to get token",
-				zap.Int("attempt", attempt+1),
-				zap.Error(err))
-			lastErr = err
+	// Server-side conversations: the stored turns are prepended to the new messages
+	var sent []models.ChatCompletionMessage
+	if req.ConversationID != "" {
+		var ok bool
+		if sent, ok = s.beginConversation(c, &req); !ok {
+			return
+		}
+	}
 
-			// If no accounts are available, don't retry
-			if strings.Contains(err.Error(), "no valid accounts available") {
-				s.logger.Warn("No valid accounts available - stopping retry attempts")
-				break
-			}
+	timeout, err := s.requestTimeout(c, req.Timeout)
+	if err != nil {
+		apiError(c, 400, models.ErrorDetail{Message: err.Error(), Type: errTypeInvalidRequest, Param: "timeout", Code: "invalid_timeout"})
+		return
+	}
+	attempts, err := s.requestAttempts(c)
+	if err != nil {
+		apiError(c, 400, models.ErrorDetail{Message: err.Error(), Type: errTypeInvalidRequest, Param: maxRetriesHeader, Code: "invalid_max_retries"})
+		return
+	}
 
-			// Brief backoff before retry for transient errors
-			time.Sleep(time.Duration(attempt+1) * time.Second)
-			continue
+	// Aliases resolve to the upstream model; responses keep the requested name
+	model, fallbacks := s.route(req.Model)
+	if !keyAllowsModel(c, req.Model, model) {
+		apiError(c, 403, models.ErrorDetail{
+			Message: s.t(c, "model_not_allowed"),
+			Type:    errTypePermission,
+			Param:   "model",
+			Code:    "model_not_allowed",
+		})
+		return
+	}
+
+	// Unusable or oversized images fail here with a clear error instead of an
+	// opaque upstream 400 or a silently text-only request
+	contentErr := s.validateContent(&req, model)
+	if contentErr != nil && contentErr.code == "model_not_vision" && s.cfg != nil && s.cfg.Proxy.VisionModel != "" {
+		// 含图片的请求自动升级到配置的视觉模型，而不是直接拒绝
+		s.requestLogger(c).Info("Upgrading image request to the vision model",
+			zap.String("requested", req.Model),
+			zap.String("vision_model", s.cfg.Proxy.VisionModel))
+		model, fallbacks = s.route(s.cfg.Proxy.VisionModel)
+		contentErr = s.validateContent(&req, model)
+	}
+	if contentErr == nil {
+		contentErr = s.checkParameterBounds(&req, model)
+	}
+	if contentErr == nil {
+		contentErr = s.prepareImages(&req)
+	}
+	if contentErr != nil {
+		apiError(c, 400, models.ErrorDetail{
+			Message: s.t(c, contentErr.code),
+			Type:    errTypeInvalidRequest,
+			Param:   contentErr.param,
+			Code:    contentErr.code,
+			Details: contentErr.message,
+		})
+		return
+	}
+	if !checkResponseFormat(req.ResponseFormat) {
+		apiError(c, 400, models.ErrorDetail{
+			Message: s.t(c, "invalid_response_format"),
+			Type:    errTypeInvalidRequest,
+			Param:   "response_format",
+			Code:    "invalid_response_format",
+		})
+		return
+	}
+	s.restoreThoughtSignatures(c, &req)
+	s.truncateToolMessages(c, &req)
+	s.trimContext(c, &req, model)
+	structured := s.newStructuredOutput(&req)
+	if structured != nil {
+		c.Set(structuredOutputKey, structured)
+	}
+
+	// Identical non-streaming requests are answered from the cache
+	store, hit := s.cachedCompletion(c, &req, model)
+	if hit {
+		return
+	}
+	if store != nil {
+		defer store()
+	}
+
+	bestOf, scorer, err := s.bestOfOptions(&req)
+	if err != nil {
+		apiError(c, 400, models.ErrorDetail{Message: err.Error(), Type: errTypeInvalidRequest, Param: "best_of", Code: "invalid_best_of"})
+		return
+	}
+	if bestOf > 1 {
+		cancel := withDeadline(c, timeout)
+		defer cancel()
+		s.bestOfCompletion(c, &req, model, bestOf, scorer, timeout)
+		if req.ConversationID != "" {
+			s.finishConversation(c, req.ConversationID, sent)
 		}
+		return
+	}
 
-		s.logger.Info("Using account for request",
-			zap.String("account_id", account.AccountID),
-			zap.String("email", account.Email),
-			zap.Int("attempt", attempt+1),
-			zap.Int("max_retries", maxRetries))
+	// Non-streaming requests get the whole answer in one generateContent response
+	url := s.upstreamURL
+	generate := !req.Stream && s.generateNonStream()
+	if generate {
+		url = s.generateURL()
+	}
 
-		// Transform request to Google format
-		googleReq := s.transformRequest(&req)
+	pr := &proxyRequest{
+		model:           model,
+		fallbacks:       fallbacks,
+		stream:          req.Stream,
+		generate:        generate,
+		url:             url,
+		timeout:         timeout,
+		attempts:        attempts,
+		estimatedTokens: estimateRequestTokens(&req),
+		upstreamError: func(c *gin.Context, status int, body []byte) {
+			if status, detail, ok := translateGoogleError(status, body); ok {
+				apiError(c, status, detail)
+				return
+			}
+			apiError(c, status, models.ErrorDetail{
+				Message: "Upstream API error",
+				Type:    errTypeUpstream,
+				Code:    "upstream_error",
+				Details: string(body),
+			})
+		},
+		exhausted: func(c *gin.Context, status int, message, code string, lastErr error) {
+			// The last upstream error explains the failure better than a generic 503
+			var googleErr *googleAPIError
+			if errors.As(lastErr, &googleErr) {
+				if status, detail, ok := translateGoogleError(googleErr.status, googleErr.body); ok {
+					apiError(c, status, detail)
+					return
+				}
+			}
+			detail := models.ErrorDetail{Message: message, Type: errTypeUpstream, Code: code}
+			switch {
+			case status == 429:
+				detail.Type = errTypeRateLimit
+			case code == "internal_error" || code == "timeout":
+				detail.Type = errTypeServer
+			}
+			if lastErr != nil {
+				detail.Details = lastErr.Error()
+			}
+			apiError(c, status, detail)
+		},
+	}
+	pr.streamTimeout, pr.splitTimeout = s.streamTimeout(c, req.Timeout)
+	pr.body = func() ([]byte, error) {
+		// Transform request to Google format for the model currently tried
+		attemptReq := req
+		attemptReq.Model = pr.model
+		// A rejected structured output is sent back with its validation error
+		attemptReq.Messages = structured.repairMessages(req.Messages)
+		return json.Marshal(s.transformRequest(&attemptReq))
+	}
+	c.Set(promptUsageKey, &promptUsage{
+		estimate: estimatePromptTokens(&req),
+		countRequest: func() (string, json.RawMessage, error) {
+			attemptReq := req
+			attemptReq.Model = pr.model
+			contents, err := json.Marshal(s.transformRequest(&attemptReq).Request.Contents)
+			return pr.model, contents, err
+		},
+	})
+	pr.respond = func(c *gin.Context, body io.Reader, account *models.Account, canRetry bool) error {
+		model := pr.responseModel(req.Model)
+		if req.Stream {
+			s.handleStreamResponse(c, body, model, account, req.StreamOptions != nil && req.StreamOptions.IncludeUsage, req.ResponseFormat)
+			return nil
+		}
+		if generate {
+			return s.handleNormalResponse(c, &generateResponse{body: body}, model, account, canRetry)
+		}
+		// Aggregate the SSE stream
+		return s.handleNormalResponse(c, sse.NewEvents(body), model, account, canRetry)
+	}
+	s.proxyWithRetry(c, pr)
 
-		// Prepare HTTP request
-		reqBody, err := json.Marshal(googleReq)
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to marshal request"})
+	if req.ConversationID != "" {
+		s.finishConversation(c, req.ConversationID, sent)
+	}
+}
+
+// proxyRequest describes one client request forwarded through account rotation.
+// The OpenAI and native Gemini endpoints differ only in how the upstream body
+// is built and how responses and errors are written back.
+type proxyRequest struct {
+	model  string // Model currently tried (after alias resolution)
+	stream bool
+	url    string // upstream endpoint
+	// generate is set for generateContent calls: upstream answers only once the
+	// whole response is generated, so waiting for headers is the generation itself
+	generate bool
+	// timeout bounds the whole request, retries and streaming included (0 = none)
+	timeout time.Duration
+	// streamTimeout replaces timeout once upstream starts answering (0 = none);
+	// splitTimeout is set when it applies, i.e. timeout is the config default
+	streamTimeout time.Duration
+	splitTimeout  bool
+	// restartDeadline moves the request deadline (set by proxyWithRetry)
+	restartDeadline func(time.Duration)
+	// attempts is the number of upstream attempts per model (0 = the default)
+	attempts int
+
+	// fallbacks are tried in order once all attempts for model are exhausted
+	fallbacks []string
+	// fellBack is set once a fallback model replaced the requested one
+	fellBack bool
+
+	// estimatedTokens steers account selection towards accounts with enough quota
+	estimatedTokens int64
+	// capture records this request for debugging (nil when not armed)
+	capture *capture
+	// lastUpstream is the most recent error response from Google; it outlives
+	// account errors (cooldown) so the client still learns why upstream failed
+	lastUpstream *googleAPIError
+	// attempted holds the accounts already tried for pr.model; retries prefer others
+	attempted map[string]bool
+	// queueDeadline ends the time this request may wait for a cooldown (set on first wait)
+	queueDeadline time.Time
+
+	// body builds the upstream request body for pr.model; called once per attempt
+	body func() ([]byte, error)
+	// respond writes a successful upstream response to the client. It may
+	// instead return errIncompleteResponse (or errSchemaMismatch) without writing
+	// anything when the upstream stream broke off (or the output failed schema
+	// validation) and canRetry allows another attempt.
+	respond func(c *gin.Context, body io.Reader, account *models.Account, canRetry bool) error
+	// upstreamError writes a non-retryable upstream error
+	upstreamError func(c *gin.Context, status int, body []byte)
+	// exhausted writes the final error once all retries failed
+	exhausted func(c *gin.Context, status int, message, code string, lastErr error)
+}
+
+// responseModel is the model name reported to the client: the requested name
+// (aliases stay hidden) unless a fallback model served the request
+func (pr *proxyRequest) responseModel(requested string) string {
+	if pr.fellBack {
+		return pr.model
+	}
+	return requested
+}
+
+// responseStarted switches a request running on the default budget over to
+// the stream timeout, so a long answer is not cut by the request timeout
+func (pr *proxyRequest) responseStarted() {
+	if !pr.splitTimeout || pr.restartDeadline == nil {
+		return
+	}
+	pr.splitTimeout = false
+	pr.timeout = pr.streamTimeout
+	pr.restartDeadline(pr.streamTimeout)
+}
+
+// proxyWithRetry runs attempts until one succeeds, the client goes away or
+// retries are exhausted
+func (s *Server) proxyWithRetry(c *gin.Context, pr *proxyRequest) {
+	maxRetries := pr.attempts
+	if maxRetries <= 0 {
+		maxRetries = defaultMaxRetries + 1
+	}
+	var lastErr error
+
+	// 限制每个Key同时进行的流式连接数，保护共享服务器的内存
+	if pr.stream && s.cfg != nil {
+		key := clientKey(c)
+		if !s.streams.acquire(key, s.cfg.Proxy.MaxStreamsPerKey) {
+			s.requestLogger(c).Warn("Concurrent stream limit reached",
+				zap.String("key_prefix", maskAPIKey(key)),
+				zap.Int("limit", s.cfg.Proxy.MaxStreamsPerKey))
+			pr.exhausted(c, 429, "Too many concurrent streaming requests for this API key.", "too_many_streams", nil)
 			return
 		}
+		defer s.streams.release(key)
+	}
 
-		// Debug log
-		s.logger.Debug("Sending request to Google",
-			zap.String("account_id", account.AccountID),
-			zap.String("email", account.Email),
-			zap.Int("body_length", len(reqBody)))
+	cancel, restart := withMovableDeadline(c, pr.timeout)
+	defer cancel()
+	pr.restartDeadline = restart
 
-		httpReq, err := http.NewRequest("POST", googleAPIURL, bytes.NewReader(reqBody))
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to create request"})
+	// 管理员开启抓包时记录上游和客户端的完整数据流
+	if pr.capture = s.captures.begin(c, pr); pr.capture != nil {
+		defer s.captures.finish(pr.capture)
+	}
+
+	// The requested model first, then its configured fallbacks in order
+	for {
+		result := s.retryModel(c, pr, maxRetries)
+		if timedOut(c) {
+			// The budget ran out before anything was written (streams report it themselves)
+			if !c.Writer.Written() {
+				s.requestLogger(c).Warn("Request timed out", zap.String("model", pr.model), zap.Duration("timeout", pr.timeout))
+				pr.exhausted(c, 504, timeoutMessage(pr.timeout), "timeout", nil)
+			}
 			return
 		}
+		if result.outcome == attemptDone {
+			return
+		}
+		lastErr = result.err
+		// Fallbacks don't help when no account is usable at all
+		if result.outcome == attemptAbort || len(pr.fallbacks) == 0 {
+			break
+		}
 
-		httpReq.Header.Set("Host", googleHost)
-		httpReq.Header.Set("User-Agent", userAgent)
-		httpReq.Header.Set("Authorization", "Bearer "+account.AccessToken)
-		httpReq.Header.Set("Content-Type", "application/json")
-		httpReq.Header.Set("Accept-Encoding", "gzip")
+		s.requestLogger(c).Warn("Falling back to next model",
+			zap.String("from", pr.model),
+			zap.String("to", pr.fallbacks[0]),
+			zap.Error(lastErr))
+		pr.model, pr.fallbacks = pr.fallbacks[0], pr.fallbacks[1:]
+		pr.fellBack = true
+		// The failure was the model's, so every account is fair game again
+		pr.attempted = nil
+	}
 
-		// Send request with optimized client configuration
-		client := &http.Client{
-			Timeout: 120 * time.Second,
-			Transport: &http.Transport{
-				MaxIdleConns:        100,
-				MaxIdleConnsPerHost: 10,
-				IdleConnTimeout:     90 * time.Second,
-			},
+	// All retries exhausted
+	s.requestLogger(c).Error("All retry attempts exhausted",
+		zap.Int("attempts", maxRetries),
+		zap.Error(lastErr))
+
+	// Provide detailed error response based on error type
+	if errors.Is(lastErr, oauth.ErrNoAccounts) {
+		pr.exhausted(c, 503, "No Google accounts are configured. Log in an account from the admin dashboard (Token 管理) or run `antigravity --login`.",
+			"no_accounts_configured", nil)
+		return
+	}
+	if pr.lastUpstream != nil {
+		pr.exhausted(c, 503, "Service temporarily unavailable. All retry attempts failed.", "service_unavailable", pr.lastUpstream)
+		return
+	}
+	if lastErr != nil && strings.Contains(lastErr.Error(), "no valid accounts available") {
+		// Use 429 to indicate rate limiting
+		pr.exhausted(c, 429, "All accounts are currently unavailable. They may be rate-limited or in cooldown. Please try again later.",
+			"no_accounts_available", lastErr)
+		return
+	}
+	pr.exhausted(c, 503, "Service temporarily unavailable. All retry attempts failed.", "service_unavailable", lastErr)
+}
+
+// retryModel runs up to maxRetries attempts for pr.model. attemptRetry or
+// attemptFallback in the result means the model failed and the next fallback
+// (if any) should be tried.
+func (s *Server) retryModel(c *gin.Context, pr *proxyRequest, maxRetries int) attemptResult {
+	// 客户端断开时取消上游请求并停止重试
+	ctx := c.Request.Context()
+
+	var result attemptResult
+	// Retry loop for handling transient errors and account rotation
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		result = s.runAttempt(c, pr, attempt, maxRetries)
+		if result.outcome != attemptRetry {
+			return result
 		}
-		resp, err := client.Do(httpReq)
-		if err != nil {
-			s.logger.Warn("Upstream API request failed",
-				zap.String("account_id", account.AccountID),
-				zap.String("email", account.Email),
-				zap.Int("attempt", attempt+1),
-				zap.String("error_type", fmt.Sprintf("%T", err)),
-				zap.Error(err))
 
-			// Record failure with detailed error message
-			errMsg := fmt.Sprintf("request failed: %v", err)
-			account.RecordFailure(errMsg)
-			s.oauthClient.AccountStore().Save(account)
-			lastErr = fmt.Errorf("upstream error: %w", err)
+		if result.backoff > 0 && !sleepCtx(ctx, result.backoff) {
+			return attemptResult{outcome: attemptDone}
+		}
+	}
+	return result
+}
 
-			// Brief exponential backoff before retry
-			if attempt < maxRetries-1 {
-				backoff := time.Duration(attempt+1) * time.Second
-				time.Sleep(backoff)
-			}
-			continue // Retry with next account
-		}
-		defer resp.Body.Close()
-
-		// Handle non-200 responses
-		if resp.StatusCode != 200 {
-			body, _ := io.ReadAll(resp.Body)
-
-			// Special handling for 429 Rate Limit
-			if resp.StatusCode == 429 {
-				// Parse Retry-After header (seconds or HTTP date)
-				cooldown := int64(10) // Default 10 seconds
-				if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
-					// Try parsing as seconds first
-					if seconds, err := strconv.ParseInt(retryAfter, 10, 64); err == nil && seconds > 0 {
-						cooldown = seconds
-					} else {
-						// Try parsing as HTTP date
-						if retryTime, err := time.Parse(time.RFC1123, retryAfter); err == nil {
-							if duration := time.Until(retryTime).Seconds(); duration > 0 {
-								cooldown = int64(duration)
-							}
-						}
-					}
-				}
+// attemptOutcome tells the retry loop what to do after one attempt
+type attemptOutcome int
 
-				s.logger.Warn("Rate limit encountered",
-					zap.String("account_id", account.AccountID),
-					zap.String("email", account.Email),
-					zap.Int("attempt", attempt+1),
-					zap.Int("rate_limit_count", account.ErrorTracking.RateLimitCount+1),
-					zap.Int64("cooldown_seconds", cooldown))
-				account.RecordRateLimit(cooldown)
-				s.oauthClient.AccountStore().Save(account)
-				lastErr = fmt.Errorf("rate limit exceeded")
-				continue // Try next account immediately
-			}
+const (
+	attemptRetry    attemptOutcome = iota // try again, possibly with another account
+	attemptAbort                          // stop retrying and report lastErr
+	attemptDone                           // a response was written (or the client went away)
+	attemptFallback                       // the model failed; skip to the next fallback model
+)
 
-			// Special handling for 403 Permission Denied
-			if resp.StatusCode == 403 {
-				s.logger.Warn("Permission denied - disabling account",
-					zap.String("account_id", account.AccountID),
-					zap.String("email", account.Email),
-					zap.String("error", string(body)))
-				account.RecordPermissionDenied()
-				s.oauthClient.AccountStore().Save(account)
-				lastErr = fmt.Errorf("permission denied")
-				continue // Try next account immediately
+// attemptResult is returned by runAttempt
+type attemptResult struct {
+	outcome attemptOutcome
+	err     error
+	backoff time.Duration // wait before the next attempt
+}
+
+// runAttempt performs a single upstream attempt.
+// Everything opened here (attempt context, response body) is released before
+// it returns, so nothing leaks across retries.
+// retryableStatus reports whether an upstream error status is worth another
+// account: 5xx and the retryable 4xx codes (400, 402, 408). The client only
+// sees such an error once retries are exhausted.
+func retryableStatus(status int) bool {
+	return status >= 500 || status == 400 || status == 402 || status == 408
+}
+
+// penalizeAccount records a non-200 upstream answer on its account: 429 cools
+// it down, 403 disables it and other errors count as failures. blameModel
+// (fallback models remain) attributes 429, 403 and retryable errors to the
+// model instead, leaving the account usable for the fallbacks.
+func (s *Server) penalizeAccount(log *zap.Logger, account *models.Account, status int, header http.Header, body []byte, blameModel bool) {
+	switch {
+	case status == 429:
+		if err := s.usageStore.RecordRateLimit(account.AccountID); err != nil {
+			log.Warn("Failed to record rate limit", zap.Error(err))
+		}
+		// 还有降级模型时，配额耗尽归因于当前模型：不冷却账号（其他模型仍可用），换账号重试，
+		// 所有账号都失败后改用下一个模型
+		if blameModel {
+			return
+		}
+		var rateLimitCount int
+		var cooldown int64
+		var source string
+		s.updateAccount(account, func(account *models.Account) {
+			rateLimitCount = 1
+			if account.ErrorTracking != nil {
+				rateLimitCount = account.ErrorTracking.RateLimitCount + 1
 			}
+			// Google 给出的等待时间（Retry-After 或 RetryInfo）优先，否则按连续限流次数退避
+			cooldown, source = rateLimitCooldown(header, body, rateLimitCount)
+			account.RecordRateLimit(cooldown)
+		})
+		log.Warn("Rate limit encountered",
+			zap.String("account_id", s.displayAccountID(account.AccountID)),
+			zap.String("email", s.displayEmail(account.Email)),
+			zap.Int("rate_limit_count", rateLimitCount),
+			zap.Int64("cooldown_seconds", cooldown),
+			zap.String("cooldown_source", source))
+
+	case status == 403:
+		if blameModel {
+			return
+		}
+		log.Warn("Permission denied - disabling account",
+			zap.String("account_id", s.displayAccountID(account.AccountID)),
+			zap.String("email", s.displayEmail(account.Email)),
+			zap.String("error", string(body)))
+		s.updateAccount(account, (*models.Account).RecordPermissionDenied)
+		s.notifyStore.Add(models.NotifyAccountDisabled, s.displayAccountID(account.AccountID),
+			fmt.Sprintf("Account %s was disabled after HTTP 403 (permission denied)", s.displayEmail(account.Email)))
+
+	default:
+		if blameModel && retryableStatus(status) {
+			return
+		}
+		s.recordFailure(account, fmt.Sprintf("HTTP %d: %s", status, string(body)))
+	}
+}
 
-			// Other errors
-			s.logger.Warn("Google API returned error",
-				zap.String("account_id", account.AccountID),
-				zap.String("email", account.Email),
-				zap.Int("status", resp.StatusCode),
-				zap.String("body", string(body)),
-				zap.Int("attempt", attempt+1))
+// updateAccount applies change to the request's copy of account and to the
+// stored account. The stored one is re-read first: the copy was loaded when
+// the request started, and saving it back would undo a token refreshed in the
+// background since then.
+func (s *Server) updateAccount(account *models.Account, change func(account *models.Account)) {
+	change(account)
+	if _, err := s.oauthClient.AccountStore().Update(account.AccountID, change); err != nil {
+		s.logger.Warn("Failed to update account", zap.String("account_id", s.displayAccountID(account.AccountID)), zap.Error(err))
+	}
+}
+
+// recordFailure records a failed request on account (see models.Account.RecordFailure)
+func (s *Server) recordFailure(account *models.Account, message string) {
+	s.updateAccount(account, func(account *models.Account) { account.RecordFailure(message) })
+}
 
-			account.RecordFailure(fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(body)))
-			s.oauthClient.AccountStore().Save(account)
+func (s *Server) runAttempt(c *gin.Context, pr *proxyRequest, attempt, maxRetries int) attemptResult {
+	// Get a valid token (and a concurrency slot of its account)
+	account, release, err := s.acquireAccount(c, pr)
+	if err == nil {
+		defer release()
+	}
+	if c.Request.Context().Err() != nil {
+		// The client went away or the budget ran out, possibly while waiting for a slot
+		return attemptResult{outcome: attemptDone, err: err}
+	}
+	if errors.Is(err, oauth.ErrNoAccounts) {
+		// Nothing to rotate through until someone logs in
+		return attemptResult{outcome: attemptAbort, err: err}
+	}
+	if err != nil {
+		s.requestLogger(c).Error("Failed to get token",
+			zap.Int("attempt", attempt+1),
+			zap.Error(err))
 
-			// New: treat 400, 402, 408 as retryable errors
-			if resp.StatusCode == 400 || resp.StatusCode == 402 || resp.StatusCode == 408 {
-				c.JSON(resp.StatusCode, gin.H{"error": "Upstream API error", "details": string(body)})
-				lastErr = fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
-				continue // retry
+		// If no accounts are available, don't retry
+		if strings.Contains(err.Error(), "no valid accounts available") {
+			// 所有账号都在冷却时排队等待，而不是直接失败；等待不计入重试次数
+			if s.waitForCooldown(c, pr) {
+				return s.runAttempt(c, pr, attempt, maxRetries)
 			}
+			if c.Request.Context().Err() != nil {
+				return attemptResult{outcome: attemptDone}
+			}
+			s.requestLogger(c).Warn("No valid accounts available - stopping retry attempts")
+			s.notifyStore.Add(models.NotifyPoolExhausted, "", "No usable accounts: all are disabled, cooling down or failed to refresh")
+			return attemptResult{outcome: attemptAbort, err: err}
+		}
 
-			// Other errors (including 5xx)
-			c.JSON(resp.StatusCode, gin.H{"error": "Upstream API error", "details": string(body)})
-			lastErr = fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+		// Brief backoff before retry for transient errors
+		return attemptResult{outcome: attemptRetry, err: err, backoff: time.Duration(attempt+1) * time.Second}
+	}
 
-			// For 4xx errors (other than 429, 400, 402, 408), don't retry
-			if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != 429 && resp.StatusCode != 400 && resp.StatusCode != 402 && resp.StatusCode != 408 {
-				return
-			}
+	// Every usable account already failed this model: move on to the next
+	// fallback instead of retrying the same accounts
+	if pr.attempted[account.AccountID] && len(pr.fallbacks) > 0 {
+		return attemptResult{outcome: attemptFallback, err: fmt.Errorf("every available account failed model %s", pr.model)}
+	}
 
-			continue // Retry for 5xx errors and the new retryable 4xx codes
+	if s.cfg == nil || !s.cfg.Proxy.RetrySameAccount {
+		if pr.attempted == nil {
+			pr.attempted = make(map[string]bool)
 		}
+		pr.attempted[account.AccountID] = true
+	}
 
-		// Success! Record and process response
-		s.logger.Info("Request successful",
-			zap.String("account_id", account.AccountID),
-			zap.String("email", account.Email),
-			zap.Int("attempt", attempt+1))
+	s.requestLogger(c).Info("Using account for request",
+		zap.String("account_id", s.displayAccountID(account.AccountID)),
+		zap.String("email", s.displayEmail(account.Email)),
+		zap.Int("attempt", attempt+1),
+		zap.Int("max_retries", maxRetries),
+		zap.String("user", c.GetString("request_user")),
+		zap.Any("metadata", c.Value("request_metadata")))
+
+	// Prepare HTTP request
+	reqBody, err := pr.body()
+	if err != nil {
+		pr.exhausted(c, 500, "Failed to build the upstream request.", "internal_error", err)
+		return attemptResult{outcome: attemptDone}
+	}
 
-		account.RecordSuccess()
-		s.oauthClient.AccountStore().Save(account)
+	// Debug log
+	s.requestLogger(c).Debug("Sending request to Google",
+		zap.String("account_id", s.displayAccountID(account.AccountID)),
+		zap.String("email", s.displayEmail(account.Email)),
+		zap.Int("body_length", len(reqBody)))
 
-		// Handle streaming response
-		if req.Stream {
-			s.handleStreamResponse(c, resp.Body, req.Model, account)
-			return
+	// Attempt-scoped context: cancelled when this attempt ends, and with the client request
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	pr.capture.attempt(s.displayAccountID(account.AccountID), reqBody)
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", pr.url, bytes.NewReader(reqBody))
+	if err != nil {
+		pr.exhausted(c, 500, "Failed to create the upstream request.", "internal_error", err)
+		return attemptResult{outcome: attemptDone}
+	}
+
+	s.setUpstreamHeaders(httpReq, account)
+	httpReq.Header.Set("Accept-Encoding", "gzip")
+
+	// generateContent starts generating as soon as it is sent
+	if pr.generate {
+		pr.responseStarted()
+	}
+
+	// With hedging a second account may answer instead; the rest of the attempt uses the winner
+	resp, account, releaseHedge, err := s.sendHedged(c, pr, ctx, cancel, account, httpReq, reqBody)
+	defer releaseHedge()
+	if err != nil {
+		pr.capture.attemptError(err)
+
+		// The client went away; don't penalize the account for our own cancellation
+		if c.Request.Context().Err() != nil {
+			s.requestLogger(c).Info("Client cancelled request",
+				zap.String("account_id", s.displayAccountID(account.AccountID)),
+				zap.Int("attempt", attempt+1))
+			return attemptResult{outcome: attemptDone}
 		}
 
-		// Handle normal response (aggregate SSE)
-		s.handleNormalResponse(c, resp.Body, req.Model, account)
-		return
+		s.requestLogger(c).Warn("Upstream API request failed",
+			zap.String("account_id", s.displayAccountID(account.AccountID)),
+			zap.String("email", s.displayEmail(account.Email)),
+			zap.Int("attempt", attempt+1),
+			zap.String("error_type", fmt.Sprintf("%T", err)),
+			zap.Error(err))
+
+		// Record failure with detailed error message
+		s.recordFailure(account, fmt.Sprintf("request failed: %v", err))
+
+		// Brief exponential backoff before retry
+		result := attemptResult{outcome: attemptRetry, err: fmt.Errorf("upstream error: %w", err)}
+		if attempt < maxRetries-1 {
+			result.backoff = time.Duration(attempt+1) * time.Second
+		}
+		return result // Retry with next account
 	}
+	defer resp.Body.Close()
+	respBody := pr.capture.upstreamBody(resp.StatusCode, resp.Body)
+
+	// Handle non-200 responses
+	if resp.StatusCode != 200 {
+		// Error bodies are logged, stored on the account and returned to the client
+		body, _ := io.ReadAll(respBody)
+		body = sanitizeUpstreamError(body)
+
+		// Cooldown, disabling or a failure record, shared with hedged requests that lost the race
+		s.penalizeAccount(s.requestLogger(c), account, resp.StatusCode, resp.Header, body, len(pr.fallbacks) > 0)
+
+		// Special handling for 429 Rate Limit
+		if resp.StatusCode == 429 {
+			if len(pr.fallbacks) > 0 {
+				s.requestLogger(c).Warn("Model quota exhausted on account",
+					zap.String("account_id", s.displayAccountID(account.AccountID)),
+					zap.String("model", pr.model),
+					zap.Int("attempt", attempt+1))
+				return attemptResult{outcome: attemptRetry, err: fmt.Errorf("rate limit exceeded for model %s", pr.model)}
+			}
+			return attemptResult{outcome: attemptRetry, err: fmt.Errorf("rate limit exceeded")} // Try next account immediately
+		}
 
-	// All retries exhausted
-	s.logger.Error("All retry attempts exhausted",
-		zap.Int("attempts", maxRetries),
-		zap.Error(lastErr))
+		// Special handling for 403 Permission Denied
+		if resp.StatusCode == 403 && len(pr.fallbacks) > 0 {
+			// The account may only lack access to this model; keep it enabled for the fallbacks
+			s.requestLogger(c).Warn("Permission denied for model",
+				zap.String("account_id", s.displayAccountID(account.AccountID)),
+				zap.String("model", pr.model),
+				zap.String("error", string(body)))
+			return attemptResult{outcome: attemptRetry, err: fmt.Errorf("permission denied for model %s", pr.model)}
+		}
+		if resp.StatusCode == 403 {
+			return attemptResult{outcome: attemptRetry, err: fmt.Errorf("permission denied")} // Try next account immediately
+		}
 
-	// Provide detailed error response based on error type
-	var errorMessage, errorCode string
-	statusCode := 503
+		// Other errors
+		s.requestLogger(c).Warn("Google API returned error",
+			zap.String("account_id", s.displayAccountID(account.AccountID)),
+			zap.String("email", s.displayEmail(account.Email)),
+			zap.Int("status", resp.StatusCode),
+			zap.String("body", string(body)),
+			zap.Int("attempt", attempt+1))
 
-	if lastErr != nil && strings.Contains(lastErr.Error(), "no valid accounts available") {
-		errorMessage = "All accounts are currently unavailable. They may be rate-limited or in cooldown. Please try again later."
-		errorCode = "no_accounts_available"
-		statusCode = 429 // Use 429 to indicate rate limiting
-	} else {
-		errorMessage = "Service temporarily unavailable. All retry attempts failed."
-		errorCode = "service_unavailable"
-	}
-
-	errorResponse := gin.H{
-		"error": gin.H{
-			"message": errorMessage,
-			"type":    "upstream_error",
-			"code":    errorCode,
-		},
+		upstreamErr := &googleAPIError{status: resp.StatusCode, body: body}
+		pr.lastUpstream = upstreamErr
+
+		// 还有降级模型时错误不记在账号上（见 penalizeAccount），但仍先换其他账号重试：
+		// 只有所有账号都对该模型失败或重试次数用尽后才换下一个模型
+		if retryableStatus(resp.StatusCode) {
+			return attemptResult{outcome: attemptRetry, err: upstreamErr}
+		}
+
+		// Other 4xx errors are not retryable
+		pr.upstreamError(c, resp.StatusCode, body)
+		return attemptResult{outcome: attemptDone, err: upstreamErr}
 	}
 
-	if lastErr != nil {
-		errorResponse["error"].(gin.H)["details"] = lastErr.Error()
+	// 流式请求在上游发出第一个事件前失败时换账号重试，此时尚未向客户端写出任何内容
+	if window := s.streamFirstEventWindow(); pr.stream && window > 0 && attempt < maxRetries-1 {
+		primed, err := primeStream(respBody, window)
+		if err != nil {
+			if c.Request.Context().Err() != nil {
+				return attemptResult{outcome: attemptDone}
+			}
+			s.requestLogger(c).Warn("Upstream stream failed before the first event, retrying",
+				zap.String("account_id", s.displayAccountID(account.AccountID)),
+				zap.Int("attempt", attempt+1),
+				zap.Error(err))
+			s.recordFailure(account, err.Error())
+			return attemptResult{outcome: attemptRetry, err: err}
+		}
+		respBody = primed
 	}
 
-	c.JSON(statusCode, errorResponse)
+	// Success! Record and process response
+	s.requestLogger(c).Info("Request successful",
+		zap.String("account_id", s.displayAccountID(account.AccountID)),
+		zap.String("email", s.displayEmail(account.Email)),
+		zap.Int("attempt", attempt+1))
+
+	s.oauthClient.AccountStore().RecordSuccess(account)
+	pr.responseStarted()
+
+	// Aliases and fallbacks can change the model; tell the client which one answered
+	c.Header("X-Served-Model", pr.model)
+
+	// The playground shows which account served the request
+	if c.GetBool("playground") {
+		c.Header("X-Playground-Account", s.displayEmail(account.Email))
+	}
+	if err := pr.respond(c, respBody, account, attempt < maxRetries-1); err != nil {
+		if c.Request.Context().Err() != nil {
+			return attemptResult{outcome: attemptDone}
+		}
+		message := "Upstream response broke off, retrying"
+		if errors.Is(err, errSchemaMismatch) {
+			message = "Response did not match the JSON schema, retrying with a repair prompt"
+		}
+		s.requestLogger(c).Warn(message,
+			zap.String("account_id", s.displayAccountID(account.AccountID)),
+			zap.Int("attempt", attempt+1),
+			zap.Error(err))
+		return attemptResult{outcome: attemptRetry, err: err}
+	}
+	return attemptResult{outcome: attemptDone}
 }
 
 func (s *Server) transformRequest(req *models.ChatCompletionRequest) *models.GoogleRequest {
-	// Determine model name and thinking config
+	// Determine model name; "-thinking" forces thinking on for any model
 	modelName := req.Model
-	enableThinking := strings.HasSuffix(modelName, "-thinking") ||
-		modelName == "gemini-2.5-pro" ||
-		strings.HasPrefix(modelName, "gemini-3-pro-")
+	forceThinking := strings.HasSuffix(modelName, "-thinking")
 
 	// Remove -thinking suffix if present
 	modelName = strings.TrimSuffix(modelName, "-thinking")
 
+	// "-image-output" suffix asks for interleaved text+image output
+	imageOutput := strings.HasSuffix(modelName, imageOutputSuffix)
+	modelName = strings.TrimSuffix(modelName, imageOutputSuffix)
+
+	// Translation behavior follows the model's capabilities (fetched metadata, else name defaults)
+	caps := s.modelCapabilities(modelName)
+	enableThinking := forceThinking || caps.Thinking
+
 	// Build contents
 	var contents []models.GoogleContent
 	var systemInstruction *models.GoogleSystemInstruction
 
+	developerAsUser := s.cfg != nil && s.cfg.Proxy.DeveloperRole == "user"
+
 	for _, msg := range req.Messages {
-		if msg.Role == "system" {
-			// Handle system message
-			text := ""
-			if str, ok := msg.Content.(string); ok {
-				text = str
-			}
-			systemInstruction = &models.GoogleSystemInstruction{
-				Role:  "user", // Google system instruction uses 'user' role internally sometimes, or specific field
-				Parts: []models.GooglePart{{Text: text}},
+		// "developer" is OpenAI's newer name for system instructions
+		isDeveloper := msg.Role == "developer"
+		if msg.Role == "system" || (isDeveloper && !developerAsUser) {
+			// Handle system message; multiple system/developer messages are kept in order
+			if systemInstruction == nil {
+				systemInstruction = &models.GoogleSystemInstruction{
+					Role: "user", // Google system instruction uses 'user' role internally sometimes, or specific field
+				}
 			}
+			systemInstruction.Parts = append(systemInstruction.Parts, models.GooglePart{Text: messageText(msg.Content)})
+			continue
+		}
+		if isDeveloper {
+			// Configured to forward developer messages as user turns with a marker prefix
+			contents = append(contents, models.GoogleContent{
+				Role:  "user",
+				Parts: []models.GooglePart{{Text: "[Developer instructions]\n" + messageText(msg.Content)}},
+			})
 			continue
 		}
 
@@ -290,29 +794,12 @@ func (s *Server) transformRequest(req *models.ChatCompletionRequest) *models.Goo
 		case []interface{}:
 			for _, item := range v {
 				if partMap, ok := item.(map[string]interface{}); ok {
-					if partMap["type"] == "text" {
-						if text, ok := partMap["text"].(string); ok {
-							parts = append(parts, models.GooglePart{Text: text})
-						}
-					} else if partMap["type"] == "image_url" {
-						// Handle image (simplified for now, assumes base64 in url)
-						if imgURL, ok := partMap["image_url"].(map[string]interface{}); ok {
-							if url, ok := imgURL["url"].(string); ok {
-								// Extract base64
-								if strings.HasPrefix(url, "data:image/") {
-									partsStr := strings.Split(url, ";base64,")
-									if len(partsStr) == 2 {
-										mimeType := strings.TrimPrefix(partsStr[0], "data:")
-										parts = append(parts, models.GooglePart{
-											InlineData: &models.GoogleInlineData{
-												MimeType: mimeType,
-												Data:     partsStr[1],
-											},
-										})
-									}
-								}
-							}
+					if part, ok := convertContentPart(partMap); ok {
+						if !caps.Vision && isImagePart(part) {
+							s.logger.Debug("Dropping image part for model without vision", zap.String("model", modelName))
+							continue
 						}
+						parts = append(parts, part)
 					}
 				}
 			}
@@ -325,6 +812,11 @@ func (s *Server) transformRequest(req *models.ChatCompletionRequest) *models.Goo
 		role := msg.Role
 		if role == "assistant" {
 			role = "model"
+			// Echoed reasoning is not part of the answer; a turn that held only reasoning is dropped
+			hadParts := len(parts) > 0
+			if parts = assistantParts(msg, parts); hadParts && len(parts) == 0 {
+				continue
+			}
 		}
 
 		contents = append(contents, models.GoogleContent{
@@ -333,32 +825,33 @@ func (s *Server) transformRequest(req *models.ChatCompletionRequest) *models.Goo
 		})
 	}
 
+	// Gemini expects alternating user/model turns
+	contents = mergeConsecutiveRoles(contents)
+
 	// Build generation config
 	genConfig := models.GoogleGenerationConfig{
 		CandidateCount: 1,
-		StopSequences: []string{
-			"<|user|>", "<|bot|>", "<|context_request|>", "<|endoftext|>", "<|end_of_turn|>",
-		},
+		StopSequences:  s.stopSequences(req),
 	}
 
-	if req.Temperature != 0 {
-		genConfig.Temperature = &req.Temperature
-	}
-	if req.TopP != 0 {
-		genConfig.TopP = &req.TopP
-	}
-	if req.TopK != 0 {
-		genConfig.TopK = &req.TopK
-	}
-	if req.MaxTokens != 0 {
-		genConfig.MaxOutputTokens = &req.MaxTokens
+	genConfig.Temperature = req.Temperature
+	genConfig.TopP = req.TopP
+	genConfig.TopK = req.TopK
+	s.clampParameters(modelName, &genConfig)
+	genConfig.MaxOutputTokens = req.MaxTokens
+
+	// Output modalities: OpenAI "modalities" field or model suffix
+	// (extra_body/google responseModalities, applied later, take precedence)
+	if modalities := responseModalities(req.Modalities); len(modalities) > 0 {
+		genConfig.ResponseModalities = modalities
+	} else if imageOutput {
+		genConfig.ResponseModalities = []string{"TEXT", "IMAGE"}
 	}
+	applyResponseFormat(req.ResponseFormat, &genConfig)
 
 	if enableThinking {
-		// Determine if this is a Gemini 3+ model (uses thinkingLevel) or Gemini 2.5 (uses thinkingBudget)
-		isGemini3Plus := strings.HasPrefix(modelName, "gemini-3-")
-
-		if isGemini3Plus {
+		// Gemini 3+ models use thinkingLevel, Gemini 2.5 and earlier use thinkingBudget
+		if caps.ThinkingStyle == models.ThinkingStyleLevel {
 			// Gemini 3+ uses thinkingLevel parameter
 			genConfig.ThinkingConfig = &models.GoogleThinkingConfig{
 				IncludeThoughts: true,
@@ -369,7 +862,10 @@ func (s *Server) transformRequest(req *models.ChatCompletionRequest) *models.Goo
 				zap.String("level", "high"))
 		} else {
 			// Gemini 2.5 and earlier use thinkingBudget parameter
-			budget := 8192
+			budget := caps.ThinkingBudget
+			if budget <= 0 {
+				budget = 8192
+			}
 			genConfig.ThinkingConfig = &models.GoogleThinkingConfig{
 				IncludeThoughts: true,
 				ThinkingBudget:  &budget,
@@ -378,6 +874,9 @@ func (s *Server) transformRequest(req *models.ChatCompletionRequest) *models.Goo
 			// Ensure MaxOutputTokens is greater than ThinkingBudget
 			// If user didn't set it, or set it too low, we override it
 			minMaxTokens := budget + 4096 // Buffer for actual response
+			if caps.MaxOutputTokens > 0 && minMaxTokens > caps.MaxOutputTokens {
+				minMaxTokens = caps.MaxOutputTokens
+			}
 			if genConfig.MaxOutputTokens == nil || *genConfig.MaxOutputTokens <= budget {
 				genConfig.MaxOutputTokens = &minMaxTokens
 			}
@@ -397,7 +896,9 @@ func (s *Server) transformRequest(req *models.ChatCompletionRequest) *models.Goo
 
 	// Build tools
 	var googleTools []models.GoogleTool
-	if len(req.Tools) > 0 {
+	if len(req.Tools) > 0 && !caps.Tools {
+		s.logger.Debug("Dropping tools for model without tool support", zap.String("model", modelName))
+	} else if len(req.Tools) > 0 {
 		funcs := []models.GoogleFunctionDeclaration{}
 		for _, t := range req.Tools {
 			if t.Type == "function" {
@@ -415,7 +916,12 @@ func (s *Server) transformRequest(req *models.ChatCompletionRequest) *models.Goo
 		}
 	}
 
-	return &models.GoogleRequest{
+	// Search grounding: requested via a web_search tool or enabled for all requests
+	if caps.Tools && (hasSearchTool(req.Tools) || (s.cfg != nil && s.cfg.Proxy.GoogleSearch)) {
+		googleTools = append(googleTools, models.GoogleTool{GoogleSearch: &models.GoogleSearch{}})
+	}
+
+	googleReq := &models.GoogleRequest{
 		Project:   generateProjectID(),
 		RequestID: "agent-" + uuid.New().String(),
 		Model:     modelName,
@@ -423,90 +929,163 @@ func (s *Server) transformRequest(req *models.ChatCompletionRequest) *models.Goo
 		Request: models.GoogleInner{
 			Contents:          contents,
 			GenerationConfig:  genConfig,
-			SessionID:         generateSessionID(),
+			SessionID:         sessionIDForRequest(req),
 			SystemInstruction: systemInstruction,
 			Tools:             googleTools,
 		},
 	}
-}
 
-func (s *Server) handleNormalResponse(c *gin.Context, body io.Reader, model string, account *models.Account) {
-	// Aggregate SSE response
-	scanner := bufio.NewScanner(body)
-	content := ""
-	reasoning := ""
-	var totalTokens, inputTokens, outputTokens int64
+	// Whitelisted provider-specific options (extra_body / google)
+	s.applyProviderOptions(req, &googleReq.Request)
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
+	return googleReq
+}
 
-		dataStr := strings.TrimPrefix(line, "data: ")
-		if dataStr == "[DONE]" {
-			break
-		}
+// doUpstream sends an attempt through the account's proxy, cancelling it when
+// upstream does not start responding within antigravity.timeout. The streamed
+// body is not limited, nor are generateContent calls, whose headers only come
+// with the finished answer.
+func (s *Server) doUpstream(ctx context.Context, cancel context.CancelFunc, pr *proxyRequest, account *models.Account, req *http.Request) (*http.Response, error) {
+	client, err := s.upstreamClient(account)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg == nil || s.cfg.Antigravity.Timeout <= 0 || pr.generate {
+		return upstream.Do(ctx, client, req)
+	}
+	timeout := s.cfg.Antigravity.Timeout
+	timer := time.AfterFunc(timeout, cancel)
+	resp, err := upstream.Do(ctx, client, req)
+	if !timer.Stop() && ctx.Err() != nil {
+		// The timer fired: the attempt's context is gone even if headers just arrived
+		upstream.DrainAndClose(resp)
+		return nil, fmt.Errorf("no response from upstream within %s: %w", timeout, ctx.Err())
+	}
+	return resp, err
+}
 
-		var googleResp models.GoogleResponse
-		if err := json.Unmarshal([]byte(dataStr), &googleResp); err != nil {
-			continue
-		}
+// upstreamClient returns the HTTP client for the account's outbound proxy. A
+// broken per-account proxy fails the call instead of falling back to the
+// global one, so the account is never seen from another address.
+func (s *Server) upstreamClient(account *models.Account) (*http.Client, error) {
+	client, err := upstream.ClientFor(account.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("account proxy: %w", err)
+	}
+	return client, nil
+}
 
-		if len(googleResp.Response.Candidates) > 0 {
-			candidate := googleResp.Response.Candidates[0]
-			for _, part := range candidate.Content.Parts {
-				if part.Text != "" {
-					if part.Thought {
-						reasoning += part.Text
-					} else {
-						content += part.Text
-					}
-				}
-			}
-		}
+// setUpstreamHeaders sets the headers every Cloud Code request carries
+func (s *Server) setUpstreamHeaders(req *http.Request, account *models.Account) {
+	req.Header.Set("User-Agent", s.userAgent)
+	req.Header.Set("Authorization", "Bearer "+account.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	upstream.ApplyHeaders(req.Header, account.Headers)
+}
 
-		// Track usage metadata
-		if googleResp.Response.UsageMetadata != nil {
-			inputTokens = int64(googleResp.Response.UsageMetadata.PromptTokenCount)
-			outputTokens = int64(googleResp.Response.UsageMetadata.CandidatesTokenCount)
-			totalTokens = int64(googleResp.Response.UsageMetadata.TotalTokenCount)
-		}
+// errIncompleteResponse means the upstream stream ended with a read error
+// before the response was complete
+var errIncompleteResponse = errors.New("upstream response ended before completion")
+
+// sseLineTooLongError reports a response cut off by an oversized upstream event
+func sseLineTooLongError(c *gin.Context) models.ErrorDetail {
+	return models.ErrorDetail{
+		Message:   fmt.Sprintf("The upstream response contained an event larger than %d MB and could not be read completely.", sse.MaxLineSize>>20),
+		Type:      errTypeUpstream,
+		Code:      "upstream_event_too_large",
+		RequestID: requestID(c),
 	}
+}
 
-	// Record usage in account
-	if account.Usage != nil {
-		account.Usage.TotalTokens += totalTokens
-		account.Usage.InputTokens += inputTokens
-		account.Usage.OutputTokens += outputTokens
-		account.Usage.RequestCount++
-		s.oauthClient.AccountStore().Save(account)
+// upstreamInterruptedError explains a partial answer: upstream broke off
+// mid-response and no retry was left
+func upstreamInterruptedError(c *gin.Context, err error) models.ErrorDetail {
+	return models.ErrorDetail{
+		Message:   "The upstream response broke off before completion; the output is incomplete.",
+		Type:      errTypeUpstream,
+		Code:      "upstream_interrupted",
+		Details:   err.Error(),
+		RequestID: requestID(c),
 	}
+}
 
-	// Record usage in usage store
-	if err := s.usageStore.RecordUsage(account.AccountID, inputTokens, outputTokens); err != nil {
-		s.logger.Warn("Failed to record usage", zap.Error(err))
+// handleNormalResponse aggregates the upstream responses into one response,
+// with a choice per upstream candidate. When the stream breaks off it returns errIncompleteResponse without writing
+// if canRetry, otherwise it returns the partial answer with finish_reason "error" and an error field.
+// Strict json_schema output is validated first; a mismatch returns errSchemaMismatch
+// while repair attempts remain.
+func (s *Server) handleNormalResponse(c *gin.Context, responses sse.Responses, model string, account *models.Account, canRetry bool) error {
+	var usage usageTracker
+	result, err := sse.Aggregate(observeUsage(responses, &usage))
+
+	inputTokens, outputTokens, totalTokens := s.settleUsage(c, account, model, &usage)
+
+	finishReason := "stop"
+	var interrupted *models.ErrorDetail
+	if errors.Is(err, sse.ErrLineTooLong) {
+		// Retrying would hit the same oversized event, and the answer is cut off at an unknown point
+		s.requestLogger(c).Error("Upstream response event too large",
+			zap.String("account_id", s.displayAccountID(account.AccountID)),
+			zap.Error(err))
+		apiError(c, 502, sseLineTooLongError(c))
+		return nil
+	} else if err != nil {
+		// An expired request budget is reported as a timeout error by proxyWithRetry
+		if canRetry || timedOut(c) {
+			return fmt.Errorf("%w: %v", errIncompleteResponse, err)
+		}
+		// 重试已用尽：返回已收到的部分内容，并明确标记为不完整，而不是伪装成正常结束
+		s.requestLogger(c).Warn("Returning partial response after upstream stream broke off",
+			zap.String("account_id", s.displayAccountID(account.AccountID)),
+			zap.Int("content_length", len(result.Choices[0].Content)),
+			zap.Int64("output_tokens", outputTokens),
+			zap.Error(err))
+		finishReason = "error"
+		detail := upstreamInterruptedError(c, err)
+		interrupted = &detail
 	}
 
-	// Estimate tokens if not provided by API
-	if totalTokens == 0 {
-		// Rough estimate: ~4 chars per token
-		totalTokens = int64(len(content) / 4)
-		outputTokens = totalTokens
+	if result.Blocked != nil && result.Empty() {
+		apiError(c, 400, blockedPromptError(result.Blocked))
+		return nil
 	}
 
-	// Fallback: Extract thinking content if present (regex)
-	if reasoning == "" {
-		// Regex to match <think>...</think> content, allowing for newlines (using (?s))
-		re := regexp.MustCompile(`(?s)<think>(.*?)</think>`)
-		matches := re.FindStringSubmatch(content)
-		if len(matches) > 1 {
-			reasoning = strings.TrimSpace(matches[1]) // Use captured group and trim whitespace
-
-			// Remove the thinking part from content
-			content = strings.Replace(content, matches[0], "", 1)
-			content = strings.TrimSpace(content)
+	structured := structuredOutputFor(c)
+	choices := make([]models.ChatCompletionChoice, 0, len(result.Choices))
+	for _, msg := range result.Choices {
+		// Fallback: models that inline their thinking in <think> tags
+		msg.ExtractThinkTag()
+
+		if structured != nil {
+			valid, err := structured.check(msg.Content)
+			if err != nil {
+				if canRetry && structured.reject(msg.Content, err) {
+					return fmt.Errorf("%w: %v", errSchemaMismatch, err)
+				}
+				apiError(c, 502, models.ErrorDetail{
+					Message: s.t(c, "structured_output_invalid"),
+					Type:    errTypeUpstream,
+					Code:    "structured_output_invalid",
+					Details: err.Error(),
+				})
+				return nil
+			}
+			msg.Content = valid
 		}
+
+		choices = append(choices, models.ChatCompletionChoice{
+			Index: msg.Index,
+			Message: models.ChatCompletionMessage{
+				Role:        "assistant",
+				Content:     msg.Content,
+				Reasoning:   msg.Reasoning,
+				Images:      msg.Images,
+				Annotations: msg.Annotations,
+
+				ReasoningSignature: msg.Signature,
+			},
+			FinishReason: finishReason,
+		})
 	}
 
 	resp := models.ChatCompletionResponse{
@@ -514,102 +1093,268 @@ func (s *Server) handleNormalResponse(c *gin.Context, body io.Reader, model stri
 		Object:  "chat.completion",
 		Created: time.Now().Unix(),
 		Model:   model,
-		Choices: []models.ChatCompletionChoice{
-			{
-				Index: 0,
-				Message: models.ChatCompletionMessage{
-					Role:      "assistant",
-					Content:   content,
-					Reasoning: reasoning,
-				},
-				FinishReason: "stop",
-			},
-		},
+		Choices: choices,
 		Usage: &models.Usage{
 			PromptTokens:     int(inputTokens),
 			CompletionTokens: int(outputTokens),
 			TotalTokens:      int(totalTokens),
 		},
+		Error: interrupted,
 	}
 
+	// Conversations continue with the first choice
+	if first := result.Choices[0]; finishReason != "error" {
+		c.Set(assistantReplyKey, models.ChatCompletionMessage{Role: "assistant", Content: first.Content, ReasoningSignature: first.Signature})
+		s.rememberThoughtSignature(c, first.Content, first.Signature)
+	}
 	c.JSON(200, resp)
+	return nil
 }
 
-func (s *Server) handleStreamResponse(c *gin.Context, body io.Reader, model string, account *models.Account) {
+func (s *Server) handleStreamResponse(c *gin.Context, body io.Reader, model string, account *models.Account, includeUsage bool, format *models.ResponseFormat) {
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
 
-	var totalTokens, inputTokens, outputTokens int64
+	sw := s.newClientStream(c.Writer)
+	framer := newChunkFramer(model)
+	pipeline := newStreamPipeline(sse.ToChunks(model), sseEncoder(sw), framer)
+	// The recorder collects the reply for server-side conversations and its thought signature
+	recorder := &conversationRecorder{}
+	pipeline.Use(recorder)
+	jsonCheck := newJSONStreamCheck(format)
+	if jsonCheck != nil {
+		pipeline.Use(jsonCheck)
+	}
+	err := pipeline.Run(body)
+	interrupted := pipeline.Interrupted()
+	if err != nil && interrupted == nil {
+		// Slow or disconnected client: stop reading so the upstream connection is released
+		s.requestLogger(c).Warn("Stream pipeline stopped early",
+			zap.String("account_id", s.displayAccountID(account.AccountID)),
+			zap.Error(err))
+	}
+	if err == nil && pipeline.Blocked() == nil && !timedOut(c) {
+		reply := recorder.Reply()
+		if c.GetString(conversationIDKey) != "" {
+			c.Set(assistantReplyKey, reply)
+		}
+		s.rememberThoughtSignature(c, messageText(reply.Content), reply.ReasoningSignature)
+	}
+
+	inputTokens, outputTokens, totalTokens := s.settleUsage(c, account, model, &pipeline.usage)
 
-	scanner := bufio.NewScanner(body)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "data: ") {
-			continue
+	// 流已开始后无法再改HTTP状态码，被拦截的提示词和超时以OpenAI风格的error事件告知客户端
+	if blocked := pipeline.Blocked(); blocked != nil {
+		detail := blockedPromptError(blocked)
+		detail.RequestID = requestID(c)
+		if data, err := json.Marshal(models.ErrorResponse{Error: detail}); err == nil {
+			sw.WriteEvent(data)
+		}
+	}
+	if errors.Is(err, sse.ErrLineTooLong) {
+		if data, err := json.Marshal(models.ErrorResponse{Error: sseLineTooLongError(c)}); err == nil {
+			sw.WriteEvent(data)
 		}
+	}
+	if jsonCheck != nil && err == nil && pipeline.Blocked() == nil && !timedOut(c) {
+		if problem := jsonCheck.Problem(); problem != nil {
+			s.requestLogger(c).Warn("Streamed output is not the requested JSON",
+				zap.String("account_id", s.displayAccountID(account.AccountID)),
+				zap.Error(problem))
+			detail := models.ErrorDetail{Message: s.t(c, "json_output_invalid"), Type: errTypeUpstream, Code: "json_output_invalid",
+				Details: problem.Error(), RequestID: requestID(c)}
+			if errors.Is(problem, errSchemaMismatch) {
+				detail.Message, detail.Code = s.t(c, "structured_output_invalid"), "structured_output_invalid"
+			}
+			if data, err := json.Marshal(models.ErrorResponse{Error: detail}); err == nil {
+				sw.WriteEvent(data)
+			}
+		}
+	}
+	if timedOut(c) {
+		detail := models.ErrorDetail{Message: "Request timed out before the response completed.", Type: errTypeServer, Code: "timeout", RequestID: requestID(c)}
+		if data, err := json.Marshal(models.ErrorResponse{Error: detail}); err == nil {
+			sw.WriteEvent(data)
+		}
+	} else if interrupted != nil && !errors.Is(interrupted, sse.ErrLineTooLong) && c.Request.Context().Err() == nil {
+		// 上游中途断开：已发送的内容无法撤回，各选项以 finish_reason "error" 结束，并附带说明原因的error事件
+		s.requestLogger(c).Warn("Upstream stream broke off, partial response sent",
+			zap.String("account_id", s.displayAccountID(account.AccountID)),
+			zap.Int64("output_tokens", outputTokens),
+			zap.Error(interrupted))
+		if data, err := json.Marshal(models.ErrorResponse{Error: upstreamInterruptedError(c, interrupted)}); err == nil {
+			sw.WriteEvent(data)
+		}
+	}
 
-		dataStr := strings.TrimPrefix(line, "data: ")
-		if dataStr == "[DONE]" {
-			break
+	if includeUsage {
+		sseEncoder(sw)(framer.usageChunk(&models.Usage{
+			PromptTokens:     int(inputTokens),
+			CompletionTokens: int(outputTokens),
+			TotalTokens:      int(totalTokens),
+		}))
+	}
+
+	sw.WriteEvent([]byte("[DONE]"))
+	sw.Close()
+}
+
+// route resolves a requested model name through the routing table and returns
+// the upstream model plus its fallback chain (fallbacks may be aliases too)
+func (s *Server) route(requested string) (string, []string) {
+	routing := s.routingTable()
+	model := routing.Resolve(requested)
+
+	var fallbacks []string
+	for _, fallback := range routing.Fallbacks[model] {
+		if resolved := routing.Resolve(fallback); resolved != model {
+			fallbacks = append(fallbacks, resolved)
 		}
+	}
+	return model, fallbacks
+}
 
-		var googleResp models.GoogleResponse
-		if err := json.Unmarshal([]byte(dataStr), &googleResp); err != nil {
-			continue
+// routingTable returns the active routing table (empty when unset, e.g. in unit tests)
+func (s *Server) routingTable() *models.RoutingTable {
+	if s.routing == nil {
+		return &models.RoutingTable{}
+	}
+	return s.routing.Get()
+}
+
+// modelCapabilities looks up capabilities: routing table overrides first, then
+// fetched model metadata, then name-based defaults for unknown models
+func (s *Server) modelCapabilities(model string) models.ModelCapabilities {
+	if caps, ok := s.routingTable().Capabilities[model]; ok {
+		return caps
+	}
+	if s.oauthClient != nil {
+		if caps, ok := s.oauthClient.ModelCapabilities(model); ok {
+			return caps
 		}
+	}
+	return models.DefaultCapabilities(model)
+}
 
-		if len(googleResp.Response.Candidates) == 0 {
-			continue
+// hasSearchTool reports whether the client asked for web search.
+// OpenAI clients send {"type": "web_search"} (or web_search_preview); Gemini
+// users tend to write google_search.
+func hasSearchTool(tools []models.Tool) bool {
+	for _, t := range tools {
+		switch t.Type {
+		case "web_search", "web_search_preview", "google_search":
+			return true
 		}
+	}
+	return false
+}
 
-		// Track usage metadata
-		if googleResp.Response.UsageMetadata != nil {
-			inputTokens = int64(googleResp.Response.UsageMetadata.PromptTokenCount)
-			outputTokens = int64(googleResp.Response.UsageMetadata.CandidatesTokenCount)
-			totalTokens = int64(googleResp.Response.UsageMetadata.TotalTokenCount)
-		}
-
-		candidate := googleResp.Response.Candidates[0]
-
-		for _, part := range candidate.Content.Parts {
-			chunk := models.ChatCompletionChunk{
-				ID:      "chatcmpl-" + uuid.New().String(),
-				Object:  "chat.completion.chunk",
-				Created: time.Now().Unix(),
-				Model:   model,
-				Choices: []models.ChatCompletionChunkChoice{
-					{
-						Index: 0,
-						Delta: models.ChatCompletionDelta{
-							Content: part.Text,
-						},
-					},
-				},
+// isImagePart reports whether a converted part carries image data
+func isImagePart(part models.GooglePart) bool {
+	if part.InlineData != nil {
+		return strings.HasPrefix(part.InlineData.MimeType, "image/")
+	}
+	if part.FileData != nil {
+		return strings.HasPrefix(part.FileData.MimeType, "image/")
+	}
+	return false
+}
+
+// responseModalities maps OpenAI modality names to Gemini responseModalities,
+// dropping anything Gemini can't produce
+func responseModalities(modalities []string) []string {
+	var result []string
+	for _, m := range modalities {
+		switch strings.ToLower(m) {
+		case "text":
+			result = append(result, "TEXT")
+		case "image":
+			result = append(result, "IMAGE")
+		}
+	}
+	return result
+}
+
+// messageText flattens OpenAI message content (string or text parts) into plain text
+func messageText(content interface{}) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var texts []string
+		for _, item := range v {
+			if partMap, ok := item.(map[string]interface{}); ok && partMap["type"] == "text" {
+				if text, ok := partMap["text"].(string); ok {
+					texts = append(texts, text)
+				}
 			}
+		}
+		return strings.Join(texts, "\n")
+	}
+	return ""
+}
+
+// mergeConsecutiveRoles folds back-to-back contents of the same role into a
+// single content with multiple parts. Many agent frameworks send consecutive
+// user (or assistant) messages, which Gemini rejects or handles poorly.
+func mergeConsecutiveRoles(contents []models.GoogleContent) []models.GoogleContent {
+	if len(contents) < 2 {
+		return contents
+	}
 
-			// Send chunk
-			respBytes, _ := json.Marshal(chunk)
-			c.Writer.Write([]byte("data: " + string(respBytes) + "\n\n"))
-			c.Writer.Flush()
+	merged := make([]models.GoogleContent, 0, len(contents))
+	for _, content := range contents {
+		if n := len(merged); n > 0 && merged[n-1].Role == content.Role {
+			merged[n-1].Parts = append(merged[n-1].Parts, content.Parts...)
+			continue
 		}
+		// Copy the parts slice so appends never alias the caller's backing array
+		content.Parts = append([]models.GooglePart(nil), content.Parts...)
+		merged = append(merged, content)
 	}
+	return merged
+}
 
-	// Record usage in account
-	if account.Usage != nil {
-		account.Usage.TotalTokens += totalTokens
-		account.Usage.InputTokens += inputTokens
-		account.Usage.OutputTokens += outputTokens
-		account.Usage.RequestCount++
-		s.oauthClient.AccountStore().Save(account)
+// recordUsage adds a finished request's tokens to the account and the daily usage store
+func (s *Server) recordUsage(c *gin.Context, account *models.Account, model string, inputTokens, outputTokens, totalTokens int64) {
+	s.usageRecorder(c)(account, model, inputTokens, outputTokens, totalTokens)
+}
+
+// usageRecorder returns recordUsage bound to the request's client, so usage
+// can still be recorded after the handler returned
+func (s *Server) usageRecorder(c *gin.Context) func(account *models.Account, model string, inputTokens, outputTokens, totalTokens int64) {
+	var anonymous string
+	if c.GetString("api_key_source") == "anonymous" {
+		anonymous = clientKey(c)
 	}
+	user := c.GetString("request_user")
+	log := s.requestLogger(c)
+	return func(account *models.Account, model string, inputTokens, outputTokens, totalTokens int64) {
+		// Record usage in account
+		s.oauthClient.AccountStore().AddUsage(account, inputTokens, outputTokens, totalTokens)
+
+		if anonymous != "" {
+			s.anonymous.add(anonymous, totalTokens)
+		}
 
-	// Record usage in usage store
-	if err := s.usageStore.RecordUsage(account.AccountID, inputTokens, outputTokens); err != nil {
-		s.logger.Warn("Failed to record usage", zap.Error(err))
+		// Record usage in usage store
+		if err := s.usageStore.RecordUsage(account.AccountID, user, model, inputTokens, outputTokens); err != nil {
+			log.Warn("Failed to record usage", zap.Error(err))
+		}
 	}
+}
 
-	c.Writer.Write([]byte("data: [DONE]\n\n"))
+// sleepCtx waits for d or until ctx is done; it reports whether the full wait elapsed
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 func generateProjectID() string {
@@ -624,3 +1369,24 @@ func generateProjectID() string {
 func generateSessionID() string {
 	return fmt.Sprintf("-%d", rand.Int63())
 }
+
+// sessionIDForRequest keeps a server-side conversation on one upstream session;
+// other requests are grouped by end user
+func sessionIDForRequest(req *models.ChatCompletionRequest) string {
+	if req.ConversationID != "" {
+		return sessionIDForUser("conversation:" + req.ConversationID)
+	}
+	return sessionIDForUser(req.User)
+}
+
+// sessionIDForUser derives a stable upstream session ID from the OpenAI "user"
+// field so upstream abuse attribution groups requests by end user.
+// Anonymous requests fall back to a random session ID.
+func sessionIDForUser(user string) string {
+	if user == "" {
+		return generateSessionID()
+	}
+	h := fnv.New64a()
+	h.Write([]byte(user))
+	return fmt.Sprintf("-%d", int64(h.Sum64()>>1))
+}
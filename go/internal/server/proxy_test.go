@@ -1,10 +1,16 @@
 package server
 
 import (
+	"encoding/json"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/antigravity/api-proxy/internal/config"
 	"github.com/antigravity/api-proxy/internal/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -13,12 +19,13 @@ func TestTransformRequest_Basic(t *testing.T) {
 		logger: zap.NewNop(),
 	}
 
+	temperature := 0.7
 	req := &models.ChatCompletionRequest{
 		Model: "gemini-2.0-flash",
 		Messages: []models.ChatCompletionMessage{
 			{Role: "user", Content: "Hello"},
 		},
-		Temperature: 0.7,
+		Temperature: &temperature,
 	}
 
 	googleReq := s.transformRequest(req)
@@ -32,6 +39,38 @@ func TestTransformRequest_Basic(t *testing.T) {
 	assert.Equal(t, 0.7, *googleReq.Request.GenerationConfig.Temperature)
 }
 
+func TestTransformRequest_ZeroSamplingParams(t *testing.T) {
+	s := &Server{
+		logger: zap.NewNop(),
+	}
+
+	// Explicit zeros (greedy decoding) are forwarded, not dropped as "unset"
+	var req models.ChatCompletionRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"gemini-2.0-flash","messages":[{"role":"user","content":"Hello"}],"temperature":0,"top_p":0,"top_k":0}`), &req))
+	config := s.transformRequest(&req).Request.GenerationConfig
+	require.NotNil(t, config.Temperature)
+	assert.Equal(t, 0.0, *config.Temperature)
+	require.NotNil(t, config.TopP)
+	assert.Equal(t, 0.0, *config.TopP)
+	require.NotNil(t, config.TopK)
+	assert.Equal(t, 0, *config.TopK)
+
+	data, err := json.Marshal(config)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"temperature":0`)
+	assert.Contains(t, string(data), `"topP":0`)
+	assert.Contains(t, string(data), `"topK":0`)
+
+	// Omitted parameters stay unset so upstream defaults apply
+	req = models.ChatCompletionRequest{}
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"gemini-2.0-flash","messages":[{"role":"user","content":"Hello"}]}`), &req))
+	config = s.transformRequest(&req).Request.GenerationConfig
+	assert.Nil(t, config.Temperature)
+	assert.Nil(t, config.TopP)
+	assert.Nil(t, config.TopK)
+	assert.Nil(t, config.MaxOutputTokens)
+}
+
 func TestTransformRequest_ThinkingModel(t *testing.T) {
 	s := &Server{
 		logger: zap.NewNop(),
@@ -96,3 +135,357 @@ func TestTransformRequest_Tools(t *testing.T) {
 	assert.NotEmpty(t, googleReq.Request.Tools)
 	assert.Equal(t, "get_time", googleReq.Request.Tools[0].FunctionDeclarations[0].Name)
 }
+
+func TestTransformRequest_UserSession(t *testing.T) {
+	s := &Server{
+		logger: zap.NewNop(),
+	}
+
+	req := &models.ChatCompletionRequest{
+		Model: "gemini-2.0-flash",
+		Messages: []models.ChatCompletionMessage{
+			{Role: "user", Content: "Hi"},
+		},
+		User: "user-123",
+	}
+
+	first := s.transformRequest(req)
+	second := s.transformRequest(req)
+
+	// Same end user maps to the same upstream session
+	assert.Equal(t, first.Request.SessionID, second.Request.SessionID)
+}
+
+func TestTransformRequest_MergeConsecutiveRoles(t *testing.T) {
+	s := &Server{
+		logger: zap.NewNop(),
+	}
+
+	req := &models.ChatCompletionRequest{
+		Model: "gemini-2.0-flash",
+		Messages: []models.ChatCompletionMessage{
+			{Role: "user", Content: "First"},
+			{Role: "user", Content: "Second"},
+			{Role: "assistant", Content: "Reply"},
+			{Role: "assistant", Content: "More"},
+			{Role: "user", Content: "Third"},
+		},
+	}
+
+	googleReq := s.transformRequest(req)
+
+	contents := googleReq.Request.Contents
+	assert.Equal(t, 3, len(contents))
+	assert.Equal(t, "user", contents[0].Role)
+	assert.Equal(t, 2, len(contents[0].Parts))
+	assert.Equal(t, "Second", contents[0].Parts[1].Text)
+	assert.Equal(t, "model", contents[1].Role)
+	assert.Equal(t, 2, len(contents[1].Parts))
+	assert.Equal(t, "user", contents[2].Role)
+}
+
+func TestTransformRequest_DeveloperRole(t *testing.T) {
+	s := &Server{
+		logger: zap.NewNop(),
+	}
+
+	req := &models.ChatCompletionRequest{
+		Model: "gemini-2.0-flash",
+		Messages: []models.ChatCompletionMessage{
+			{Role: "system", Content: "Be helpful"},
+			{Role: "developer", Content: "Answer in English"},
+			{Role: "user", Content: "Hi"},
+		},
+	}
+
+	googleReq := s.transformRequest(req)
+
+	assert.NotNil(t, googleReq.Request.SystemInstruction)
+	assert.Equal(t, 2, len(googleReq.Request.SystemInstruction.Parts))
+	assert.Equal(t, "Answer in English", googleReq.Request.SystemInstruction.Parts[1].Text)
+	assert.Equal(t, 1, len(googleReq.Request.Contents))
+
+	// Configured to forward developer messages as user content
+	s.cfg = &config.Config{Proxy: config.ProxyConfig{DeveloperRole: "user"}}
+	googleReq = s.transformRequest(req)
+
+	assert.Equal(t, 1, len(googleReq.Request.SystemInstruction.Parts))
+	assert.Equal(t, 1, len(googleReq.Request.Contents))
+	assert.Equal(t, 2, len(googleReq.Request.Contents[0].Parts))
+	assert.Contains(t, googleReq.Request.Contents[0].Parts[0].Text, "Answer in English")
+}
+
+func TestTransformRequest_InputAudio(t *testing.T) {
+	s := &Server{
+		logger: zap.NewNop(),
+	}
+
+	req := &models.ChatCompletionRequest{
+		Model: "gemini-2.0-flash",
+		Messages: []models.ChatCompletionMessage{
+			{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "Transcribe this"},
+				map[string]interface{}{"type": "input_audio", "input_audio": map[string]interface{}{
+					"data":   "UklGRg==",
+					"format": "wav",
+				}},
+				map[string]interface{}{"type": "input_audio", "input_audio": map[string]interface{}{
+					"data":   "AAAA",
+					"format": "unknown",
+				}},
+			}},
+		},
+	}
+
+	googleReq := s.transformRequest(req)
+
+	parts := googleReq.Request.Contents[0].Parts
+	assert.Equal(t, 2, len(parts))
+	assert.Equal(t, "audio/wav", parts[1].InlineData.MimeType)
+	assert.Equal(t, "UklGRg==", parts[1].InlineData.Data)
+}
+
+func TestTransformRequest_DocumentParts(t *testing.T) {
+	s := &Server{
+		logger: zap.NewNop(),
+	}
+
+	req := &models.ChatCompletionRequest{
+		Model: "gemini-2.0-flash",
+		Messages: []models.ChatCompletionMessage{
+			{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "Summarize"},
+				map[string]interface{}{"type": "file", "file": map[string]interface{}{
+					"file_data": "data:application/pdf;base64,JVBERi0=",
+					"filename":  "report.pdf",
+				}},
+				map[string]interface{}{"type": "file", "file": map[string]interface{}{
+					"file_data": "aGVsbG8=",
+					"filename":  "notes.txt",
+				}},
+				map[string]interface{}{"type": "file", "file": map[string]interface{}{
+					"file_id": "gs://bucket/paper.pdf",
+				}},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{
+					"url": "data:application/pdf;base64,JVBERi0=",
+				}},
+			}},
+		},
+	}
+
+	googleReq := s.transformRequest(req)
+
+	parts := googleReq.Request.Contents[0].Parts
+	assert.Equal(t, 5, len(parts))
+	assert.Equal(t, "application/pdf", parts[1].InlineData.MimeType)
+	assert.Equal(t, "JVBERi0=", parts[1].InlineData.Data)
+	assert.Equal(t, "text/plain", parts[2].InlineData.MimeType)
+	assert.Equal(t, "gs://bucket/paper.pdf", parts[3].FileData.FileURI)
+	assert.Equal(t, "application/pdf", parts[4].InlineData.MimeType)
+}
+
+func TestTransformRequest_ProviderOptions(t *testing.T) {
+	s := &Server{
+		logger: zap.NewNop(),
+	}
+
+	req := &models.ChatCompletionRequest{
+		Model: "gemini-2.0-flash",
+		Messages: []models.ChatCompletionMessage{
+			{Role: "user", Content: "Hi"},
+		},
+		ExtraBody: map[string]interface{}{
+			"cached_content": "cachedContents/abc",
+			"google": map[string]interface{}{
+				"safety_settings": []interface{}{
+					map[string]interface{}{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"},
+				},
+			},
+			"project": "should-be-ignored",
+		},
+		Google: map[string]interface{}{
+			"responseModalities": []interface{}{"text", "image"},
+		},
+	}
+
+	googleReq := s.transformRequest(req)
+
+	assert.Equal(t, "cachedContents/abc", googleReq.Request.CachedContent)
+	assert.Equal(t, 1, len(googleReq.Request.SafetySettings))
+	assert.Equal(t, []string{"TEXT", "IMAGE"}, googleReq.Request.GenerationConfig.ResponseModalities)
+	assert.NotEqual(t, "should-be-ignored", googleReq.Project)
+}
+
+func TestTransformRequest_ResponseModalities(t *testing.T) {
+	s := &Server{
+		logger: zap.NewNop(),
+	}
+
+	req := &models.ChatCompletionRequest{
+		Model: "gemini-2.0-flash-exp-image-output",
+		Messages: []models.ChatCompletionMessage{
+			{Role: "user", Content: "Draw a cat"},
+		},
+	}
+
+	googleReq := s.transformRequest(req)
+	assert.Equal(t, "gemini-2.0-flash-exp", googleReq.Model)
+	assert.Equal(t, []string{"TEXT", "IMAGE"}, googleReq.Request.GenerationConfig.ResponseModalities)
+
+	req.Model = "gemini-2.0-flash-exp"
+	req.Modalities = []string{"image", "audio"}
+	googleReq = s.transformRequest(req)
+	assert.Equal(t, []string{"IMAGE"}, googleReq.Request.GenerationConfig.ResponseModalities)
+}
+
+func TestEstimateRequestTokens(t *testing.T) {
+	maxTokens := 100
+	req := &models.ChatCompletionRequest{
+		MaxTokens: &maxTokens,
+		Messages: []models.ChatCompletionMessage{
+			{Role: "system", Content: strings.Repeat("a", 400)},
+			{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "text", "text": strings.Repeat("b", 40)},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64,AAAA"}},
+			}},
+		},
+	}
+
+	assert.Equal(t, int64(100+10+mediaPartTokens+100), estimateRequestTokens(req))
+}
+
+func TestEstimateTextTokens(t *testing.T) {
+	cases := []struct {
+		text string
+		want int64
+	}{
+		{"", 0},
+		{"Hello", 1},
+		{"Hello, world!", 4},
+		{"你好，世界", 5},
+		{"こんにちは", 5},
+		{"func main() {}", 6},
+		{"x := 12345", 5},
+		{"line one\n    line two", 5},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, estimateTextTokens(tc.text), tc.text)
+
+		// Streamed in pieces the estimate is the same wherever the text is split
+		for split := range tc.text {
+			var e tokenEstimator
+			e.Write(tc.text[:split])
+			e.Write(tc.text[split:])
+			assert.Equal(t, tc.want, e.Tokens(), "%q split at %d", tc.text, split)
+		}
+	}
+
+	// CJK text is far denser than its byte length suggests
+	cjk := strings.Repeat("中文", 100)
+	assert.Equal(t, int64(200), estimateTextTokens(cjk))
+	assert.Greater(t, estimateTextTokens(cjk), int64(len(cjk)/charsPerToken/2))
+}
+
+func TestTruncateToolResult(t *testing.T) {
+	text := strings.Repeat("a", 60) + strings.Repeat("b", 40)
+
+	out, ok := truncateToolResult(text, 200, true)
+	assert.False(t, ok)
+	assert.Equal(t, text, out)
+
+	out, ok = truncateToolResult(text, 20, false)
+	assert.True(t, ok)
+	assert.Equal(t, strings.Repeat("a", 20)+"\n\n[... tool output truncated: 80 of 100 characters omitted ...]", out)
+
+	out, ok = truncateToolResult(text, 20, true)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(out, strings.Repeat("a", 15)+"\n\n[..."), out)
+	assert.True(t, strings.HasSuffix(out, "...]\n\n"+strings.Repeat("b", 5)), out)
+
+	// Limits count characters, not bytes
+	out, ok = truncateToolResult(strings.Repeat("文", 30), 30, true)
+	assert.False(t, ok)
+	assert.Equal(t, strings.Repeat("文", 30), out)
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	var schema map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"type": "object",
+		"properties": {
+			"status": {"type": "string", "enum": ["ok", "failed"]},
+			"items": {"type": "array", "items": {"$ref": "#/$defs/item"}, "maxItems": 2},
+			"note": {"anyOf": [{"type": "string"}, {"type": "null"}]}
+		},
+		"required": ["status", "items"],
+		"additionalProperties": false,
+		"$defs": {
+			"item": {"type": "object", "properties": {"id": {"type": "integer", "minimum": 1}}, "required": ["id"]}
+		}
+	}`), &schema))
+
+	cases := map[string]string{
+		`{"status":"ok","items":[{"id":1}],"note":null}`:       "",
+		`{"status":"ok","items":[]}`:                           "",
+		`{"status":"done","items":[]}`:                         "$.status: value is not one of the allowed enum values",
+		`{"status":"ok"}`:                                      `$: missing required property "items"`,
+		`{"status":"ok","items":[],"extra":1}`:                 `$: unexpected property "extra"`,
+		`{"status":"ok","items":[{"id":1.5}]}`:                 "$.items[0].id: expected integer, got number",
+		`{"status":"ok","items":[{"id":0}]}`:                   "$.items[0].id: expected a value >= 1",
+		`{"status":"ok","items":[{"id":1},{"id":2},{"id":3}]}`: "$.items: expected at most 2 items, got 3",
+		`{"status":"ok","items":[],"note":5}`:                  "$.note: value matches none of the anyOf schemas",
+		`[]`:                                                   "$: expected object, got array",
+	}
+	for input, want := range cases {
+		var value interface{}
+		require.NoError(t, json.Unmarshal([]byte(input), &value))
+		err := validateJSONSchema(schema, value)
+		if want == "" {
+			assert.NoError(t, err, input)
+			continue
+		}
+		require.Error(t, err, input)
+		assert.True(t, strings.HasPrefix(err.Error(), want), "%s: %v", input, err)
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	assert.Equal(t, `{"a":1}`, extractJSON("```json\n{\"a\":1}\n```"))
+	assert.Equal(t, `{"a":1}`, extractJSON(`Here you go: {"a":1} Hope this helps!`))
+	assert.Equal(t, `[1,2]`, extractJSON("```\n[1,2]\n```"))
+	assert.Equal(t, `plain`, extractJSON(" plain "))
+}
+
+func TestRateLimitCooldown(t *testing.T) {
+	retryInfo := []byte(`[{"error":{"code":429,"status":"RESOURCE_EXHAUSTED","details":[{"@type":"type.googleapis.com/google.rpc.RetryInfo","retryDelay":"3.5s"}]}}]`)
+	quotaReset := []byte(`{"error":{"code":429,"details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","metadata":{"quotaResetDelay":"1m0.5s"}}]}}`)
+
+	tests := []struct {
+		name       string
+		retryAfter string
+		body       []byte
+		count      int
+		seconds    int64
+		source     string
+	}{
+		{"header seconds", "30", retryInfo, 1, 30, "retry_after"},
+		{"header date", time.Now().Add(20 * time.Second).UTC().Format(http.TimeFormat), nil, 1, 20, "retry_after"},
+		{"retry info", "", retryInfo, 1, 4, "retry_info"},
+		{"invalid header falls through", "soon", retryInfo, 1, 4, "retry_info"},
+		{"quota reset delay", "", quotaReset, 1, 61, "retry_info"},
+		{"first backoff", "", []byte(`{"error":{"code":429}}`), 1, 10, "backoff"},
+		{"third backoff", "", nil, 3, 40, "backoff"},
+		{"capped backoff", "", nil, 40, 300, "backoff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.retryAfter != "" {
+				header.Set("Retry-After", tt.retryAfter)
+			}
+			seconds, source := rateLimitCooldown(header, tt.body, tt.count)
+			assert.InDelta(t, tt.seconds, seconds, 1)
+			assert.Equal(t, tt.source, source)
+		})
+	}
+}
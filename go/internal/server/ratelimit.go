@@ -0,0 +1,256 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// 按API Key的固定窗口限流，并通过 x-ratelimit-* 响应头告知客户端剩余额度，
+// 账号池全部冷却时同样告知，便于客户端自行退避而不是疯狂重试。
+// 每个窗口内第一次超限时在通知中心记一条 budget_exceeded，之后的拒绝不再重复写入
+
+// defaultRateLimitWindow applies when a key's limit has no window configured
+const defaultRateLimitWindow = time.Minute
+
+// Account cooldown after an upstream 429 that names no delay: doubled for
+// every consecutive rate limit of the account, up to the maximum
+const (
+	baseRateLimitCooldown = 10 * time.Second
+	maxRateLimitCooldown  = 5 * time.Minute
+)
+
+// rateLimitCooldown returns the account cooldown in seconds for an upstream
+// 429 and where it came from: the Retry-After header (seconds or HTTP date),
+// google.rpc.RetryInfo in the error body, or the adaptive backoff for the
+// account's count-th consecutive rate limit.
+func rateLimitCooldown(header http.Header, body []byte, count int) (int64, string) {
+	if retryAfter := header.Get("Retry-After"); retryAfter != "" {
+		if seconds, err := strconv.ParseInt(retryAfter, 10, 64); err == nil && seconds > 0 {
+			return seconds, "retry_after"
+		}
+		if retryTime, err := http.ParseTime(retryAfter); err == nil {
+			if wait := time.Until(retryTime); wait > 0 {
+				return ceilSeconds(wait), "retry_after"
+			}
+		}
+	}
+	if delay := googleRetryDelay(body); delay > 0 {
+		return ceilSeconds(delay), "retry_info"
+	}
+
+	backoff := maxRateLimitCooldown
+	if count >= 1 && count <= 6 {
+		backoff = min(baseRateLimitCooldown<<(count-1), maxRateLimitCooldown)
+	}
+	return int64(backoff / time.Second), "backoff"
+}
+
+// ceilSeconds rounds d up to whole seconds, so a cooldown never ends early
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// rateWindowSweep is how often take drops windows that have ended
+const rateWindowSweep = time.Minute
+
+// keyLimiter counts requests per API key in fixed windows
+type keyLimiter struct {
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+	swept   time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	end   time.Time
+	count int
+}
+
+func newKeyLimiter() *keyLimiter {
+	return &keyLimiter{windows: make(map[string]*rateWindow)}
+}
+
+// take counts one request for key and returns the count in the current
+// window (including this one) and when the window resets
+func (l *keyLimiter) take(key string, window time.Duration) (count int, reset time.Time) {
+	return l.takeN(key, window, 1)
+}
+
+// takeN is take for n requests at once
+func (l *keyLimiter) takeN(key string, window time.Duration, n int) (count int, reset time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	// Keys seen once (anonymous clients, deleted keys) must not pile up
+	if now.Sub(l.swept) >= rateWindowSweep {
+		for k, w := range l.windows {
+			if !now.Before(w.end) {
+				delete(l.windows, k)
+			}
+		}
+		l.swept = now
+	}
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= window {
+		w = &rateWindow{start: now, end: now.Add(window)}
+		l.windows[key] = w
+	}
+	w.count += n
+	return w.count, w.start.Add(window)
+}
+
+// rateLimitMiddleware enforces per-key limits and emits rate limit headers.
+// Must run after apiKeyAuthMiddleware.
+func (s *Server) rateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		remaining := -1 // unknown
+		var reset time.Time
+
+		if value, ok := c.Get("api_key"); ok {
+			key := value.(*models.APIKey)
+			if window, ok := keyRateWindow(key); ok {
+				count, windowReset := s.keyLimiter.take(key.Key, window)
+				remaining = max(key.RateLimit.MaxRequests-count, 0)
+				reset = windowReset
+				c.Header("x-ratelimit-limit-requests", strconv.Itoa(key.RateLimit.MaxRequests))
+
+				if count > key.RateLimit.MaxRequests {
+					s.rejectRateLimited(c, key, window, count, 1, reset)
+					return
+				}
+			}
+		}
+
+		// 账号池没有可用账号时，剩余额度为0，重置时间为最早结束冷却的账号
+		if pool := s.oauthClient.PoolStatus(); pool.Available == 0 && !pool.NextAvailable.IsZero() {
+			remaining = 0
+			if pool.NextAvailable.After(reset) {
+				reset = pool.NextAvailable
+			}
+		}
+
+		if remaining >= 0 {
+			setRateLimitHeaders(c, remaining, reset)
+		}
+		c.Next()
+	}
+}
+
+// keyRateWindow returns the window of key's rate limit; false when the key
+// has no limit
+func keyRateWindow(key *models.APIKey) (time.Duration, bool) {
+	if key.RateLimit == nil || !key.RateLimit.Enabled || key.RateLimit.MaxRequests <= 0 {
+		return 0, false
+	}
+	window := time.Duration(key.RateLimit.WindowMs) * time.Millisecond
+	if window <= 0 {
+		window = defaultRateLimitWindow
+	}
+	return window, true
+}
+
+// rejectRateLimited answers a request that took the window past the key's
+// limit with a 429; taken is how many requests it counted
+func (s *Server) rejectRateLimited(c *gin.Context, key *models.APIKey, window time.Duration, count, taken int, reset time.Time) {
+	// Notify once per window, when the count first crosses the limit
+	if count-taken <= key.RateLimit.MaxRequests {
+		s.notifyStore.Add(models.NotifyBudgetExceeded, "", fmt.Sprintf("%s exceeded its rate limit of %d requests per %s",
+			keyLabel(key), key.RateLimit.MaxRequests, window))
+	}
+	setRateLimitHeaders(c, 0, reset)
+	c.Header("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+	apiError(c, 429, models.ErrorDetail{
+		Message: "Rate limit exceeded for this API key. Please retry after the window resets.",
+		Type:    errTypeRateLimit,
+		Code:    "rate_limit_exceeded",
+	})
+	c.Abort()
+}
+
+// chargeRequests counts n more requests against the caller's rate limit, for
+// endpoints that fan one call out into several upstream requests. It reports
+// false after answering with a 429 when that exceeds the limit.
+func (s *Server) chargeRequests(c *gin.Context, n int) bool {
+	value, ok := c.Get("api_key")
+	if !ok || n <= 0 {
+		return true
+	}
+	key := value.(*models.APIKey)
+	window, ok := keyRateWindow(key)
+	if !ok {
+		return true
+	}
+	count, reset := s.keyLimiter.takeN(key.Key, window, n)
+	if count > key.RateLimit.MaxRequests {
+		s.rejectRateLimited(c, key, window, count, n, reset)
+		return false
+	}
+	setRateLimitHeaders(c, key.RateLimit.MaxRequests-count, reset)
+	return true
+}
+
+// keyLabel names a client key in notifications without exposing the key itself
+func keyLabel(key *models.APIKey) string {
+	if ip, ok := strings.CutPrefix(key.Key, anonymousKeyPrefix); ok {
+		return "Anonymous client " + ip
+	}
+	if key.Name != "" {
+		return fmt.Sprintf("API key %q", key.Name)
+	}
+	return "API key " + maskAPIKey(key.Key)
+}
+
+// setRateLimitHeaders writes remaining/reset in OpenAI's format (reset as a duration like "6m0s")
+func setRateLimitHeaders(c *gin.Context, remaining int, reset time.Time) {
+	c.Header("x-ratelimit-remaining-requests", strconv.Itoa(remaining))
+	if !reset.IsZero() {
+		c.Header("x-ratelimit-reset-requests", time.Until(reset).Round(time.Second).String())
+	}
+}
+
+// streamLimiter caps simultaneous streaming responses per API key
+type streamLimiter struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func newStreamLimiter() *streamLimiter {
+	return &streamLimiter{active: make(map[string]int)}
+}
+
+// acquire reserves a stream slot for key; limit <= 0 means unlimited.
+// Callers must call release once the stream ends when acquire returns true.
+func (l *streamLimiter) acquire(key string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit > 0 && l.active[key] >= limit {
+		return false
+	}
+	l.active[key]++
+	return true
+}
+
+// release frees a slot taken by acquire
+func (l *streamLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[key] <= 1 {
+		delete(l.active, key)
+		return
+	}
+	l.active[key]--
+}
+
+// clientKey returns the API key a request authenticated with
+func clientKey(c *gin.Context) string {
+	return c.GetString("client_key")
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// 就绪检查：全新部署在登录账号之前，/ready 返回503，
+// 可选地直接拒绝API流量，而不是让每个请求重试到503
+
+// ready reports whether the server can serve API traffic.
+// Without server.require_account the server is always ready.
+func (s *Server) ready() bool {
+	if !s.cfg.Server.RequireAccount {
+		return true
+	}
+	return s.oauthClient.PoolStatus().Available > 0
+}
+
+// readyCheck is the readiness probe; /health stays a pure liveness check
+func (s *Server) readyCheck(c *gin.Context) {
+	pool := s.oauthClient.PoolStatus()
+	accounts := gin.H{"total": pool.Total, "available": pool.Available}
+
+	if s.ready() {
+		c.JSON(200, gin.H{"status": "ready", "accounts": accounts})
+		return
+	}
+	c.JSON(503, gin.H{
+		"status":   "not_ready",
+		"reason":   "no usable account (enabled and not cooling down)",
+		"accounts": accounts,
+	})
+}
+
+// readinessMiddleware rejects API requests while not ready when
+// server.reject_until_ready is set
+func (s *Server) readinessMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !s.cfg.Server.RejectUntilReady || s.ready() {
+			c.Next()
+			return
+		}
+
+		// 有账号在冷却中时告知客户端何时重试
+		if pool := s.oauthClient.PoolStatus(); !pool.NextAvailable.IsZero() {
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(pool.NextAvailable).Seconds())+1))
+		}
+		apiError(c, 503, models.ErrorDetail{
+			Message: "Service not ready: no usable account is available yet. Log in an account from the admin dashboard.",
+			Type:    errTypeUnavailable,
+			Code:    "service_not_ready",
+		})
+		c.Abort()
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// 历史推理内容：客户端回传之前的 assistant 消息时，推理文本（reasoning / reasoning_content 字段，
+// 或内容中的 <think>...</think>）不作为普通内容发给上游，否则模型会把自己的思考当成回答的一部分；
+// reasoning_signature 作为 thoughtSignature 附在该轮的第一个 part 上，让模型延续之前的推理。
+// 多数客户端不会回传 reasoning_signature 这样的非标准字段，因此服务端按 API Key 和回答内容记住每个回答的签名，
+// 客户端回传同样的 assistant 消息时自动补上
+
+// thinkBlock matches inline reasoning that clients echo back inside content
+var thinkBlock = regexp.MustCompile(`(?s)<think>.*?</think>\s*`)
+
+// assistantParts prepares the parts of a prior assistant turn: echoed
+// <think> blocks are removed (parts left empty are dropped) and the thought
+// signature is re-attached
+func assistantParts(msg models.ChatCompletionMessage, parts []models.GooglePart) []models.GooglePart {
+	kept := parts[:0]
+	for _, part := range parts {
+		if part.Text != "" && strings.Contains(part.Text, "<think>") {
+			part.Text = thinkBlock.ReplaceAllString(part.Text, "")
+			if strings.TrimSpace(part.Text) == "" && part.InlineData == nil && part.FileData == nil &&
+				part.FunctionCall == nil && part.FunctionResponse == nil {
+				continue
+			}
+		}
+		kept = append(kept, part)
+	}
+	if msg.ReasoningSignature != "" && len(kept) > 0 {
+		kept[0].ThoughtSignature = msg.ReasoningSignature
+	}
+	return kept
+}
+
+// Thought signatures are remembered for this long, up to this many answers
+const (
+	thoughtSignatureTTL  = 24 * time.Hour
+	maxThoughtSignatures = 10000
+)
+
+// signatureEntry is a remembered thought signature
+type signatureEntry struct {
+	key       string
+	signature string
+	expiresAt time.Time
+}
+
+// signatureStore maps answers to their thought signatures, evicting the
+// oldest entries first
+type signatureStore struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // newest first
+}
+
+func newSignatureStore() *signatureStore {
+	return &signatureStore{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+func (st *signatureStore) put(key, signature string, now time.Time) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if elem, ok := st.entries[key]; ok {
+		st.order.Remove(elem)
+	}
+	st.entries[key] = st.order.PushFront(&signatureEntry{key: key, signature: signature, expiresAt: now.Add(thoughtSignatureTTL)})
+	for st.order.Len() > maxThoughtSignatures {
+		oldest := st.order.Back()
+		st.order.Remove(oldest)
+		delete(st.entries, oldest.Value.(*signatureEntry).key)
+	}
+}
+
+func (st *signatureStore) get(key string, now time.Time) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	elem, ok := st.entries[key]
+	if !ok {
+		return ""
+	}
+	entry := elem.Value.(*signatureEntry)
+	if now.After(entry.expiresAt) {
+		st.order.Remove(elem)
+		delete(st.entries, key)
+		return ""
+	}
+	return entry.signature
+}
+
+// signatureKey identifies an answer of one API key by its visible text
+func signatureKey(c *gin.Context, text string) string {
+	text = strings.TrimSpace(thinkBlock.ReplaceAllString(text, ""))
+	if text == "" {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(clientKey(c)))
+	h.Write([]byte{0})
+	h.Write([]byte(text))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// rememberThoughtSignature keeps the signature of an answer for its follow-up turns
+func (s *Server) rememberThoughtSignature(c *gin.Context, content, signature string) {
+	if signature == "" {
+		return
+	}
+	if key := signatureKey(c, content); key != "" {
+		s.signatures.put(key, signature, time.Now())
+	}
+}
+
+// restoreThoughtSignatures fills in the signatures of prior assistant turns
+// that the client sent back without them
+func (s *Server) restoreThoughtSignatures(c *gin.Context, req *models.ChatCompletionRequest) {
+	for i := range req.Messages {
+		msg := &req.Messages[i]
+		if msg.Role != "assistant" || msg.ReasoningSignature != "" {
+			continue
+		}
+		if key := signatureKey(c, messageText(msg.Content)); key != "" {
+			msg.ReasoningSignature = s.signatures.get(key, time.Now())
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// refreshAccountSummary is one account's refresh reliability over the queried period
+type refreshAccountSummary struct {
+	AccountID string `json:"account_id"`
+	Email     string `json:"email,omitempty"`
+	Refreshed int    `json:"refreshed"`
+	Failed    int    `json:"failed"`
+	// SuccessRate is refreshed / (refreshed + failed); 0 without attempts
+	SuccessRate float64 `json:"success_rate"`
+	LastError   string  `json:"last_error,omitempty"`
+	LastFailure int64   `json:"last_failure,omitempty"` // Unix milliseconds
+}
+
+// getRefreshHistory lists the token refresh cycles of the last ?days= days
+// (default 7, at most 30) with per-account reliability
+func (s *Server) getRefreshHistory(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days < 1 {
+		days = 7
+	}
+	days = min(days, 30)
+
+	cycles, err := s.usageStore.GetRefreshHistory(days)
+	if err != nil {
+		s.requestLogger(c).Error("Failed to get refresh history", zap.Error(err))
+		c.JSON(500, gin.H{"error": s.t(c, "failed_refresh_history")})
+		return
+	}
+
+	byAccount := make(map[string]*refreshAccountSummary)
+	for _, cycle := range cycles { // newest first, so the first failure seen is the last one
+		for _, result := range cycle.Accounts {
+			if result.Result != models.RefreshResultRefreshed && result.Result != models.RefreshResultFailed {
+				continue
+			}
+			summary, ok := byAccount[result.AccountID]
+			if !ok {
+				summary = &refreshAccountSummary{AccountID: result.AccountID}
+				byAccount[result.AccountID] = summary
+			}
+			if result.Result == models.RefreshResultRefreshed {
+				summary.Refreshed++
+				continue
+			}
+			summary.Failed++
+			if summary.LastFailure == 0 {
+				summary.LastFailure = cycle.StartedAt
+				summary.LastError = result.Error
+			}
+		}
+	}
+
+	store := s.oauthClient.AccountStore()
+	accounts := make([]refreshAccountSummary, 0, len(byAccount))
+	for id, summary := range byAccount {
+		summary.SuccessRate = float64(summary.Refreshed) / float64(summary.Refreshed+summary.Failed)
+		if account, err := store.Load(id); err == nil {
+			summary.Email = s.displayEmail(account.Email)
+		}
+		summary.AccountID = s.displayAccountID(id)
+		accounts = append(accounts, *summary)
+	}
+	for i := range cycles {
+		for j := range cycles[i].Accounts {
+			cycles[i].Accounts[j].AccountID = s.displayAccountID(cycles[i].Accounts[j].AccountID)
+		}
+	}
+	// Least reliable accounts first
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].SuccessRate != accounts[j].SuccessRate {
+			return accounts[i].SuccessRate < accounts[j].SuccessRate
+		}
+		return accounts[i].AccountID < accounts[j].AccountID
+	})
+
+	c.JSON(200, gin.H{
+		"days":     days,
+		"cycles":   cycles,
+		"accounts": accounts,
+	})
+}
+
+// getRefreshStatus shows whether the background refresh scheduler is alive,
+// when it last ran and will run next, and each account's latest refresh result
+func (s *Server) getRefreshStatus(c *gin.Context) {
+	status := s.oauthClient.RefreshStatus()
+	store := s.oauthClient.AccountStore()
+	for i := range status.Accounts {
+		if account, err := store.Load(status.Accounts[i].AccountID); err == nil {
+			status.Accounts[i].Email = s.displayEmail(account.Email)
+		}
+		status.Accounts[i].AccountID = s.displayAccountID(status.Accounts[i].AccountID)
+	}
+	if status.LastCycle != nil {
+		// The cycle's account list is shared with the scheduler
+		accounts := make([]models.RefreshAccountResult, len(status.LastCycle.Accounts))
+		for i, result := range status.LastCycle.Accounts {
+			result.AccountID = s.displayAccountID(result.AccountID)
+			accounts[i] = result
+		}
+		status.LastCycle.Accounts = accounts
+	}
+	c.JSON(200, status)
+}
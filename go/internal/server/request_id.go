@@ -0,0 +1,61 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// 请求 ID：每个请求分配一个 ID，写入 X-Request-Id 响应头、OpenAI 错误体的 request_id 字段和相关日志，
+// 用户报告失败的调用时给出该 ID 即可定位日志。客户端自带的合法 X-Request-Id 会被沿用，便于跨服务追踪
+
+// requestIDHeader carries the request ID in both directions
+const requestIDHeader = "X-Request-Id"
+
+const (
+	requestIDKey     = "request_id"
+	requestLoggerKey = "request_logger"
+)
+
+// maxRequestIDLength bounds client-supplied IDs so they can't bloat logs
+const maxRequestIDLength = 128
+
+// requestIDMiddleware assigns the request ID and a logger tagged with it
+func (s *Server) requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = "req_" + uuid.New().String()
+		}
+		c.Set(requestIDKey, id)
+		c.Set(requestLoggerKey, s.logger.With(zap.String("request_id", id)))
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// validRequestID accepts short IDs of visible ASCII characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID of the current request, or "" outside the middleware
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// requestLogger returns the server logger tagged with the request ID
+func (s *Server) requestLogger(c *gin.Context) *zap.Logger {
+	if logger, ok := c.Value(requestLoggerKey).(*zap.Logger); ok {
+		return logger
+	}
+	return s.logger
+}
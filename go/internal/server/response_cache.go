@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 响应缓存：开启 proxy.response_cache 后，同一个 API Key 发出的相同非流式请求（模型、消息和所有参数都相同）
+// 在有效期内直接返回缓存的响应，不再请求上游，重复的评测和客户端重试不会消耗账号额度。
+// 内存中按 LRU 淘汰，配置了 dir 时同时写入磁盘。请求头 X-Cache-Bypass: true 跳过缓存，
+// 响应头 X-Cache 为 HIT、MISS 或 BYPASS。流式请求、服务端会话和不完整的响应不缓存
+
+// cacheBypassHeader makes a request skip the response cache
+const cacheBypassHeader = "X-Cache-Bypass"
+
+// Response cache defaults, used when the config leaves them unset
+const (
+	defaultResponseCacheTTL        = 10 * time.Minute
+	defaultResponseCacheMaxEntries = 1000
+)
+
+// cachedResponse is one cached chat completion
+type cachedResponse struct {
+	Key         string `json:"key"`
+	Body        []byte `json:"body"`
+	ServedModel string `json:"served_model,omitempty"`
+	ExpiresAt   int64  `json:"expires_at"` // Unix ms
+}
+
+func (e *cachedResponse) expired(now time.Time) bool {
+	return now.UnixMilli() >= e.ExpiresAt
+}
+
+// responseCache is an LRU of cached responses, optionally backed by a directory
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// get returns the live entry for key from memory, then from dir
+func (rc *responseCache) get(key, dir string, now time.Time) *cachedResponse {
+	rc.mu.Lock()
+	if elem, ok := rc.entries[key]; ok {
+		entry := elem.Value.(*cachedResponse)
+		if !entry.expired(now) {
+			rc.order.MoveToFront(elem)
+			rc.mu.Unlock()
+			return entry
+		}
+		rc.order.Remove(elem)
+		delete(rc.entries, key)
+	}
+	rc.mu.Unlock()
+
+	if dir == "" {
+		return nil
+	}
+	path := filepath.Join(dir, key+".json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var entry cachedResponse
+	if json.Unmarshal(data, &entry) != nil || entry.Key != key || entry.expired(now) {
+		os.Remove(path)
+		return nil
+	}
+	return &entry
+}
+
+// put stores entry, evicting the least recently used entries beyond maxEntries
+func (rc *responseCache) put(entry *cachedResponse, dir string, maxEntries int) error {
+	rc.mu.Lock()
+	if elem, ok := rc.entries[entry.Key]; ok {
+		elem.Value = entry
+		rc.order.MoveToFront(elem)
+	} else {
+		rc.entries[entry.Key] = rc.order.PushFront(entry)
+	}
+	for rc.order.Len() > maxEntries {
+		oldest := rc.order.Back()
+		rc.order.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*cachedResponse).Key)
+	}
+	rc.mu.Unlock()
+
+	if dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, entry.Key+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// responseCacheKey hashes everything that determines the answer: the API
+// key (cached answers are never shared between keys), the upstream model and
+// the request itself
+func responseCacheKey(c *gin.Context, req *models.ChatCompletionRequest, model string) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(clientKey(c)))
+	h.Write([]byte{0})
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cacheWriter keeps a copy of the response written to the client
+type cacheWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	w.buf.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *cacheWriter) WriteString(s string) (int, error) {
+	w.buf.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Unwrap lets http.ResponseController reach the connection for write deadlines
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// cachedCompletion answers req from the response cache. It returns hit=true
+// when the cached response was written; otherwise store, when not nil, must
+// be called after the request was handled to cache a complete response.
+func (s *Server) cachedCompletion(c *gin.Context, req *models.ChatCompletionRequest, model string) (store func(), hit bool) {
+	if s.cfg == nil || !s.cfg.Proxy.ResponseCache.Enabled || req.Stream || req.ConversationID != "" {
+		return nil, false
+	}
+	cacheCfg := s.cfg.Proxy.ResponseCache
+	if bypass, _ := strconv.ParseBool(c.GetHeader(cacheBypassHeader)); bypass {
+		c.Header("X-Cache", "BYPASS")
+		return nil, false
+	}
+	key, err := responseCacheKey(c, req, model)
+	if err != nil {
+		return nil, false
+	}
+
+	if entry := s.responseCache.get(key, cacheCfg.Dir, time.Now()); entry != nil {
+		s.responseCache.hits.Add(1)
+		s.requestLogger(c).Info("Serving cached response", zap.String("cache_key", key[:16]))
+		c.Header("X-Cache", "HIT")
+		if entry.ServedModel != "" {
+			c.Header("X-Served-Model", entry.ServedModel)
+		}
+		c.Data(200, "application/json; charset=utf-8", entry.Body)
+		return nil, true
+	}
+	s.responseCache.misses.Add(1)
+	c.Header("X-Cache", "MISS")
+
+	writer := &cacheWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	return func() {
+		c.Writer = writer.ResponseWriter
+		// Only complete answers: no errors and no partial responses
+		if _, complete := c.Get(assistantReplyKey); !complete || writer.Status() != 200 {
+			return
+		}
+		ttl := cacheCfg.TTL
+		if ttl <= 0 {
+			ttl = defaultResponseCacheTTL
+		}
+		maxEntries := cacheCfg.MaxEntries
+		if maxEntries <= 0 {
+			maxEntries = defaultResponseCacheMaxEntries
+		}
+		entry := &cachedResponse{
+			Key:         key,
+			Body:        writer.buf.Bytes(),
+			ServedModel: writer.Header().Get("X-Served-Model"),
+			ExpiresAt:   time.Now().Add(ttl).UnixMilli(),
+		}
+		if err := s.responseCache.put(entry, cacheCfg.Dir, maxEntries); err != nil {
+			s.requestLogger(c).Warn("Failed to store cached response", zap.Error(err))
+		}
+	}, false
+}
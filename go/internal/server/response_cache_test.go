@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegration_ResponseCache(t *testing.T) {
+	h := newTestHarness(t)
+	h.cfg.Proxy.ResponseCache = config.ResponseCacheConfig{Enabled: true, Dir: filepath.Join(t.TempDir(), "cache")}
+	h.addAccount("acc1")
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, sseEvents(textEvent(fmt.Sprintf("Answer %d", h.calls.Load())), usageEvent(3, 2)))
+	}
+
+	first := h.chat(helloRequest)
+	require.Equal(t, 200, first.Code, first.Body.String())
+	assert.Equal(t, "MISS", first.Header().Get("X-Cache"))
+
+	second := h.chat(helloRequest)
+	require.Equal(t, 200, second.Code)
+	assert.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.NotEmpty(t, second.Header().Get("X-Served-Model"))
+	assert.Equal(t, int64(1), h.calls.Load(), "the repeat did not reach upstream")
+
+	// The bypass header always goes upstream
+	data, err := json.Marshal(helloRequest)
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewReader(data))
+	req.Header.Set(cacheBypassHeader, "true")
+	rec := h.api(req)
+	require.Equal(t, 200, rec.Code)
+	assert.Equal(t, "BYPASS", rec.Header().Get("X-Cache"))
+	assert.Contains(t, rec.Body.String(), "Answer 2")
+
+	// Other parameters are another request
+	rec = h.chat(map[string]interface{}{"model": "gemini-2.5-pro", "messages": helloRequest["messages"], "temperature": 0.5})
+	assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+	assert.Equal(t, int64(3), h.calls.Load())
+
+	// Entries on disk survive a restart
+	h.server.responseCache = newResponseCache()
+	rec = h.chat(helloRequest)
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Equal(t, first.Body.String(), rec.Body.String())
+
+	// Expired entries are gone from memory and disk
+	h.cfg.Proxy.ResponseCache.TTL = time.Millisecond
+	rec = h.chat(map[string]interface{}{"model": "gemini-2.5-pro", "messages": helloRequest["messages"], "top_k": 3})
+	assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+	time.Sleep(5 * time.Millisecond)
+	h.server.responseCache = newResponseCache()
+	rec = h.chat(map[string]interface{}{"model": "gemini-2.5-pro", "messages": helloRequest["messages"], "top_k": 3})
+	assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+	assert.Equal(t, int64(5), h.calls.Load())
+}
+
+func TestIntegration_ResponseCacheSkipsErrorsAndStreams(t *testing.T) {
+	h := newTestHarness(t)
+	h.cfg.Proxy.ResponseCache = config.ResponseCacheConfig{Enabled: true}
+	h.addAccount("acc1")
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"code":404,"message":"model not found","status":"NOT_FOUND"}}`, 404)
+	}
+
+	for i := 0; i < 2; i++ {
+		h.addAccount("acc1") // a fresh account, the failure put the last one in cooldown
+		rec := h.chat(helloRequest)
+		assert.Equal(t, 404, rec.Code)
+		assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+	}
+	assert.Equal(t, int64(2), h.calls.Load(), "errors are not cached")
+
+	h.handler = func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, sseEvents(textEvent("Hello"), usageEvent(3, 2)))
+	}
+	h.addAccount("acc1")
+	stream := map[string]interface{}{"model": "gemini-2.5-pro", "messages": helloRequest["messages"], "stream": true}
+	for i := 0; i < 2; i++ {
+		rec := h.chat(stream)
+		assert.Equal(t, 200, rec.Code)
+		assert.Empty(t, rec.Header().Get("X-Cache"))
+	}
+	assert.Equal(t, int64(4), h.calls.Load(), "streams are not cached")
+}
+
+func TestResponseCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	rc := newResponseCache()
+	now := time.Now()
+	expires := now.Add(time.Minute).UnixMilli()
+	for _, key := range []string{"a", "b"} {
+		require.NoError(t, rc.put(&cachedResponse{Key: key, ExpiresAt: expires}, "", 2))
+	}
+	require.NotNil(t, rc.get("a", "", now))
+	require.NoError(t, rc.put(&cachedResponse{Key: "c", ExpiresAt: expires}, "", 2))
+
+	assert.NotNil(t, rc.get("a", "", now))
+	assert.Nil(t, rc.get("b", "", now), "b was used least recently")
+	assert.NotNil(t, rc.get("c", "", now))
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/antigravity/api-proxy/internal/storage"
+	"go.uber.org/zap"
+)
+
+// 目录清理任务：按 storage.retention 定期清理服务端写入的目录（Files API 文件、上传目录、响应缓存磁盘目录），
+// 删除过期文件并把目录大小控制在上限内，累计的删除数量和回收空间通过 /metrics 暴露。
+// 抓包（captures）只保存在内存中且有条数上限，不需要清理
+
+// retentionDirs names the cleaned directories in a stable order for /metrics
+var retentionDirs = []string{"files", "uploads", "response_cache"}
+
+// retentionJanitor runs the periodic cleanup
+type retentionJanitor struct {
+	s *Server
+
+	mu      sync.Mutex
+	stop    chan struct{}
+	removed map[string]storage.PruneResult // Totals since start, by directory
+}
+
+func newRetentionJanitor(s *Server) *retentionJanitor {
+	return &retentionJanitor{s: s, removed: make(map[string]storage.PruneResult)}
+}
+
+// Start runs a cleanup now and then every storage.retention.interval
+func (j *retentionJanitor) Start() {
+	interval := j.s.cfg.Storage.Retention.Interval
+	if interval <= 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stop != nil {
+		return
+	}
+	j.stop = make(chan struct{})
+	stop := j.stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			j.Run(time.Now())
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the periodic cleanup
+func (j *retentionJanitor) Stop() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.stop != nil {
+		close(j.stop)
+		j.stop = nil
+	}
+}
+
+// Run cleans every configured directory once
+func (j *retentionJanitor) Run(now time.Time) {
+	cfg := j.s.cfg
+	retention := cfg.Storage.Retention
+	j.prune("files", j.s.batchStore.FilesDir(), retention.Files, now, j.activeBatchFiles())
+	j.prune("uploads", cfg.Storage.UploadsDir, retention.Uploads, now, nil)
+	if dir := cfg.Proxy.ResponseCache.Dir; dir != "" {
+		j.prune("response_cache", dir, retention.ResponseCache, now, nil)
+	}
+}
+
+// prune applies limits to dir and adds what was removed to the totals
+func (j *retentionJanitor) prune(name, dir string, limits config.DirRetention, now time.Time, keep func(id string) bool) {
+	if dir == "" {
+		return
+	}
+	result, err := storage.PruneDir(dir, limits.MaxAge, limits.MaxSizeMB*1024*1024, now, keep)
+	if err != nil {
+		j.s.logger.Warn("Failed to clean directory", zap.String("dir", dir), zap.Error(err))
+	}
+	if result.Files == 0 {
+		return
+	}
+	j.s.logger.Info("Cleaned directory",
+		zap.String("dir", dir),
+		zap.Int("files", result.Files),
+		zap.Int64("bytes", result.Bytes))
+
+	j.mu.Lock()
+	total := j.removed[name]
+	total.Files += result.Files
+	total.Bytes += result.Bytes
+	j.removed[name] = total
+	j.mu.Unlock()
+}
+
+// activeBatchFiles keeps the input and output files of batches still running
+func (j *retentionJanitor) activeBatchFiles() func(id string) bool {
+	all, err := j.s.batchStore.AllBatches()
+	if err != nil {
+		// Without knowing which files are in use, keep them all
+		j.s.logger.Warn("Failed to load batches, skipping file cleanup", zap.Error(err))
+		return func(string) bool { return true }
+	}
+	active := make(map[string]bool)
+	for _, batches := range all {
+		for _, batch := range batches {
+			switch batch.Status {
+			case models.BatchValidating, models.BatchInProgress, models.BatchFinalizing, models.BatchCancelling:
+			default:
+				continue
+			}
+			active[batch.InputFileID] = true
+			for _, id := range []*string{batch.OutputFileID, batch.ErrorFileID} {
+				if id != nil {
+					active[*id] = true
+				}
+			}
+		}
+	}
+	return func(id string) bool { return active[id] }
+}
+
+// writeMetrics appends the cleanup totals in Prometheus text format
+func (j *retentionJanitor) writeMetrics(b *strings.Builder) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fmt.Fprintf(b, "# HELP antigravity_retention_removed_files_total Files removed by directory cleanup.\n")
+	fmt.Fprintf(b, "# TYPE antigravity_retention_removed_files_total counter\n")
+	for _, name := range retentionDirs {
+		fmt.Fprintf(b, "antigravity_retention_removed_files_total{dir=%q} %d\n", name, j.removed[name].Files)
+	}
+	fmt.Fprintf(b, "# HELP antigravity_retention_reclaimed_bytes_total Bytes reclaimed by directory cleanup.\n")
+	fmt.Fprintf(b, "# TYPE antigravity_retention_reclaimed_bytes_total counter\n")
+	for _, name := range retentionDirs {
+		fmt.Fprintf(b, "antigravity_retention_reclaimed_bytes_total{dir=%q} %d\n", name, j.removed[name].Bytes)
+	}
+}
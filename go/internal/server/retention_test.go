@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeAged creates a file with the given size and modification time
+func writeAged(t *testing.T, path string, size int, modTime time.Time) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, make([]byte, size), 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestRetention_RemovesOldAndOversizedFiles(t *testing.T) {
+	h := newTestHarness(t)
+	h.cfg.Storage.UploadsDir = filepath.Join(h.cfg.Storage.DataDir, "uploads")
+	h.cfg.Storage.Retention.Files.MaxAge = 24 * time.Hour
+	h.cfg.Storage.Retention.Uploads.MaxSizeMB = 1
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+
+	// Batch files: metadata and content go together; files of running batches stay
+	files := h.server.batchStore.FilesDir()
+	writeAged(t, filepath.Join(files, "file-old.json"), 10, old)
+	writeAged(t, filepath.Join(files, "file-old.jsonl"), 100, old)
+	writeAged(t, filepath.Join(files, "file-busy.json"), 10, old)
+	writeAged(t, filepath.Join(files, "file-busy.jsonl"), 100, old)
+	writeAged(t, filepath.Join(files, "file-new.json"), 10, now)
+	require.NoError(t, h.server.batchStore.SaveBatch("owner", &models.Batch{
+		ID: "batch-1", Status: models.BatchInProgress, InputFileID: "file-busy",
+	}))
+
+	// Uploads over 1 MB lose their oldest files first
+	for i, name := range []string{"a", "b", "c"} {
+		writeAged(t, filepath.Join(h.cfg.Storage.UploadsDir, name), 600*1024, now.Add(time.Duration(i-3)*time.Minute))
+	}
+
+	h.server.retention.Run(now)
+
+	assert.NoFileExists(t, filepath.Join(files, "file-old.json"))
+	assert.NoFileExists(t, filepath.Join(files, "file-old.jsonl"))
+	assert.FileExists(t, filepath.Join(files, "file-busy.jsonl"))
+	assert.FileExists(t, filepath.Join(files, "file-new.json"))
+	assert.NoFileExists(t, filepath.Join(h.cfg.Storage.UploadsDir, "a"))
+	assert.NoFileExists(t, filepath.Join(h.cfg.Storage.UploadsDir, "b"))
+	assert.FileExists(t, filepath.Join(h.cfg.Storage.UploadsDir, "c"))
+
+	rec := httptest.NewRecorder()
+	h.server.Router().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `antigravity_retention_removed_files_total{dir="files"} 2`)
+	assert.Contains(t, rec.Body.String(), `antigravity_retention_reclaimed_bytes_total{dir="files"} 110`)
+	assert.Contains(t, rec.Body.String(), `antigravity_retention_removed_files_total{dir="uploads"} 2`)
+	assert.Contains(t, rec.Body.String(), `antigravity_retention_reclaimed_bytes_total{dir="uploads"} 1228800`)
+}
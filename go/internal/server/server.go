@@ -2,13 +2,21 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/antigravity/api-proxy/internal/models"
 	"github.com/antigravity/api-proxy/internal/oauth"
+	"github.com/antigravity/api-proxy/internal/report"
 	"github.com/antigravity/api-proxy/internal/storage"
+	"github.com/antigravity/api-proxy/internal/sysmon"
+	"github.com/antigravity/api-proxy/internal/update"
+	"github.com/antigravity/api-proxy/internal/upstream"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
@@ -20,7 +28,40 @@ type Server struct {
 	router      *gin.Engine
 	oauthClient *oauth.Client
 	keyStore    *storage.KeyStore
+	templates   *storage.KeyTemplateStore
 	usageStore  *storage.UsageStore
+	notifyStore *storage.NotificationStore
+	reports     *report.Generator
+	keyLimiter  *keyLimiter
+	streams     *streamLimiter
+	captures    *captureStore
+	batchStore  *storage.BatchStore
+	routing     *storage.RoutingStore
+	// conversations is nil unless conversations.enabled
+	conversations *storage.ConversationStore
+	oidc          *oidcAuth // nil unless security.oidc.enabled
+	anonymous     *anonymousQuota
+	batches       *batchRunner
+	updates       *update.Checker // nil until StartUpdateCheck
+	resources     *sysmon.Monitor
+	retention     *retentionJanitor
+	// cooldownQueue holds requests while every account cools down
+	cooldownQueue *cooldownQueue
+	// accountSlots limits in-flight requests per account
+	accountSlots *accountSlots
+	// usageTasks tracks usage still being recorded after its response, see settleUsage
+	usageTasks sync.WaitGroup
+	// hedges counts the second requests of hedged attempts
+	hedges hedgeStats
+	// responseCache holds responses of identical non-streaming requests
+	responseCache *responseCache
+	// signatures remembers the thought signatures of recent answers
+	signatures *signatureStore
+
+	// upstreamURL is the streamGenerateContent endpoint (overridable in tests)
+	upstreamURL string
+	// userAgent is sent with every Cloud Code request
+	userAgent string
 }
 
 // New creates a new server instance
@@ -29,36 +70,160 @@ func New(cfg *config.Config, logger *zap.Logger) (*Server, error) {
 	gin.SetMode(cfg.Server.Mode)
 
 	s := &Server{
-		cfg:    cfg,
-		logger: logger,
-		router: gin.New(),
+		cfg:         cfg,
+		logger:      logger,
+		router:      gin.New(),
+		upstreamURL: defaultBaseURL + streamGeneratePath,
+		userAgent:   defaultUserAgent,
+		keyLimiter:  newKeyLimiter(),
+		streams:     newStreamLimiter(),
+		captures:    newCaptureStore(),
+		anonymous:   newAnonymousQuota(),
+
+		cooldownQueue: &cooldownQueue{},
+		accountSlots:  newAccountSlots(),
+		responseCache: newResponseCache(),
+		signatures:    newSignatureStore(),
 	}
 
+	// 只信任配置的反向代理传来的客户端IP，其余请求以连接对端地址为准
+	if err := s.router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid server.trusted_proxies: %w", err)
+	}
+
+	// 上游环境（antigravity 配置或 ANTIGRAVITY_* 环境变量）
+	if base := cfg.Antigravity.BaseURL; base != "" {
+		s.upstreamURL = strings.TrimRight(base, "/") + streamGeneratePath
+	}
+	if ua := cfg.Antigravity.UserAgent; ua != "" {
+		s.userAgent = ua
+	}
+
+	// 所有上游请求（聊天、OAuth、模型列表）共用的连接池
+	pool := cfg.Proxy.Upstream
+	upstream.Configure(upstream.PoolConfig{
+		MaxIdleConns:        pool.MaxIdleConns,
+		MaxIdleConnsPerHost: pool.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     pool.MaxConnsPerHost,
+		IdleConnTimeout:     pool.IdleConnTimeout,
+		DialTimeout:         pool.DialTimeout,
+		TLSHandshakeTimeout: pool.TLSHandshakeTimeout,
+		DisableHTTP2:        pool.DisableHTTP2,
+		ProxyURL:            pool.ProxyURL,
+		Headers:             pool.Headers,
+	})
+
 	// Initialize storage
 	s.keyStore = storage.NewKeyStore(cfg.Storage.KeysDir)
+	s.templates = storage.NewKeyTemplateStore(cfg.Storage.DataDir)
 	s.usageStore = storage.NewUsageStore(cfg.Storage.UsageDir)
+	s.notifyStore = storage.NewNotificationStore(cfg.Storage.DataDir)
+	s.routing = storage.NewRoutingStore(cfg.Storage.DataDir)
+	if cfg.Conversations.Enabled {
+		s.conversations = storage.NewConversationStore(cfg.Storage.DataDir, cfg.Conversations.TTL)
+		if removed, err := s.conversations.Prune(); err != nil {
+			logger.Warn("Failed to prune expired conversations", zap.Error(err))
+		} else if removed > 0 {
+			logger.Info("Pruned expired conversations", zap.Int("removed", removed))
+		}
+	}
+	if cfg.Security.OIDC.Enabled {
+		s.oidc = newOIDCAuth(cfg.Security.OIDC)
+	}
 
 	// Initialize OAuth client (uses server port for callback)
 	s.oauthClient = oauth.NewClient(cfg.Server.Port, cfg.Storage.AccountsDir, logger)
+	s.oauthClient.AccountStore().StartFlusher(cfg.Storage.AccountFlushInterval)
+	s.oauthClient.SetNotificationStore(s.notifyStore)
+	s.oauthClient.SetRefreshHistory(s.usageStore)
+	if interval := cfg.OAuth.ModelsRefreshInterval; interval != 0 {
+		s.oauthClient.SetModelsRefreshInterval(interval)
+	}
+	if base := cfg.Antigravity.BaseURL; base != "" {
+		s.oauthClient.SetAPIBaseURL(base)
+	}
+	s.oauthClient.SetRetryPolicy(oauth.RetryPolicy{
+		Timeout:    cfg.OAuth.HTTPTimeout,
+		MaxRetries: cfg.OAuth.HTTPMaxRetries,
+		Backoff:    cfg.OAuth.HTTPRetryBackoff,
+	})
+	s.oauthClient.SetEmailMasking(cfg.Security.MaskEmails)
+	if quota := cfg.Proxy.AccountDailyTokens; quota > 0 {
+		s.oauthClient.SetRemainingQuota(func(accountID string) (int64, bool) {
+			return quota - s.usageStore.TodayTokens(accountID), true
+		})
+	}
 	s.oauthClient.StartBackgroundRefresh()
 
+	// 批处理任务（恢复上次未完成的批次）
+	s.batchStore = storage.NewBatchStore(cfg.Storage.DataDir)
+	s.batches = newBatchRunner(s, cfg.Proxy.BatchConcurrency)
+
+	// 每日报告
+	s.reports = report.NewGenerator(cfg.Reports, s.usageStore, s.notifyStore, logger)
+	s.reports.SetAccountIDMasking(cfg.Security.MaskEmails)
+	s.reports.Start()
+
+	// 数据目录磁盘空间、inode 和文件描述符监控
+	s.resources = sysmon.NewMonitor(cfg.Resources, cfg.Storage.DataDir, logger, func(message string) {
+		s.notifyStore.Add(models.NotifyResourceLow, "", message)
+	})
+	s.resources.Start()
+
+	// 服务端写入目录的定期清理
+	s.retention = newRetentionJanitor(s)
+	s.retention.Start()
+
 	// 设置中间件
 	s.setupMiddleware()
 
 	// 设置路由
 	s.setupRoutes()
 
+	// Resume needs the router: batch requests run through the normal handler chain
+	s.batches.Resume()
+
 	return s, nil
 }
 
+// Close stops background workers started by New
+func (s *Server) Close() {
+	s.oauthClient.StopBackgroundRefresh()
+	s.reports.Stop()
+	s.batches.Close()
+	s.resources.Stop()
+	s.retention.Stop()
+	if s.updates != nil {
+		s.updates.Stop()
+	}
+	s.usageTasks.Wait()
+	if err := s.oauthClient.AccountStore().Close(); err != nil {
+		s.logger.Warn("Failed to flush account updates", zap.Error(err))
+	}
+}
+
+// StartUpdateCheck starts the background release check for the running version
+func (s *Server) StartUpdateCheck(version string) {
+	s.updates = update.NewChecker(s.cfg.Updates, version, s.logger)
+	s.updates.Start()
+}
+
 // Router returns the gin engine
 func (s *Server) Router() *gin.Engine {
 	return s.router
 }
 
+// PoolStatus reports how many accounts exist and how many are usable
+func (s *Server) PoolStatus() oauth.PoolStatus {
+	return s.oauthClient.PoolStatus()
+}
+
 func (s *Server) setupMiddleware() {
 	// Recovery middleware
-	s.router.Use(gin.Recovery())
+	s.router.Use(gin.CustomRecovery(recoveryHandler))
+
+	// Request ID middleware, before the logger so every log line carries it
+	s.router.Use(s.requestIDMiddleware())
 
 	// Logger middleware
 	s.router.Use(s.loggerMiddleware())
@@ -77,14 +242,39 @@ func (s *Server) setupRoutes() {
 
 	// 健康检查
 	s.router.GET("/health", s.healthCheck)
+	s.router.GET("/ready", s.readyCheck)
 	s.router.GET("/ping", s.ping)
+	s.router.GET("/metrics", s.metrics)
 
 	// OpenAI兼容 API - 需要API Key认证
 	api := s.router.Group("/v1")
-	api.Use(s.apiKeyAuthMiddleware())
+	api.Use(s.apiKeyAuthMiddleware(), s.readinessMiddleware(), s.rateLimitMiddleware())
 	{
 		api.POST("/chat/completions", s.chatCompletions)
+		api.POST("/summarize", s.summarize)
+		api.POST("/moderations", s.moderations)
 		api.GET("/models", s.listModels)
+
+		// Files / Batches API（匿名访问不可用）
+		stored := api.Group("", s.requireAPIKey())
+		stored.POST("/files", s.uploadFile)
+		stored.GET("/files", s.listFiles)
+		stored.GET("/files/:id", s.getFile)
+		stored.GET("/files/:id/content", s.getFileContent)
+		stored.DELETE("/files/:id", s.deleteFile)
+		stored.POST("/batches", s.createBatch)
+		stored.GET("/batches", s.listBatches)
+		stored.GET("/batches/:id", s.getBatch)
+		stored.POST("/batches/:id/cancel", s.cancelBatch)
+		stored.GET("/conversations/:id", s.getConversation)
+		stored.DELETE("/conversations/:id", s.deleteConversation)
+	}
+
+	// 原生Gemini API透传 - 同样需要API Key认证
+	gemini := s.router.Group("/v1beta")
+	gemini.Use(s.apiKeyAuthMiddleware(), s.readinessMiddleware(), s.rateLimitMiddleware())
+	{
+		gemini.POST("/models/*action", s.geminiAction)
 	}
 
 	// 管理后台API
@@ -95,24 +285,33 @@ func (s *Server) setupRoutes() {
 		admin.POST("/logout", s.adminLogout)
 		admin.GET("/verify", s.adminVerify)
 
+		// OIDC 单点登录
+		admin.GET("/oidc", s.oidcInfo)
+		admin.GET("/oidc/login", s.oidcLogin)
+		admin.GET("/oidc/callback", s.oidcCallback)
+
 		// 需要认证的路由
 		auth := admin.Group("/")
 		auth.Use(s.adminAuthMiddleware())
 		{
 			// Token管理
-			auth.GET("/tokens", s.listTokens)
+			auth.GET("/tokens", s.adminOnly(), s.listTokens) // 含访问令牌和刷新令牌，只读角色不可见
 			auth.POST("/tokens/login", s.triggerOAuthLogin)
 			auth.POST("/tokens/callback", s.addTokenFromCallback)
 			auth.PATCH("/tokens/:id", s.toggleToken)
+			auth.POST("/tokens/:id/models", s.refreshTokenModels)
 			auth.DELETE("/tokens/:id", s.deleteToken)
 			auth.GET("/tokens/stats", s.getTokenStats)
 			auth.GET("/tokens/usage", s.getTokenUsage)
 
 			// 密钥管理
-			auth.GET("/keys", s.listKeys)
+			auth.GET("/keys", s.adminOnly(), s.listKeys) // 返回完整密钥，只读角色不可见
 			auth.POST("/keys/generate", s.generateKey)
 			auth.DELETE("/keys/:key", s.deleteKey)
 			auth.GET("/keys/stats", s.getKeyStats)
+			auth.GET("/keys/templates", s.listKeyTemplates)
+			auth.POST("/keys/templates", s.saveKeyTemplate)
+			auth.DELETE("/keys/templates/:name", s.deleteKeyTemplate)
 
 			// 日志
 			auth.GET("/logs", s.getLogs)
@@ -122,12 +321,43 @@ func (s *Server) setupRoutes() {
 			auth.GET("/status", s.getSystemStatus)
 
 			// 设置
-			auth.GET("/settings", s.getSettings)
+			auth.GET("/settings", s.adminOnly(), s.getSettings) // 含密码和密钥，只读角色不可见
 			auth.POST("/settings", s.saveSettings)
 
 			// 使用统计
 			auth.GET("/usage/summary", s.getUsageSummary)
 			auth.GET("/usage/history", s.getUsageHistory)
+			auth.GET("/usage/users", s.getUsageByUser)
+			auth.GET("/refresh/history", s.getRefreshHistory)
+			auth.GET("/refresh/status", s.getRefreshStatus)
+
+			// 通知中心
+			auth.GET("/notifications", s.listNotifications)
+			auth.POST("/notifications/read-all", s.markAllNotificationsRead)
+			auth.PATCH("/notifications/:id", s.markNotificationRead)
+			auth.DELETE("/notifications", s.clearNotifications)
+
+			// 每日报告
+			auth.GET("/reports", s.listReports)
+			auth.POST("/reports/generate", s.generateReport)
+			auth.GET("/reports/:date", s.getReport)
+
+			// 模型路由表
+			auth.GET("/routing", s.getRouting)
+			auth.PUT("/routing", s.updateRouting)
+
+			// 容量模拟
+			auth.GET("/simulate", s.simulate)
+
+			// Playground：只读角色不能发起请求（非GET）
+			auth.GET("/playground/models", s.listModels)
+			auth.POST("/playground/chat", s.playgroundChat)
+
+			// 调试抓包
+			auth.GET("/captures", s.listCaptures)
+			auth.POST("/captures", s.armCapture)
+			auth.GET("/captures/:id", s.adminOnly(), s.downloadCapture) // 含完整请求和响应体
+			auth.DELETE("/captures", s.clearCaptures)
 		}
 	}
 
@@ -136,6 +366,8 @@ func (s *Server) setupRoutes() {
 
 	// 静态文件（管理后台前端）- 放在 /ui 路径
 	s.setupStaticFiles()
+
+	s.router.NoRoute(noRoute)
 }
 
 // 基础handlers
@@ -147,6 +379,79 @@ func (s *Server) ping(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "pong"})
 }
 
+// metrics exposes runtime and upstream connection gauges in Prometheus text format
+func (s *Server) metrics(c *gin.Context) {
+	stats := upstream.GetStats()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP antigravity_upstream_active_connections Upstream requests whose response body is still open.\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_upstream_active_connections gauge\n")
+	fmt.Fprintf(&b, "antigravity_upstream_active_connections %d\n", stats.ActiveConnections)
+	fmt.Fprintf(&b, "# HELP antigravity_upstream_requests_total Upstream requests sent since start.\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_upstream_requests_total counter\n")
+	fmt.Fprintf(&b, "antigravity_upstream_requests_total %d\n", stats.TotalRequests)
+	fmt.Fprintf(&b, "# HELP antigravity_upstream_open_connections Pooled upstream connections currently open (idle or in use).\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_upstream_open_connections gauge\n")
+	fmt.Fprintf(&b, "antigravity_upstream_open_connections %d\n", stats.OpenConnections)
+	fmt.Fprintf(&b, "# HELP antigravity_upstream_dials_total New upstream connections dialed.\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_upstream_dials_total counter\n")
+	fmt.Fprintf(&b, "antigravity_upstream_dials_total %d\n", stats.Dials)
+	fmt.Fprintf(&b, "# HELP antigravity_upstream_dial_errors_total Upstream connection attempts that failed.\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_upstream_dial_errors_total counter\n")
+	fmt.Fprintf(&b, "antigravity_upstream_dial_errors_total %d\n", stats.DialErrors)
+	fmt.Fprintf(&b, "# HELP antigravity_upstream_reused_connections_total Upstream requests sent on a reused pooled connection.\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_upstream_reused_connections_total counter\n")
+	fmt.Fprintf(&b, "antigravity_upstream_reused_connections_total %d\n", stats.ReusedConnections)
+	fmt.Fprintf(&b, "# HELP antigravity_upstream_http2_responses_total Upstream responses received over HTTP/2.\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_upstream_http2_responses_total counter\n")
+	fmt.Fprintf(&b, "antigravity_upstream_http2_responses_total %d\n", stats.HTTP2Responses)
+	fmt.Fprintf(&b, "# HELP antigravity_cooldown_queue_waiting Requests waiting for an account to leave cooldown.\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_cooldown_queue_waiting gauge\n")
+	fmt.Fprintf(&b, "antigravity_cooldown_queue_waiting %d\n", s.cooldownQueue.waiting.Load())
+	fmt.Fprintf(&b, "# HELP antigravity_cooldown_queued_total Requests that waited for an account to leave cooldown.\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_cooldown_queued_total counter\n")
+	fmt.Fprintf(&b, "antigravity_cooldown_queued_total %d\n", s.cooldownQueue.queued.Load())
+	fmt.Fprintf(&b, "# HELP antigravity_hedged_requests_total Second requests sent because the first account had not answered within proxy.hedge_delay.\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_hedged_requests_total counter\n")
+	fmt.Fprintf(&b, "antigravity_hedged_requests_total %d\n", s.hedges.fired.Load())
+	fmt.Fprintf(&b, "# HELP antigravity_hedge_wins_total Hedged second requests that answered first.\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_hedge_wins_total counter\n")
+	fmt.Fprintf(&b, "antigravity_hedge_wins_total %d\n", s.hedges.won.Load())
+	fmt.Fprintf(&b, "# HELP antigravity_response_cache_hits_total Requests answered from the response cache.\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_response_cache_hits_total counter\n")
+	fmt.Fprintf(&b, "antigravity_response_cache_hits_total %d\n", s.responseCache.hits.Load())
+	fmt.Fprintf(&b, "# HELP antigravity_response_cache_misses_total Cacheable requests sent upstream.\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_response_cache_misses_total counter\n")
+	fmt.Fprintf(&b, "antigravity_response_cache_misses_total %d\n", s.responseCache.misses.Load())
+	s.retention.writeMetrics(&b)
+	fmt.Fprintf(&b, "# HELP go_goroutines Number of goroutines that currently exist.\n")
+	fmt.Fprintf(&b, "# TYPE go_goroutines gauge\n")
+	fmt.Fprintf(&b, "go_goroutines %d\n", runtime.NumGoroutine())
+
+	resources := s.resources.Status()
+	if disk := resources.Disk; disk != nil {
+		fmt.Fprintf(&b, "# HELP antigravity_data_dir_free_bytes Free space on the filesystem holding the data directory.\n")
+		fmt.Fprintf(&b, "# TYPE antigravity_data_dir_free_bytes gauge\n")
+		fmt.Fprintf(&b, "antigravity_data_dir_free_bytes %d\n", disk.FreeBytes)
+		fmt.Fprintf(&b, "# HELP antigravity_data_dir_free_inodes Free inodes on the filesystem holding the data directory.\n")
+		fmt.Fprintf(&b, "# TYPE antigravity_data_dir_free_inodes gauge\n")
+		fmt.Fprintf(&b, "antigravity_data_dir_free_inodes %d\n", disk.FreeInodes)
+	}
+	if fd := resources.FD; fd != nil {
+		fmt.Fprintf(&b, "# HELP process_open_fds Number of open file descriptors.\n")
+		fmt.Fprintf(&b, "# TYPE process_open_fds gauge\n")
+		fmt.Fprintf(&b, "process_open_fds %d\n", fd.Open)
+		fmt.Fprintf(&b, "# HELP process_max_fds Maximum number of open file descriptors.\n")
+		fmt.Fprintf(&b, "# TYPE process_max_fds gauge\n")
+		fmt.Fprintf(&b, "process_max_fds %d\n", fd.Limit)
+	}
+	fmt.Fprintf(&b, "# HELP antigravity_resource_warnings Resource thresholds currently exceeded (disk, inodes, file descriptors).\n")
+	fmt.Fprintf(&b, "# TYPE antigravity_resource_warnings gauge\n")
+	fmt.Fprintf(&b, "antigravity_resource_warnings %d\n", len(resources.Warnings))
+
+	c.Data(200, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
 // API handlers - chatCompletions 在 proxy.go 中实现
 
 func (s *Server) listModels(c *gin.Context) {
@@ -183,6 +488,9 @@ func (s *Server) listModels(c *gin.Context) {
 							"object":   "model",
 							"owned_by": model["owned_by"],
 						}
+						if caps, ok := model["capabilities"]; ok {
+							modelsMap[modelID]["capabilities"] = caps
+						}
 					}
 				}
 			}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/antigravity/api-proxy/internal/storage"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 账号池容量模拟：给定假设的请求速率和单次token数，结合配置的账号额度或历史429记录，
+// 估算当前账号池能支撑多久、需要多少账号，用于容量规划
+
+// simulationHistoryDays is how much usage history the projection looks at
+const simulationHistoryDays = 7
+
+// simulationInput is a hypothetical workload
+type simulationInput struct {
+	RequestsPerMinute float64 `json:"requests_per_minute"`
+	TokensPerRequest  int64   `json:"tokens_per_request"`
+}
+
+// simulationPool is the current pool plus its usage history
+type simulationPool struct {
+	Accounts int // enabled accounts
+	// ConfiguredQuota is proxy.account_daily_tokens (0 = not configured)
+	ConfiguredQuota int64
+	// TodayTokens is what each enabled account has used today
+	TodayTokens []int64
+	History     []storage.UsageRecord
+}
+
+// simulationResult is returned by /admin/simulate
+type simulationResult struct {
+	Input simulationInput `json:"input"`
+	Pool  struct {
+		Accounts              int    `json:"accounts"`
+		DailyTokensPerAccount int64  `json:"daily_tokens_per_account"`
+		QuotaSource           string `json:"quota_source"` // config, history or unknown
+		RemainingToday        *int64 `json:"remaining_today,omitempty"`
+	} `json:"pool"`
+	History struct {
+		Days           int     `json:"days"`
+		Requests       int64   `json:"requests"`
+		RateLimited    int64   `json:"rate_limited"`
+		RateLimitRatio float64 `json:"rate_limit_ratio"`
+	} `json:"history"`
+	Projection struct {
+		DemandTokensPerDay int64 `json:"demand_tokens_per_day"`
+		// The fields below are omitted when the per-account quota is unknown
+		PoolTokensPerDay    *int64   `json:"pool_tokens_per_day,omitempty"`
+		Utilization         *float64 `json:"utilization,omitempty"`
+		Sustainable         *bool    `json:"sustainable,omitempty"`
+		HoursUntilExhausted *float64 `json:"hours_until_exhausted,omitempty"`
+		AccountsNeeded      *int     `json:"accounts_needed,omitempty"`
+		AttemptsPerRequest  float64  `json:"expected_attempts_per_request"`
+	} `json:"projection"`
+}
+
+// simulatePool projects how the pool copes with a workload.
+//
+// The per-account daily quota comes from proxy.account_daily_tokens when set;
+// otherwise it is estimated as the median tokens an account served on days it
+// was rate limited (the point where upstream started refusing). The historical
+// 429 ratio estimates how many attempts each request costs.
+func simulatePool(in simulationInput, pool simulationPool) simulationResult {
+	var out simulationResult
+	out.Input = in
+	out.Pool.Accounts = pool.Accounts
+	out.History.Days = simulationHistoryDays
+
+	var throttledDays []int64
+	for _, record := range pool.History {
+		out.History.Requests += record.RequestCount
+		out.History.RateLimited += record.RateLimited
+		if record.RateLimited > 0 && record.TotalTokens > 0 {
+			throttledDays = append(throttledDays, record.TotalTokens)
+		}
+	}
+	if attempts := out.History.Requests + out.History.RateLimited; attempts > 0 {
+		out.History.RateLimitRatio = round2(float64(out.History.RateLimited) / float64(attempts))
+	}
+	out.Projection.AttemptsPerRequest = round2(1 / (1 - min(out.History.RateLimitRatio, 0.99)))
+
+	quota := pool.ConfiguredQuota
+	switch {
+	case quota > 0:
+		out.Pool.QuotaSource = "config"
+	case len(throttledDays) > 0:
+		sort.Slice(throttledDays, func(i, j int) bool { return throttledDays[i] < throttledDays[j] })
+		quota = throttledDays[len(throttledDays)/2]
+		out.Pool.QuotaSource = "history"
+	default:
+		out.Pool.QuotaSource = "unknown"
+	}
+	out.Pool.DailyTokensPerAccount = quota
+
+	tokensPerMinute := in.RequestsPerMinute * float64(in.TokensPerRequest)
+	demand := int64(tokensPerMinute * 60 * 24)
+	out.Projection.DemandTokensPerDay = demand
+	if quota <= 0 {
+		return out
+	}
+
+	poolTokens := quota * int64(pool.Accounts)
+	out.Projection.PoolTokensPerDay = &poolTokens
+
+	var remaining int64
+	for _, used := range pool.TodayTokens {
+		remaining += max(quota-used, 0)
+	}
+	out.Pool.RemainingToday = &remaining
+
+	needed := int(math.Ceil(float64(demand) / float64(quota)))
+	out.Projection.AccountsNeeded = &needed
+	sustainable := demand <= poolTokens
+	out.Projection.Sustainable = &sustainable
+	if poolTokens > 0 {
+		utilization := round2(float64(demand) / float64(poolTokens))
+		out.Projection.Utilization = &utilization
+	}
+	if tokensPerMinute > 0 {
+		hours := round2(float64(remaining) / tokensPerMinute / 60)
+		out.Projection.HoursUntilExhausted = &hours
+	}
+	return out
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// simulate handles GET /admin/simulate?rpm=<requests per minute>&tokens=<tokens per request>
+func (s *Server) simulate(c *gin.Context) {
+	rpm, err := strconv.ParseFloat(c.Query("rpm"), 64)
+	if err != nil || rpm <= 0 {
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_simulation_input")})
+		return
+	}
+	tokens, err := strconv.ParseInt(c.Query("tokens"), 10, 64)
+	if err != nil || tokens <= 0 {
+		c.JSON(400, gin.H{"error": s.t(c, "invalid_simulation_input")})
+		return
+	}
+
+	pool := simulationPool{ConfiguredQuota: s.cfg.Proxy.AccountDailyTokens}
+	store := s.oauthClient.AccountStore()
+	ids, _ := store.List()
+	for _, id := range ids {
+		account, err := store.Load(id)
+		if err != nil || !account.Enable {
+			continue
+		}
+		pool.Accounts++
+		pool.TodayTokens = append(pool.TodayTokens, s.usageStore.TodayTokens(id))
+	}
+	if pool.History, err = s.usageStore.GetUsageHistory(simulationHistoryDays); err != nil {
+		s.logger.Warn("Failed to load usage history for simulation", zap.Error(err))
+	}
+
+	c.JSON(200, simulatePool(simulationInput{RequestsPerMinute: rpm, TokensPerRequest: tokens}, pool))
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/antigravity/api-proxy/internal/embed"
+	"github.com/antigravity/api-proxy/internal/i18n"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
@@ -35,17 +36,18 @@ func (s *Server) setupStaticFiles() {
 	s.logger.Warn("No public files found (embedded or external)")
 	// 提供一个简单的fallback页面
 	s.router.GET("/ui", func(c *gin.Context) {
-		c.HTML(404, "", gin.H{})
-		c.Writer.WriteString(`
-			<html>
-			<head><title>Admin Panel Not Found</title></head>
+		lang := s.lang(c)
+		title := i18n.T(lang, "page_panel_not_found")
+		c.Data(404, "text/html; charset=utf-8", []byte(`
+			<html lang="`+lang+`">
+			<head><meta charset="utf-8"><title>`+title+`</title></head>
 			<body style="font-family: Arial; padding: 50px; text-align: center;">
-				<h1>❌ Admin Panel Not Found</h1>
-				<p>The admin panel files are not embedded in this build.</p>
-				<p>Please rebuild with: <code>make build</code></p>
-				<p>API endpoints are available at: <code>/admin/*</code></p>
+				<h1>❌ `+title+`</h1>
+				<p>`+i18n.T(lang, "page_panel_not_embedded")+`</p>
+				<p>`+i18n.T(lang, "page_panel_rebuild")+` <code>make build</code></p>
+				<p>`+i18n.T(lang, "page_panel_api")+` <code>/admin/*</code></p>
 			</body>
 			</html>
-		`)
+		`))
 	})
 }
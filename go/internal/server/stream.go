@@ -0,0 +1,270 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/antigravity/api-proxy/internal/sse"
+	"github.com/google/uuid"
+)
+
+// 流式响应管道：解析SSE → 转换parts → 后处理 → 编码输出
+// 每个阶段都可以替换或扩展，新功能以stage形式接入，而不是继续堆在handler里
+
+// streamStage post-processes translated chunks.
+// Process may drop (return nil), pass through or expand a chunk;
+// Flush is called once after the upstream stream ends so stateful stages can
+// emit anything they buffered.
+type streamStage interface {
+	Process(chunk *models.ChatCompletionChunk) []*models.ChatCompletionChunk
+	Flush() []*models.ChatCompletionChunk
+}
+
+// streamStageFunc adapts a stateless function to a streamStage
+type streamStageFunc func(chunk *models.ChatCompletionChunk) []*models.ChatCompletionChunk
+
+// Process calls f(chunk)
+func (f streamStageFunc) Process(chunk *models.ChatCompletionChunk) []*models.ChatCompletionChunk {
+	return f(chunk)
+}
+
+// Flush has nothing buffered for stateless stages
+func (f streamStageFunc) Flush() []*models.ChatCompletionChunk {
+	return nil
+}
+
+// streamInterrupter is implemented by stages that end the stream differently
+// when upstream broke off; Interrupt is called before Flush
+type streamInterrupter interface {
+	Interrupt()
+}
+
+// streamEncoder writes one chunk to the client
+type streamEncoder func(chunk *models.ChatCompletionChunk) error
+
+// streamPipeline wires the stages together
+type streamPipeline struct {
+	translate sse.Translator
+	stages    []streamStage
+	encode    streamEncoder
+
+	// usage accumulates the token counts reported by upstream
+	usage usageTracker
+	// blocked is set when upstream refused the prompt
+	blocked *models.GooglePromptFeedback
+	// interrupted is the read error when the upstream stream broke off
+	interrupted error
+}
+
+// newStreamPipeline creates a pipeline; stages run in the given order
+func newStreamPipeline(translate sse.Translator, encode streamEncoder, stages ...streamStage) *streamPipeline {
+	return &streamPipeline{
+		translate: translate,
+		stages:    stages,
+		encode:    encode,
+	}
+}
+
+// Use appends a post-processing stage
+func (p *streamPipeline) Use(stage streamStage) {
+	p.stages = append(p.stages, stage)
+}
+
+// Run consumes the upstream SSE body until EOF or [DONE]
+func (p *streamPipeline) Run(body io.Reader) error {
+	events := sse.NewEvents(body)
+	for googleResp := events.Next(); googleResp != nil; googleResp = events.Next() {
+		p.usage.Observe(&googleResp.Response)
+		if feedback := googleResp.Response.PromptFeedback; feedback != nil && feedback.BlockReason != "" {
+			p.blocked = feedback
+		}
+
+		if err := p.emit(p.translate(googleResp), 0); err != nil {
+			return err
+		}
+	}
+
+	// 上游中途断开：已发送的内容保留，各阶段在flush前得知回答不完整
+	if err := events.Err(); err != nil {
+		p.interrupted = err
+		for _, stage := range p.stages {
+			if interrupter, ok := stage.(streamInterrupter); ok {
+				interrupter.Interrupt()
+			}
+		}
+	}
+
+	// 依次flush各阶段，flush输出的chunk只经过后续阶段
+	for i, stage := range p.stages {
+		if err := p.emit(stage.Flush(), i+1); err != nil {
+			return err
+		}
+	}
+
+	return events.Err()
+}
+
+// Usage returns the token counts seen so far
+func (p *streamPipeline) Usage() (inputTokens, outputTokens, totalTokens int64) {
+	return p.usage.Usage()
+}
+
+// Blocked returns the prompt feedback when upstream refused the prompt
+func (p *streamPipeline) Blocked() *models.GooglePromptFeedback {
+	return p.blocked
+}
+
+// Interrupted returns the read error that cut the upstream stream short
+func (p *streamPipeline) Interrupted() error {
+	return p.interrupted
+}
+
+// emit runs chunks through stages[from:] and encodes the survivors
+func (p *streamPipeline) emit(chunks []*models.ChatCompletionChunk, from int) error {
+	for _, stage := range p.stages[from:] {
+		var next []*models.ChatCompletionChunk
+		for _, chunk := range chunks {
+			next = append(next, stage.Process(chunk)...)
+		}
+		chunks = next
+	}
+
+	for _, chunk := range chunks {
+		if err := p.encode(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkFramer frames the stream exactly like OpenAI: every chunk shares one id
+// and created timestamp, the first chunk of each choice carries only the
+// assistant role and the last one only the finish_reason. It must be the last stage.
+// Choices still open when upstream breaks off finish with "error".
+type chunkFramer struct {
+	id      string
+	created int64
+	model   string
+
+	// choices holds the framing state per choice index
+	choices map[int]*framedChoice
+	// interrupted is set when upstream broke off before the end
+	interrupted bool
+}
+
+// framedChoice is what the framer tracks for one choice
+type framedChoice struct {
+	finishReason string
+	toolCalls    bool
+}
+
+func newChunkFramer(model string) *chunkFramer {
+	return &chunkFramer{
+		id:      "chatcmpl-" + uuid.New().String(),
+		created: time.Now().Unix(),
+		model:   model,
+		choices: make(map[int]*framedChoice),
+	}
+}
+
+// start returns the state of a choice, emitting its role chunk the first time
+func (f *chunkFramer) start(index int, out []*models.ChatCompletionChunk) (*framedChoice, []*models.ChatCompletionChunk) {
+	if choice, ok := f.choices[index]; ok {
+		return choice, out
+	}
+	choice := &framedChoice{}
+	f.choices[index] = choice
+	return choice, append(out, f.chunk(index, models.ChatCompletionDelta{Role: "assistant"}, nil))
+}
+
+// Process stamps the chunk and holds back finish reasons until Flush
+func (f *chunkFramer) Process(chunk *models.ChatCompletionChunk) []*models.ChatCompletionChunk {
+	// The first choice always opens the stream
+	_, out := f.start(0, nil)
+
+	empty := true
+	for i := range chunk.Choices {
+		choice := &chunk.Choices[i]
+		var state *framedChoice
+		state, out = f.start(choice.Index, out)
+		if choice.FinishReason != nil {
+			state.finishReason = *choice.FinishReason
+			choice.FinishReason = nil
+		}
+		if len(choice.Delta.ToolCalls) > 0 {
+			state.toolCalls = true
+		}
+		if !emptyDelta(choice.Delta) {
+			empty = false
+		}
+	}
+	if empty {
+		return out
+	}
+
+	chunk.ID, chunk.Created, chunk.Model = f.id, f.created, f.model
+	return append(out, chunk)
+}
+
+// Interrupt marks the stream as cut short
+func (f *chunkFramer) Interrupt() {
+	f.interrupted = true
+}
+
+// Flush emits the terminal finish_reason chunk of every choice
+func (f *chunkFramer) Flush() []*models.ChatCompletionChunk {
+	_, out := f.start(0, nil)
+	indexes := slices.Sorted(maps.Keys(f.choices))
+	for _, index := range indexes {
+		choice := f.choices[index]
+		reason := choice.finishReason
+		switch {
+		case reason == "" && f.interrupted:
+			reason = "error"
+		case reason == "":
+			reason = "stop"
+		case reason == "stop" && choice.toolCalls:
+			reason = "tool_calls"
+		}
+		out = append(out, f.chunk(index, models.ChatCompletionDelta{}, &reason))
+	}
+	return out
+}
+
+// usageChunk is the trailing stream_options.include_usage chunk
+func (f *chunkFramer) usageChunk(usage *models.Usage) *models.ChatCompletionChunk {
+	chunk := f.chunk(0, models.ChatCompletionDelta{}, nil)
+	chunk.Choices = []models.ChatCompletionChunkChoice{}
+	chunk.Usage = usage
+	return chunk
+}
+
+func (f *chunkFramer) chunk(index int, delta models.ChatCompletionDelta, finishReason *string) *models.ChatCompletionChunk {
+	return &models.ChatCompletionChunk{
+		ID:      f.id,
+		Object:  "chat.completion.chunk",
+		Created: f.created,
+		Model:   f.model,
+		Choices: []models.ChatCompletionChunkChoice{{Index: index, Delta: delta, FinishReason: finishReason}},
+	}
+}
+
+func emptyDelta(d models.ChatCompletionDelta) bool {
+	return d.Role == "" && d.Content == "" && d.Reasoning == "" && d.ReasoningSignature == "" &&
+		len(d.ToolCalls) == 0 && len(d.Images) == 0 && len(d.Annotations) == 0
+}
+
+// sseEncoder writes chunks as SSE events through a buffered stream writer
+func sseEncoder(sw *streamWriter) streamEncoder {
+	return func(chunk *models.ChatCompletionChunk) error {
+		respBytes, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		return sw.WriteEvent(respBytes)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// 流式首事件缓冲：流式请求在向客户端写出任何内容之前，先等待上游的第一个数据事件（最多 proxy.stream_first_event_window）。
+// 上游在此之前出错（连接断开、空流、流内 error 事件）时直接换账号重试，客户端感知不到；
+// 超过等待窗口仍没有数据时不再等待，按原样开始转发
+
+// errStreamNoData means the upstream stream failed before its first data event
+var errStreamNoData = errors.New("upstream stream failed before the first event")
+
+// primeResult is what the priming reader saw up to the first data event
+type primeResult struct {
+	// prefix holds every line read so far, including the first data event
+	prefix []byte
+	// readErr is the read error that ended the stream early (io.EOF for a clean end)
+	readErr error
+	// failure is set when the stream failed before any data event
+	failure error
+}
+
+// primeStream reads body up to its first data event. It returns an error
+// wrapping errStreamNoData when the stream fails first; otherwise (or once
+// window elapses without a verdict) it returns a reader replaying the whole stream.
+func primeStream(body io.Reader, window time.Duration) (io.Reader, error) {
+	rest := bufio.NewReader(body)
+	done := make(chan primeResult, 1)
+	go func() {
+		done <- readFirstEvent(rest)
+	}()
+
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		if result.failure != nil {
+			return nil, result.failure
+		}
+		return io.MultiReader(bytes.NewReader(result.prefix), rest), nil
+	case <-timer.C:
+		// Committed: whatever happens now is handled by the normal stream code
+		return &lateStream{done: done, rest: rest}, nil
+	}
+}
+
+// readFirstEvent reads lines until the first data event or the end of the stream
+func readFirstEvent(r *bufio.Reader) primeResult {
+	var result primeResult
+	for {
+		line, err := r.ReadBytes('\n')
+		result.prefix = append(result.prefix, line...)
+		if data, ok := strings.CutPrefix(strings.TrimSpace(string(line)), "data: "); ok {
+			if problem := streamEventError(data); problem != "" {
+				result.failure = fmt.Errorf("%w: %s", errStreamNoData, problem)
+			}
+			return result
+		}
+		if err != nil {
+			result.readErr = err
+			if errors.Is(err, io.EOF) {
+				result.failure = fmt.Errorf("%w: stream ended", errStreamNoData)
+			} else {
+				result.failure = fmt.Errorf("%w: %v", errStreamNoData, err)
+			}
+			return result
+		}
+	}
+}
+
+// streamEventError describes a data event that reports a failure instead of content
+func streamEventError(data string) string {
+	if data == "[DONE]" {
+		return "stream ended"
+	}
+	var event struct {
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		} `json:"error"`
+	}
+	if json.Unmarshal([]byte(data), &event) != nil || event.Error == nil {
+		return ""
+	}
+	return fmt.Sprintf("error event %d %s: %s", event.Error.Code, event.Error.Status, event.Error.Message)
+}
+
+// lateStream replays a stream whose first event had not arrived within the window
+type lateStream struct {
+	done   chan primeResult
+	rest   io.Reader
+	reader io.Reader
+}
+
+func (s *lateStream) Read(p []byte) (int, error) {
+	if s.reader == nil {
+		result := <-s.done
+		tail := s.rest
+		if result.readErr != nil {
+			tail = &errReader{err: result.readErr}
+		}
+		s.reader = io.MultiReader(bytes.NewReader(result.prefix), tail)
+	}
+	return s.reader.Read(p)
+}
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
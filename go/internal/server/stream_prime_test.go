@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrimeStream(t *testing.T) {
+	const events = ": keep-alive\n\ndata: {\"response\":{}}\n\ndata: {\"response\":{}}\n\n"
+	r, err := primeStream(strings.NewReader(events), time.Second)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, events, string(data), "the buffered prefix is replayed")
+
+	_, err = primeStream(strings.NewReader(": keep-alive\n\n"), time.Second)
+	assert.ErrorIs(t, err, errStreamNoData)
+
+	_, err = primeStream(strings.NewReader("data: {\"error\":{\"code\":503,\"status\":\"UNAVAILABLE\",\"message\":\"busy\"}}\n\n"), time.Second)
+	assert.ErrorIs(t, err, errStreamNoData)
+	assert.Contains(t, err.Error(), "busy")
+}
+
+func TestPrimeStream_WindowElapses(t *testing.T) {
+	pr, pw := io.Pipe()
+	r, err := primeStream(pr, 20*time.Millisecond)
+	require.NoError(t, err, "a slow first event commits to the stream instead of failing")
+
+	go func() {
+		pw.Write([]byte("data: {}\n\n"))
+		pw.CloseWithError(errors.New("connection reset"))
+	}()
+	data, err := io.ReadAll(r)
+	assert.Equal(t, "data: {}\n\n", string(data))
+	assert.EqualError(t, err, "connection reset")
+
+	// A stream that fails after the window surfaces the original read error
+	pr, pw = io.Pipe()
+	r, err = primeStream(pr, 20*time.Millisecond)
+	require.NoError(t, err)
+	pw.CloseWithError(errors.New("connection reset"))
+	_, err = io.ReadAll(r)
+	assert.EqualError(t, err, "connection reset")
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/antigravity/api-proxy/internal/sse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamPipeline_Stages(t *testing.T) {
+	body := strings.NewReader(
+		`data: {"response":{"candidates":[{"content":{"role":"model","parts":[{"text":"Hello"},{"text":""}]}}]}}` + "\n\n" +
+			`data: {"response":{"candidates":[{"content":{"role":"model","parts":[{"text":" world"}]}}],"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":2,"totalTokenCount":5}}}` + "\n\n" +
+			"data: [DONE]\n\n")
+
+	var out []string
+	encode := func(chunk *models.ChatCompletionChunk) error {
+		out = append(out, chunk.Choices[0].Delta.Content)
+		return nil
+	}
+
+	// Drop empty deltas
+	dropEmpty := streamStageFunc(func(chunk *models.ChatCompletionChunk) []*models.ChatCompletionChunk {
+		if chunk.Choices[0].Delta.Content == "" {
+			return nil
+		}
+		return []*models.ChatCompletionChunk{chunk}
+	})
+
+	pipeline := newStreamPipeline(sse.ToChunks("test-model"), encode, dropEmpty)
+	require.NoError(t, pipeline.Run(body))
+
+	assert.Equal(t, []string{"Hello", " world"}, out)
+
+	input, output, total := pipeline.Usage()
+	assert.Equal(t, int64(3), input)
+	assert.Equal(t, int64(2), output)
+	assert.Equal(t, int64(5), total)
+}
+
+func TestStreamWriter_CoalescesAndFlushes(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := newStreamWriter(rec)
+
+	require.NoError(t, sw.WriteEvent([]byte(`{"a":1}`)))
+	require.NoError(t, sw.WriteEvent([]byte(`{"b":2}`)))
+
+	// Nothing reaches the client before the coalescing window closes
+	assert.Equal(t, 0, rec.Body.Len())
+
+	require.NoError(t, sw.Close())
+	assert.Equal(t, "data: {\"a\":1}\n\ndata: {\"b\":2}\n\n", rec.Body.String())
+	assert.True(t, rec.Flushed)
+
+	assert.ErrorIs(t, sw.WriteEvent([]byte("late")), errStreamClosed)
+}
+
+func TestStreamWriter_HeartbeatWhileIdle(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := newStreamWriter(rec)
+	sw.StartHeartbeat(20 * time.Millisecond)
+
+	// Silent upstream: pings go out on their own
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, sw.WriteEvent([]byte(`{"a":1}`)))
+	require.NoError(t, sw.Close())
+
+	body := rec.Body.String()
+	assert.GreaterOrEqual(t, strings.Count(body, ": ping\n\n"), 1)
+	assert.True(t, strings.HasSuffix(body, "data: {\"a\":1}\n\n"))
+
+	// No pings after Close
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, body, rec.Body.String())
+}
+
+func TestStreamWriter_FlushPolicy(t *testing.T) {
+	// No coalescing: every event goes out at once
+	rec := httptest.NewRecorder()
+	sw := newStreamWriter(rec)
+	sw.SetFlushPolicy(-1, 0)
+	require.NoError(t, sw.WriteEvent([]byte(`{"a":1}`)))
+	assert.Equal(t, "data: {\"a\":1}\n\n", rec.Body.String())
+	assert.True(t, rec.Flushed)
+
+	// A long window still flushes once enough bytes are buffered
+	rec = httptest.NewRecorder()
+	sw = newStreamWriter(rec)
+	sw.SetFlushPolicy(time.Hour, 20)
+	require.NoError(t, sw.WriteEvent([]byte(`{"a":1}`)))
+	assert.Zero(t, rec.Body.Len())
+	require.NoError(t, sw.WriteEvent([]byte(`{"b":2}`)))
+	assert.Equal(t, "data: {\"a\":1}\n\ndata: {\"b\":2}\n\n", rec.Body.String())
+	require.NoError(t, sw.Close())
+}
+
+// plainWriter is a ResponseWriter without http.Flusher
+type plainWriter struct {
+	header http.Header
+	body   strings.Builder
+}
+
+func (w *plainWriter) Header() http.Header         { return w.header }
+func (w *plainWriter) Write(p []byte) (int, error) { return w.body.Write(p) }
+func (w *plainWriter) WriteHeader(int)             {}
+
+func TestStreamWriter_WithoutFlusher(t *testing.T) {
+	w := &plainWriter{header: http.Header{}}
+	sw := newStreamWriter(w)
+	sw.SetFlushPolicy(-1, 0)
+	require.NoError(t, sw.WriteEvent([]byte(`{"a":1}`)), "a writer that cannot flush is not an error")
+	require.NoError(t, sw.Close())
+	assert.Equal(t, "data: {\"a\":1}\n\n", w.body.String())
+}
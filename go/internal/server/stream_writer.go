@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// streamBufferSize is the write buffer kept per streaming connection
+	streamBufferSize = 16 * 1024
+	// streamFlushInterval bounds how long an event may sit in the buffer;
+	// events arriving within this window are coalesced into one flush.
+	// proxy.stream_flush_interval overrides it
+	streamFlushInterval = 50 * time.Millisecond
+	// streamWriteTimeout is the per-write deadline for slow clients
+	streamWriteTimeout = 30 * time.Second
+)
+
+var errStreamClosed = errors.New("stream writer closed")
+
+// streamWriter buffers SSE events for one client connection.
+// Writes are coalesced and flushed at most every flushInterval (or once
+// flushBytes are buffered), and each flush carries a write deadline so a slow
+// consumer fails fast instead of pinning the upstream connection indefinitely.
+type streamWriter struct {
+	mu           sync.Mutex
+	w            http.ResponseWriter
+	rc           *http.ResponseController
+	buf          *bufio.Writer
+	writeTimeout time.Duration
+	// flushInterval is the coalescing window; <= 0 flushes every event at once
+	flushInterval time.Duration
+	// flushBytes flushes early once this much is buffered; 0 waits for the window
+	flushBytes int
+	// canFlush is false for writers without http.Flusher: buffered data is
+	// still written, it just cannot be pushed to the client early
+	canFlush bool
+	timer    *time.Timer
+	err      error
+	closed   bool
+
+	// heartbeat fires after heartbeatInterval without events (nil when disabled)
+	heartbeat         *time.Timer
+	heartbeatInterval time.Duration
+}
+
+// newStreamWriter wraps w with buffering and write deadlines
+func newStreamWriter(w http.ResponseWriter) *streamWriter {
+	return &streamWriter{
+		w:             w,
+		rc:            http.NewResponseController(w),
+		buf:           bufio.NewWriterSize(w, streamBufferSize),
+		writeTimeout:  streamWriteTimeout,
+		flushInterval: streamFlushInterval,
+		canFlush:      canFlush(w),
+	}
+}
+
+// canFlush reports whether w, or a writer it wraps, implements http.Flusher
+func canFlush(w http.ResponseWriter) bool {
+	for {
+		if _, ok := w.(http.Flusher); ok {
+			return true
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// SetFlushPolicy replaces the coalescing window and the early-flush size; see
+// the flushInterval and flushBytes fields. Call it before the first event.
+func (sw *streamWriter) SetFlushPolicy(interval time.Duration, bytes int) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.flushInterval = interval
+	sw.flushBytes = bytes
+	if bytes > sw.buf.Size() {
+		sw.buf = bufio.NewWriterSize(sw.w, bytes)
+	}
+}
+
+// WriteEvent queues one SSE "data:" event
+func (sw *streamWriter) WriteEvent(data []byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	if sw.err != nil {
+		return sw.err
+	}
+	if sw.closed {
+		return errStreamClosed
+	}
+
+	if err := sw.setDeadline(); err != nil {
+		return err
+	}
+	sw.buf.WriteString("data: ")
+	sw.buf.Write(data)
+	if _, err := sw.buf.WriteString("\n\n"); err != nil {
+		sw.err = err
+		return err
+	}
+
+	if sw.heartbeat != nil {
+		sw.heartbeat.Reset(sw.heartbeatInterval)
+	}
+
+	// 不合并或已攒够 flushBytes 时立即输出；否则没有挂起的定时flush时才安排一次，窗口内的后续事件合并输出
+	if sw.flushInterval <= 0 || (sw.flushBytes > 0 && sw.buf.Buffered() >= sw.flushBytes) {
+		return sw.flushLocked()
+	}
+	if sw.timer == nil {
+		sw.timer = time.AfterFunc(sw.flushInterval, sw.timedFlush)
+	}
+	return nil
+}
+
+// StartHeartbeat sends an SSE comment line (": ping") whenever no event was
+// written for interval, so idle-timeout proxies (nginx, Cloudflare) keep the
+// connection open while a thinking model is silent. interval <= 0 disables it.
+func (sw *streamWriter) StartHeartbeat(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.closed || sw.heartbeat != nil {
+		return
+	}
+	sw.heartbeatInterval = interval
+	sw.heartbeat = time.AfterFunc(interval, sw.ping)
+}
+
+func (sw *streamWriter) ping() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.closed || sw.err != nil {
+		return
+	}
+	if err := sw.setDeadline(); err != nil {
+		return
+	}
+	if _, err := sw.buf.WriteString(": ping\n\n"); err != nil {
+		sw.err = err
+		return
+	}
+	if sw.flushLocked() == nil {
+		sw.heartbeat.Reset(sw.heartbeatInterval)
+	}
+}
+
+// Flush writes out everything buffered so far
+func (sw *streamWriter) Flush() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.flushLocked()
+}
+
+// Close flushes pending events and stops the flush timer.
+// The underlying ResponseWriter is left open.
+func (sw *streamWriter) Close() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	err := sw.flushLocked()
+	sw.closed = true
+	if sw.heartbeat != nil {
+		sw.heartbeat.Stop()
+	}
+	// Clear the deadline so later writes by the handler are not affected
+	_ = sw.rc.SetWriteDeadline(time.Time{})
+	return err
+}
+
+func (sw *streamWriter) timedFlush() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.timer = nil
+	if !sw.closed {
+		sw.flushLocked()
+	}
+}
+
+func (sw *streamWriter) flushLocked() error {
+	if sw.timer != nil {
+		sw.timer.Stop()
+		sw.timer = nil
+	}
+	if sw.err != nil {
+		return sw.err
+	}
+	if sw.buf.Buffered() == 0 {
+		return nil
+	}
+
+	if err := sw.setDeadline(); err != nil {
+		return err
+	}
+	if err := sw.buf.Flush(); err != nil {
+		sw.err = err
+		return err
+	}
+	if !sw.canFlush {
+		return nil
+	}
+	if err := sw.rc.Flush(); err != nil {
+		sw.err = err
+		return err
+	}
+	return nil
+}
+
+// setDeadline extends the connection write deadline before each write.
+// Writers that don't support deadlines (e.g. httptest recorders) are ignored.
+func (sw *streamWriter) setDeadline() error {
+	if sw.writeTimeout <= 0 {
+		return nil
+	}
+	err := sw.rc.SetWriteDeadline(time.Now().Add(sw.writeTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		sw.err = err
+		return err
+	}
+	return nil
+}
+
+// streamHeartbeat is the configured keepalive interval (<= 0 disables heartbeats)
+func (s *Server) streamHeartbeat() time.Duration {
+	if s.cfg == nil {
+		return 0
+	}
+	return s.cfg.Proxy.StreamHeartbeat
+}
+
+// newClientStream starts an SSE writer with the configured flush policy and heartbeat
+func (s *Server) newClientStream(w http.ResponseWriter) *streamWriter {
+	sw := newStreamWriter(w)
+	if s.cfg != nil {
+		interval := s.cfg.Proxy.StreamFlushInterval
+		if interval == 0 {
+			interval = streamFlushInterval
+		}
+		sw.SetFlushPolicy(interval, s.cfg.Proxy.StreamFlushBytes)
+	}
+	sw.StartHeartbeat(s.streamHeartbeat())
+	return sw
+}
+
+// streamFirstEventWindow is how long a stream may be held back waiting for its
+// first upstream event; 0 disables the buffering
+func (s *Server) streamFirstEventWindow() time.Duration {
+	if s.cfg == nil || s.cfg.Proxy.StreamFirstEventWindow < 0 {
+		return 0
+	}
+	return s.cfg.Proxy.StreamFirstEventWindow
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// 严格结构化输出：json_schema 且 strict: true 的非流式请求，在返回前按 schema 校验模型输出。
+// 先尝试本地修复（去掉 ```json 代码块和前后多余文字），仍不符合时把错误信息发回模型重新生成，
+// 次数由 proxy.structured_output_repairs 控制，全部失败返回 502，客户端不会拿到无法解析的 JSON。
+// 流式请求的输出无法撤回：json_object / json_schema 流结束时校验累计的输出，无效时在 [DONE] 之前追加 error 事件
+
+// structuredOutputKey holds the *structuredOutput of a strict json_schema request
+const structuredOutputKey = "structured_output"
+
+// errSchemaMismatch makes runAttempt retry with a repair prompt
+var errSchemaMismatch = errors.New("response does not match the requested JSON schema")
+
+// structuredOutput tracks validation and repair of one strict json_schema request
+type structuredOutput struct {
+	schema  map[string]interface{}
+	repairs int // repair attempts left
+	// rejected and problem describe the last invalid output; the next attempt asks the model to fix it
+	rejected string
+	problem  string
+}
+
+// checkResponseFormat rejects response formats the proxy can't honor
+func checkResponseFormat(format *models.ResponseFormat) bool {
+	if format == nil {
+		return true
+	}
+	switch format.Type {
+	case "", "text", "json_object":
+		return true
+	case "json_schema":
+		return format.JSONSchema != nil && format.JSONSchema.Schema != nil
+	}
+	return false
+}
+
+// applyResponseFormat maps response_format onto the Gemini generation config
+func applyResponseFormat(format *models.ResponseFormat, genConfig *models.GoogleGenerationConfig) {
+	if format == nil {
+		return
+	}
+	switch format.Type {
+	case "json_object":
+		genConfig.ResponseMimeType = "application/json"
+	case "json_schema":
+		genConfig.ResponseMimeType = "application/json"
+		if format.JSONSchema != nil {
+			genConfig.ResponseJSONSchema = format.JSONSchema.Schema
+		}
+	}
+}
+
+// newStructuredOutput returns the validation state for a strict json_schema
+// request, or nil when the response is not validated. Streamed output can't be
+// taken back once sent, so only non-streaming requests are validated.
+func (s *Server) newStructuredOutput(req *models.ChatCompletionRequest) *structuredOutput {
+	format := req.ResponseFormat
+	if req.Stream || format == nil || format.Type != "json_schema" || format.JSONSchema == nil || !format.JSONSchema.Strict {
+		return nil
+	}
+	repairs := 0
+	if s.cfg != nil && s.cfg.Proxy.StructuredOutputRepairs > 0 {
+		repairs = s.cfg.Proxy.StructuredOutputRepairs
+	}
+	return &structuredOutput{schema: format.JSONSchema.Schema, repairs: repairs}
+}
+
+// check validates content and returns the (possibly locally repaired) JSON
+func (so *structuredOutput) check(content string) (string, error) {
+	candidates := []string{content}
+	if repaired := extractJSON(content); repaired != content {
+		candidates = append(candidates, repaired)
+	}
+	var firstErr error
+	for _, candidate := range candidates {
+		var value interface{}
+		err := json.Unmarshal([]byte(candidate), &value)
+		if err == nil {
+			err = validateJSONSchema(so.schema, value)
+		} else {
+			err = errors.New("output is not valid JSON: " + err.Error())
+		}
+		if err == nil {
+			return candidate, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return "", firstErr
+}
+
+// reject remembers an invalid output for the repair prompt. ok is false when
+// no repair attempts are left.
+func (so *structuredOutput) reject(content string, problem error) bool {
+	if so.repairs <= 0 {
+		return false
+	}
+	so.repairs--
+	so.rejected, so.problem = content, problem.Error()
+	return true
+}
+
+// repairMessages appends the rejected output and the validation error to the
+// conversation so the model can correct itself
+func (so *structuredOutput) repairMessages(messages []models.ChatCompletionMessage) []models.ChatCompletionMessage {
+	if so == nil || so.problem == "" {
+		return messages
+	}
+	out := make([]models.ChatCompletionMessage, 0, len(messages)+2)
+	out = append(out, messages...)
+	return append(out,
+		models.ChatCompletionMessage{Role: "assistant", Content: so.rejected},
+		models.ChatCompletionMessage{Role: "user", Content: "Your previous response did not match the required JSON schema (" + so.problem +
+			"). Reply again with only the corrected JSON value, without code fences or commentary."},
+	)
+}
+
+// extractJSON strips Markdown code fences and text around the outermost JSON
+// object or array
+func extractJSON(content string) string {
+	text := strings.TrimSpace(content)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```")
+		if newline := strings.IndexByte(text, '\n'); newline >= 0 {
+			text = text[newline+1:] // language tag, e.g. ```json
+		}
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+	}
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return text
+	}
+	closing := byte('}')
+	if text[start] == '[' {
+		closing = ']'
+	}
+	if end := strings.LastIndexByte(text, closing); end > start {
+		return text[start : end+1]
+	}
+	return text
+}
+
+// jsonStreamCheck validates the streamed output of a JSON response_format.
+// Chunks already sent can't be repaired, so the stream only reports the
+// problem: the handler appends an error event before [DONE].
+type jsonStreamCheck struct {
+	// schema is only set for strict json_schema requests
+	schema    map[string]interface{}
+	text      strings.Builder
+	toolCalls bool
+}
+
+// newJSONStreamCheck returns a check for json_object and json_schema streams, or nil
+func newJSONStreamCheck(format *models.ResponseFormat) *jsonStreamCheck {
+	if format == nil || (format.Type != "json_object" && format.Type != "json_schema") {
+		return nil
+	}
+	check := &jsonStreamCheck{}
+	if format.Type == "json_schema" && format.JSONSchema != nil && format.JSONSchema.Strict {
+		check.schema = format.JSONSchema.Schema
+	}
+	return check
+}
+
+// Process accumulates the content of the first choice; chunks pass through unchanged
+func (j *jsonStreamCheck) Process(chunk *models.ChatCompletionChunk) []*models.ChatCompletionChunk {
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		j.text.WriteString(choice.Delta.Content)
+		if len(choice.Delta.ToolCalls) > 0 {
+			j.toolCalls = true
+		}
+	}
+	return []*models.ChatCompletionChunk{chunk}
+}
+
+// Flush has nothing buffered
+func (j *jsonStreamCheck) Flush() []*models.ChatCompletionChunk {
+	return nil
+}
+
+// Problem validates the accumulated output. errSchemaMismatch marks output
+// that is valid JSON but does not match a strict schema.
+func (j *jsonStreamCheck) Problem() error {
+	content := strings.TrimSpace(j.text.String())
+	if content == "" && j.toolCalls {
+		// The model called a tool instead of answering
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return errors.New("output is not valid JSON: " + err.Error())
+	}
+	if j.schema != nil {
+		if err := validateJSONSchema(j.schema, value); err != nil {
+			return fmt.Errorf("%w: %v", errSchemaMismatch, err)
+		}
+	}
+	return nil
+}
+
+// structuredOutputFor returns the validation state stored on the request, if any
+func structuredOutputFor(c *gin.Context) *structuredOutput {
+	so, _ := c.Value(structuredOutputKey).(*structuredOutput)
+	return so
+}
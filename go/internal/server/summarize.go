@@ -0,0 +1,285 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/antigravity/api-proxy/internal/oauth"
+	"github.com/antigravity/api-proxy/internal/upstream"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// 对话摘要：POST /v1/summarize 用低成本模型（默认 proxy.context_summary_model）把一段消息压缩为摘要，
+// 长时间运行的 Agent 可以用返回的 message 替换早期消息，控制上下文长度；密钥的 summarize 裁剪策略也使用这里的实现
+
+// defaultSummaryInstructions is the system prompt of a summary request
+const defaultSummaryInstructions = "Summarize the following conversation excerpt for the assistant that continues it. " +
+	"Keep facts, decisions, names, numbers, code identifiers and open questions; omit pleasantries. Answer with the summary only."
+
+// summaryMessage wraps a summary as a system message that replaces the summarized turns
+func summaryMessage(summary string) models.ChatCompletionMessage {
+	return models.ChatCompletionMessage{
+		Role:    "system",
+		Content: "Summary of the earlier part of this conversation, which was shortened to fit the context window:\n" + summary,
+	}
+}
+
+// summarizeRequest is the body of POST /v1/summarize
+type summarizeRequest struct {
+	Messages []models.ChatCompletionMessage `json:"messages"`
+	// Model defaults to proxy.context_summary_model
+	Model string `json:"model,omitempty"`
+	// Instructions replaces the default summarization prompt
+	Instructions string `json:"instructions,omitempty"`
+	MaxTokens    *int   `json:"max_tokens,omitempty"`
+}
+
+// summarizeResponse carries the summary and a ready-to-use replacement message
+type summarizeResponse struct {
+	ID      string                       `json:"id"`
+	Object  string                       `json:"object"` // always "summary"
+	Created int64                        `json:"created"`
+	Model   string                       `json:"model"`
+	Summary string                       `json:"summary"`
+	Message models.ChatCompletionMessage `json:"message"`
+	Usage   *models.Usage                `json:"usage"`
+}
+
+// summarize handles POST /v1/summarize
+func (s *Server) summarize(c *gin.Context) {
+	var req summarizeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		openAIError(c, 400, "invalid_request", "Invalid request body: "+err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		apiError(c, 400, models.ErrorDetail{Message: "messages must not be empty", Type: errTypeInvalidRequest, Param: "messages", Code: "invalid_request"})
+		return
+	}
+
+	requested := req.Model
+	if requested == "" {
+		requested = s.cfg.Proxy.ContextSummaryModel
+	}
+	model, _ := s.route(requested)
+	if !keyAllowsModel(c, requested, model) {
+		apiError(c, 403, models.ErrorDetail{
+			Message: s.t(c, "model_not_allowed"),
+			Type:    errTypePermission,
+			Param:   "model",
+			Code:    "model_not_allowed",
+		})
+		return
+	}
+
+	timeout, err := s.requestTimeout(c, 0)
+	if err != nil {
+		openAIError(c, 400, "invalid_timeout", err.Error())
+		return
+	}
+	cancel := withDeadline(c, timeout)
+	defer cancel()
+
+	summary, usage, err := s.summarizeMessages(c, model, req.Instructions, req.MaxTokens, req.Messages)
+	if err != nil {
+		helperCallError(c, err, timeout, "Failed to summarize the messages")
+		return
+	}
+
+	c.JSON(200, summarizeResponse{
+		ID:      "sum-" + uuid.New().String(),
+		Object:  "summary",
+		Created: time.Now().Unix(),
+		Model:   requested,
+		Summary: summary,
+		Message: summaryMessage(summary),
+		Usage:   usage,
+	})
+}
+
+// summarizeMessages condenses messages with model; empty instructions use the
+// default summarization prompt. The call is recorded like any other request.
+func (s *Server) summarizeMessages(c *gin.Context, model, instructions string, maxTokens *int, messages []models.ChatCompletionMessage) (string, *models.Usage, error) {
+	// The transcript must itself fit the summary model; keep its beginning and end
+	text := conversationTranscript(messages)
+	if window := s.modelCapabilities(model).MaxInputTokens; window > summaryReserveTokens {
+		text, _ = truncateToolResult(text, (window-summaryReserveTokens)*2, true)
+	}
+	if instructions == "" {
+		instructions = defaultSummaryInstructions
+	}
+
+	resp, usage, err := s.generateOnce(c, s.transformRequest(&models.ChatCompletionRequest{
+		Model:     model,
+		MaxTokens: maxTokens,
+		Messages: []models.ChatCompletionMessage{
+			{Role: "system", Content: instructions},
+			{Role: "user", Content: text},
+		},
+	}))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var summary strings.Builder
+	if len(resp.Candidates) > 0 {
+		for _, part := range resp.Candidates[0].Content.Parts {
+			if !part.Thought {
+				summary.WriteString(part.Text)
+			}
+		}
+	}
+	if strings.TrimSpace(summary.String()) == "" {
+		return "", nil, fmt.Errorf("summary model returned no text")
+	}
+	return strings.TrimSpace(summary.String()), usage, nil
+}
+
+// conversationTranscript renders messages as "role: text" paragraphs for a helper model
+func conversationTranscript(messages []models.ChatCompletionMessage) string {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		text := messageText(msg.Content)
+		for _, call := range msg.ToolCalls {
+			text += "\n[called tool " + call.Function.Name + "]"
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, text)
+	}
+	return transcript.String()
+}
+
+// generateOnce sends a non-streaming request outside the chat retry loop
+// (helper calls such as summaries and moderation) and records its usage.
+// Like chat attempts it holds a slot of the account's concurrency limit,
+// records rate limits and errors on the account and moves on to another
+// account after a 429, a 403 or a retryable error.
+func (s *Server) generateOnce(c *gin.Context, googleReq *models.GoogleRequest) (*models.GoogleResponseInner, *models.Usage, error) {
+	attempts, err := s.requestAttempts(c)
+	if err != nil {
+		attempts = defaultMaxRetries + 1
+	}
+	pr := &proxyRequest{attempted: make(map[string]bool)}
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		account, release, err := s.acquireAccount(c, pr)
+		if err != nil {
+			if lastErr != nil {
+				return nil, nil, lastErr
+			}
+			return nil, nil, err
+		}
+		pr.attempted[account.AccountID] = true
+
+		resp, err := s.generateWith(c, account, googleReq)
+		release()
+		if err == nil {
+			s.oauthClient.AccountStore().RecordSuccess(account)
+			return resp, s.recordResponseUsage(c, account, googleReq.Model, resp), nil
+		}
+		lastErr = err
+		if c.Request.Context().Err() != nil {
+			return nil, nil, err
+		}
+		if !s.penalizeHelperCall(c, account, err) {
+			return nil, nil, err
+		}
+	}
+	return nil, nil, lastErr
+}
+
+// penalizeHelperCall records a failed generateWith call on its account the
+// way chat attempts do and reports whether another account may succeed
+func (s *Server) penalizeHelperCall(c *gin.Context, account *models.Account, err error) bool {
+	var upstreamErr *googleAPIError
+	if !errors.As(err, &upstreamErr) {
+		s.recordFailure(account, fmt.Sprintf("request failed: %v", err))
+		return true
+	}
+	s.penalizeAccount(s.requestLogger(c), account, upstreamErr.status, upstreamErr.header, upstreamErr.body, false)
+	return upstreamErr.status == 429 || upstreamErr.status == 403 || retryableStatus(upstreamErr.status)
+}
+
+// generateWith sends a single non-streaming request with account. Usage is
+// not recorded, so calls may run in parallel.
+func (s *Server) generateWith(c *gin.Context, account *models.Account, googleReq *models.GoogleRequest) (*models.GoogleResponseInner, error) {
+	body, err := json.Marshal(googleReq)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.generateContent(c.Request.Context(), account, body)
+	if err != nil {
+		s.requestLogger(c).Warn("Helper request failed",
+			zap.String("account_id", s.displayAccountID(account.AccountID)),
+			zap.String("model", googleReq.Model),
+			zap.Error(err))
+		return nil, err
+	}
+
+	var resp models.GoogleResponseInner
+	if err := json.Unmarshal(unwrapGeminiResponse(data), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// recordResponseUsage records the usage of a generateWith response
+func (s *Server) recordResponseUsage(c *gin.Context, account *models.Account, model string, resp *models.GoogleResponseInner) *models.Usage {
+	var tracker usageTracker
+	tracker.Observe(resp)
+	inputTokens, outputTokens, totalTokens := tracker.Usage()
+	s.recordUsage(c, account, model, inputTokens, outputTokens, totalTokens)
+	return &models.Usage{PromptTokens: int(inputTokens), CompletionTokens: int(outputTokens), TotalTokens: int(totalTokens)}
+}
+
+// helperCallError answers a failed generateOnce call
+func helperCallError(c *gin.Context, err error, timeout time.Duration, message string) {
+	var upstreamErr *googleAPIError
+	if errors.As(err, &upstreamErr) {
+		if status, detail, ok := translateGoogleError(upstreamErr.status, upstreamErr.body); ok {
+			apiError(c, status, detail)
+			return
+		}
+	}
+	switch {
+	case errors.Is(err, oauth.ErrNoAccounts) || strings.Contains(err.Error(), "no valid accounts available"):
+		apiError(c, 503, models.ErrorDetail{Message: "No usable Google account is available.", Type: errTypeUnavailable, Code: "no_accounts_available"})
+	case timedOut(c):
+		apiError(c, 504, models.ErrorDetail{Message: timeoutMessage(timeout), Type: errTypeServer, Code: "timeout"})
+	default:
+		apiError(c, 502, models.ErrorDetail{Message: message, Type: errTypeUpstream, Code: "upstream_error", Details: err.Error()})
+	}
+}
+
+// generateContent makes a single non-streaming upstream call
+func (s *Server) generateContent(ctx context.Context, account *models.Account, body []byte) ([]byte, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.generateURL(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.setUpstreamHeaders(httpReq, account)
+
+	client, err := s.upstreamClient(account)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := upstream.Do(ctx, client, httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer upstream.DrainAndClose(resp)
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &googleAPIError{status: resp.StatusCode, body: sanitizeUpstreamError(body), header: resp.Header}
+	}
+	return io.ReadAll(resp.Body)
+}
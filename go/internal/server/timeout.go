@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求级超时：整个请求（含所有重试和流式输出）共享一个截止时间。
+// 默认 proxy.request_timeout，客户端可通过 X-Request-Timeout 头或请求体 timeout 字段
+// （秒）覆盖，上限为 proxy.max_request_timeout
+//
+// 使用配置的默认值时，proxy.request_timeout 只限制上游开始响应之前的阶段（选号、重试、排队、等待响应头）；
+// 上游开始响应后改由 proxy.stream_timeout 限制读取整个响应的时长，长时间的生成不会被默认时限截断。
+// 连接建立（proxy.upstream.dial_timeout、tls_handshake_timeout）和每次尝试等待响应头（antigravity.timeout）另有时限。
+// 非流式的 generateContent 请求生成完才返回响应头，发出请求即视为开始响应，不受 antigravity.timeout 限制
+//
+// 重试次数同样可按请求调整：默认 proxy.max_retries，X-Max-Retries 头覆盖，上限为 proxy.max_retries_limit，
+// 批处理任务可以快速失败，交互式客户端则可以多重试几次
+
+// requestTimeoutHeader carries a per-request budget, in seconds or as a Go duration ("90s")
+const requestTimeoutHeader = "X-Request-Timeout"
+
+var errInvalidTimeout = errors.New("timeout must be a positive number of seconds")
+
+// maxRetriesHeader carries a per-request retry count (retries after the first attempt)
+const maxRetriesHeader = "X-Max-Retries"
+
+// Retry defaults when the config leaves them unset
+const (
+	defaultMaxRetries      = 4
+	defaultMaxRetriesLimit = 10
+)
+
+var errInvalidMaxRetries = errors.New(maxRetriesHeader + " must be a non-negative integer")
+
+// requestTimeout resolves the budget for this request; bodySeconds is the
+// request body's timeout field (0 when absent) and wins over the header.
+// It returns 0 when no deadline applies.
+func (s *Server) requestTimeout(c *gin.Context, bodySeconds float64) (time.Duration, error) {
+	var timeout time.Duration
+	if s.cfg != nil && s.cfg.Proxy.RequestTimeout > 0 {
+		timeout = s.cfg.Proxy.RequestTimeout
+	}
+
+	if header := strings.TrimSpace(c.GetHeader(requestTimeoutHeader)); header != "" {
+		d, err := parseTimeout(header)
+		if err != nil {
+			return 0, err
+		}
+		timeout = d
+	}
+	if bodySeconds < 0 {
+		return 0, errInvalidTimeout
+	}
+	if bodySeconds > 0 {
+		timeout = time.Duration(bodySeconds * float64(time.Second))
+	}
+
+	if s.cfg != nil && s.cfg.Proxy.MaxRequestTimeout > 0 && timeout > s.cfg.Proxy.MaxRequestTimeout {
+		timeout = s.cfg.Proxy.MaxRequestTimeout
+	}
+	return timeout, nil
+}
+
+// requestAttempts resolves how many upstream attempts this request gets: one
+// plus the retries from the config or the X-Max-Retries header, capped at
+// proxy.max_retries_limit
+func (s *Server) requestAttempts(c *gin.Context) (int, error) {
+	retries, limit := defaultMaxRetries, defaultMaxRetriesLimit
+	if s.cfg != nil {
+		switch {
+		case s.cfg.Proxy.MaxRetries > 0:
+			retries = s.cfg.Proxy.MaxRetries
+		case s.cfg.Proxy.MaxRetries < 0:
+			retries = 0
+		}
+		if s.cfg.Proxy.MaxRetriesLimit > 0 {
+			limit = s.cfg.Proxy.MaxRetriesLimit
+		}
+	}
+
+	if header := strings.TrimSpace(c.GetHeader(maxRetriesHeader)); header != "" {
+		n, err := strconv.Atoi(header)
+		if err != nil || n < 0 {
+			return 0, errInvalidMaxRetries
+		}
+		retries = min(n, limit)
+	}
+	return retries + 1, nil
+}
+
+// parseTimeout accepts seconds ("30", "1.5") or a Go duration ("90s", "2m")
+func parseTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0, errInvalidTimeout
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, errInvalidTimeout
+	}
+	return d, nil
+}
+
+// withDeadline bounds the request context by timeout (no-op for 0); the
+// returned cancel func must be called when the request finishes
+func withDeadline(c *gin.Context, timeout time.Duration) context.CancelFunc {
+	cancel, _ := withMovableDeadline(c, timeout)
+	return cancel
+}
+
+// withMovableDeadline is withDeadline whose deadline can be replaced later:
+// restart(d) ends the request d from now instead, or never for d <= 0. It
+// has no effect once the deadline has passed.
+func withMovableDeadline(c *gin.Context, timeout time.Duration) (cancel context.CancelFunc, restart func(time.Duration)) {
+	ctx, cancelCause := context.WithCancelCause(c.Request.Context())
+	c.Request = c.Request.WithContext(ctx)
+	expire := func() { cancelCause(context.DeadlineExceeded) }
+
+	var mu sync.Mutex
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, expire)
+	}
+	restart = func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		if timer != nil && !timer.Stop() {
+			return
+		}
+		timer = nil
+		if d > 0 {
+			timer = time.AfterFunc(d, expire)
+		}
+	}
+	cancel = func() {
+		restart(0)
+		cancelCause(context.Canceled)
+	}
+	return cancel, restart
+}
+
+// streamTimeout is the limit on reading a response once the upstream has
+// started answering. It only applies (ok) when the request runs on the
+// configured default budget: a budget the client set bounds the whole request.
+func (s *Server) streamTimeout(c *gin.Context, bodySeconds float64) (timeout time.Duration, ok bool) {
+	if s.cfg == nil || bodySeconds > 0 || strings.TrimSpace(c.GetHeader(requestTimeoutHeader)) != "" {
+		return 0, false
+	}
+	return max(s.cfg.Proxy.StreamTimeout, 0), true
+}
+
+// timedOut reports whether the request's own deadline (not the client) ended it
+func timedOut(c *gin.Context) bool {
+	return errors.Is(context.Cause(c.Request.Context()), context.DeadlineExceeded)
+}
+
+// timeoutMessage describes an expired request budget
+func timeoutMessage(timeout time.Duration) string {
+	return fmt.Sprintf("Request timed out after %s. Raise the budget with the %s header or the timeout field.", timeout, requestTimeoutHeader)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 工具结果截断：客户端常把整个文件或完整命令输出作为工具结果回传，超出上游请求上限后只会得到含糊的400。
+// 转发前把超长的结果截断到 proxy.tool_results.max_chars，并在截断处注明省略了多少字符，模型据此知道内容不完整
+
+// toolResultLimit returns the per-result character limit and whether the tail
+// is kept; limit <= 0 disables truncation
+func (s *Server) toolResultLimit() (limit int, keepTail bool) {
+	if s.cfg == nil {
+		return 0, false
+	}
+	cfg := s.cfg.Proxy.ToolResults
+	return cfg.MaxChars, cfg.Strategy != "head"
+}
+
+// truncateToolResult shortens text to limit characters, keeping the beginning
+// (and end) and marking the omitted middle. ok is false when nothing was cut.
+func truncateToolResult(text string, limit int, keepTail bool) (string, bool) {
+	if limit <= 0 || len(text) <= limit {
+		return text, false
+	}
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text, false
+	}
+	omitted := len(runes) - limit
+	note := fmt.Sprintf("[... tool output truncated: %d of %d characters omitted ...]", omitted, len(runes))
+	if !keepTail {
+		return string(runes[:limit]) + "\n\n" + note, true
+	}
+	head := limit - limit/4
+	return string(runes[:head]) + "\n\n" + note + "\n\n" + string(runes[len(runes)-(limit-head):]), true
+}
+
+// truncateToolMessages truncates oversized tool-role messages in place
+func (s *Server) truncateToolMessages(c *gin.Context, req *models.ChatCompletionRequest) {
+	limit, keepTail := s.toolResultLimit()
+	if limit <= 0 {
+		return
+	}
+	for i := range req.Messages {
+		msg := &req.Messages[i]
+		if msg.Role != "tool" {
+			continue
+		}
+		switch v := msg.Content.(type) {
+		case string:
+			if text, ok := truncateToolResult(v, limit, keepTail); ok {
+				s.logToolTruncation(c, msg.ToolCallID, len(v))
+				msg.Content = text
+			}
+		case []interface{}:
+			for _, item := range v {
+				part, ok := item.(map[string]interface{})
+				if !ok || part["type"] != "text" {
+					continue
+				}
+				original, _ := part["text"].(string)
+				if text, ok := truncateToolResult(original, limit, keepTail); ok {
+					s.logToolTruncation(c, msg.ToolCallID, len(original))
+					part["text"] = text
+				}
+			}
+		}
+	}
+}
+
+// truncateFunctionResponses truncates oversized functionResponse payloads in a
+// native Gemini request body. A truncated response is replaced by its JSON text
+// under "output"; the body is returned unchanged when nothing was cut.
+func (s *Server) truncateFunctionResponses(c *gin.Context, body []byte) []byte {
+	limit, keepTail := s.toolResultLimit()
+	if limit <= 0 || len(body) <= limit || !bytes.Contains(body, []byte(`"functionResponse"`)) {
+		return body
+	}
+
+	var req map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // keep large integers intact when re-encoding
+	if err := decoder.Decode(&req); err != nil {
+		return body
+	}
+
+	changed := false
+	contents, _ := req["contents"].([]interface{})
+	for _, item := range contents {
+		content, _ := item.(map[string]interface{})
+		parts, _ := content["parts"].([]interface{})
+		for _, p := range parts {
+			part, _ := p.(map[string]interface{})
+			fr, _ := part["functionResponse"].(map[string]interface{})
+			if fr == nil {
+				continue
+			}
+			raw, err := json.Marshal(fr["response"])
+			if err != nil {
+				continue
+			}
+			text, ok := truncateToolResult(string(raw), limit, keepTail)
+			if !ok {
+				continue
+			}
+			name, _ := fr["name"].(string)
+			s.logToolTruncation(c, name, len(raw))
+			fr["response"] = map[string]interface{}{"output": text, "truncated": true}
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	out, err := json.Marshal(req)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func (s *Server) logToolTruncation(c *gin.Context, tool string, size int) {
+	s.requestLogger(c).Info("Truncated oversized tool result",
+		zap.String("tool", tool),
+		zap.Int("bytes", size))
+}
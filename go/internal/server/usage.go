@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/antigravity/api-proxy/internal/sse"
+	"github.com/antigravity/api-proxy/internal/upstream"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// 响应token统计：上游的 usageMetadata 是累计值，但并非每个事件都带全部字段，
+// 只取最后一次会在末尾事件缺字段时丢失已报告的数字。这里逐字段保留最大值；
+// 上游尚未报告输出token时逐段估算生成的文本（不缓存全文），报告后即停止估算。
+// 开启 proxy.count_tokens 时精确的提示词token数在响应返回后再向上游查询，不拖慢响应
+
+// usageTracker accumulates token counts across the events of one upstream response
+type usageTracker struct {
+	prompt, candidates, total int64
+	// text estimates the text generated so far per candidate index; only kept
+	// up while upstream hasn't reported candidate tokens
+	text map[int]*tokenEstimator
+	// promptEstimate stands in for prompt when upstream never reported it
+	promptEstimate int64
+}
+
+// Observe records the usage metadata and generated text of one event
+func (u *usageTracker) Observe(resp *models.GoogleResponseInner) {
+	if meta := resp.UsageMetadata; meta != nil {
+		u.prompt = max(u.prompt, int64(meta.PromptTokenCount))
+		u.candidates = max(u.candidates, int64(meta.CandidatesTokenCount))
+		u.total = max(u.total, int64(meta.TotalTokenCount))
+	}
+	if u.candidates > 0 {
+		return
+	}
+	for _, candidate := range resp.Candidates {
+		for _, part := range candidate.Content.Parts {
+			if part.Text == "" {
+				continue
+			}
+			if u.text == nil {
+				u.text = make(map[int]*tokenEstimator)
+			}
+			e, ok := u.text[candidate.Index]
+			if !ok {
+				e = &tokenEstimator{}
+				u.text[candidate.Index] = e
+			}
+			e.Write(part.Text)
+		}
+	}
+}
+
+// Usage returns the token counts; counts upstream never reported are estimated
+// from the prompt and the generated text
+func (u *usageTracker) Usage() (inputTokens, outputTokens, totalTokens int64) {
+	inputTokens = u.prompt
+	if inputTokens == 0 {
+		inputTokens = u.promptEstimate
+	}
+	outputTokens = u.candidates
+	if outputTokens == 0 {
+		for _, e := range u.text {
+			outputTokens += e.Tokens()
+		}
+	}
+	return inputTokens, outputTokens, max(u.total, inputTokens+outputTokens)
+}
+
+// usageResponses observes the usage of each response as it is read
+type usageResponses struct {
+	sse.Responses
+	usage *usageTracker
+}
+
+// observeUsage wraps responses so that usage sees every response
+func observeUsage(responses sse.Responses, usage *usageTracker) sse.Responses {
+	return usageResponses{Responses: responses, usage: usage}
+}
+
+func (r usageResponses) Next() *models.GoogleResponse {
+	resp := r.Responses.Next()
+	if resp != nil {
+		r.usage.Observe(&resp.Response)
+	}
+	return resp
+}
+
+// promptUsageKey holds the *promptUsage of the current request on the gin context
+const promptUsageKey = "prompt_usage"
+
+// promptUsage describes the prompt for usage accounting when upstream omits usageMetadata
+type promptUsage struct {
+	estimate int64
+	// countRequest returns the upstream model being served and its contents,
+	// for an exact count via countTokens
+	countRequest func() (model string, contents json.RawMessage, err error)
+}
+
+// countTokensTimeout bounds the background countTokens call of settleUsage
+const countTokensTimeout = 30 * time.Second
+
+// settleUsage returns the token counts of a finished response and records
+// them. Prompt tokens upstream didn't report are estimated locally; when
+// proxy.count_tokens is on, the exact countTokens result replaces the
+// estimate in the recorded usage, fetched in the background so the response
+// doesn't wait for it.
+func (s *Server) settleUsage(c *gin.Context, account *models.Account, model string, usage *usageTracker) (inputTokens, outputTokens, totalTokens int64) {
+	count := s.completeUsage(c, usage)
+	inputTokens, outputTokens, totalTokens = usage.Usage()
+	record := s.usageRecorder(c)
+	if count == nil {
+		record(account, model, inputTokens, outputTokens, totalTokens)
+		return inputTokens, outputTokens, totalTokens
+	}
+
+	exact := *usage
+	log := s.requestLogger(c)
+	s.usageTasks.Add(1)
+	go func() {
+		defer s.usageTasks.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), countTokensTimeout)
+		defer cancel()
+		if tokens, err := count(ctx, account); err == nil {
+			exact.promptEstimate = tokens
+		} else {
+			log.Debug("countTokens failed, using the estimated prompt tokens",
+				zap.String("account_id", s.displayAccountID(account.AccountID)),
+				zap.Error(err))
+		}
+		inputTokens, outputTokens, totalTokens := exact.Usage()
+		record(account, model, inputTokens, outputTokens, totalTokens)
+	}()
+	return inputTokens, outputTokens, totalTokens
+}
+
+// completeUsage fills in the estimated prompt tokens upstream didn't report.
+// With proxy.count_tokens on it also returns the countTokens call for the
+// exact number, nil otherwise.
+func (s *Server) completeUsage(c *gin.Context, usage *usageTracker) func(ctx context.Context, account *models.Account) (int64, error) {
+	if usage.prompt > 0 {
+		return nil
+	}
+	value, ok := c.Get(promptUsageKey)
+	if !ok {
+		return nil
+	}
+	prompt := value.(*promptUsage)
+	usage.promptEstimate = prompt.estimate
+	if s.cfg == nil || !s.cfg.Proxy.CountTokens || prompt.countRequest == nil {
+		return nil
+	}
+	return func(ctx context.Context, account *models.Account) (int64, error) {
+		model, contents, err := prompt.countRequest()
+		if err != nil {
+			return 0, err
+		}
+		return s.countTokens(ctx, account, model, contents)
+	}
+}
+
+// countTokens asks upstream for the exact token count of contents
+func (s *Server) countTokens(ctx context.Context, account *models.Account, model string, contents json.RawMessage) (int64, error) {
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"request": map[string]interface{}{
+			"model":    "models/" + model,
+			"contents": contents,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	url := strings.Replace(s.upstreamURL, ":streamGenerateContent?alt=sse", ":countTokens", 1)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(reqBody))
+	if err != nil {
+		return 0, err
+	}
+	s.setUpstreamHeaders(httpReq, account)
+
+	client, err := s.upstreamClient(account)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := upstream.Do(ctx, client, httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer upstream.DrainAndClose(resp)
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("countTokens returned HTTP %d", resp.StatusCode)
+	}
+	var result struct {
+		TotalTokens int64 `json:"totalTokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	if result.TotalTokens <= 0 {
+		return 0, fmt.Errorf("countTokens returned no count")
+	}
+	return result.TotalTokens, nil
+}
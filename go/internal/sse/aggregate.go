@@ -0,0 +1,130 @@
+package sse
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/antigravity/api-proxy/internal/models"
+)
+
+// Message is the answer aggregated for one candidate
+type Message struct {
+	// Index is the candidate's index, i.e. the OpenAI choice index
+	Index     int
+	Content   string
+	Reasoning string
+	// Signature is the last thought signature seen
+	Signature string
+	Images    []models.ImagePart
+	// Annotations are the grounding sources followed by the citations
+	Annotations []models.Annotation
+}
+
+// Result is everything aggregated from the responses of one attempt
+type Result struct {
+	// Choices holds one message per candidate ordered by index; there is
+	// always at least the first one
+	Choices []*Message
+	// Blocked is set when upstream refused the prompt
+	Blocked *models.GooglePromptFeedback
+}
+
+// Empty reports whether no candidate produced text or images
+func (r *Result) Empty() bool {
+	for _, msg := range r.Choices {
+		if msg.Content != "" || len(msg.Images) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// messageBuilder collects the parts of one candidate across responses
+type messageBuilder struct {
+	msg                  Message
+	content, reasoning   strings.Builder
+	grounding, citations []models.Annotation
+}
+
+// Aggregate reads all responses and merges every candidate into the message
+// of its index. The error is responses.Err(); the result then holds what was
+// received before the responses broke off.
+func Aggregate(responses Responses) (*Result, error) {
+	result := &Result{}
+	builders := make(map[int]*messageBuilder)
+
+	for resp := responses.Next(); resp != nil; resp = responses.Next() {
+		for i, candidate := range resp.Response.Candidates {
+			index := candidateIndex(i, candidate)
+			b, ok := builders[index]
+			if !ok {
+				b = &messageBuilder{msg: Message{Index: index}}
+				builders[index] = b
+			}
+			for _, part := range candidate.Content.Parts {
+				if part.Text != "" {
+					if part.Thought {
+						b.reasoning.WriteString(part.Text)
+					} else {
+						b.content.WriteString(part.Text)
+					}
+				}
+				if part.ThoughtSignature != "" {
+					b.msg.Signature = part.ThoughtSignature
+				}
+				if part.InlineData != nil && strings.HasPrefix(part.InlineData.MimeType, "image/") {
+					b.msg.Images = append(b.msg.Images, models.NewImagePart(part.InlineData.MimeType, part.InlineData.Data))
+				}
+			}
+			// Grounding metadata arrives with the last chunk and covers the whole answer;
+			// citations are reported on the chunks where recitation happens
+			if candidate.GroundingMetadata != nil {
+				b.grounding = candidate.GroundingMetadata.Annotations()
+			}
+			b.citations = append(b.citations, candidate.CitationMetadata.Annotations()...)
+		}
+
+		if feedback := resp.Response.PromptFeedback; feedback != nil && feedback.BlockReason != "" {
+			result.Blocked = feedback
+		}
+	}
+
+	if _, ok := builders[0]; !ok {
+		builders[0] = &messageBuilder{}
+	}
+	for _, b := range builders {
+		msg := b.msg
+		msg.Content, msg.Reasoning = b.content.String(), b.reasoning.String()
+		msg.Annotations = append(b.grounding, b.citations...)
+		result.Choices = append(result.Choices, &msg)
+	}
+	slices.SortFunc(result.Choices, func(a, b *Message) int { return a.Index - b.Index })
+	return result, responses.Err()
+}
+
+// candidateIndex is the choice index of the i-th candidate of a response.
+// Upstream omits index 0, so a missing index falls back to the position.
+func candidateIndex(i int, candidate models.GoogleCandidate) int {
+	if candidate.Index == 0 {
+		return i
+	}
+	return candidate.Index
+}
+
+// thinkTag matches a <think>...</think> block, newlines included
+var thinkTag = regexp.MustCompile(`(?s)<think>(.*?)</think>`)
+
+// ExtractThinkTag moves a <think>...</think> block out of the content into the
+// reasoning, for models that inline their thoughts instead of sending thought
+// parts. Messages that already have reasoning are left alone.
+func (m *Message) ExtractThinkTag() {
+	if m.Reasoning != "" {
+		return
+	}
+	matches := thinkTag.FindStringSubmatch(m.Content)
+	if len(matches) > 1 {
+		m.Reasoning = strings.TrimSpace(matches[1])
+		m.Content = strings.TrimSpace(strings.Replace(m.Content, matches[0], "", 1))
+	}
+}
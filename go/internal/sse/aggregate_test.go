@@ -0,0 +1,107 @@
+package sse
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// events joins upstream event payloads into an SSE body ending with [DONE]
+func events(payloads ...string) io.Reader {
+	var b strings.Builder
+	for _, payload := range payloads {
+		b.WriteString("data: " + payload + "\n\n")
+	}
+	b.WriteString("data: [DONE]\n\n")
+	return strings.NewReader(b.String())
+}
+
+func TestAggregate(t *testing.T) {
+	result, err := Aggregate(NewEvents(events(
+		`{"response":{"candidates":[{"content":{"parts":[{"text":"Let me think","thought":true}]}}]}}`,
+		`{"response":{"candidates":[{"content":{"parts":[{"text":"Hello","thoughtSignature":"sig-1"}]}}]}}`,
+		`{"response":{"candidates":[{"content":{"parts":[{"text":" world"},{"inlineData":{"mimeType":"image/png","data":"AAAA"}}]},`+
+			`"citationMetadata":{"citations":[{"startIndex":0,"endIndex":5,"uri":"https://example.com/cited"}]}}]}}`,
+		`{"response":{"candidates":[{"content":{"parts":[]},"finishReason":"STOP",`+
+			`"groundingMetadata":{"groundingChunks":[{"web":{"uri":"https://example.com/found","title":"Found"}}],`+
+			`"groundingSupports":[{"segment":{"startIndex":0,"endIndex":5},"groundingChunkIndices":[0]}]}}]}}`,
+	)))
+	require.NoError(t, err)
+	assert.Nil(t, result.Blocked)
+	require.Len(t, result.Choices, 1)
+	msg := result.Choices[0]
+
+	assert.Equal(t, "Hello world", msg.Content)
+	assert.Equal(t, "Let me think", msg.Reasoning)
+	assert.Equal(t, "sig-1", msg.Signature)
+	require.Len(t, msg.Images, 1)
+
+	// Grounding sources come before citations
+	require.Len(t, msg.Annotations, 2)
+	assert.Equal(t, "https://example.com/found", msg.Annotations[0].URLCitation.URL)
+	assert.Equal(t, "https://example.com/cited", msg.Annotations[1].URLCitation.URL)
+}
+
+func TestAggregate_Blocked(t *testing.T) {
+	result, err := Aggregate(NewEvents(events(`{"response":{"promptFeedback":{"blockReason":"SAFETY"}}}`)))
+	require.NoError(t, err)
+	require.NotNil(t, result.Blocked)
+	assert.Equal(t, "SAFETY", result.Blocked.BlockReason)
+	assert.True(t, result.Empty())
+	require.Len(t, result.Choices, 1, "the first choice is always there")
+}
+
+func TestAggregate_MultipleCandidates(t *testing.T) {
+	result, err := Aggregate(NewEvents(events(
+		`{"response":{"candidates":[{"content":{"parts":[{"text":"A1"}]}},{"index":1,"content":{"parts":[{"text":"B1"}]}}]}}`,
+		// Only the second candidate continues; without an index the position counts
+		`{"response":{"candidates":[{"index":1,"content":{"parts":[{"text":" B2"}]}}]}}`,
+		`{"response":{"candidates":[{"content":{"parts":[{"text":" A2"}]}},{"content":{"parts":[{"text":" B3"}]}}]}}`,
+	)))
+	require.NoError(t, err)
+	require.Len(t, result.Choices, 2)
+	assert.Equal(t, 0, result.Choices[0].Index)
+	assert.Equal(t, "A1 A2", result.Choices[0].Content)
+	assert.Equal(t, 1, result.Choices[1].Index)
+	assert.Equal(t, "B1 B2 B3", result.Choices[1].Content)
+	assert.False(t, result.Empty())
+}
+
+// brokenReader returns its data, then a read error instead of EOF
+type brokenReader struct {
+	data io.Reader
+}
+
+var errBroken = errors.New("connection reset")
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, errBroken
+	}
+	return n, err
+}
+
+func TestAggregate_KeepsPartialAnswer(t *testing.T) {
+	body := &brokenReader{data: strings.NewReader(`data: {"response":{"candidates":[{"content":{"parts":[{"text":"partial"}]}}]}}` + "\n\n")}
+	result, err := Aggregate(NewEvents(body))
+	assert.ErrorIs(t, err, errBroken)
+	assert.Equal(t, "partial", result.Choices[0].Content)
+}
+
+func TestMessage_ExtractThinkTag(t *testing.T) {
+	msg := &Message{Content: "<think>\nstep one\n</think>\n\nThe answer"}
+	msg.ExtractThinkTag()
+	assert.Equal(t, "step one", msg.Reasoning)
+	assert.Equal(t, "The answer", msg.Content)
+
+	// Real thought parts win over inline tags
+	msg = &Message{Content: "<think>inline</think> text", Reasoning: "thought part"}
+	msg.ExtractThinkTag()
+	assert.Equal(t, "thought part", msg.Reasoning)
+	assert.Equal(t, "<think>inline</think> text", msg.Content)
+}
@@ -0,0 +1,131 @@
+package sse
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/google/uuid"
+)
+
+// Translator converts one upstream SSE event into OpenAI chunks
+type Translator func(resp *models.GoogleResponse) []*models.ChatCompletionChunk
+
+// ToChunks returns a translator emitting one chunk per part of each
+// candidate, with the candidate's index as the choice index. It numbers the
+// function calls of each choice across the whole stream, so use one
+// translator per stream. Chunk ids and timestamps are left to the caller.
+func ToChunks(model string) Translator {
+	// toolCalls numbers the function calls of each choice (OpenAI's tool_calls index)
+	toolCalls := make(map[int]int)
+	return func(resp *models.GoogleResponse) []*models.ChatCompletionChunk {
+		if len(resp.Response.Candidates) == 0 {
+			if feedback := resp.Response.PromptFeedback; feedback != nil && feedback.BlockReason != "" {
+				return []*models.ChatCompletionChunk{finishChunk(model, 0, "content_filter")}
+			}
+			return nil
+		}
+
+		var chunks []*models.ChatCompletionChunk
+		for i, candidate := range resp.Response.Candidates {
+			index := candidateIndex(i, candidate)
+			for _, part := range candidate.Content.Parts {
+				if part.FunctionCall != nil {
+					chunks = append(chunks, toolCallChunks(model, index, toolCalls[index], part.FunctionCall)...)
+					toolCalls[index]++
+					continue
+				}
+				delta := models.ChatCompletionDelta{ReasoningSignature: part.ThoughtSignature}
+				if part.Thought {
+					delta.Reasoning = part.Text
+				} else {
+					delta.Content = part.Text
+				}
+				// Image generation models return images as inline data parts
+				if part.InlineData != nil && strings.HasPrefix(part.InlineData.MimeType, "image/") {
+					delta.Images = []models.ImagePart{models.NewImagePart(part.InlineData.MimeType, part.InlineData.Data)}
+				}
+
+				chunks = append(chunks, &models.ChatCompletionChunk{
+					Object: "chat.completion.chunk",
+					Model:  model,
+					Choices: []models.ChatCompletionChunkChoice{
+						{
+							Index: index,
+							Delta: delta,
+						},
+					},
+				})
+			}
+
+			// 搜索来源和引用信息单独作为一个chunk发送
+			annotations := append(candidate.GroundingMetadata.Annotations(), candidate.CitationMetadata.Annotations()...)
+			if len(annotations) > 0 {
+				chunks = append(chunks, &models.ChatCompletionChunk{
+					Object: "chat.completion.chunk",
+					Model:  model,
+					Choices: []models.ChatCompletionChunkChoice{
+						{
+							Index: index,
+							Delta: models.ChatCompletionDelta{Annotations: annotations},
+						},
+					},
+				})
+			}
+
+			if candidate.FinishReason != "" {
+				chunks = append(chunks, finishChunk(model, index, openAIFinishReason(candidate.FinishReason)))
+			}
+		}
+		return chunks
+	}
+}
+
+// toolCallChunks forwards a Gemini function call as soon as it arrives, in
+// OpenAI's delta shape: a header with the id, type and name, then the arguments
+func toolCallChunks(model string, choice, index int, call *models.GoogleFunctionCall) []*models.ChatCompletionChunk {
+	id := call.ID
+	if id == "" {
+		id = "call_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:24]
+	}
+	arguments := "{}"
+	if len(call.Args) > 0 {
+		if data, err := json.Marshal(call.Args); err == nil {
+			arguments = string(data)
+		}
+	}
+
+	deltas := []models.ToolCall{
+		{Index: &index, ID: id, Type: "function", Function: models.FunctionCall{Name: call.Name}},
+		{Index: &index, Function: models.FunctionCall{Arguments: arguments}},
+	}
+	chunks := make([]*models.ChatCompletionChunk, 0, len(deltas))
+	for _, delta := range deltas {
+		chunks = append(chunks, &models.ChatCompletionChunk{
+			Object:  "chat.completion.chunk",
+			Model:   model,
+			Choices: []models.ChatCompletionChunkChoice{{Index: choice, Delta: models.ChatCompletionDelta{ToolCalls: []models.ToolCall{delta}}}},
+		})
+	}
+	return chunks
+}
+
+// finishChunk is an empty delta carrying only a finish_reason
+func finishChunk(model string, choice int, reason string) *models.ChatCompletionChunk {
+	return &models.ChatCompletionChunk{
+		Object:  "chat.completion.chunk",
+		Model:   model,
+		Choices: []models.ChatCompletionChunkChoice{{Index: choice, FinishReason: &reason}},
+	}
+}
+
+// openAIFinishReason maps a Gemini finishReason to its OpenAI equivalent
+func openAIFinishReason(reason string) string {
+	switch reason {
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "content_filter"
+	}
+	return "stop"
+}
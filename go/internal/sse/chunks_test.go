@@ -0,0 +1,72 @@
+package sse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToChunks_Citations(t *testing.T) {
+	resp, done := ParseLine(`data: {"response":{"candidates":[{"content":{"role":"model","parts":[{"text":"quoted text"}]},` +
+		`"citationMetadata":{"citations":[{"startIndex":0,"endIndex":11,"uri":"https://example.com/source","license":"mit"},{"startIndex":0,"endIndex":4,"license":"no uri"}]}}]}}`)
+	require.False(t, done)
+	require.NotNil(t, resp)
+
+	chunks := ToChunks("test-model")(resp)
+	require.Len(t, chunks, 2, "text chunk plus an annotations chunk")
+	assert.Equal(t, "quoted text", chunks[0].Choices[0].Delta.Content)
+
+	annotations := chunks[1].Choices[0].Delta.Annotations
+	require.Len(t, annotations, 1, "citations without a URI are skipped")
+	assert.Equal(t, "https://example.com/source", annotations[0].URLCitation.URL)
+	assert.Equal(t, 11, annotations[0].URLCitation.EndIndex)
+}
+
+func TestToChunks_ToolCallsAndFinishReason(t *testing.T) {
+	translate := ToChunks("test-model")
+	first, _ := ParseLine(`data: {"response":{"candidates":[{"content":{"parts":[{"text":"thinking","thought":true},` +
+		`{"functionCall":{"id":"call_a","name":"lookup","args":{"q":"go"}}}]}}]}}`)
+	second, _ := ParseLine(`data: {"response":{"candidates":[{"content":{"parts":[{"functionCall":{"name":"fetch"}}]},"finishReason":"MAX_TOKENS"}]}}`)
+
+	chunks := translate(first)
+	require.Len(t, chunks, 3, "reasoning, then the tool call header and arguments")
+	assert.Equal(t, "thinking", chunks[0].Choices[0].Delta.Reasoning)
+	header := chunks[1].Choices[0].Delta.ToolCalls[0]
+	assert.Equal(t, 0, *header.Index)
+	assert.Equal(t, "call_a", header.ID)
+	assert.Equal(t, "lookup", header.Function.Name)
+	assert.JSONEq(t, `{"q":"go"}`, chunks[2].Choices[0].Delta.ToolCalls[0].Function.Arguments)
+
+	// Tool calls are numbered across the stream; missing ids and args are filled in
+	chunks = translate(second)
+	require.Len(t, chunks, 3)
+	header = chunks[0].Choices[0].Delta.ToolCalls[0]
+	assert.Equal(t, 1, *header.Index)
+	assert.NotEmpty(t, header.ID)
+	assert.Equal(t, "{}", chunks[1].Choices[0].Delta.ToolCalls[0].Function.Arguments)
+	require.NotNil(t, chunks[2].Choices[0].FinishReason)
+	assert.Equal(t, "length", *chunks[2].Choices[0].FinishReason)
+}
+
+func TestToChunks_BlockedPrompt(t *testing.T) {
+	resp, _ := ParseLine(`data: {"response":{"promptFeedback":{"blockReason":"SAFETY"}}}`)
+	chunks := ToChunks("test-model")(resp)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, "content_filter", *chunks[0].Choices[0].FinishReason)
+}
+
+func TestToChunks_MultipleCandidates(t *testing.T) {
+	translate := ToChunks("test-model")
+	resp, _ := ParseLine(`data: {"response":{"candidates":[{"content":{"parts":[{"functionCall":{"name":"a"}}]}},` +
+		`{"index":1,"content":{"parts":[{"functionCall":{"name":"b"}}]},"finishReason":"STOP"}]}}`)
+
+	chunks := translate(resp)
+	require.Len(t, chunks, 5)
+	for i, choice := range []int{0, 0, 1, 1, 1} {
+		assert.Equal(t, choice, chunks[i].Choices[0].Index, "chunk %d", i)
+	}
+	// Each choice numbers its own tool calls
+	assert.Equal(t, 0, *chunks[2].Choices[0].Delta.ToolCalls[0].Index)
+	assert.Equal(t, "stop", *chunks[4].Choices[0].FinishReason)
+}
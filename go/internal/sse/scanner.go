@@ -0,0 +1,106 @@
+package sse
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antigravity/api-proxy/internal/models"
+)
+
+// 上游 SSE 解析与 OpenAI 格式转换：逐行读取 streamGenerateContent 的事件，
+// 聚合为一个完整回答（Aggregate）或逐个转换为 OpenAI chunk（ToChunks）。
+// 各个对外接口（OpenAI、以及后续的 Anthropic、Responses API）共用这里的解析逻辑，不各自维护一份
+
+// MaxLineSize bounds one upstream SSE line. Gemini sends each event as a
+// single data line, which can carry large tool arguments, code blocks or
+// inline images, far beyond bufio.Scanner's 64KB default.
+const MaxLineSize = 32 << 20
+
+// ErrLineTooLong means an upstream event exceeded MaxLineSize; the rest of
+// the stream cannot be read
+var ErrLineTooLong = fmt.Errorf("upstream SSE event exceeds %d MB", MaxLineSize>>20)
+
+// Scanner reads upstream SSE lines with a buffer that grows up to MaxLineSize
+type Scanner struct {
+	*bufio.Scanner
+}
+
+// NewScanner returns a Scanner reading body
+func NewScanner(body io.Reader) Scanner {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), MaxLineSize)
+	return Scanner{scanner}
+}
+
+// Err reports an oversized line as ErrLineTooLong instead of bufio.ErrTooLong
+func (s Scanner) Err() error {
+	err := s.Scanner.Err()
+	if errors.Is(err, bufio.ErrTooLong) {
+		return ErrLineTooLong
+	}
+	return err
+}
+
+// ParseLine decodes a single "data: " line from the upstream stream.
+// It returns done=true on the [DONE] sentinel and a nil response for lines
+// that carry no usable event.
+func ParseLine(line string) (resp *models.GoogleResponse, done bool) {
+	if !strings.HasPrefix(line, "data: ") {
+		return nil, false
+	}
+
+	dataStr := strings.TrimPrefix(line, "data: ")
+	if dataStr == "[DONE]" {
+		return nil, true
+	}
+
+	var googleResp models.GoogleResponse
+	if err := json.Unmarshal([]byte(dataStr), &googleResp); err != nil {
+		return nil, false
+	}
+	return &googleResp, false
+}
+
+// Responses yields the upstream responses of one attempt: the events of a
+// streamGenerateContent stream, or a single generateContent body
+type Responses interface {
+	// Next returns the next response, or nil at the end
+	Next() *models.GoogleResponse
+	// Err is the error that ended the responses early
+	Err() error
+}
+
+// Events reads the events of an SSE stream up to [DONE]
+type Events struct {
+	scanner Scanner
+	done    bool
+}
+
+// NewEvents returns the events of the SSE stream body
+func NewEvents(body io.Reader) *Events {
+	return &Events{scanner: NewScanner(body)}
+}
+
+// Next returns the next event, skipping lines without one
+func (e *Events) Next() *models.GoogleResponse {
+	for !e.done && e.scanner.Scan() {
+		resp, done := ParseLine(e.scanner.Text())
+		if done {
+			e.done = true
+			break
+		}
+		if resp != nil {
+			return resp
+		}
+	}
+	return nil
+}
+
+// Err is the read error that ended the stream, if any
+func (e *Events) Err() error {
+	return e.scanner.Err()
+}
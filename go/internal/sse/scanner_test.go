@@ -0,0 +1,71 @@
+package sse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	resp, done := ParseLine(`data: {"response":{"candidates":[{"content":{"parts":[{"text":"hi"}]}}]}}`)
+	require.False(t, done)
+	require.NotNil(t, resp)
+	assert.Equal(t, "hi", resp.Response.Candidates[0].Content.Parts[0].Text)
+
+	resp, done = ParseLine("data: [DONE]")
+	assert.True(t, done)
+	assert.Nil(t, resp)
+
+	for _, line := range []string{"", "event: ping", ": keepalive", "data: {not json"} {
+		resp, done = ParseLine(line)
+		assert.False(t, done, line)
+		assert.Nil(t, resp, line)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	events := NewEvents(strings.NewReader(
+		"event: ping\n\n" +
+			`data: {"response":{"candidates":[{"content":{"parts":[{"text":"a"}]}}]}}` + "\n\n" +
+			`data: {"response":{"candidates":[{"content":{"parts":[{"text":"b"}]}}]}}` + "\n\n" +
+			"data: [DONE]\n\n" +
+			`data: {"response":{"candidates":[{"content":{"parts":[{"text":"after done"}]}}]}}` + "\n\n"))
+
+	var texts []string
+	for resp := events.Next(); resp != nil; resp = events.Next() {
+		texts = append(texts, resp.Response.Candidates[0].Content.Parts[0].Text)
+	}
+	assert.Equal(t, []string{"a", "b"}, texts)
+	assert.NoError(t, events.Err())
+	assert.Nil(t, events.Next(), "nothing is read past [DONE]")
+}
+
+func TestEvents_LineTooLong(t *testing.T) {
+	events := NewEvents(strings.NewReader("data: " + strings.Repeat("x", MaxLineSize) + "\n\n"))
+	assert.Nil(t, events.Next())
+	assert.ErrorIs(t, events.Err(), ErrLineTooLong)
+}
+
+// Run with: go test ./internal/sse -run=^$ -fuzz=FuzzParseLine -fuzztime=30s
+
+func FuzzParseLine(f *testing.F) {
+	f.Add(`data: {"response":{"candidates":[{"content":{"parts":[{"text":"hi"}]}}]}}`)
+	f.Add(`data: [DONE]`)
+	f.Add(`data: `)
+	f.Add(`data: {"response":{"candidates":[null]}}`)
+	f.Add(`data: {"response":{"candidates":[{"content":{"parts":[{"inlineData":{}}]}}],"usageMetadata":null}}`)
+	f.Add(`event: ping`)
+
+	translate := ToChunks("fuzz-model")
+	f.Fuzz(func(t *testing.T, line string) {
+		resp, done := ParseLine(line)
+		if done && resp != nil {
+			t.Fatalf("done sentinel must not carry a response")
+		}
+		if resp != nil {
+			translate(resp)
+		}
+	})
+}
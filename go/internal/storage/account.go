@@ -5,24 +5,73 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/antigravity/api-proxy/internal/models"
 )
 
 // AccountStore handles account persistence
+// Decoded accounts are cached in memory and only re-read when the file's
+// modification time or size changes (e.g. edited by the admin API).
 type AccountStore struct {
 	accountsDir string
+
+	mu    sync.Mutex
+	cache map[string]cachedAccount
+	// generation changes whenever a cached account does, see Generation
+	generation atomic.Uint64
+
+	// writeMu serializes Save and Update so an Update never saves over a write
+	// that happened between its read and its save
+	writeMu sync.Mutex
+
+	// flusher batches hot-path updates, see StartFlusher
+	flusher accountFlusher
+}
+
+// cachedAccount is a decoded account plus the file state it was read from
+type cachedAccount struct {
+	account *models.Account
+	modTime time.Time
+	size    int64
 }
 
 // NewAccountStore creates a new account store
 func NewAccountStore(accountsDir string) *AccountStore {
 	return &AccountStore{
 		accountsDir: accountsDir,
+		cache:       make(map[string]cachedAccount),
 	}
 }
 
 // Save saves an account to file
 func (s *AccountStore) Save(account *models.Account) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.save(account)
+}
+
+// Update re-reads the account, applies change to it and saves the result,
+// which it returns. Use it instead of Save for a copy loaded a while ago: fields
+// saved meanwhile, such as a token refreshed in the background or an admin
+// edit, are kept. A deleted account is not recreated.
+func (s *AccountStore) Update(accountID string, change func(account *models.Account)) (*models.Account, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	account, err := s.Load(accountID)
+	if err != nil {
+		return nil, err
+	}
+	change(account)
+	if err := s.save(account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *AccountStore) save(account *models.Account) error {
 	// 确保目录存在
 	if err := os.MkdirAll(s.accountsDir, 0755); err != nil {
 		return fmt.Errorf("failed to create accounts directory: %w", err)
@@ -38,19 +87,36 @@ func (s *AccountStore) Save(account *models.Account) error {
 		return fmt.Errorf("failed to marshal account: %w", err)
 	}
 
-	// 写入文件
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	// 先写临时文件再替换，并发写入或中途退出时不会留下半截的账号文件
+	if err := writeFileAtomic(filePath, data); err != nil {
 		return fmt.Errorf("failed to write account file: %w", err)
 	}
+	s.savedOver(account.AccountID)
+
+	// 更新缓存，避免下次读取时重新解析
+	if info, err := os.Stat(filePath); err == nil {
+		s.storeCache(account.AccountID, account, info)
+	}
 
 	return nil
 }
 
 // Load loads an account from file
+// The returned account is a private copy and may be mutated freely.
 func (s *AccountStore) Load(accountID string) (*models.Account, error) {
 	filename := accountID + ".json"
 	filePath := filepath.Join(s.accountsDir, filename)
 
+	info, err := os.Stat(filePath)
+	if err != nil {
+		s.dropCache(accountID)
+		return nil, fmt.Errorf("failed to read account file: %w", err)
+	}
+	if account := s.loadCache(accountID, info); account != nil {
+		s.applyPending(account)
+		return account, nil
+	}
+
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read account file: %w", err)
@@ -61,6 +127,8 @@ func (s *AccountStore) Load(accountID string) (*models.Account, error) {
 		return nil, fmt.Errorf("failed to unmarshal account: %w", err)
 	}
 
+	s.storeCache(accountID, &account, info)
+	s.applyPending(&account)
 	return &account, nil
 }
 
@@ -85,9 +153,70 @@ func (s *AccountStore) List() ([]string, error) {
 	return accountIDs, nil
 }
 
+// Generation changes whenever an account is saved, deleted, or found changed
+// on disk by Load, so callers can reuse results derived from the accounts
+// until it moves. Files edited outside the store are only noticed on their
+// next Load.
+func (s *AccountStore) Generation() uint64 {
+	return s.generation.Load()
+}
+
 // Delete deletes an account file
 func (s *AccountStore) Delete(accountID string) error {
 	filename := accountID + ".json"
 	filePath := filepath.Join(s.accountsDir, filename)
+	s.dropCache(accountID)
 	return os.Remove(filePath)
 }
+
+// writeFileAtomic replaces path with data via a temporary file in the same directory
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadCache returns a copy of the cached account if the file is unchanged
+func (s *AccountStore) loadCache(accountID string, info os.FileInfo) *models.Account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.cache[accountID]
+	if !ok || !entry.modTime.Equal(info.ModTime()) || entry.size != info.Size() {
+		return nil
+	}
+	return entry.account.Clone()
+}
+
+func (s *AccountStore) storeCache(accountID string, account *models.Account, info os.FileInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache[accountID] = cachedAccount{
+		account: account.Clone(),
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+	s.generation.Add(1)
+}
+
+func (s *AccountStore) dropCache(accountID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cache[accountID]; ok {
+		delete(s.cache, accountID)
+		s.generation.Add(1)
+	}
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+)
+
+// 账号文件延迟写入：每个请求成功后都要更新账号状态和用量，原来每次都完整重写一遍账号文件。
+// 开启 StartFlusher 后，这些热路径上的更新先记在内存中，按间隔合并写入（关闭时再写一次），
+// 写入时重新读取文件再叠加，不会覆盖管理接口等其他地方的修改
+
+// accountDelta is the hot-path state of an account not yet written to its file
+type accountDelta struct {
+	// successAt is when the last unsaved success happened (Unix ms); 0 means none
+	successAt int64
+	usage     models.UsageStats
+}
+
+// accountFlusher holds the pending deltas; nil deltas means writes go straight to disk
+type accountFlusher struct {
+	mu     sync.Mutex
+	deltas map[string]*accountDelta
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// StartFlusher defers RecordSuccess and AddUsage writes, flushing them every
+// interval; Close flushes what is left. Without it those calls save right away.
+func (s *AccountStore) StartFlusher(interval time.Duration) {
+	f := &s.flusher
+	f.mu.Lock()
+	if f.stop != nil || interval <= 0 {
+		f.mu.Unlock()
+		return
+	}
+	f.deltas = make(map[string]*accountDelta)
+	f.stop = make(chan struct{})
+	f.done = make(chan struct{})
+	stop, done := f.stop, f.done
+	f.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Flush()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the flusher and writes the pending updates
+func (s *AccountStore) Close() error {
+	f := &s.flusher
+	f.mu.Lock()
+	stop, done := f.stop, f.done
+	f.stop = nil
+	f.mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+
+	// Later updates are saved right away
+	f.mu.Lock()
+	deltas := f.deltas
+	f.deltas = nil
+	f.mu.Unlock()
+	return s.flush(deltas)
+}
+
+// RecordSuccess marks the account's last request as successful (see
+// models.Account.RecordSuccess), applying it to account as well
+func (s *AccountStore) RecordSuccess(account *models.Account) error {
+	account.RecordSuccess()
+	successAt := account.LastRefresh
+	if s.deferChange(account.AccountID, func(delta *accountDelta) { delta.successAt = successAt }) {
+		return nil
+	}
+	_, err := s.Update(account.AccountID, func(saved *models.Account) {
+		saved.RecordSuccess()
+		saved.LastRefresh = successAt
+	})
+	return err
+}
+
+// AddUsage adds a finished request's tokens to the account's usage counters.
+// Accounts without counters are left alone.
+func (s *AccountStore) AddUsage(account *models.Account, inputTokens, outputTokens, totalTokens int64) error {
+	if account.Usage == nil {
+		return nil
+	}
+	add := func(usage *models.UsageStats) {
+		usage.TotalTokens += totalTokens
+		usage.InputTokens += inputTokens
+		usage.OutputTokens += outputTokens
+		usage.RequestCount++
+	}
+	if s.deferChange(account.AccountID, func(delta *accountDelta) { add(&delta.usage) }) {
+		return nil
+	}
+	add(account.Usage)
+	_, err := s.Update(account.AccountID, func(saved *models.Account) {
+		if saved.Usage != nil {
+			add(saved.Usage)
+		}
+	})
+	return err
+}
+
+// deferChange records a change for the next flush; false means no flusher is running
+func (s *AccountStore) deferChange(accountID string, change func(delta *accountDelta)) bool {
+	f := &s.flusher
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deltas == nil {
+		return false
+	}
+	delta, ok := f.deltas[accountID]
+	if !ok {
+		delta = &accountDelta{}
+		f.deltas[accountID] = delta
+	}
+	change(delta)
+	return true
+}
+
+// Flush writes the pending updates. Each account is re-read first so changes
+// saved meanwhile are kept; an account deleted meanwhile is skipped.
+func (s *AccountStore) Flush() error {
+	f := &s.flusher
+	f.mu.Lock()
+	deltas := f.deltas
+	if deltas != nil {
+		f.deltas = make(map[string]*accountDelta)
+	}
+	f.mu.Unlock()
+	return s.flush(deltas)
+}
+
+func (s *AccountStore) flush(deltas map[string]*accountDelta) error {
+	var firstErr error
+	for accountID, delta := range deltas {
+		_, err := s.Update(accountID, func(account *models.Account) {
+			if delta.successAt > 0 {
+				account.RecordSuccess()
+				account.LastRefresh = delta.successAt
+			}
+			if account.Usage != nil {
+				account.Usage.TotalTokens += delta.usage.TotalTokens
+				account.Usage.InputTokens += delta.usage.InputTokens
+				account.Usage.OutputTokens += delta.usage.OutputTokens
+				account.Usage.RequestCount += delta.usage.RequestCount
+			}
+		})
+		if err != nil && !errors.Is(err, os.ErrNotExist) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// applyPending applies an unsaved success to a loaded account, so callers that
+// save it back keep the success
+func (s *AccountStore) applyPending(account *models.Account) {
+	f := &s.flusher
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if delta, ok := f.deltas[account.AccountID]; ok && delta.successAt > 0 {
+		account.RecordSuccess()
+		account.LastRefresh = delta.successAt
+	}
+}
+
+// savedOver drops an unsaved success once the account was saved: the saved
+// copy either carries it (see applyPending) or has a newer status, such as a
+// failure right after it. Usage deltas are still added on the next flush.
+func (s *AccountStore) savedOver(accountID string) {
+	f := &s.flusher
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if delta, ok := f.deltas[accountID]; ok {
+		delta.successAt = 0
+	}
+}
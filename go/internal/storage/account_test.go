@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountStore_CacheFollowsFileChanges(t *testing.T) {
+	dir := t.TempDir()
+	store := NewAccountStore(dir)
+	require.NoError(t, store.Save(&models.Account{AccountID: "acc1", AccessToken: "cached"}))
+
+	// Loads return private copies
+	account, err := store.Load("acc1")
+	require.NoError(t, err)
+	account.AccessToken = "mutated"
+	account, err = store.Load("acc1")
+	require.NoError(t, err)
+	assert.Equal(t, "cached", account.AccessToken)
+
+	// An edit from outside the store changes the size and is picked up
+	path := filepath.Join(dir, "acc1.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"accountId":"acc1","access_token":"edited by hand"}`), 0644))
+	account, err = store.Load("acc1")
+	require.NoError(t, err)
+	assert.Equal(t, "edited by hand", account.AccessToken)
+
+	// Same size, newer modification time
+	require.NoError(t, os.WriteFile(path, []byte(`{"accountId":"acc1","access_token":"edited by HAND"}`), 0644))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+	account, err = store.Load("acc1")
+	require.NoError(t, err)
+	assert.Equal(t, "edited by HAND", account.AccessToken)
+
+	// A deleted file is not served from the cache
+	require.NoError(t, os.Remove(path))
+	_, err = store.Load("acc1")
+	assert.Error(t, err)
+}
+
+func TestAccountStore_UpdateKeepsConcurrentSaves(t *testing.T) {
+	store := NewAccountStore(t.TempDir())
+	require.NoError(t, store.Save(&models.Account{AccountID: "acc1", AccessToken: "old"}))
+	stale, err := store.Load("acc1")
+	require.NoError(t, err)
+
+	// Another writer refreshes the token after the stale copy was loaded
+	refreshed := stale.Clone()
+	refreshed.AccessToken = "new"
+	require.NoError(t, store.Save(refreshed))
+
+	_, err = store.Update(stale.AccountID, func(account *models.Account) { account.RecordFailure("boom") })
+	require.NoError(t, err)
+	saved, err := store.Load("acc1")
+	require.NoError(t, err)
+	assert.Equal(t, "new", saved.AccessToken)
+	assert.Equal(t, "boom", saved.ErrorTracking.LastError)
+
+	// Deleted accounts are not recreated
+	require.NoError(t, store.Delete("acc1"))
+	_, err = store.Update("acc1", func(account *models.Account) {})
+	assert.Error(t, err)
+	_, err = store.Load("acc1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/antigravity/api-proxy/internal/models"
+)
+
+// BatchStore persists batch files and batch jobs under <DataDir>/batches.
+// Every file and batch is owned by the client that created it; owner is an
+// opaque identifier chosen by the caller.
+//
+//	files/<id>.json   file metadata
+//	files/<id>.jsonl  file content
+//	jobs/<id>.json    batch state
+type BatchStore struct {
+	mu       sync.Mutex
+	filesDir string
+	jobsDir  string
+}
+
+// ownedFile and ownedBatch are the on-disk records; Owner is never returned to clients
+type ownedFile struct {
+	Owner string             `json:"owner"`
+	File  *models.FileObject `json:"file"`
+}
+
+type ownedBatch struct {
+	Owner string        `json:"owner"`
+	Batch *models.Batch `json:"batch"`
+}
+
+// NewBatchStore creates a batch store under dataDir
+func NewBatchStore(dataDir string) *BatchStore {
+	base := filepath.Join(dataDir, "batches")
+	return &BatchStore{
+		filesDir: filepath.Join(base, "files"),
+		jobsDir:  filepath.Join(base, "jobs"),
+	}
+}
+
+// SaveFile stores a file's metadata and content
+func (s *BatchStore) SaveFile(owner string, file *models.FileObject, content []byte) error {
+	if err := os.MkdirAll(s.filesDir, 0755); err != nil {
+		return fmt.Errorf("failed to create files directory: %w", err)
+	}
+	if err := os.WriteFile(s.FileContentPath(file.ID), content, 0644); err != nil {
+		return fmt.Errorf("failed to write file content: %w", err)
+	}
+	return writeJSON(filepath.Join(s.filesDir, file.ID+".json"), &ownedFile{Owner: owner, File: file})
+}
+
+// LoadFile returns a file's metadata if it belongs to owner
+func (s *BatchStore) LoadFile(owner, id string) (*models.FileObject, error) {
+	var record ownedFile
+	if err := readJSON(filepath.Join(s.filesDir, safeID(id)+".json"), &record); err != nil {
+		return nil, err
+	}
+	if record.Owner != owner || record.File == nil {
+		return nil, os.ErrNotExist
+	}
+	return record.File, nil
+}
+
+// ReadFileContent returns a file's content if it belongs to owner
+func (s *BatchStore) ReadFileContent(owner, id string) ([]byte, error) {
+	if _, err := s.LoadFile(owner, id); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(s.FileContentPath(id))
+}
+
+// FileContentPath is where a file's content is stored
+func (s *BatchStore) FileContentPath(id string) string {
+	return filepath.Join(s.filesDir, safeID(id)+".jsonl")
+}
+
+// FilesDir is the directory holding file metadata and content
+func (s *BatchStore) FilesDir() string {
+	return s.filesDir
+}
+
+// ListFiles returns owner's files, newest first
+func (s *BatchStore) ListFiles(owner string) ([]*models.FileObject, error) {
+	matches, err := filepath.Glob(filepath.Join(s.filesDir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	files := []*models.FileObject{}
+	for _, match := range matches {
+		var record ownedFile
+		if readJSON(match, &record) == nil && record.Owner == owner && record.File != nil {
+			files = append(files, record.File)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].CreatedAt > files[j].CreatedAt })
+	return files, nil
+}
+
+// DeleteFile removes a file owned by owner
+func (s *BatchStore) DeleteFile(owner, id string) error {
+	if _, err := s.LoadFile(owner, id); err != nil {
+		return err
+	}
+	os.Remove(s.FileContentPath(id))
+	return os.Remove(filepath.Join(s.filesDir, safeID(id)+".json"))
+}
+
+// SaveBatch stores a batch's state
+func (s *BatchStore) SaveBatch(owner string, batch *models.Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.jobsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create batches directory: %w", err)
+	}
+	return writeJSON(filepath.Join(s.jobsDir, batch.ID+".json"), &ownedBatch{Owner: owner, Batch: batch})
+}
+
+// LoadBatch returns a batch if it belongs to owner
+func (s *BatchStore) LoadBatch(owner, id string) (*models.Batch, error) {
+	batchOwner, batch, err := s.loadBatch(filepath.Join(s.jobsDir, safeID(id)+".json"))
+	if err != nil {
+		return nil, err
+	}
+	if batchOwner != owner {
+		return nil, os.ErrNotExist
+	}
+	return batch, nil
+}
+
+// ListBatches returns owner's batches, newest first
+func (s *BatchStore) ListBatches(owner string) ([]*models.Batch, error) {
+	all, err := s.AllBatches()
+	if err != nil {
+		return nil, err
+	}
+	batches := []*models.Batch{}
+	for batchOwner, owned := range all {
+		if batchOwner == owner {
+			batches = append(batches, owned...)
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].CreatedAt > batches[j].CreatedAt })
+	return batches, nil
+}
+
+// AllBatches returns every stored batch grouped by owner
+func (s *BatchStore) AllBatches() (map[string][]*models.Batch, error) {
+	matches, err := filepath.Glob(filepath.Join(s.jobsDir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list batches: %w", err)
+	}
+
+	result := make(map[string][]*models.Batch)
+	for _, match := range matches {
+		owner, batch, err := s.loadBatch(match)
+		if err != nil {
+			continue
+		}
+		result[owner] = append(result[owner], batch)
+	}
+	return result, nil
+}
+
+func (s *BatchStore) loadBatch(path string) (string, *models.Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var record ownedBatch
+	if err := readJSON(path, &record); err != nil {
+		return "", nil, err
+	}
+	if record.Batch == nil {
+		return "", nil, os.ErrNotExist
+	}
+	return record.Owner, record.Batch, nil
+}
+
+// MigrateOwners rewrites the owner of every file and batch record to
+// migrate(owner), leaving records it doesn't change untouched
+func (s *BatchStore) MigrateOwners(migrate func(owner string) string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for _, pattern := range []string{filepath.Join(s.filesDir, "*.json"), filepath.Join(s.jobsDir, "*.json")} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("failed to list batch records: %w", err)
+		}
+		for _, match := range matches {
+			// Files and batches share the owner field; the rest is kept as is
+			var record map[string]json.RawMessage
+			var owner string
+			if readJSON(match, &record) != nil || json.Unmarshal(record["owner"], &owner) != nil {
+				continue
+			}
+			migrated := migrate(owner)
+			if migrated == owner {
+				continue
+			}
+			record["owner"], _ = json.Marshal(migrated)
+			if err := writeJSON(match, record); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// safeID strips path separators so IDs from URLs can't escape the store
+func safeID(id string) string {
+	return strings.NewReplacer("/", "", "\\", "", "..", "").Replace(id)
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", filepath.Base(path), err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
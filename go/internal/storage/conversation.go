@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+)
+
+// ErrConversationOwned is returned when a conversation ID belongs to another API key
+var ErrConversationOwned = errors.New("conversation belongs to another API key")
+
+// ConversationStore persists conversation histories under <DataDir>/conversations,
+// one file per conversation, each owned by the API key that created it.
+// Conversations idle for longer than the TTL are treated as missing and removed.
+type ConversationStore struct {
+	mu  sync.Mutex
+	dir string
+	ttl time.Duration // 0 keeps conversations forever
+}
+
+// ownedConversation is the on-disk record; Owner is never returned to clients
+type ownedConversation struct {
+	Owner        string               `json:"owner"`
+	Conversation *models.Conversation `json:"conversation"`
+}
+
+// NewConversationStore creates a conversation store under dataDir
+func NewConversationStore(dataDir string, ttl time.Duration) *ConversationStore {
+	return &ConversationStore{dir: filepath.Join(dataDir, "conversations"), ttl: ttl}
+}
+
+// Load returns the conversation; os.ErrNotExist when it is missing or expired,
+// ErrConversationOwned when another key created it
+func (s *ConversationStore) Load(owner, id string) (*models.Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(owner, id)
+}
+
+// Append adds messages to the conversation, creating it if needed, and drops
+// the oldest turns beyond maxMessages (0 = unlimited)
+func (s *ConversationStore) Append(owner, id string, messages []models.ChatCompletionMessage, maxMessages int) (*models.Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	conv, err := s.load(owner, id)
+	if errors.Is(err, os.ErrNotExist) {
+		conv = &models.Conversation{ID: id, Object: "conversation", CreatedAt: now}
+	} else if err != nil {
+		return nil, err
+	}
+	conv.Messages = trimConversation(append(conv.Messages, messages...), maxMessages)
+	conv.UpdatedAt = now
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create conversations directory: %w", err)
+	}
+	if err := writeJSON(s.path(id), &ownedConversation{Owner: owner, Conversation: conv}); err != nil {
+		return nil, err
+	}
+	return conv, nil
+}
+
+// Delete removes a conversation owned by owner
+func (s *ConversationStore) Delete(owner, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.load(owner, id); err != nil {
+		return err
+	}
+	return os.Remove(s.path(id))
+}
+
+// Prune removes expired conversations and returns how many were removed
+func (s *ConversationStore) Prune() (int, error) {
+	if s.ttl <= 0 {
+		return 0, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, match := range matches {
+		var record ownedConversation
+		if readJSON(match, &record) != nil || record.Conversation == nil || s.expired(record.Conversation) {
+			if os.Remove(match) == nil {
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
+// load reads a conversation; the caller holds s.mu
+func (s *ConversationStore) load(owner, id string) (*models.Conversation, error) {
+	var record ownedConversation
+	if err := readJSON(s.path(id), &record); err != nil {
+		if os.IsNotExist(err) {
+			return nil, os.ErrNotExist
+		}
+		return nil, fmt.Errorf("failed to read conversation: %w", err)
+	}
+	if record.Conversation == nil || s.expired(record.Conversation) {
+		os.Remove(s.path(id))
+		return nil, os.ErrNotExist
+	}
+	if record.Owner != owner {
+		return nil, ErrConversationOwned
+	}
+	return record.Conversation, nil
+}
+
+func (s *ConversationStore) expired(conv *models.Conversation) bool {
+	return s.ttl > 0 && time.Since(time.Unix(conv.UpdatedAt, 0)) > s.ttl
+}
+
+func (s *ConversationStore) path(id string) string {
+	return filepath.Join(s.dir, safeID(id)+".json")
+}
+
+// trimConversation drops the oldest non-system messages beyond max; tool
+// results left without the assistant turn that called them are dropped too
+func trimConversation(messages []models.ChatCompletionMessage, max int) []models.ChatCompletionMessage {
+	if max <= 0 || len(messages) <= max {
+		return messages
+	}
+	var system, rest []models.ChatCompletionMessage
+	for _, msg := range messages {
+		if msg.Role == "system" || msg.Role == "developer" {
+			system = append(system, msg)
+		} else {
+			rest = append(rest, msg)
+		}
+	}
+	if drop := len(system) + len(rest) - max; drop > 0 {
+		rest = rest[min(drop, len(rest)):]
+	}
+	for len(rest) > 0 && rest[0].Role == "tool" {
+		rest = rest[1:]
+	}
+	return append(system, rest...)
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/antigravity/api-proxy/internal/models"
+)
+
+// KeyTemplateStore persists key templates to <DataDir>/key_templates.json and
+// serves them from memory; the built-in templates apply until the file exists
+type KeyTemplateStore struct {
+	mu        sync.RWMutex
+	filePath  string
+	templates map[string]models.KeyTemplate
+}
+
+// NewKeyTemplateStore loads the templates under dataDir
+func NewKeyTemplateStore(dataDir string) *KeyTemplateStore {
+	s := &KeyTemplateStore{
+		filePath:  filepath.Join(dataDir, "key_templates.json"),
+		templates: make(map[string]models.KeyTemplate),
+	}
+	templates := models.DefaultKeyTemplates()
+	if data, err := os.ReadFile(s.filePath); err == nil {
+		var saved []models.KeyTemplate
+		if json.Unmarshal(data, &saved) == nil {
+			templates = saved
+		}
+	}
+	for _, t := range templates {
+		s.templates[t.Name] = t
+	}
+	return s
+}
+
+// List returns all templates sorted by name
+func (s *KeyTemplateStore) List() []models.KeyTemplate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	templates := make([]models.KeyTemplate, 0, len(s.templates))
+	for _, t := range s.templates {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+// Get returns the template called name
+func (s *KeyTemplateStore) Get(name string) (models.KeyTemplate, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.templates[name]
+	return t, ok
+}
+
+// Save validates and stores a template, replacing one with the same name
+func (s *KeyTemplateStore) Save(t models.KeyTemplate) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, existed := s.templates[t.Name]
+	s.templates[t.Name] = t
+	if err := s.persist(); err != nil {
+		if existed {
+			s.templates[t.Name] = previous
+		} else {
+			delete(s.templates, t.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes a template; os.ErrNotExist when there is none
+func (s *KeyTemplateStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, ok := s.templates[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(s.templates, name)
+	if err := s.persist(); err != nil {
+		s.templates[name] = previous
+		return err
+	}
+	return nil
+}
+
+// persist writes all templates; the caller holds s.mu
+func (s *KeyTemplateStore) persist() error {
+	templates := make([]models.KeyTemplate, 0, len(s.templates))
+	for _, t := range s.templates {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+
+	data, err := json.MarshalIndent(templates, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal key templates: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	if err := os.WriteFile(s.filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write key templates: %w", err)
+	}
+	return nil
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/antigravity/api-proxy/internal/models"
@@ -125,6 +127,11 @@ func sanitizeKeyFilename(key string) string {
 // UsageStore handles usage statistics persistence
 type UsageStore struct {
 	usageDir string
+	// writeMu serializes update so concurrent requests (and RecordRateLimit)
+	// never save over each other's counts
+	writeMu sync.Mutex
+	// refreshMu serializes access to the refresh history file
+	refreshMu sync.Mutex
 }
 
 // NewUsageStore creates a new usage store
@@ -136,16 +143,111 @@ func NewUsageStore(usageDir string) *UsageStore {
 
 // UsageRecord represents a usage record
 type UsageRecord struct {
-	Date         string `json:"date"`          // YYYY-MM-DD
+	Date         string `json:"date"` // YYYY-MM-DD
 	AccountID    string `json:"account_id"`
 	TotalTokens  int64  `json:"total_tokens"`
 	InputTokens  int64  `json:"input_tokens"`
 	OutputTokens int64  `json:"output_tokens"`
 	RequestCount int64  `json:"request_count"`
+	// Users counts requests per end-user (OpenAI "user" field) for abuse attribution
+	Users map[string]int64 `json:"users,omitempty"`
+	// UserTokens counts tokens per end-user
+	UserTokens map[string]int64 `json:"user_tokens,omitempty"`
+	// Models counts requests per requested model
+	Models map[string]int64 `json:"models,omitempty"`
+	// RateLimited counts upstream 429 responses
+	RateLimited int64 `json:"rate_limited,omitempty"`
 }
 
 // RecordUsage records usage for an account
-func (s *UsageStore) RecordUsage(accountID string, inputTokens, outputTokens int64) error {
+// user is the optional end-user identifier supplied by the client
+func (s *UsageStore) RecordUsage(accountID, user, model string, inputTokens, outputTokens int64) error {
+	return s.update(accountID, func(record *UsageRecord) {
+		record.InputTokens += inputTokens
+		record.OutputTokens += outputTokens
+		record.TotalTokens += inputTokens + outputTokens
+		record.RequestCount++
+		if user != "" {
+			if record.Users == nil {
+				record.Users = make(map[string]int64)
+			}
+			record.Users[user]++
+			if record.UserTokens == nil {
+				record.UserTokens = make(map[string]int64)
+			}
+			record.UserTokens[user] += inputTokens + outputTokens
+		}
+		if model != "" {
+			if record.Models == nil {
+				record.Models = make(map[string]int64)
+			}
+			record.Models[model]++
+		}
+	})
+}
+
+// UserUsage is one end user's consumption, broken down by account
+type UserUsage struct {
+	User     string                      `json:"user"`
+	Requests int64                       `json:"requests"`
+	Tokens   int64                       `json:"tokens"`
+	Accounts map[string]UserAccountUsage `json:"accounts"`
+}
+
+// UserAccountUsage is what one end user consumed on one account
+type UserAccountUsage struct {
+	Requests int64 `json:"requests"`
+	Tokens   int64 `json:"tokens"`
+}
+
+// SummarizeUsers aggregates records per end user, heaviest token users first
+func SummarizeUsers(records []UsageRecord) []UserUsage {
+	byUser := make(map[string]*UserUsage)
+	for _, record := range records {
+		for user, requests := range record.Users {
+			usage, ok := byUser[user]
+			if !ok {
+				usage = &UserUsage{User: user, Accounts: make(map[string]UserAccountUsage)}
+				byUser[user] = usage
+			}
+			tokens := record.UserTokens[user]
+			usage.Requests += requests
+			usage.Tokens += tokens
+			account := usage.Accounts[record.AccountID]
+			account.Requests += requests
+			account.Tokens += tokens
+			usage.Accounts[record.AccountID] = account
+		}
+	}
+
+	result := make([]UserUsage, 0, len(byUser))
+	for _, usage := range byUser {
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Tokens != result[j].Tokens {
+			return result[i].Tokens > result[j].Tokens
+		}
+		if result[i].Requests != result[j].Requests {
+			return result[i].Requests > result[j].Requests
+		}
+		return result[i].User < result[j].User
+	})
+	return result
+}
+
+// RecordRateLimit counts an upstream 429 for an account (used for capacity planning)
+func (s *UsageStore) RecordRateLimit(accountID string) error {
+	return s.update(accountID, func(record *UsageRecord) {
+		record.RateLimited++
+	})
+}
+
+// update applies fn to today's record for an account and saves it
+func (s *UsageStore) update(accountID string, fn func(record *UsageRecord)) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
 	// Ensure directory exists
 	if err := os.MkdirAll(s.usageDir, 0755); err != nil {
 		return fmt.Errorf("failed to create usage directory: %w", err)
@@ -153,7 +255,7 @@ func (s *UsageStore) RecordUsage(accountID string, inputTokens, outputTokens int
 
 	// Get today's date
 	today := time.Now().Format("2006-01-02")
-	
+
 	// Build file path for today
 	filename := fmt.Sprintf("%s_%s.json", today, accountID)
 	filePath := filepath.Join(s.usageDir, filename)
@@ -171,11 +273,7 @@ func (s *UsageStore) RecordUsage(accountID string, inputTokens, outputTokens int
 		}
 	}
 
-	// Update record
-	record.InputTokens += inputTokens
-	record.OutputTokens += outputTokens
-	record.TotalTokens += inputTokens + outputTokens
-	record.RequestCount++
+	fn(&record)
 
 	// Save record
 	data, err = json.MarshalIndent(record, "", "  ")
@@ -183,13 +281,52 @@ func (s *UsageStore) RecordUsage(accountID string, inputTokens, outputTokens int
 		return fmt.Errorf("failed to marshal usage record: %w", err)
 	}
 
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	// Replace the file atomically so TodayTokens and the reports never read half a record
+	if err := writeFileAtomic(filePath, data); err != nil {
 		return fmt.Errorf("failed to write usage file: %w", err)
 	}
 
 	return nil
 }
 
+// TodayTokens returns the tokens an account has used today (0 if none recorded)
+func (s *UsageStore) TodayTokens(accountID string) int64 {
+	filename := fmt.Sprintf("%s_%s.json", time.Now().Format("2006-01-02"), accountID)
+	data, err := os.ReadFile(filepath.Join(s.usageDir, filename))
+	if err != nil {
+		return 0
+	}
+
+	var record UsageRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return 0
+	}
+	return record.TotalTokens
+}
+
+// GetUsageForDate returns all account records for one day (YYYY-MM-DD)
+func (s *UsageStore) GetUsageForDate(date string) ([]UsageRecord, error) {
+	matches, err := filepath.Glob(filepath.Join(s.usageDir, date+"_*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list usage files: %w", err)
+	}
+
+	records := []UsageRecord{}
+	for _, filePath := range matches {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			continue
+		}
+
+		var record UsageRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
 // GetUsageHistory gets usage history for a date range
 func (s *UsageStore) GetUsageHistory(days int) ([]UsageRecord, error) {
 	entries, err := os.ReadDir(s.usageDir)
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageStore_ConcurrentUpdatesKeepEveryCount(t *testing.T) {
+	store := NewUsageStore(t.TempDir())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, store.RecordUsage("acc1", "alice", "gemini-2.5-flash", 10, 5))
+		}()
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, store.RecordRateLimit("acc1"))
+		}()
+	}
+	wg.Wait()
+
+	records, err := store.GetUsageForDate(time.Now().Format("2006-01-02"))
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, int64(20), records[0].RequestCount)
+	assert.Equal(t, int64(300), records[0].TotalTokens)
+	assert.Equal(t, int64(20), records[0].RateLimited)
+	assert.Equal(t, int64(20), records[0].Users["alice"])
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+	"github.com/google/uuid"
+)
+
+// maxNotifications caps the persisted list; oldest entries are dropped first
+const maxNotifications = 500
+
+// NotificationStore persists admin notifications to a single JSON file
+// A nil *NotificationStore is valid and discards everything.
+type NotificationStore struct {
+	mu       sync.Mutex
+	filePath string
+	items    []*models.Notification // oldest first
+}
+
+// NewNotificationStore creates a notification store under dataDir
+func NewNotificationStore(dataDir string) *NotificationStore {
+	s := &NotificationStore{
+		filePath: filepath.Join(dataDir, "notifications.json"),
+	}
+	if data, err := os.ReadFile(s.filePath); err == nil {
+		json.Unmarshal(data, &s.items)
+	}
+	return s
+}
+
+// Add records an event. An unread notification with the same kind and
+// account is updated in place instead of creating a duplicate.
+func (s *NotificationStore) Add(kind, accountID, message string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	for _, n := range s.items {
+		if !n.Read && n.Kind == kind && n.AccountID == accountID {
+			n.Count++
+			n.Message = message
+			n.UpdatedAt = now
+			return s.saveLocked()
+		}
+	}
+
+	s.items = append(s.items, &models.Notification{
+		ID:        uuid.New().String(),
+		Kind:      kind,
+		AccountID: accountID,
+		Message:   message,
+		Count:     1,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if len(s.items) > maxNotifications {
+		s.items = s.items[len(s.items)-maxNotifications:]
+	}
+	return s.saveLocked()
+}
+
+// List returns notifications newest first
+func (s *NotificationStore) List(unreadOnly bool) []models.Notification {
+	if s == nil {
+		return []models.Notification{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]models.Notification, 0, len(s.items))
+	for i := len(s.items) - 1; i >= 0; i-- {
+		if unreadOnly && s.items[i].Read {
+			continue
+		}
+		result = append(result, *s.items[i])
+	}
+	return result
+}
+
+// UnreadCount returns the number of unread notifications
+func (s *NotificationStore) UnreadCount() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, n := range s.items {
+		if !n.Read {
+			count++
+		}
+	}
+	return count
+}
+
+// MarkRead marks one notification as read
+func (s *NotificationStore) MarkRead(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, n := range s.items {
+		if n.ID == id {
+			n.Read = true
+			return s.saveLocked()
+		}
+	}
+	return os.ErrNotExist
+}
+
+// MarkAllRead marks every notification as read
+func (s *NotificationStore) MarkAllRead() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, n := range s.items {
+		n.Read = true
+	}
+	return s.saveLocked()
+}
+
+// Clear removes all notifications
+func (s *NotificationStore) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items = nil
+	return s.saveLocked()
+}
+
+func (s *NotificationStore) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(s.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create notifications directory: %w", err)
+	}
+
+	items := s.items
+	if items == nil {
+		items = []*models.Notification{}
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal notifications: %w", err)
+	}
+
+	if err := os.WriteFile(s.filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write notifications file: %w", err)
+	}
+	return nil
+}
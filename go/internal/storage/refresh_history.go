@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/models"
+)
+
+// 令牌刷新历史：每轮后台刷新的结果（各账号的结果和耗时）按行追加到 usage 目录的 refresh_history.jsonl，
+// 保留 refreshHistoryDays 天，管理员据此查看刷新的长期可靠性，而不只是最近几行日志
+
+// refreshHistoryFile lives next to the daily usage records; its .jsonl
+// extension keeps it out of the usage record listing
+const refreshHistoryFile = "refresh_history.jsonl"
+
+// refreshHistoryDays is how long refresh cycles are kept
+const refreshHistoryDays = 30
+
+// RecordRefreshCycle appends a refresh cycle and drops cycles older than the
+// retention period. A nil *UsageStore discards the cycle.
+func (s *UsageStore) RecordRefreshCycle(cycle models.RefreshCycle) error {
+	if s == nil {
+		return nil
+	}
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	cycles, err := s.loadRefreshCycles()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().AddDate(0, 0, -refreshHistoryDays).UnixMilli()
+	kept := cycles[:0]
+	for _, c := range cycles {
+		if c.StartedAt >= cutoff {
+			kept = append(kept, c)
+		}
+	}
+	kept = append(kept, cycle)
+
+	if err := os.MkdirAll(s.usageDir, 0755); err != nil {
+		return fmt.Errorf("failed to create usage directory: %w", err)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, c := range kept {
+		if err := encoder.Encode(c); err != nil {
+			return fmt.Errorf("failed to marshal refresh cycle: %w", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(s.usageDir, refreshHistoryFile), buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write refresh history: %w", err)
+	}
+	return nil
+}
+
+// GetRefreshHistory returns the refresh cycles of the last days, newest first
+func (s *UsageStore) GetRefreshHistory(days int) ([]models.RefreshCycle, error) {
+	s.refreshMu.Lock()
+	cycles, err := s.loadRefreshCycles()
+	s.refreshMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days).UnixMilli()
+	result := []models.RefreshCycle{}
+	for _, cycle := range cycles {
+		if cycle.StartedAt >= cutoff {
+			result = append(result, cycle)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].StartedAt > result[j].StartedAt })
+	return result, nil
+}
+
+// loadRefreshCycles reads all stored cycles, oldest first; unreadable lines are skipped
+func (s *UsageStore) loadRefreshCycles() ([]models.RefreshCycle, error) {
+	file, err := os.Open(filepath.Join(s.usageDir, refreshHistoryFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open refresh history: %w", err)
+	}
+	defer file.Close()
+
+	var cycles []models.RefreshCycle
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // one line lists every account
+	for scanner.Scan() {
+		var cycle models.RefreshCycle
+		if json.Unmarshal(scanner.Bytes(), &cycle) == nil {
+			cycles = append(cycles, cycle)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read refresh history: %w", err)
+	}
+	return cycles, nil
+}
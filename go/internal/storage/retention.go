@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 目录清理：服务端写入的目录（Files API 文件、上传目录、响应缓存）没有自然的删除时机，
+// 长期运行的部署会一直增长。PruneDir 按保留时间和总大小上限删除最旧的条目。
+// 同一 ID 的文件（如 file-x.json 与 file-x.jsonl）视为一个条目，一起保留或一起删除，
+// 避免留下只有元数据或只有内容的半个文件。
+
+// PruneResult is what a PruneDir pass removed
+type PruneResult struct {
+	Files int   // Files removed
+	Bytes int64 // Bytes reclaimed
+}
+
+// pruneEntry is every file sharing one ID
+type pruneEntry struct {
+	id      string
+	paths   []string
+	size    int64
+	modTime time.Time // Newest file of the entry
+}
+
+// PruneDir removes entries of dir last modified more than maxAge before now,
+// then the oldest remaining entries until the directory holds at most
+// maxBytes. Zero or negative limits are not enforced. Entries for which keep
+// returns true (it may be nil) are never removed but still count towards
+// maxBytes. A missing directory is not an error.
+func PruneDir(dir string, maxAge time.Duration, maxBytes int64, now time.Time, keep func(id string) bool) (PruneResult, error) {
+	var result PruneResult
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return result, err
+	}
+
+	byID := make(map[string]*pruneEntry)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue // Removed since ReadDir
+		}
+		id, _, _ := strings.Cut(file.Name(), ".")
+		entry := byID[id]
+		if entry == nil {
+			entry = &pruneEntry{id: id}
+			byID[id] = entry
+		}
+		entry.paths = append(entry.paths, filepath.Join(dir, file.Name()))
+		entry.size += info.Size()
+		if info.ModTime().After(entry.modTime) {
+			entry.modTime = info.ModTime()
+		}
+	}
+
+	entries := make([]*pruneEntry, 0, len(byID))
+	var total int64
+	for _, entry := range byID {
+		entries = append(entries, entry)
+		total += entry.size
+	}
+	// Oldest first
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+
+	for _, entry := range entries {
+		if keep != nil && keep(entry.id) {
+			continue
+		}
+		tooOld := maxAge > 0 && now.Sub(entry.modTime) > maxAge
+		tooBig := maxBytes > 0 && total > maxBytes
+		if !tooOld && !tooBig {
+			continue
+		}
+		for _, path := range entry.paths {
+			info, err := os.Stat(path)
+			if err != nil || os.Remove(path) != nil {
+				continue
+			}
+			result.Files++
+			result.Bytes += info.Size()
+			total -= info.Size()
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/antigravity/api-proxy/internal/models"
+)
+
+// RoutingStore persists the model routing table to <DataDir>/routing.json
+// and serves it from memory
+type RoutingStore struct {
+	mu       sync.RWMutex
+	filePath string
+	table    *models.RoutingTable
+}
+
+// NewRoutingStore loads the routing table under dataDir (empty if missing)
+func NewRoutingStore(dataDir string) *RoutingStore {
+	s := &RoutingStore{
+		filePath: filepath.Join(dataDir, "routing.json"),
+		table:    &models.RoutingTable{},
+	}
+	if data, err := os.ReadFile(s.filePath); err == nil {
+		var table models.RoutingTable
+		if json.Unmarshal(data, &table) == nil && table.Validate() == nil {
+			s.table = &table
+		}
+	}
+	return s
+}
+
+// Get returns the current table; callers must not modify it
+func (s *RoutingStore) Get() *models.RoutingTable {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.table
+}
+
+// Set validates, persists and activates a new table
+func (s *RoutingStore) Set(table *models.RoutingTable) error {
+	if err := table.Validate(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(table, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal routing table: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(s.filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	if err := os.WriteFile(s.filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write routing table: %w", err)
+	}
+	s.table = table
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package sysmon
+
+import "errors"
+
+func diskUsage(dir string) (*DiskStatus, error) {
+	return nil, errors.New("disk usage is not supported on this platform")
+}
+
+func fdUsage() *FDStatus {
+	return nil
+}
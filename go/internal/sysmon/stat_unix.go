@@ -0,0 +1,47 @@
+//go:build linux || darwin
+
+package sysmon
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// diskUsage reports space and inodes of the filesystem holding dir
+func diskUsage(dir string) (*DiskStatus, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return nil, err
+	}
+	bsize := uint64(st.Bsize)
+	disk := &DiskStatus{
+		TotalBytes:  uint64(st.Blocks) * bsize,
+		FreeBytes:   uint64(st.Bavail) * bsize,
+		TotalInodes: uint64(st.Files),
+		FreeInodes:  uint64(st.Ffree),
+	}
+	// Blocks reserved for root count as used, as in df
+	disk.UsedPercent = percent(uint64(st.Blocks-st.Bfree), uint64(st.Blocks-st.Bfree+st.Bavail))
+	disk.InodesUsedPercent = percent(disk.TotalInodes-disk.FreeInodes, disk.TotalInodes)
+	return disk, nil
+}
+
+// fdUsage counts open descriptors against the soft RLIMIT_NOFILE; nil if unavailable
+func fdUsage() *FDStatus {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return nil
+	}
+	dir := "/proc/self/fd"
+	if runtime.GOOS == "darwin" {
+		dir = "/dev/fd"
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	// ReadDir holds one descriptor of its own while listing
+	open := max(len(entries)-1, 0)
+	return &FDStatus{Open: open, Limit: limit.Cur, UsedPercent: percent(uint64(open), limit.Cur)}
+}
@@ -0,0 +1,173 @@
+package sysmon
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"go.uber.org/zap"
+)
+
+// 资源监控：定期检查数据目录所在磁盘的剩余空间和 inode，以及进程打开的文件描述符数。
+// 使用量存储每个请求写一个文件，inode 耗尽时磁盘看起来仍有空间，写入却全部失败，
+// 因此需要单独监控并在接近上限时告警。
+
+// DiskStatus describes the filesystem holding the data directory
+type DiskStatus struct {
+	TotalBytes        uint64  `json:"total_bytes"`
+	FreeBytes         uint64  `json:"free_bytes"`
+	UsedPercent       float64 `json:"used_percent"`
+	TotalInodes       uint64  `json:"total_inodes"`
+	FreeInodes        uint64  `json:"free_inodes"`
+	InodesUsedPercent float64 `json:"inodes_used_percent"`
+}
+
+// FDStatus describes the process's open file descriptors
+type FDStatus struct {
+	Open        int     `json:"open"`
+	Limit       uint64  `json:"limit"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+// Status is the result of the last check
+type Status struct {
+	Enabled bool   `json:"enabled"`
+	DataDir string `json:"data_dir"`
+	// Disk and FD are nil when the platform can't report them
+	Disk      *DiskStatus `json:"disk,omitempty"`
+	FD        *FDStatus   `json:"fd,omitempty"`
+	Warnings  []string    `json:"warnings,omitempty"`
+	CheckedAt string      `json:"checked_at,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// Monitor checks resources in the background
+type Monitor struct {
+	cfg     config.ResourcesConfig
+	dataDir string
+	logger  *zap.Logger
+	// onWarning is called for each warning that wasn't active on the previous check
+	onWarning func(message string)
+
+	mu     sync.Mutex
+	status Status
+	stop   chan struct{}
+}
+
+// NewMonitor creates a monitor for dataDir; onWarning may be nil
+func NewMonitor(cfg config.ResourcesConfig, dataDir string, logger *zap.Logger, onWarning func(message string)) *Monitor {
+	return &Monitor{
+		cfg:       cfg,
+		dataDir:   dataDir,
+		logger:    logger,
+		onWarning: onWarning,
+		status:    Status{Enabled: !cfg.Disabled, DataDir: dataDir},
+	}
+}
+
+// Status returns the result of the last check
+func (m *Monitor) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// Check measures resources once, stores the result and reports new warnings
+func (m *Monitor) Check() Status {
+	status := Status{Enabled: true, DataDir: m.dataDir, CheckedAt: time.Now().Format(time.RFC3339)}
+	disk, err := diskUsage(m.dataDir)
+	if err != nil {
+		status.Error = err.Error()
+	}
+	status.Disk = disk
+	status.FD = fdUsage()
+	status.Warnings = m.warnings(status)
+
+	m.mu.Lock()
+	previous := m.status.Warnings
+	m.status = status
+	m.mu.Unlock()
+
+	// Only warnings that just appeared are logged, so a full disk doesn't flood the log
+	for _, warning := range status.Warnings {
+		if slices.Contains(previous, warning) {
+			continue
+		}
+		m.logger.Warn("Resource usage is high", zap.String("warning", warning))
+		if m.onWarning != nil {
+			m.onWarning(warning)
+		}
+	}
+	if status.Error != "" {
+		m.logger.Debug("Resource check failed", zap.String("error", status.Error))
+	}
+	return status
+}
+
+// Start checks once right away and then every cfg.Interval; no-op when disabled
+func (m *Monitor) Start() {
+	if m.cfg.Disabled {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		return
+	}
+	m.stop = make(chan struct{})
+	stop := m.stop
+
+	interval := m.cfg.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			m.Check()
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background checks; safe to call when never started
+func (m *Monitor) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+}
+
+// warnings lists the thresholds status exceeds; messages are stable across
+// checks (no live numbers) so repeats can be recognised
+func (m *Monitor) warnings(status Status) []string {
+	var warnings []string
+	if disk := status.Disk; disk != nil && m.cfg.DiskWarnPercent > 0 {
+		if disk.UsedPercent >= m.cfg.DiskWarnPercent {
+			warnings = append(warnings, fmt.Sprintf("disk holding %s is more than %.0f%% full", m.dataDir, m.cfg.DiskWarnPercent))
+		}
+		if disk.InodesUsedPercent >= m.cfg.DiskWarnPercent {
+			warnings = append(warnings, fmt.Sprintf("disk holding %s has used more than %.0f%% of its inodes", m.dataDir, m.cfg.DiskWarnPercent))
+		}
+	}
+	if fd := status.FD; fd != nil && m.cfg.FDWarnPercent > 0 && fd.UsedPercent >= m.cfg.FDWarnPercent {
+		warnings = append(warnings, fmt.Sprintf("more than %.0f%% of the file descriptor limit (%d) is in use", m.cfg.FDWarnPercent, fd.Limit))
+	}
+	return warnings
+}
+
+func percent(used, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(int(float64(used)/float64(total)*10000)) / 100
+}
@@ -0,0 +1,73 @@
+package sysmon
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCheck_WarnsOncePerCondition(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("disk usage is not supported on this platform")
+	}
+	dir := t.TempDir()
+
+	var notified []string
+	cfg := config.ResourcesConfig{DiskWarnPercent: 90, FDWarnPercent: 80}
+	monitor := NewMonitor(cfg, dir, zap.NewNop(), func(message string) { notified = append(notified, message) })
+
+	status := monitor.Check()
+	require.Empty(t, status.Error)
+	require.NotNil(t, status.Disk)
+	assert.Positive(t, status.Disk.TotalBytes)
+	require.NotNil(t, status.FD)
+	assert.Positive(t, status.FD.Open)
+	assert.Equal(t, status, monitor.Status())
+	if status.Disk.UsedPercent == 0 {
+		t.Skip("test filesystem reports no usage")
+	}
+
+	// Any usage crosses a tiny threshold; the warning is reported only when it appears
+	monitor.cfg.DiskWarnPercent = 0.001
+	status = monitor.Check()
+	require.NotEmpty(t, status.Warnings)
+	assert.Contains(t, status.Warnings[0], dir)
+	monitor.Check()
+	assert.Equal(t, status.Warnings, notified)
+
+	// Once the condition clears it is reported again the next time it appears
+	monitor.cfg.DiskWarnPercent = 100
+	assert.Empty(t, monitor.Check().Warnings)
+	monitor.cfg.DiskWarnPercent = 0.001
+	monitor.Check()
+	assert.Len(t, notified, 2*len(status.Warnings))
+}
+
+func TestWarnings(t *testing.T) {
+	monitor := NewMonitor(config.ResourcesConfig{DiskWarnPercent: 90, FDWarnPercent: 80}, "./data", zap.NewNop(), nil)
+
+	assert.Empty(t, monitor.warnings(Status{
+		Disk: &DiskStatus{UsedPercent: 50, InodesUsedPercent: 89.99},
+		FD:   &FDStatus{Open: 10, Limit: 1024, UsedPercent: 0.97},
+	}))
+	assert.Equal(t, []string{
+		"disk holding ./data has used more than 90% of its inodes",
+		"more than 80% of the file descriptor limit (1024) is in use",
+	}, monitor.warnings(Status{
+		Disk: &DiskStatus{UsedPercent: 50, InodesUsedPercent: 95},
+		FD:   &FDStatus{Open: 900, Limit: 1024, UsedPercent: 87.89},
+	}), "a full inode table is reported even with plenty of free space")
+	assert.Empty(t, monitor.warnings(Status{}), "unsupported platforms report nothing")
+}
+
+func TestStart_DisabledMakesNoChecks(t *testing.T) {
+	monitor := NewMonitor(config.ResourcesConfig{Disabled: true}, t.TempDir(), zap.NewNop(), nil)
+	monitor.Start()
+	defer monitor.Stop()
+	assert.False(t, monitor.Status().Enabled)
+	assert.Empty(t, monitor.Status().CheckedAt)
+}
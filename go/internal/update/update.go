@@ -0,0 +1,284 @@
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"go.uber.org/zap"
+)
+
+// 新版本检查：定期查询 GitHub Releases，报告是否有新版本，
+// 并从更新日志中提取不兼容变更，尤其是需要修改配置文件的变更。
+//
+// 更新日志约定：标题含 "Breaking" 的小节下的条目，或以 "BREAKING:" 开头的行，
+// 视为不兼容变更；其中提到 config / 配置 的条目视为配置变更。
+
+// Release is the subset of the GitHub release object we use
+type Release struct {
+	TagName    string `json:"tag_name"`
+	Name       string `json:"name"`
+	Body       string `json:"body"`
+	HTMLURL    string `json:"html_url"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+}
+
+// Status is the result of the last check
+type Status struct {
+	Enabled         bool   `json:"enabled"`
+	Current         string `json:"current"`
+	Latest          string `json:"latest,omitempty"`
+	UpdateAvailable bool   `json:"update_available"`
+	// BreakingConfig is true when any release newer than Current changes the config format
+	BreakingConfig bool `json:"breaking_config"`
+	// Breaking lists the breaking-change notes of every newer release, prefixed with its tag
+	Breaking   []string `json:"breaking,omitempty"`
+	ReleaseURL string   `json:"release_url,omitempty"`
+	CheckedAt  string   `json:"checked_at,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Checker polls the releases API in the background
+type Checker struct {
+	cfg     config.UpdatesConfig
+	current string
+	logger  *zap.Logger
+	client  *http.Client
+
+	mu     sync.Mutex
+	status Status
+	stop   chan struct{}
+}
+
+// NewChecker creates a checker for the running version
+func NewChecker(cfg config.UpdatesConfig, current string, logger *zap.Logger) *Checker {
+	return &Checker{
+		cfg:     cfg,
+		current: current,
+		logger:  logger,
+		client:  &http.Client{Timeout: 15 * time.Second},
+		status:  Status{Enabled: !cfg.Disabled, Current: current},
+	}
+}
+
+// Status returns the result of the last check
+func (c *Checker) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// Check queries the releases API once and stores the result
+func (c *Checker) Check(ctx context.Context) Status {
+	status := Status{Enabled: true, Current: c.current, CheckedAt: time.Now().Format(time.RFC3339)}
+	releases, err := c.fetch(ctx)
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status = evaluate(status, releases)
+	}
+
+	c.mu.Lock()
+	c.status = status
+	c.mu.Unlock()
+	return status
+}
+
+// Start checks once right away and then every cfg.Interval; no-op when disabled
+func (c *Checker) Start() {
+	if c.cfg.Disabled {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		return
+	}
+	c.stop = make(chan struct{})
+	stop := c.stop
+
+	go func() {
+		ticker := time.NewTicker(c.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			c.log(c.Check(context.Background()))
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background checks; safe to call when never started
+func (c *Checker) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+}
+
+func (c *Checker) log(status Status) {
+	switch {
+	case status.Error != "":
+		c.logger.Debug("Update check failed", zap.String("error", status.Error))
+	case status.BreakingConfig:
+		c.logger.Warn("New version available with breaking config changes; review the release notes before upgrading",
+			zap.String("current", status.Current),
+			zap.String("latest", status.Latest),
+			zap.Strings("breaking", status.Breaking),
+			zap.String("url", status.ReleaseURL))
+	case status.UpdateAvailable:
+		c.logger.Info("New version available",
+			zap.String("current", status.Current),
+			zap.String("latest", status.Latest),
+			zap.String("url", status.ReleaseURL))
+	}
+}
+
+// fetch lists the most recent releases of cfg.Repo
+func (c *Checker) fetch(ctx context.Context) ([]Release, error) {
+	url := fmt.Sprintf("%s/repos/%s/releases?per_page=30", strings.TrimSuffix(c.cfg.APIURL, "/"), c.cfg.Repo)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "antigravity-api-proxy/"+c.current)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GET %s: HTTP %d", url, resp.StatusCode)
+	}
+
+	var releases []Release
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("decode releases: %w", err)
+	}
+	return releases, nil
+}
+
+// evaluate compares the published releases against status.Current
+func evaluate(status Status, releases []Release) Status {
+	type versioned struct {
+		Release
+		version []int
+	}
+	var published []versioned
+	for _, r := range releases {
+		if r.Draft || r.Prerelease {
+			continue
+		}
+		if v, ok := parseVersion(r.TagName); ok {
+			published = append(published, versioned{r, v})
+		}
+	}
+	if len(published) == 0 {
+		return status
+	}
+	sort.Slice(published, func(i, j int) bool { return compareVersions(published[i].version, published[j].version) > 0 })
+	status.Latest = published[0].TagName
+	status.ReleaseURL = published[0].HTMLURL
+
+	// Development builds have no version to compare against
+	current, ok := parseVersion(status.Current)
+	if !ok {
+		return status
+	}
+	for _, r := range published {
+		if compareVersions(r.version, current) <= 0 {
+			break
+		}
+		status.UpdateAvailable = true
+		for _, note := range breakingNotes(r.Body) {
+			status.Breaking = append(status.Breaking, r.TagName+": "+note)
+			if isConfigNote(note) {
+				status.BreakingConfig = true
+			}
+		}
+	}
+	return status
+}
+
+// breakingNotes extracts breaking-change entries from release notes
+func breakingNotes(body string) []string {
+	var notes []string
+	inSection := false
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			inSection = strings.Contains(strings.ToLower(line), "breaking")
+			continue
+		}
+		item := strings.TrimSpace(strings.TrimLeft(line, "-*"))
+		if item == "" {
+			continue
+		}
+		if upper := strings.ToUpper(item); strings.HasPrefix(upper, "BREAKING:") || strings.HasPrefix(upper, "BREAKING CHANGE:") {
+			notes = append(notes, strings.TrimSpace(item[strings.Index(item, ":")+1:]))
+		} else if inSection {
+			notes = append(notes, item)
+		}
+	}
+	return notes
+}
+
+func isConfigNote(note string) bool {
+	return strings.Contains(strings.ToLower(note), "config") || strings.Contains(note, "配置")
+}
+
+// parseVersion parses "v1.2.3" (pre-release and build suffixes are ignored)
+func parseVersion(tag string) ([]int, bool) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "v")
+	if i := strings.IndexAny(tag, "-+"); i >= 0 {
+		tag = tag[:i]
+	}
+	if tag == "" {
+		return nil, false
+	}
+	parts := strings.Split(tag, ".")
+	version := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		version[i] = n
+	}
+	return version, true
+}
+
+// compareVersions returns -1, 0 or 1; missing components count as 0
+func compareVersions(a, b []int) int {
+	for i := 0; i < max(len(a), len(b)); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
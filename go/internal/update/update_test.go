@@ -0,0 +1,106 @@
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/antigravity/api-proxy/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func releasesServer(t *testing.T, releases []Release) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/acme/proxy/releases", r.URL.Path)
+		json.NewEncoder(w).Encode(releases)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestChecker(apiURL, current string) *Checker {
+	cfg := config.UpdatesConfig{Repo: "acme/proxy", APIURL: apiURL, Interval: time.Hour}
+	return NewChecker(cfg, current, zap.NewNop())
+}
+
+func TestCheck_ReportsBreakingConfigChanges(t *testing.T) {
+	srv := releasesServer(t, []Release{
+		{TagName: "v2.0.0-rc1", Prerelease: true, Body: "BREAKING: config rewritten"},
+		{TagName: "v1.3.0", Draft: true},
+		{TagName: "v1.2.0", HTMLURL: "https://example.com/v1.2.0", Body: "## Features\n- faster\n\n## Breaking changes\n- `proxy.images` config moved to `images`\n- dropped Go 1.22\n"},
+		{TagName: "v1.1.0", Body: "- BREAKING: /admin/status field renamed"},
+		{TagName: "v1.0.0", Body: "## Breaking\n- config.yaml format changed"},
+	})
+
+	status := newTestChecker(srv.URL, "v1.0.0").Check(context.Background())
+	require.Empty(t, status.Error)
+	assert.True(t, status.UpdateAvailable)
+	assert.Equal(t, "v1.2.0", status.Latest)
+	assert.Equal(t, "https://example.com/v1.2.0", status.ReleaseURL)
+	assert.True(t, status.BreakingConfig)
+	assert.Equal(t, []string{
+		"v1.2.0: `proxy.images` config moved to `images`",
+		"v1.2.0: dropped Go 1.22",
+		"v1.1.0: /admin/status field renamed",
+	}, status.Breaking, "only releases newer than the running version count")
+
+	// Tags without the "v" prefix compare the same way
+	status = newTestChecker(srv.URL, "1.1.0").Check(context.Background())
+	assert.True(t, status.UpdateAvailable)
+	assert.True(t, status.BreakingConfig)
+
+	status = newTestChecker(srv.URL, "v1.2.0").Check(context.Background())
+	assert.False(t, status.UpdateAvailable)
+	assert.Empty(t, status.Breaking)
+}
+
+func TestCheck_DevBuildsAndFailures(t *testing.T) {
+	srv := releasesServer(t, []Release{{TagName: "v1.0.0", Body: "BREAKING: config changed"}})
+
+	// Development builds see the latest release but are never told to upgrade
+	status := newTestChecker(srv.URL, "dev").Check(context.Background())
+	assert.Equal(t, "v1.0.0", status.Latest)
+	assert.False(t, status.UpdateAvailable)
+	assert.False(t, status.BreakingConfig)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer failing.Close()
+	checker := newTestChecker(failing.URL, "v1.0.0")
+	status = checker.Check(context.Background())
+	assert.Contains(t, status.Error, "HTTP 403")
+	assert.Equal(t, status, checker.Status())
+}
+
+func TestStart_DisabledMakesNoRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("disabled checker must not call the releases API")
+	}))
+	defer srv.Close()
+
+	cfg := config.UpdatesConfig{Disabled: true, Repo: "acme/proxy", APIURL: srv.URL, Interval: time.Millisecond}
+	checker := NewChecker(cfg, "v1.0.0", zap.NewNop())
+	checker.Start()
+	defer checker.Stop()
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, checker.Status().Enabled)
+}
+
+func TestCompareVersions(t *testing.T) {
+	parse := func(s string) []int {
+		v, ok := parseVersion(s)
+		require.True(t, ok, s)
+		return v
+	}
+	assert.Equal(t, 1, compareVersions(parse("v1.10.0"), parse("v1.9.3")))
+	assert.Equal(t, 0, compareVersions(parse("1.2"), parse("v1.2.0")))
+	assert.Equal(t, -1, compareVersions(parse("v1.2.3-beta"), parse("v1.2.4")))
+	_, ok := parseVersion("dev")
+	assert.False(t, ok)
+}
@@ -0,0 +1,59 @@
+package upstream
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 自定义请求头：部分账号需要额外的请求头（如客户端元数据）才能通过上游的权益校验。
+// proxy.upstream.headers 附加到所有 Cloud Code 请求（聊天、countTokens、模型列表），
+// 账号的 headers 字段按名称覆盖全局设置；认证和传输相关的请求头由代理管理，不能覆盖
+
+// reservedHeaders are set by the proxy itself and cannot be configured
+var reservedHeaders = map[string]bool{
+	"Authorization":     true,
+	"Content-Type":      true,
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Accept-Encoding":   true,
+	"Host":              true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+}
+
+// ValidateHeaders checks configured extra headers: names must be HTTP tokens
+// that are not reserved, and values must fit on one line.
+func ValidateHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if name == "" || strings.ContainsFunc(name, func(r rune) bool {
+			return r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r)
+		}) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if reservedHeaders[http.CanonicalHeaderKey(name)] {
+			return fmt.Errorf("header %s is set by the proxy and cannot be overridden", http.CanonicalHeaderKey(name))
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("invalid value for header %s", http.CanonicalHeaderKey(name))
+		}
+	}
+	return nil
+}
+
+// ApplyHeaders sets the global extra headers on h, then the account's, which
+// win by name. Invalid entries (e.g. from a hand-edited account file) are skipped.
+func ApplyHeaders(h http.Header, account map[string]string) {
+	sharedMu.Lock()
+	global := sharedConfig.Headers
+	sharedMu.Unlock()
+
+	for _, headers := range []map[string]string{global, account} {
+		for name, value := range headers {
+			if ValidateHeaders(map[string]string{name: value}) != nil {
+				continue
+			}
+			h.Set(name, value)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package upstream
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateHeaders(t *testing.T) {
+	assert.NoError(t, ValidateHeaders(map[string]string{"X-Client-Metadata": `{"ideType":"ANTIGRAVITY"}`, "user-agent": "custom"}))
+	assert.NoError(t, ValidateHeaders(nil))
+	for _, headers := range []map[string]string{
+		{"authorization": "Bearer x"},
+		{"Content-Length": "1"},
+		{"Bad Name": "x"},
+		{"X-Split": "a\r\nInjected: 1"},
+	} {
+		assert.Error(t, ValidateHeaders(headers), headers)
+	}
+}
+
+func TestApplyHeaders_AccountOverridesGlobal(t *testing.T) {
+	Configure(PoolConfig{Headers: map[string]string{"x-a": "global", "x-b": "global"}})
+	t.Cleanup(func() { Configure(PoolConfig{}) })
+
+	h := http.Header{}
+	h.Set("Authorization", "Bearer mine")
+	ApplyHeaders(h, map[string]string{"X-B": "account", "Authorization": "Bearer other"})
+	assert.Equal(t, "global", h.Get("X-A"))
+	assert.Equal(t, "account", h.Get("X-B"))
+	assert.Equal(t, "Bearer mine", h.Get("Authorization"), "reserved headers are skipped")
+}
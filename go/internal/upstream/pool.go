@@ -0,0 +1,157 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 共享连接池：所有访问Google的请求（聊天、OAuth、模型列表）共用同一个 Transport，
+// 复用空闲连接和TLS会话，默认协商 HTTP/2；连接池参数来自 proxy.upstream 配置。
+// 设置了独立出站代理的账号使用各自代理的连接池（ClientFor），其余参数相同
+
+// Pool defaults, used for zero PoolConfig fields
+const (
+	DefaultMaxIdleConns        = 100
+	DefaultMaxIdleConnsPerHost = 20
+	DefaultIdleConnTimeout     = 90 * time.Second
+	DefaultDialTimeout         = 10 * time.Second
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+	DefaultKeepAlive           = 30 * time.Second
+)
+
+// PoolConfig tunes the shared upstream transport; zero fields use the defaults
+type PoolConfig struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits dialing, in-use and idle connections per host; 0 means no limit
+	MaxConnsPerHost     int
+	IdleConnTimeout     time.Duration
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+	// DisableHTTP2 keeps connections on HTTP/1.1
+	DisableHTTP2 bool
+	// ProxyURL sends requests through an outbound proxy (http, https, socks5);
+	// empty means the HTTP(S)_PROXY environment variables
+	ProxyURL string
+	// Headers are extra headers for Cloud Code requests, see ApplyHeaders
+	Headers map[string]string
+}
+
+var (
+	sharedMu     sync.Mutex
+	sharedClient *http.Client
+	// sharedConfig is the pool configuration, reused for per-proxy clients
+	sharedConfig PoolConfig
+	// proxyClients holds one pooled client per per-account proxy URL
+	proxyClients map[string]*http.Client
+
+	dials       atomic.Int64
+	dialErrors  atomic.Int64
+	openConns   atomic.Int64
+	reusedConns atomic.Int64
+	http2Resps  atomic.Int64
+)
+
+// Client returns the shared upstream client. It has no overall timeout:
+// deadlines come from the request context.
+func Client() *http.Client {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if sharedClient == nil {
+		sharedClient = &http.Client{Transport: NewTransport(PoolConfig{})}
+	}
+	return sharedClient
+}
+
+// Configure replaces the shared transport. Requests already in flight finish
+// on the old transport, whose idle connections are closed.
+func Configure(cfg PoolConfig) {
+	client := &http.Client{Transport: NewTransport(cfg)}
+
+	sharedMu.Lock()
+	old := sharedClient
+	oldProxies := proxyClients
+	sharedClient = client
+	sharedConfig = cfg
+	proxyClients = nil
+	sharedMu.Unlock()
+
+	if old != nil {
+		old.CloseIdleConnections()
+	}
+	for _, proxied := range oldProxies {
+		proxied.CloseIdleConnections()
+	}
+}
+
+// NewTransport builds a pooled transport whose connections are counted in the pool stats
+func NewTransport(cfg PoolConfig) *http.Transport {
+	if cfg.MaxIdleConns == 0 {
+		cfg.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost == 0 {
+		cfg.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	if cfg.IdleConnTimeout == 0 {
+		cfg.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = DefaultDialTimeout
+	}
+	if cfg.TLSHandshakeTimeout == 0 {
+		cfg.TLSHandshakeTimeout = DefaultTLSHandshakeTimeout
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		// The URL was validated with ParseProxyURL when it was configured
+		if proxyURL, err := ParseProxyURL(cfg.ProxyURL); err == nil {
+			proxy = http.ProxyURL(proxyURL)
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: DefaultKeepAlive}
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials.Add(1)
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				dialErrors.Add(1)
+				return nil, err
+			}
+			openConns.Add(1)
+			return &countedConn{Conn: conn}, nil
+		},
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+		// A custom DialContext disables HTTP/2 unless it is forced
+		ForceAttemptHTTP2: !cfg.DisableHTTP2,
+	}
+	if cfg.DisableHTTP2 {
+		// A non-nil empty map turns off the transport's HTTP/2 upgrade
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// countedConn decrements the open connection count on first Close
+type countedConn struct {
+	net.Conn
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { openConns.Add(-1) })
+	return err
+}
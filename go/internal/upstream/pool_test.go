@@ -0,0 +1,68 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_ReusesPooledConnections(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	Configure(PoolConfig{})
+	before := GetStats()
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := Do(context.Background(), nil, req)
+		require.NoError(t, err)
+		DrainAndClose(resp)
+	}
+
+	stats := GetStats()
+	assert.Equal(t, before.Dials+1, stats.Dials)
+	assert.Equal(t, before.ReusedConnections+2, stats.ReusedConnections)
+	assert.Equal(t, before.OpenConnections+1, stats.OpenConnections)
+
+	// Reconfiguring closes the idle connections of the old pool
+	Configure(PoolConfig{})
+	assert.Eventually(t, func() bool { return GetStats().OpenConnections == before.OpenConnections },
+		time.Second, 10*time.Millisecond)
+}
+
+func TestNewTransport_HTTP2(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(cfg PoolConfig) *http.Response {
+		transport := NewTransport(cfg)
+		transport.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+		defer transport.CloseIdleConnections()
+
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := Do(context.Background(), &http.Client{Transport: transport}, req)
+		require.NoError(t, err)
+		DrainAndClose(resp)
+		return resp
+	}
+
+	before := GetStats()
+	assert.Equal(t, 2, get(PoolConfig{}).ProtoMajor)
+	assert.Equal(t, before.HTTP2Responses+1, GetStats().HTTP2Responses)
+
+	assert.Equal(t, 1, get(PoolConfig{DisableHTTP2: true}).ProtoMajor)
+}
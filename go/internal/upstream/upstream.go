@@ -0,0 +1,158 @@
+package upstream
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// 所有访问Google上游的请求都应通过 Do 发送：
+// 统一绑定context，并跟踪活跃连接数，确保取消的请求总能释放连接（未指定client时使用共享连接池）；
+// 调用方自行设置 Accept-Encoding 时Go不会自动解压，gzip/deflate 响应体在这里透明解压
+
+var (
+	activeConns   atomic.Int64
+	totalRequests atomic.Int64
+)
+
+// Stats is a snapshot of upstream connection counters
+type Stats struct {
+	ActiveConnections int64 `json:"activeConnections"`
+	TotalRequests     int64 `json:"totalRequests"`
+	// Pool counters cover transports built by NewTransport
+	OpenConnections   int64 `json:"openConnections"`
+	Dials             int64 `json:"dials"`
+	DialErrors        int64 `json:"dialErrors"`
+	ReusedConnections int64 `json:"reusedConnections"`
+	HTTP2Responses    int64 `json:"http2Responses"`
+}
+
+// Do sends req bound to ctx using client (the shared Client if nil).
+// The returned response body must be closed by the caller; closing it (or a
+// failed request) releases the active connection slot exactly once. gzip and
+// deflate bodies are returned decompressed.
+func Do(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	if client == nil {
+		client = Client()
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				reusedConns.Add(1)
+			}
+		},
+	})
+
+	totalRequests.Add(1)
+	activeConns.Add(1)
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		activeConns.Add(-1)
+		return nil, err
+	}
+	if resp.ProtoMajor == 2 {
+		http2Resps.Add(1)
+	}
+
+	resp.Body = &trackedBody{ReadCloser: resp.Body}
+	decodeBody(resp)
+	return resp, nil
+}
+
+// GetStats returns the current upstream counters
+func GetStats() Stats {
+	return Stats{
+		ActiveConnections: activeConns.Load(),
+		TotalRequests:     totalRequests.Load(),
+		OpenConnections:   openConns.Load(),
+		Dials:             dials.Load(),
+		DialErrors:        dialErrors.Load(),
+		ReusedConnections: reusedConns.Load(),
+		HTTP2Responses:    http2Resps.Load(),
+	}
+}
+
+// DrainAndClose discards up to 4KB of the remaining body so the connection
+// can be reused, then closes it. Safe to call on a nil response.
+func DrainAndClose(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+	io.CopyN(io.Discard, resp.Body, 4096)
+	resp.Body.Close()
+}
+
+// trackedBody decrements the active connection count on first Close
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { activeConns.Add(-1) })
+	return err
+}
+
+// decodeBody decompresses a gzip or deflate body in place. Go's transport only
+// does this itself when it added Accept-Encoding, not when the caller did.
+func decodeBody(resp *http.Response) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "gzip", "x-gzip", "deflate":
+	default:
+		return
+	}
+	resp.Body = &decodedBody{raw: resp.Body, encoding: encoding}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// decodedBody opens the decompressor on first Read, so a corrupt or truncated
+// body surfaces as a read error like any other broken stream
+type decodedBody struct {
+	raw      io.ReadCloser
+	encoding string
+	reader   io.Reader
+	err      error
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.reader == nil && b.err == nil {
+		b.reader, b.err = newDecoder(b.raw, b.encoding)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.reader.Read(p)
+}
+
+func (b *decodedBody) Close() error {
+	return b.raw.Close()
+}
+
+func newDecoder(r io.Reader, encoding string) (io.Reader, error) {
+	if encoding != "deflate" {
+		return gzip.NewReader(r)
+	}
+	// HTTP deflate is zlib-wrapped (RFC 9110), but some servers send raw DEFLATE
+	buffered := bufio.NewReader(r)
+	if header, err := buffered.Peek(2); err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
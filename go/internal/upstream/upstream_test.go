@@ -0,0 +1,120 @@
+package upstream
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDo_TracksActiveConnections(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	before := GetStats()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.NoError(t, err)
+	resp, err := Do(context.Background(), srv.Client(), req)
+	require.NoError(t, err)
+
+	assert.Equal(t, before.ActiveConnections+1, GetStats().ActiveConnections)
+	assert.Equal(t, before.TotalRequests+1, GetStats().TotalRequests)
+
+	// Closing twice must only release the slot once
+	resp.Body.Close()
+	resp.Body.Close()
+	assert.Equal(t, before.ActiveConnections, GetStats().ActiveConnections)
+}
+
+func TestDo_CancelledContextReleasesSlot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	before := GetStats()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.NoError(t, err)
+	_, err = Do(ctx, srv.Client(), req)
+	assert.Error(t, err)
+	assert.Equal(t, before.ActiveConnections, GetStats().ActiveConnections)
+}
+
+func TestDo_DecompressesBodies(t *testing.T) {
+	const payload = "data: {\"response\": {}}\n\n"
+	compress := func(newWriter func(io.Writer) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		w.Write([]byte(payload))
+		w.Close()
+		return buf.Bytes()
+	}
+	rawDeflate := func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	}
+
+	cases := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", []byte(payload)},
+		{"gzip", compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })},
+		{"deflate", compress(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })},
+		{"deflate", compress(rawDeflate)},
+	}
+	for _, tc := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tc.encoding != "" {
+				w.Header().Set("Content-Encoding", tc.encoding)
+			}
+			w.Write(tc.body)
+		}))
+
+		req, err := http.NewRequest("GET", srv.URL, nil)
+		require.NoError(t, err)
+		// Setting the header ourselves disables the transport's own decompression
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		resp, err := Do(context.Background(), srv.Client(), req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		srv.Close()
+
+		require.NoError(t, err, tc.encoding)
+		assert.Equal(t, payload, string(body), tc.encoding)
+		assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	}
+}
+
+func TestDo_CorruptCompressedBodyFailsOnRead(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write([]byte("not gzip"))
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := Do(context.Background(), srv.Client(), req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err)
+}